@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/anotherLostKitten/Anglish/internal/air"
+	"github.com/anotherLostKitten/Anglish/internal/air/lower"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+func runAir(args []string) int {
+	flags := flag.NewFlagSet("air", flag.ExitOnError)
+	format := flags.String("format", "json", "output format: json or bin")
+	out := flags.String("o", "", "write the module to this file instead of stdout")
+	validate := flags.Bool("validate", false, "validate an existing AIR file (json or bin) instead of lowering a contract")
+	schema := flags.Bool("schema", false, "print the JSON schema for AIR modules")
+	flags.Parse(args)
+
+	if *schema {
+		os.Stdout.Write(air.Schema)
+		return 0
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: anglish %s\n", commands["air"].usage)
+		return 2
+	}
+
+	if *validate {
+		data, err := os.ReadFile(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		m, err := air.Decode(data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", flags.Arg(0), err)
+			return 1
+		}
+		errs := air.Validate(m)
+		for _, err := range errs {
+			fmt.Printf("%s: %v\n", flags.Arg(0), err)
+		}
+		if len(errs) > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	c, ok := loadContract(flags.Arg(0))
+	if !ok {
+		return 1
+	}
+	po := parse.GetParseOrder(&c)
+	m, errs := lower.Lower(&po)
+	errs = append(errs, air.Validate(m)...)
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", flags.Arg(0), err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+
+	var data []byte
+	var err error
+	switch *format {
+	case "json":
+		data, err = json.MarshalIndent(m, "", "  ")
+		data = append(data, '\n')
+	case "bin":
+		data, err = m.MarshalBinary()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %q, expected json or bin\n", *format)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if *out == "" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
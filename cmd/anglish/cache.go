@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/anotherLostKitten/Anglish/internal/generate"
+)
+
+func runCache(args []string) int {
+	flags := flag.NewFlagSet("cache", flag.ExitOnError)
+	dir := flags.String("dir", generate.DefaultCacheDir(), "cache directory")
+	prune := flags.Bool("prune", false, "with verify, remove the entries that fail")
+	// flags may come before the subcommand or after it
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		fmt.Fprintf(os.Stderr, "usage: anglish %s\n", commands["cache"].usage)
+		return 2
+	}
+	cmd := flags.Arg(0)
+	flags.Parse(flags.Args()[1:])
+	if flags.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "usage: anglish %s\n", commands["cache"].usage)
+		return 2
+	}
+
+	cache, err := generate.OpenCache(*dir, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	switch cmd {
+	case "ls":
+		entries, err := cache.List()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		total := int64(0)
+		for _, e := range entries {
+			key := e.Key
+			if len(key) > 12 {
+				key = key[:12]
+			}
+			fmt.Printf("%s  %-24s %8d  %s  %s\n", key, e.Subject, e.Size, e.Used.Format("2006-01-02 15:04"), e.Config)
+			total += e.Size
+		}
+		fmt.Printf("%d entries, %d bytes in %s\n", len(entries), total, cache.Dir())
+	case "clear":
+		if err := cache.Clear(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	case "verify":
+		problems, err := cache.Verify(*prune)
+		for _, p := range problems {
+			fmt.Printf("%s: %v\n", p.Path, p.Err)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		if len(problems) > 0 && !*prune {
+			return 1
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "usage: anglish %s\n", commands["cache"].usage)
+		return 2
+	}
+	return 0
+}
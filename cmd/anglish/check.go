@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/anotherLostKitten/Anglish/internal/check"
+	"github.com/anotherLostKitten/Anglish/internal/load"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// loadFiles parses the contracts named by args, files or directories of them,
+// with workers at a time; ok is false if args named nothing that exists
+func loadFiles(args []string, workers int) ([]load.File, bool) {
+	paths, err := load.Paths(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return nil, false
+	}
+	return load.Files(paths, load.Config{Workers: workers}), true
+}
+
+// printLoadErrors prints why a file could not be read or parsed; ok is false
+// if it could not
+func printLoadErrors(f *load.File) bool {
+	if f.Err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", f.Err)
+		return false
+	}
+	for _, e := range f.Errors {
+		fmt.Printf("%s: ", f.Path)
+		parse.PrintErrorInfo(e)
+	}
+	return len(f.Errors) == 0
+}
+
+func runCheck(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	workers := flags.Int("j", 0, "how many files to parse at once, 0 for one per CPU")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "usage: anglish %s\n", commands["check"].usage)
+		return 2
+	}
+
+	files, ok := loadFiles(flags.Args(), *workers)
+	if !ok {
+		return 2
+	}
+	failed := false
+	for i := range files {
+		f := &files[i]
+		if !printLoadErrors(f) {
+			failed = true
+			continue
+		}
+		diags := check.Names(&f.Order, &check.DefaultRules)
+		diags = append(diags, check.Semantic(&f.Contract, &f.Order, &check.DefaultRules)...)
+		routes := parse.GetRoutingTable(&f.Order)
+		diags = append(diags, check.Routes(&routes, &check.DefaultRules)...)
+		check.SortDiagnostics(diags)
+		for _, d := range diags {
+			fmt.Printf("%s: ", f.Path)
+			check.PrintDiagnostic(d)
+		}
+		failed = failed || check.HasErrors(diags)
+	}
+	fmt.Printf("%d files checked\n", len(files))
+	if failed {
+		return 1
+	}
+	return 0
+}
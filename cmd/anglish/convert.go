@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anotherLostKitten/Anglish/internal/check"
+	"github.com/anotherLostKitten/Anglish/internal/convert"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// formatOf tells a contract's format by its file extension: "ang", "json" or "yaml"
+func formatOf(path string) (string, bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ang": return "ang", true
+	case ".json": return "json", true
+	case ".yaml", ".yml": return "yaml", true
+	default: return "", false
+	}
+}
+
+func dataFormat(format string) convert.Format {
+	if format == "yaml" {
+		return convert.YAML
+	}
+	return convert.JSON
+}
+
+// readDocument reads a contract in any format as a document, printing what is
+// wrong with it. A document from JSON or YAML is checked as a .ang file would
+// be, its problems reported by where they are in the document.
+func readDocument(path, format string) (convert.Document, bool) {
+	if format == "ang" {
+		c, ok := loadContract(path)
+		return convert.FromContract(&c), ok
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return convert.Document{}, false
+	}
+	d, err := convert.Unmarshal(data, dataFormat(format))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return d, false
+	}
+	c, errs := convert.ToContract(&d)
+	for _, err := range errs {
+		fmt.Printf("%s: %v\n", path, err)
+	}
+	if len(errs) > 0 {
+		return d, false
+	}
+	po := parse.GetParseOrder(&c)
+	diags := check.Names(&po, &check.DefaultRules)
+	diags = append(diags, check.Semantic(&c, &po, &check.DefaultRules)...)
+	routes := parse.GetRoutingTable(&po)
+	diags = append(diags, check.Routes(&routes, &check.DefaultRules)...)
+	for _, diag := range diags {
+		fmt.Printf("%s: %s: %s: %s [%s]\n", path, d.Origin(diag.Line), diag.Severity.ToStr(), diag.Msg, diag.Rule)
+	}
+	return d, !check.HasErrors(diags)
+}
+
+func runConvert(args []string) int {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	to := flags.String("to", "", "ang, json or yaml; by default that of -o, or json for a .ang file and ang otherwise")
+	out := flags.String("o", "", "write the contract to this file instead of stdout")
+	schema := flags.Bool("schema", false, "print the JSON schema for contracts as JSON or YAML")
+	flags.Parse(args)
+
+	if *schema {
+		os.Stdout.Write(convert.Schema)
+		return 0
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: anglish %s\n", commands["convert"].usage)
+		return 2
+	}
+	in_path := flags.Arg(0)
+	from, ok := formatOf(in_path)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "%s: unknown extension, expected .ang, .json, .yaml or .yml\n", in_path)
+		return 2
+	}
+	target := *to
+	if target == "" && *out != "" {
+		target, _ = formatOf(*out)
+	}
+	if target == "" {
+		target = "ang"
+		if from == "ang" {
+			target = "json"
+		}
+	}
+	if target == "yml" {
+		target = "yaml"
+	}
+	if target != "ang" && target != "json" && target != "yaml" {
+		fmt.Fprintf(os.Stderr, "unknown format %q, expected ang, json or yaml\n", target)
+		return 2
+	}
+
+	d, ok := readDocument(in_path, from)
+	if !ok {
+		return 1
+	}
+	var data []byte
+	if target == "ang" {
+		data = []byte(d.Source())
+	} else {
+		var err error
+		data, err = convert.Marshal(&d, dataFormat(target))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+
+	if *out == "" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/anotherLostKitten/Anglish/internal/diff"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// runDiff exits 0 if the contracts declare the same things, 1 if they differ,
+// and 2 if either could not be parsed, as diff(1) does
+func runDiff(args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	as_json := flags.Bool("json", false, "print the report as JSON")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "usage: anglish %s\n", commands["diff"].usage)
+		return 2
+	}
+
+	old_c, ok := loadContract(flags.Arg(0))
+	if !ok {
+		return 2
+	}
+	new_c, ok := loadContract(flags.Arg(1))
+	if !ok {
+		return 2
+	}
+	old_po, new_po := parse.GetParseOrder(&old_c), parse.GetParseOrder(&new_c)
+	report := diff.Compare(&old_po, &new_po)
+
+	if *as_json {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+		fmt.Println(string(out))
+	} else {
+		report.Write(os.Stdout)
+	}
+	if report.Empty() {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/anotherLostKitten/Anglish/internal/doc"
+	"github.com/anotherLostKitten/Anglish/internal/graph"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// renderSVG lays the graph out with Graphviz, if it is installed
+func renderSVG(dot string) (string, bool) {
+	if _, err := exec.LookPath("dot"); err != nil {
+		return "", false
+	}
+	cmd := exec.Command("dot", "-Tsvg")
+	cmd.Stdin = strings.NewReader(dot)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return "", false
+	}
+	return out.String(), true
+}
+
+func runDoc(args []string) int {
+	flags := flag.NewFlagSet("doc", flag.ExitOnError)
+	out_dir := flags.String("o", "anglish-doc", "directory to write the site to")
+	format := flags.String("format", "html", "html or md")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: anglish %s\n", commands["doc"].usage)
+		return 2
+	}
+
+	opts := doc.Options{Title: filepath.Base(flags.Arg(0))}
+	switch *format {
+	case "html": opts.Format = doc.HTML
+	case "md", "markdown": opts.Format = doc.Markdown
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %q, expected html or md\n", *format)
+		return 2
+	}
+
+	c, ok := loadContract(flags.Arg(0))
+	if !ok {
+		return 1
+	}
+	po := parse.GetParseOrder(&c)
+	rt := parse.GetRoutingTable(&po)
+	dot, err := graph.DOT(&po, &rt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if svg, ok := renderSVG(dot); ok {
+		opts.GraphSVG = svg
+	}
+
+	if err := os.MkdirAll(*out_dir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	files, err := doc.Render(&po, &rt, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	for name, text := range files {
+		if err := os.WriteFile(filepath.Join(*out_dir, name), []byte(text), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+	return 0
+}
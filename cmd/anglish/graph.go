@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/anotherLostKitten/Anglish/internal/graph"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+func runGraph(args []string) int {
+	flags := flag.NewFlagSet("graph", flag.ExitOnError)
+	out := flags.String("o", "", "write the DOT graph to this file instead of stdout")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: anglish %s\n", commands["graph"].usage)
+		return 2
+	}
+
+	c, ok := loadContract(flags.Arg(0))
+	if !ok {
+		return 1
+	}
+	po := parse.GetParseOrder(&c)
+	rt := parse.GetRoutingTable(&po)
+	dot, err := graph.DOT(&po, &rt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	data := []byte(dot)
+	if *out == "" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
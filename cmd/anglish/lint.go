@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/anotherLostKitten/Anglish/internal/check"
+	"github.com/anotherLostKitten/Anglish/internal/lint"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// readLintConfig reads a JSON object of rule name to severity, eg.
+// {"unused-task": "error", "ident-case": "off"}
+func readLintConfig(path string, config lint.Config) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var levels map[string]string
+	if err := json.Unmarshal(src, &levels); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for rule, level := range levels {
+		sev, ok := check.ParseSeverity(level)
+		if !ok {
+			return fmt.Errorf("%s: unknown severity %q for %s", path, level, rule)
+		}
+		config[rule] = sev
+	}
+	return nil
+}
+
+func parseSeverityFlag(value string, config lint.Config) error {
+	for _, kv := range strings.Split(value, ",") {
+		if kv == "" {
+			continue
+		}
+		rule, level, found := strings.Cut(kv, "=")
+		sev, ok := check.ParseSeverity(level)
+		if !found || !ok {
+			return fmt.Errorf("bad -severity entry %q, expected rule=off|info|warning|error", kv)
+		}
+		config[rule] = sev
+	}
+	return nil
+}
+
+// diagnose runs the name, semantic and routing checks and the linter
+func diagnose(c *parse.Contract, po *parse.ParseOrder, linter *lint.Linter) []check.Diagnostic {
+	diags := check.Names(po, &check.DefaultRules)
+	diags = append(diags, check.Semantic(c, po, &check.DefaultRules)...)
+	routes := parse.GetRoutingTable(po)
+	diags = append(diags, check.Routes(&routes, &check.DefaultRules)...)
+	return append(diags, linter.Run(c, po)...)
+}
+
+func runLint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	config_path := flags.String("config", "", "JSON file mapping rule names to severities")
+	severities := flags.String("severity", "", "comma separated rule=level overrides")
+	workers := flags.Int("j", 0, "how many files to parse at once, 0 for one per CPU")
+	flags.Parse(args)
+
+	config := make(lint.Config)
+	if *config_path != "" {
+		if err := readLintConfig(*config_path, config); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+	}
+	if err := parseSeverityFlag(*severities, config); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	files, ok := loadFiles(flags.Args(), *workers)
+	if !ok {
+		return 2
+	}
+	linter := lint.NewLinter(lint.DefaultRules(), config)
+	failed := false
+	for i := range files {
+		f := &files[i]
+		failed = !printLoadErrors(f) || failed
+		if f.Err != nil {
+			continue
+		}
+
+		diags := diagnose(&f.Contract, &f.Order, linter)
+		for _, d := range diags {
+			fmt.Printf("%s: ", f.Path)
+			check.PrintDiagnostic(d)
+		}
+		failed = failed || check.HasErrors(diags)
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/anotherLostKitten/Anglish/internal/lsp"
+)
+
+func runLSP(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintf(os.Stderr, "usage: anglish %s\n", commands["lsp"].usage)
+		return 2
+	}
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -2,20 +2,75 @@ package main
 
 import (
 	"fmt"
-	// "io"
+	"os"
+	"sort"
 	"strings"
 
 	"github.com/anotherLostKitten/Anglish/internal/parse"
 )
 
+type command struct {
+	run func(args []string) int
+	usage string
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"air": {runAir, "air [-format json|bin] [-o out] file.ang | air -validate file.air | air -schema"},
+		"cache": {runCache, "cache [-dir dir] ls | clear | verify [-prune]"},
+		"check": {runCheck, "check [-j workers] file.ang|dir..."},
+		"convert": {runConvert, "convert [-to ang|json|yaml] [-o out] file.ang|file.json|file.yaml | convert -schema"},
+		"diff": {runDiff, "diff [-json] old.ang new.ang"},
+		"doc": {runDoc, "doc [-format html|md] [-o dir] file.ang"},
+		"graph": {runGraph, "graph [-o out.dot] file.ang"},
+		"lint": {runLint, "lint [-config rules.json] [-severity rule=level,...] [-j workers] file.ang|dir..."},
+		"lsp": {runLSP, "lsp"},
+		"rename": {runRename, "rename [-n] <@space|#agent|$task|@space.$task|=path> <new name> file.ang"},
+		"rules": {runRules, "rules [-o out.json] file.ang"},
+		"verify": {runVerify, "verify -decl <@space|#agent|$task|@space.$task|=path> file.ang artifact"},
+		"watch": {runWatch, "watch [-o out] [-interval 500ms] [-generate=false] [-cache dir] [-cache-size MiB] [-config rules.json] [-severity rule=level,...] dir"},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: anglish <command> [arguments]\n\ncommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+}
+
 func main() {
-	r := strings.NewReader("@spacey:CALL ( in=%argy )\n> i'm vibing %big time 100%\n>\n>$tasky(in=%a, out=%b)\n$tasky(in=%a, out=%b)\n>hi hi hi")
-	c, errors := parse.ParseFromReader(r)
-	fmt.Printf("error number : %d\n", len(errors))
-	for i := 0; i < len(errors); i++ {
-		parse.PrintErrorInfo(errors[i])
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
 	}
-	fmt.Printf("%+v\n", c)
+	os.Exit(cmd.run(os.Args[2:]))
+}
 
-	_ = parse.GetParseOrder(&c)
+// loadContract parses a contract file, printing any parser errors; ok is false
+// if the file could not be read or had parser errors.
+func loadContract(path string) (parse.Contract, bool) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return parse.Contract{}, false
+	}
+	c, errors := parse.ParseFromReader(strings.NewReader(string(src)))
+	for _, e := range errors {
+		fmt.Printf("%s: ", path)
+		parse.PrintErrorInfo(e)
+	}
+	return c, len(errors) == 0
 }
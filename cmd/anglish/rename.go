@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+	"github.com/anotherLostKitten/Anglish/internal/refactor"
+)
+
+func runRename(args []string) int {
+	flags := flag.NewFlagSet("rename", flag.ExitOnError)
+	dry_run := flags.Bool("n", false, "list the edits instead of rewriting the file")
+	flags.Parse(args)
+	if flags.NArg() != 3 {
+		fmt.Fprintf(os.Stderr, "usage: anglish %s\n", commands["rename"].usage)
+		return 2
+	}
+	old_name, new_name, path := flags.Arg(0), flags.Arg(1), flags.Arg(2)
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	c, ok := loadContract(path)
+	if !ok {
+		return 1
+	}
+	po := parse.GetParseOrder(&c)
+	target, ok := po.LookupQualified(old_name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "%s is not declared in %s, or names several declarations\n", old_name, path)
+		return 1
+	}
+
+	edits, err := refactor.NewIndex(&po).Rename(string(src), 0, target, new_name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if *dry_run {
+		tree := c.GetSyntaxTree()
+		sort.Slice(edits, func(i, j int) bool { return edits[i].Span.Start < edits[j].Span.Start })
+		for _, e := range edits {
+			line, col := tree.Position(e.Span.Start)
+			fmt.Printf("%s:%d:%d: %s -> %s\n", path, line + 1, col + 1, tree.Text(e.Span), e.NewText)
+		}
+		return 0
+	}
+
+	renamed, err := parse.ApplyEdits(string(src), edits)
+	if err == nil {
+		err = os.WriteFile(path, []byte(renamed), 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "renamed %s in %d places\n", old_name, len(edits))
+	return 0
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+	"github.com/anotherLostKitten/Anglish/internal/rules"
+)
+
+func runRules(args []string) int {
+	flags := flag.NewFlagSet("rules", flag.ExitOnError)
+	out := flags.String("o", "", "write the obligations to this file instead of stdout")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: anglish %s\n", commands["rules"].usage)
+		return 2
+	}
+
+	c, ok := loadContract(flags.Arg(0))
+	if !ok {
+		return 1
+	}
+	po := parse.GetParseOrder(&c)
+	data, err := rules.Export(rules.Extract(&c, &po))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	data = append(data, '\n')
+	if *out == "" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
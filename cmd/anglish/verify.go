@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+	"github.com/anotherLostKitten/Anglish/internal/verify"
+)
+
+func runVerify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	decl_name := flags.String("decl", "", "declaration the artifact was generated from, eg. =fetch or @store.$get")
+	flags.Parse(args)
+	if *decl_name == "" || flags.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "usage: anglish %s\n", commands["verify"].usage)
+		return 2
+	}
+
+	c, ok := loadContract(flags.Arg(0))
+	if !ok {
+		return 1
+	}
+	po := parse.GetParseOrder(&c)
+	node, ok := po.LookupQualified(*decl_name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "%s is not declared in %s, or names several declarations\n", *decl_name, flags.Arg(0))
+		return 1
+	}
+	artifact, err := os.ReadFile(flags.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	judge, err := verify.NewOpenAIJudge()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	report, err := verify.Verify(context.Background(), judge, po.GetNode(node), string(artifact))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	data, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(data))
+	if !report.Pass {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anotherLostKitten/Anglish/internal/check"
+	"github.com/anotherLostKitten/Anglish/internal/generate"
+	"github.com/anotherLostKitten/Anglish/internal/lint"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// watched is a contract file being watched, kept parsed between changes
+type watched struct {
+	doc *parse.Document
+	builder *generate.Builder
+}
+
+type watcher struct {
+	dir, out_dir string
+	linter *lint.Linter
+	// nil if not generating
+	gen generate.Generator
+	cache *generate.Cache
+	files map[string]*watched
+}
+
+func runWatch(args []string) int {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	out_dir := flags.String("o", "anglish-out", "directory to write artifacts to")
+	interval := flags.Duration("interval", 500 * time.Millisecond, "how often to look for changes")
+	gen := flags.Bool("generate", true, "generate artifacts; with -generate=false only diagnostics are shown")
+	cache_dir := flags.String("cache", generate.DefaultCacheDir(), "directory to cache artifacts in, \"\" for none")
+	cache_size := flags.Int64("cache-size", 512, "MiB the cache may take up before old artifacts are evicted")
+	config_path := flags.String("config", "", "JSON file mapping lint rule names to severities")
+	severities := flags.String("severity", "", "comma separated rule=level overrides")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: anglish %s\n", commands["watch"].usage)
+		return 2
+	}
+
+	config := make(lint.Config)
+	if *config_path != "" {
+		if err := readLintConfig(*config_path, config); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+	}
+	if err := parseSeverityFlag(*severities, config); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	w := watcher{
+		dir: flags.Arg(0),
+		out_dir: filepath.Clean(*out_dir),
+		linter: lint.NewLinter(lint.DefaultRules(), config),
+		files: make(map[string]*watched),
+	}
+	if *gen {
+		g, err := generate.NewOpenAIGenerator()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		w.gen = g
+		if *cache_dir != "" {
+			w.cache, err = generate.OpenCache(*cache_dir, *cache_size << 20)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return 1
+			}
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	for {
+		if err := w.scan(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// scan looks for contracts that were added, changed or removed since the last
+// scan, and rebuilds the changed ones
+func (w *watcher) scan(ctx context.Context) error {
+	seen := make(map[string]bool)
+	err := filepath.WalkDir(w.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path == w.out_dir {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || filepath.Ext(path) != ".ang" {
+			return nil
+		}
+		seen[path] = true
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		f, ok := w.files[path]
+		if ok && f.doc.Source() == string(src) {
+			return nil
+		}
+		fmt.Printf("== %s\n", path)
+		if ok {
+			f.doc.Update(string(src))
+		} else {
+			f = &watched{doc: parse.NewDocument(string(src), parse.ParseConfig{})}
+			if w.gen != nil {
+				f.builder = generate.NewBuilder(w.gen, w.cache)
+			}
+			w.files[path] = f
+		}
+		w.rebuild(ctx, path, f)
+		return nil
+	})
+
+	for path := range w.files {
+		if !seen[path] {
+			fmt.Printf("== %s: removed\n", path)
+			delete(w.files, path)
+		}
+	}
+	return err
+}
+
+// rebuild shows a contract's diagnostics and, if it has no errors, generates
+// what its last change affected
+func (w *watcher) rebuild(ctx context.Context, path string, f *watched) {
+	errors := f.doc.Errors()
+	for _, e := range errors {
+		fmt.Printf("%s: ", path)
+		parse.PrintErrorInfo(e)
+	}
+	if len(errors) > 0 {
+		return
+	}
+	diags := diagnose(f.doc.Contract(), f.doc.Order(), w.linter)
+	for _, d := range diags {
+		fmt.Printf("%s: ", path)
+		check.PrintDiagnostic(d)
+	}
+	if check.HasErrors(diags) || f.builder == nil {
+		return
+	}
+
+	results, err := f.builder.Build(ctx, f.doc.Contract(), f.doc.Order())
+	if err != nil {
+		return
+	}
+	generated, reused, failed := 0, 0, 0
+	for _, res := range results {
+		if res.Err == nil {
+			res.Err = writeArtifact(w.artifactPath(path, res.Subject), res.Text)
+		}
+		switch {
+		case res.Err != nil:
+			fmt.Printf("%s: %s: %v\n", path, res.Subject, res.Err)
+			failed++
+		case res.Reused:
+			reused++
+		default:
+			generated++
+		}
+	}
+	fmt.Printf("%s: %d generated, %d reused, %d failed\n", path, generated, reused, failed)
+}
+
+// artifactPath is where the artifact for a declaration of the contract at
+// path goes, eg. @store.$get in shop.ang goes to shop/space.store.task.get
+func (w *watcher) artifactPath(path, subject string) string {
+	rel, err := filepath.Rel(w.dir, path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	sigils := strings.NewReplacer("@", "space.", "#", "agent.", "$", "task.", "=", "path.")
+	return filepath.Join(w.out_dir, strings.TrimSuffix(rel, ".ang"), sigils.Replace(subject))
+}
+
+// writeArtifact writes an artifact unless it is already there
+func writeArtifact(path, text string) error {
+	if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, []byte(text)) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(text), 0644)
+}
@@ -0,0 +1,256 @@
+package air
+
+import (
+	"fmt"
+)
+
+// The AIR is the contract as the runtime sees it: every declaration resolved to
+// a node id, with no source text beyond the vibe prose. It does not depend on
+// the parser, so the runtime can read, write and Validate it on its own.
+//
+// Node ids are shared by spaces, agents, tasks and paths, and index Module.Kinds.
+
+const Version = 4
+
+// NoParent marks a space, agent, task or path declared at the top level
+const NoParent int32 = -1
+
+// NoSite marks the top-level instance of a space, the one nothing imports
+const NoSite int32 = -1
+
+type Kind byte
+const (
+	KindSpace Kind = iota
+	KindAgent
+	KindTask
+	KindPath
+)
+
+func (k Kind) ToStr() string {
+	switch k {
+	case KindSpace: return "space"
+	case KindAgent: return "agent"
+	case KindTask: return "task"
+	case KindPath: return "path"
+	default: return "???"
+	}
+}
+
+func (k Kind) MarshalText() ([]byte, error) {
+	if k > KindPath {
+		return nil, fmt.Errorf("air: unknown node kind %d", k)
+	}
+	return []byte(k.ToStr()), nil
+}
+
+func (k *Kind) UnmarshalText(text []byte) error {
+	for _, kind := range []Kind{KindSpace, KindAgent, KindTask, KindPath} {
+		if string(text) == kind.ToStr() {
+			*k = kind
+			return nil
+		}
+	}
+	return fmt.Errorf("air: unknown node kind %q", text)
+}
+
+// space, agent and path types are kept as their tag names, eg. "CALL", "" if untyped
+var SpaceTypes = []string{"", "UI", "IO", "DATA", "CALL", "CHAT"}
+var AgentTypes = []string{"AF", "DF"}
+var PathTypes = []string{"INVOKE", "ATTEND"}
+var PathAccesses = []string{"", "READ", "WRITE", "CONTROL"}
+
+type Param struct {
+	Name string `json:"name"`
+	In bool `json:"in"`
+}
+
+// Node fields shared by every declaration
+type Node struct {
+	ID uint32 `json:"id"`
+	Name string `json:"name"`
+	Vibe []string `json:"vibe,omitempty"`
+	// nodes this one's $use() imports resolve to
+	Imports []uint32 `json:"imports,omitempty"`
+	// every node this one depends on, including Imports
+	Deps []uint32 `json:"deps,omitempty"`
+}
+
+type Space struct {
+	Node
+	Type string `json:"type"`
+	Replicable bool `json:"replicable"`
+	// the space this one is nested in
+	Parent int32 `json:"parent"`
+	Params []Param `json:"params,omitempty"`
+	Agents []uint32 `json:"agents,omitempty"`
+	Tasks []uint32 `json:"tasks,omitempty"`
+	// subspaces and paths declared inside this space
+	Spaces []uint32 `json:"spaces,omitempty"`
+	Paths []uint32 `json:"paths,omitempty"`
+}
+
+type Agent struct {
+	Node
+	Type string `json:"type"`
+	Parent int32 `json:"parent"`
+	Params []Param `json:"params,omitempty"`
+}
+
+type Task struct {
+	Node
+	Parent int32 `json:"parent"`
+	Params []Param `json:"params,omitempty"`
+}
+
+type Path struct {
+	Node
+	Type string `json:"type"`
+	// "" if the path may carry anything
+	Access string `json:"access"`
+	Parent int32 `json:"parent"`
+	Source uint32 `json:"source"`
+	Dest uint32 `json:"dest"`
+}
+
+// Instance is one instance of a space: the top-level one, or the one made by the
+// $use() of it in the space Site.
+type Instance struct {
+	Space uint32 `json:"space"`
+	Site int32 `json:"site"`
+}
+
+// Route says where a message over Path, sent from the instance of its source in
+// FromSite, arrives: the instance of its destination in ToSite.
+type Route struct {
+	Path uint32 `json:"path"`
+	FromSite int32 `json:"from_site"`
+	ToSite int32 `json:"to_site"`
+}
+
+type Module struct {
+	Version int `json:"version"`
+	// Kinds[id] is the kind of node id
+	Kinds []Kind `json:"kinds"`
+	Spaces []Space `json:"spaces"`
+	Agents []Agent `json:"agents"`
+	Tasks []Task `json:"tasks"`
+	Paths []Path `json:"paths"`
+	// the routing table, as resolved by parse.GetRoutingTable
+	Instances []Instance `json:"instances"`
+	Routes []Route `json:"routes"`
+	// node ids in dependency order
+	Order []uint32 `json:"order"`
+}
+
+func (m *Module) Space(id uint32) *Space {
+	for i := range m.Spaces {
+		if m.Spaces[i].ID == id {
+			return &m.Spaces[i]
+		}
+	}
+	return nil
+}
+
+func (m *Module) Agent(id uint32) *Agent {
+	for i := range m.Agents {
+		if m.Agents[i].ID == id {
+			return &m.Agents[i]
+		}
+	}
+	return nil
+}
+
+func (m *Module) Task(id uint32) *Task {
+	for i := range m.Tasks {
+		if m.Tasks[i].ID == id {
+			return &m.Tasks[i]
+		}
+	}
+	return nil
+}
+
+func (m *Module) Path(id uint32) *Path {
+	for i := range m.Paths {
+		if m.Paths[i].ID == id {
+			return &m.Paths[i]
+		}
+	}
+	return nil
+}
+
+// Lookup finds a node by kind and name. Agents and tasks of different spaces
+// may share a name, in which case it finds the first; see Child.
+func (m *Module) Lookup(kind Kind, name string) (uint32, bool) {
+	switch kind {
+	case KindSpace:
+		for _, s := range m.Spaces {
+			if s.Name == name {
+				return s.ID, true
+			}
+		}
+	case KindAgent:
+		for _, a := range m.Agents {
+			if a.Name == name {
+				return a.ID, true
+			}
+		}
+	case KindTask:
+		for _, t := range m.Tasks {
+			if t.Name == name {
+				return t.ID, true
+			}
+		}
+	case KindPath:
+		for _, p := range m.Paths {
+			if p.Name == name {
+				return p.ID, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// Child finds an agent or task of a space by name
+func (m *Module) Child(space uint32, kind Kind, name string) (uint32, bool) {
+	s := m.Space(space)
+	if s == nil {
+		return 0, false
+	}
+	if kind == KindAgent {
+		for _, id := range s.Agents {
+			if a := m.Agent(id); a != nil && a.Name == name {
+				return id, true
+			}
+		}
+	}
+	if kind == KindTask {
+		for _, id := range s.Tasks {
+			if t := m.Task(id); t != nil && t.Name == name {
+				return id, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// InstancesOf lists the sites of a space's instances
+func (m *Module) InstancesOf(space uint32) []int32 {
+	var sites []int32
+	for _, inst := range m.Instances {
+		if inst.Space == space {
+			sites = append(sites, inst.Site)
+		}
+	}
+	return sites
+}
+
+// Route finds the site a message over a path arrives at when sent from the
+// instance in from_site
+func (m *Module) Route(path uint32, from_site int32) (int32, bool) {
+	for _, r := range m.Routes {
+		if r.Path == path && r.FromSite == from_site {
+			return r.ToSite, true
+		}
+	}
+	return NoSite, false
+}
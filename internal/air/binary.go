@@ -0,0 +1,359 @@
+package air
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Compact binary form: the magic "AIR\x00", then the module as a flat sequence of
+// uvarints (ids, counts, lengths), varints (parents, sites), single bytes (kinds,
+// bools) and length-prefixed strings, in the field order of the types in air.go.
+
+var magic = []byte("AIR\x00")
+
+func DecodeJSON(data []byte) (*Module, error) {
+	var m Module
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Decode reads either form, telling them apart by the binary magic
+func Decode(data []byte) (*Module, error) {
+	if len(data) >= len(magic) && string(data[:len(magic)]) == string(magic) {
+		return DecodeBinary(data)
+	}
+	return DecodeJSON(data)
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *encoder) int(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+func (e *encoder) bool(b bool) {
+	if b {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *encoder) str(s string) {
+	e.uint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) strs(ss []string) {
+	e.uint(uint64(len(ss)))
+	for _, s := range ss {
+		e.str(s)
+	}
+}
+
+func (e *encoder) ids(ids []uint32) {
+	e.uint(uint64(len(ids)))
+	for _, id := range ids {
+		e.uint(uint64(id))
+	}
+}
+
+func (e *encoder) params(ps []Param) {
+	e.uint(uint64(len(ps)))
+	for _, p := range ps {
+		e.str(p.Name)
+		e.bool(p.In)
+	}
+}
+
+func (e *encoder) node(n *Node) {
+	e.uint(uint64(n.ID))
+	e.str(n.Name)
+	e.strs(n.Vibe)
+	e.ids(n.Imports)
+	e.ids(n.Deps)
+}
+
+func (m *Module) MarshalBinary() ([]byte, error) {
+	e := encoder{buf: append([]byte{}, magic...)}
+	e.uint(uint64(m.Version))
+	e.uint(uint64(len(m.Kinds)))
+	for _, k := range m.Kinds {
+		e.buf = append(e.buf, byte(k))
+	}
+
+	e.uint(uint64(len(m.Spaces)))
+	for i := range m.Spaces {
+		s := &m.Spaces[i]
+		e.node(&s.Node)
+		e.str(s.Type)
+		e.bool(s.Replicable)
+		e.int(int64(s.Parent))
+		e.params(s.Params)
+		e.ids(s.Agents)
+		e.ids(s.Tasks)
+		e.ids(s.Spaces)
+		e.ids(s.Paths)
+	}
+	e.uint(uint64(len(m.Agents)))
+	for i := range m.Agents {
+		a := &m.Agents[i]
+		e.node(&a.Node)
+		e.str(a.Type)
+		e.int(int64(a.Parent))
+		e.params(a.Params)
+	}
+	e.uint(uint64(len(m.Tasks)))
+	for i := range m.Tasks {
+		t := &m.Tasks[i]
+		e.node(&t.Node)
+		e.int(int64(t.Parent))
+		e.params(t.Params)
+	}
+	e.uint(uint64(len(m.Paths)))
+	for i := range m.Paths {
+		p := &m.Paths[i]
+		e.node(&p.Node)
+		e.str(p.Type)
+		e.str(p.Access)
+		e.int(int64(p.Parent))
+		e.uint(uint64(p.Source))
+		e.uint(uint64(p.Dest))
+	}
+	e.uint(uint64(len(m.Instances)))
+	for _, inst := range m.Instances {
+		e.uint(uint64(inst.Space))
+		e.int(int64(inst.Site))
+	}
+	e.uint(uint64(len(m.Routes)))
+	for _, r := range m.Routes {
+		e.uint(uint64(r.Path))
+		e.int(int64(r.FromSite))
+		e.int(int64(r.ToSite))
+	}
+	e.ids(m.Order)
+	return e.buf, nil
+}
+
+var ErrTruncated = errors.New("air: truncated binary module")
+
+// decoder remembers the first error; later reads return zero values
+type decoder struct {
+	data []byte
+	err error
+}
+
+func (d *decoder) uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = ErrTruncated
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) int() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = ErrTruncated
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) == 0 {
+		d.err = ErrTruncated
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) bool() bool {
+	return d.byte() != 0
+}
+
+// count reads a length and checks that at least that many bytes remain, so
+// corrupt input can't make us allocate huge slices
+func (d *decoder) count() int {
+	n := d.uint()
+	if n > uint64(len(d.data)) {
+		d.err = ErrTruncated
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) str() string {
+	n := d.count()
+	if d.err != nil {
+		return ""
+	}
+	s := string(d.data[:n])
+	d.data = d.data[n:]
+	return s
+}
+
+func (d *decoder) strs() []string {
+	n := d.count()
+	if n == 0 {
+		return nil
+	}
+	ss := make([]string, n)
+	for i := range ss {
+		ss[i] = d.str()
+	}
+	return ss
+}
+
+func (d *decoder) id() uint32 {
+	v := d.uint()
+	if v > 0xffffffff {
+		d.err = fmt.Errorf("air: node id %d out of range", v)
+		return 0
+	}
+	return uint32(v)
+}
+
+func (d *decoder) parent() int32 {
+	v := d.int()
+	if v < int64(NoParent) || v > 0x7fffffff {
+		d.err = fmt.Errorf("air: parent %d out of range", v)
+		return NoParent
+	}
+	return int32(v)
+}
+
+func (d *decoder) ids() []uint32 {
+	n := d.count()
+	if n == 0 {
+		return nil
+	}
+	ids := make([]uint32, n)
+	for i := range ids {
+		ids[i] = d.id()
+	}
+	return ids
+}
+
+func (d *decoder) params() []Param {
+	n := d.count()
+	if n == 0 {
+		return nil
+	}
+	ps := make([]Param, n)
+	for i := range ps {
+		ps[i].Name = d.str()
+		ps[i].In = d.bool()
+	}
+	return ps
+}
+
+func (d *decoder) node() Node {
+	return Node{
+		ID: d.id(),
+		Name: d.str(),
+		Vibe: d.strs(),
+		Imports: d.ids(),
+		Deps: d.ids(),
+	}
+}
+
+func DecodeBinary(data []byte) (*Module, error) {
+	if len(data) < len(magic) || string(data[:len(magic)]) != string(magic) {
+		return nil, errors.New("air: not a binary AIR module")
+	}
+	d := decoder{data: data[len(magic):]}
+	m := Module{}
+	m.Version = int(d.uint())
+	if d.err == nil && m.Version != Version {
+		return nil, fmt.Errorf("air: unsupported version %d", m.Version)
+	}
+
+	m.Kinds = make([]Kind, d.count())
+	for i := range m.Kinds {
+		m.Kinds[i] = Kind(d.byte())
+	}
+
+	m.Spaces = make([]Space, d.count())
+	for i := range m.Spaces {
+		s := &m.Spaces[i]
+		s.Node = d.node()
+		s.Type = d.str()
+		s.Replicable = d.bool()
+		s.Parent = d.parent()
+		s.Params = d.params()
+		s.Agents = d.ids()
+		s.Tasks = d.ids()
+		s.Spaces = d.ids()
+		s.Paths = d.ids()
+	}
+	m.Agents = make([]Agent, d.count())
+	for i := range m.Agents {
+		a := &m.Agents[i]
+		a.Node = d.node()
+		a.Type = d.str()
+		a.Parent = d.parent()
+		a.Params = d.params()
+	}
+	m.Tasks = make([]Task, d.count())
+	for i := range m.Tasks {
+		t := &m.Tasks[i]
+		t.Node = d.node()
+		t.Parent = d.parent()
+		t.Params = d.params()
+	}
+	m.Paths = make([]Path, d.count())
+	for i := range m.Paths {
+		p := &m.Paths[i]
+		p.Node = d.node()
+		p.Type = d.str()
+		p.Access = d.str()
+		p.Parent = d.parent()
+		p.Source = d.id()
+		p.Dest = d.id()
+	}
+	m.Instances = make([]Instance, d.count())
+	for i := range m.Instances {
+		m.Instances[i].Space = d.id()
+		m.Instances[i].Site = d.parent()
+	}
+	m.Routes = make([]Route, d.count())
+	for i := range m.Routes {
+		m.Routes[i].Path = d.id()
+		m.Routes[i].FromSite = d.parent()
+		m.Routes[i].ToSite = d.parent()
+	}
+	m.Order = d.ids()
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.data) != 0 {
+		return nil, fmt.Errorf("air: %d trailing bytes after module", len(d.data))
+	}
+	return &m, nil
+}
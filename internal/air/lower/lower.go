@@ -0,0 +1,196 @@
+package lower
+
+import (
+	"fmt"
+
+	"github.com/anotherLostKitten/Anglish/internal/air"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+func params(ps []parse.Param) []air.Param {
+	if len(ps) == 0 {
+		return nil
+	}
+	out := make([]air.Param, len(ps))
+	for i := range ps {
+		out[i] = air.Param{
+			Name: ps[i].GetDataName(),
+			In: ps[i].IsIn(),
+		}
+	}
+	return out
+}
+
+func ids(ns []uint64) []uint32 {
+	if len(ns) == 0 {
+		return nil
+	}
+	out := make([]uint32, len(ns))
+	for i, n := range ns {
+		out[i] = uint32(n)
+	}
+	return out
+}
+
+type lowerer struct {
+	po *parse.ParseOrder
+	m *air.Module
+	parents []int32
+	errs []error
+}
+
+func (l *lowerer) resolve(from uint64, id parse.Ident) uint32 {
+	n, ok := l.po.Resolve(from, id)
+	if !ok {
+		l.errs = append(l.errs, fmt.Errorf("%s refers to undeclared %s", l.po.GetQualifiedName(from), id.ToStr()))
+	}
+	return uint32(n)
+}
+
+// children lists the declarations of one kind made in a space
+func (l *lowerer) children(space uint64, kind air.Kind) []uint32 {
+	var out []uint32
+	for _, c := range l.po.GetChildIDs(space) {
+		var k air.Kind
+		switch l.po.GetNode(c).(type) {
+		case *parse.SpaceDecl: k = air.KindSpace
+		case *parse.AgentDecl: k = air.KindAgent
+		case *parse.TaskDecl: k = air.KindTask
+		case *parse.PathDecl: k = air.KindPath
+		}
+		if k == kind {
+			out = append(out, uint32(c))
+		}
+	}
+	return out
+}
+
+// id finds a name the routing table has already resolved
+func (l *lowerer) id(name parse.Ident) uint32 {
+	n, _ := l.po.Lookup(name)
+	return uint32(n)
+}
+
+func (l *lowerer) site(inst parse.SpaceInstance) int32 {
+	if inst.IsTopLevel() {
+		return air.NoSite
+	}
+	return int32(l.id(inst.Site))
+}
+
+func (l *lowerer) node(id uint64, u parse.ParseUnit) air.Node {
+	n := air.Node{
+		ID: uint32(id),
+		Name: u.GetName().GetIdent(),
+		Deps: ids(l.po.GetDeps(id)),
+	}
+	prose := u.GetVibe().GetProse()
+	if len(prose) > 0 {
+		n.Vibe = append([]string{}, prose...)
+	}
+	for _, mr := range u.GetVibe().GetMetaRefs() {
+		if use, ok := mr.(*parse.MetaRefUseImport); ok {
+			n.Imports = append(n.Imports, l.resolve(id, use.GetImported()))
+		}
+	}
+	return n
+}
+
+// Lower builds the AIR for a resolved contract. Node ids are the ParseOrder's.
+// Any reference that does not resolve is reported and the module is not usable.
+func Lower(po *parse.ParseOrder) (*air.Module, []error) {
+	l := lowerer{
+		po: po,
+		m: &air.Module{
+			Version: air.Version,
+			Kinds: make([]air.Kind, po.Len()),
+			Spaces: []air.Space{},
+			Agents: []air.Agent{},
+			Tasks: []air.Task{},
+			Paths: []air.Path{},
+			Instances: []air.Instance{},
+			Routes: []air.Route{},
+		},
+		parents: make([]int32, po.Len()),
+	}
+	for i := range l.parents {
+		l.parents[i] = air.NoParent
+	}
+
+	// parents first, so the declarations below can pick them up
+	for i := 0; i < po.Len(); i++ {
+		if _, ok := po.GetNode(uint64(i)).(*parse.SpaceDecl); !ok {
+			continue
+		}
+		for _, c := range po.GetChildIDs(uint64(i)) {
+			l.parents[c] = int32(i)
+		}
+	}
+
+	for i := 0; i < po.Len(); i++ {
+		id := uint64(i)
+		switch d := po.GetNode(id).(type) {
+		case *parse.SpaceDecl:
+			l.m.Kinds[i] = air.KindSpace
+			s := air.Space{
+				Node: l.node(id, d),
+				Type: d.GetSpaceType().ToStr(),
+				Replicable: d.IsReplicable(),
+				Parent: l.parents[i],
+				Params: params(d.GetParams()),
+			}
+			s.Agents = l.children(id, air.KindAgent)
+			s.Tasks = l.children(id, air.KindTask)
+			s.Spaces = l.children(id, air.KindSpace)
+			s.Paths = l.children(id, air.KindPath)
+			l.m.Spaces = append(l.m.Spaces, s)
+		case *parse.AgentDecl:
+			l.m.Kinds[i] = air.KindAgent
+			l.m.Agents = append(l.m.Agents, air.Agent{
+				Node: l.node(id, d),
+				Type: d.GetAgentType().ToStr(),
+				Parent: l.parents[i],
+				Params: params(d.GetParams()),
+			})
+		case *parse.TaskDecl:
+			l.m.Kinds[i] = air.KindTask
+			l.m.Tasks = append(l.m.Tasks, air.Task{
+				Node: l.node(id, d),
+				Parent: l.parents[i],
+				Params: params(d.GetParams()),
+			})
+		case *parse.PathDecl:
+			l.m.Kinds[i] = air.KindPath
+			l.m.Paths = append(l.m.Paths, air.Path{
+				Node: l.node(id, d),
+				Type: d.GetPathType().ToStr(),
+				Access: d.GetAccess().ToStr(),
+				Parent: l.parents[i],
+				Source: l.resolve(id, d.GetSource()),
+				Dest: l.resolve(id, d.GetDest()),
+			})
+		}
+	}
+
+	// ambiguous routes are left out; the runtime refuses to send over them
+	rt := parse.GetRoutingTable(po)
+	for _, inst := range rt.GetInstances() {
+		l.m.Instances = append(l.m.Instances, air.Instance{
+			Space: l.id(inst.Space),
+			Site: l.site(inst),
+		})
+	}
+	for _, r := range rt.GetRoutes() {
+		l.m.Routes = append(l.m.Routes, air.Route{
+			Path: l.id(r.Path),
+			FromSite: l.site(r.From),
+			ToSite: l.site(r.To),
+		})
+	}
+
+	l.m.Order = ids(po.GetSorted())
+	if len(l.m.Order) != po.Len() {
+		l.errs = append(l.errs, fmt.Errorf("dependency order is incomplete: %d of %d nodes sorted", len(l.m.Order), po.Len()))
+	}
+	return l.m, l.errs
+}
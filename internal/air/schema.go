@@ -0,0 +1,11 @@
+package air
+
+import (
+	_ "embed"
+)
+
+// Schema is the JSON Schema for the JSON form of a Module. It describes shape
+// only; Validate also checks that references resolve.
+//
+//go:embed schema.json
+var Schema []byte
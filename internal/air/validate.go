@@ -0,0 +1,273 @@
+package air
+
+import (
+	"fmt"
+)
+
+func oneOf(val string, allowed []string) bool {
+	for _, a := range allowed {
+		if val == a {
+			return true
+		}
+	}
+	return false
+}
+
+type validator struct {
+	m *Module
+	errs []error
+	seen []bool
+	// names are unique per kind and parent space: two spaces may each have a $init
+	names map[Kind]map[int32]map[string]bool
+}
+
+func (v *validator) errorf(format string, args ...any) {
+	v.errs = append(v.errs, fmt.Errorf(format, args...))
+}
+
+func (v *validator) ref(from string, id uint32, want ...Kind) {
+	if int(id) >= len(v.m.Kinds) {
+		v.errorf("%s refers to node %d, which does not exist", from, id)
+		return
+	}
+	for _, k := range want {
+		if v.m.Kinds[id] == k {
+			return
+		}
+	}
+	v.errorf("%s refers to node %d, a %s", from, id, v.m.Kinds[id].ToStr())
+}
+
+func (v *validator) node(n *Node, kind Kind, parent int32) string {
+	from := fmt.Sprintf("%s %q (node %d)", kind.ToStr(), n.Name, n.ID)
+	if int(n.ID) >= len(v.m.Kinds) {
+		v.errorf("%s has an id outside of kinds", from)
+		return from
+	}
+	if v.m.Kinds[n.ID] != kind {
+		v.errorf("%s is listed as a %s in kinds", from, v.m.Kinds[n.ID].ToStr())
+	}
+	if v.seen[n.ID] {
+		v.errorf("%s reuses an id", from)
+	}
+	v.seen[n.ID] = true
+
+	if n.Name == "" {
+		v.errorf("%s has no name", from)
+	} else if v.names[kind][parent][n.Name] {
+		v.errorf("%s is declared more than once", from)
+	}
+	if v.names[kind][parent] == nil {
+		v.names[kind][parent] = make(map[string]bool)
+	}
+	v.names[kind][parent][n.Name] = true
+
+	deps := make(map[uint32]bool)
+	for _, d := range n.Deps {
+		v.ref(from+" dependency", d, KindSpace, KindAgent, KindTask, KindPath)
+		deps[d] = true
+	}
+	for _, imp := range n.Imports {
+		v.ref(from+" import", imp, KindSpace, KindAgent)
+		if !deps[imp] {
+			v.errorf("%s imports node %d but does not depend on it", from, imp)
+		}
+	}
+	return from
+}
+
+func (v *validator) parent(from string, parent int32, child uint32, kind Kind) {
+	if parent == NoParent {
+		return
+	}
+	if parent < 0 {
+		v.errorf("%s has parent %d", from, parent)
+		return
+	}
+	v.ref(from+" parent", uint32(parent), KindSpace)
+	s := v.m.Space(uint32(parent))
+	if s == nil {
+		return
+	}
+	var children []uint32
+	switch kind {
+	case KindSpace: children = s.Spaces
+	case KindAgent: children = s.Agents
+	case KindTask: children = s.Tasks
+	case KindPath: children = s.Paths
+	}
+	for _, c := range children {
+		if c == child {
+			return
+		}
+	}
+	v.errorf("%s is not listed by its parent space %q", from, s.Name)
+}
+
+// nesting checks that a space is not nested, however deeply, in itself
+func (v *validator) nesting(from string, s *Space) {
+	parent := s.Parent
+	for steps := 0; parent != NoParent; steps++ {
+		if parent == int32(s.ID) || steps > len(v.m.Spaces) {
+			v.errorf("%s is nested in itself", from)
+			return
+		}
+		p := v.m.Space(uint32(parent))
+		if p == nil {
+			return
+		}
+		parent = p.Parent
+	}
+}
+
+func (v *validator) site(from string, site int32) {
+	if site == NoSite {
+		return
+	}
+	if site < 0 {
+		v.errorf("%s has site %d", from, site)
+		return
+	}
+	v.ref(from+" site", uint32(site), KindSpace)
+}
+
+// routes checks the routing table: every space has an instance, and a route
+// joins an instance of its path's source to one of its destination.
+func (v *validator) routes() {
+	type inst struct {
+		space uint32
+		site int32
+	}
+	insts := make(map[inst]bool)
+	for _, i := range v.m.Instances {
+		from := fmt.Sprintf("instance of node %d in site %d", i.Space, i.Site)
+		v.ref(from, i.Space, KindSpace)
+		v.site(from, i.Site)
+		if insts[inst{i.Space, i.Site}] {
+			v.errorf("%s is listed more than once", from)
+		}
+		insts[inst{i.Space, i.Site}] = true
+	}
+	for i := range v.m.Spaces {
+		s := &v.m.Spaces[i]
+		if len(v.m.InstancesOf(s.ID)) == 0 {
+			v.errorf("space %q (node %d) has no instances", s.Name, s.ID)
+		}
+	}
+
+	type key struct {
+		path uint32
+		from_site int32
+	}
+	routed := make(map[key]bool)
+	for _, r := range v.m.Routes {
+		from := fmt.Sprintf("route of node %d from site %d", r.Path, r.FromSite)
+		v.ref(from, r.Path, KindPath)
+		p := v.m.Path(r.Path)
+		if p == nil {
+			continue
+		}
+		// bad endpoints are reported with the path
+		if !insts[inst{p.Source, r.FromSite}] && v.m.Space(p.Source) != nil {
+			v.errorf("%s starts at no instance of its source", from)
+		}
+		if !insts[inst{p.Dest, r.ToSite}] && v.m.Space(p.Dest) != nil {
+			v.errorf("%s ends at no instance of its destination", from)
+		}
+		if routed[key{r.Path, r.FromSite}] {
+			v.errorf("%s is listed more than once", from)
+		}
+		routed[key{r.Path, r.FromSite}] = true
+	}
+}
+
+// Validate checks that a Module is internally consistent: ids, kinds, names and
+// references all line up and Order visits every node once. It returns every
+// problem found rather than stopping at the first.
+func Validate(m *Module) []error {
+	v := validator{
+		m: m,
+		seen: make([]bool, len(m.Kinds)),
+		names: map[Kind]map[int32]map[string]bool{
+			KindSpace: {},
+			KindAgent: {},
+			KindTask: {},
+			KindPath: {},
+		},
+	}
+	if m.Version != Version {
+		v.errorf("unsupported AIR version %d, expected %d", m.Version, Version)
+	}
+
+	for i := range m.Spaces {
+		s := &m.Spaces[i]
+		from := v.node(&s.Node, KindSpace, s.Parent)
+		if !oneOf(s.Type, SpaceTypes) {
+			v.errorf("%s has unknown type %q", from, s.Type)
+		}
+		v.parent(from, s.Parent, s.ID, KindSpace)
+		v.nesting(from, s)
+		for _, a := range s.Agents {
+			v.ref(from+" agent", a, KindAgent)
+		}
+		for _, t := range s.Tasks {
+			v.ref(from+" task", t, KindTask)
+		}
+		for _, sub := range s.Spaces {
+			v.ref(from+" subspace", sub, KindSpace)
+		}
+		for _, p := range s.Paths {
+			v.ref(from+" path", p, KindPath)
+		}
+	}
+	for i := range m.Agents {
+		a := &m.Agents[i]
+		from := v.node(&a.Node, KindAgent, a.Parent)
+		if !oneOf(a.Type, AgentTypes) {
+			v.errorf("%s has unknown type %q", from, a.Type)
+		}
+		v.parent(from, a.Parent, a.ID, KindAgent)
+	}
+	for i := range m.Tasks {
+		t := &m.Tasks[i]
+		from := v.node(&t.Node, KindTask, t.Parent)
+		v.parent(from, t.Parent, t.ID, KindTask)
+	}
+	for i := range m.Paths {
+		p := &m.Paths[i]
+		from := v.node(&p.Node, KindPath, p.Parent)
+		if !oneOf(p.Type, PathTypes) {
+			v.errorf("%s has unknown type %q", from, p.Type)
+		}
+		v.parent(from, p.Parent, p.ID, KindPath)
+		if !oneOf(p.Access, PathAccesses) {
+			v.errorf("%s has unknown access %q", from, p.Access)
+		}
+		v.ref(from+" source", p.Source, KindSpace)
+		v.ref(from+" destination", p.Dest, KindSpace)
+	}
+
+	v.routes()
+
+	for id, seen := range v.seen {
+		if !seen {
+			v.errorf("node %d (%s) is in kinds but not declared", id, m.Kinds[id].ToStr())
+		}
+	}
+
+	if len(m.Order) != len(m.Kinds) {
+		v.errorf("order has %d nodes, expected %d", len(m.Order), len(m.Kinds))
+	}
+	ordered := make([]bool, len(m.Kinds))
+	for _, id := range m.Order {
+		if int(id) >= len(m.Kinds) {
+			v.errorf("order refers to node %d, which does not exist", id)
+			continue
+		}
+		if ordered[id] {
+			v.errorf("order visits node %d more than once", id)
+		}
+		ordered[id] = true
+	}
+	return v.errs
+}
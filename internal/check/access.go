@@ -0,0 +1,114 @@
+package check
+
+import (
+	"fmt"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// checkAccess enforces rules.Access on every use of a =path: a vibe line that
+// names the path, in its source space or in the path itself, may only call
+// destination tasks whose %data moves the way the path's access tag allows.
+func checkAccess(p *parse.PathDecl, po *parse.ParseOrder, rules *SemanticRules) []Diagnostic {
+	var diags []Diagnostic
+	ar, ok := rules.Access[p.GetAccess()]
+	if rules.Violation == Off || !ok {
+		return diags
+	}
+	dest := lookupSpace(po, p.GetDest())
+	if dest == nil {
+		return diags
+	}
+
+	vibes := []*parse.VibeBlock{p.GetVibe()}
+	var src_inst *parse.Instantiation
+	if src := lookupSpace(po, p.GetSource()); src != nil {
+		src_inst = src.GetInstantiation()
+		vibes = append(vibes, src.GetVibe())
+		for i := range src.GetAgents() {
+			vibes = append(vibes, src.GetAgents()[i].GetVibe())
+		}
+		for i := range src.GetTasks() {
+			vibes = append(vibes, src.GetTasks()[i].GetVibe())
+		}
+	}
+
+	for _, vb := range vibes {
+		own := vb == p.GetVibe()
+		for _, mr := range vb.GetMetaRefs() {
+			tr, ok := mr.(*parse.MetaRefTask)
+			// @other.$task is not a call over this path
+			if !ok || (tr.GetSpace() != "" && tr.GetSpace() != p.GetDest().GetIdent()) {
+				continue
+			}
+			line, _ := tr.GetPos()
+			if !own && !linePaths(vb, line)[p.GetName().GetIdent()] {
+				continue
+			}
+			t := lookupTask(dest, tr.GetIdent())
+			if t == nil {
+				continue
+			}
+			params := append(append([]parse.Param{}, t.GetParams()...), tr.GetArgs()...)
+			reads := false
+			for _, param := range params {
+				reads = reads || !param.IsIn()
+			}
+			for _, param := range params {
+				var verb string
+				switch {
+				case param.IsIn() && (!ar.AllowIn || ar.Request && !reads): verb = "writes %" + param.GetDataName() + " into"
+				case !param.IsIn() && !ar.AllowOut: verb = "reads %" + param.GetDataName() + " from"
+				default: continue
+				}
+				inst := p.GetInstantiation()
+				if !own {
+					inst = src_inst
+				}
+				diags = append(diags, Diagnostic{
+					Severity: rules.Violation,
+					Rule: "path-access",
+					Line: line,
+					Instantiation: inst,
+					Msg: fmt.Sprintf("%s is :%s, but %s %s %s",
+						p.GetName().ToStr(), p.GetAccess().ToStr(), t.GetName().ToStr(), verb, p.GetDest().ToStr()),
+				})
+				break
+			}
+		}
+	}
+	return diags
+}
+
+// linePaths lists the =paths named on one line of a vibe block
+func linePaths(vb *parse.VibeBlock, line uint64) map[string]bool {
+	paths := make(map[string]bool)
+	for _, mr := range vb.GetMetaRefs() {
+		pr, ok := mr.(*parse.MetaRefPath)
+		if !ok {
+			continue
+		}
+		if l, _ := pr.GetPos(); l == line {
+			paths[pr.GetIdent()] = true
+		}
+	}
+	return paths
+}
+
+func lookupSpace(po *parse.ParseOrder, id parse.Ident) *parse.SpaceDecl {
+	i, ok := po.Lookup(id)
+	if !ok {
+		return nil
+	}
+	s, _ := po.GetNode(i).(*parse.SpaceDecl)
+	return s
+}
+
+func lookupTask(s *parse.SpaceDecl, name string) *parse.TaskDecl {
+	for i := range s.GetTasks() {
+		if s.GetTasks()[i].GetName().GetIdent() == name {
+			return &s.GetTasks()[i]
+		}
+	}
+	return nil
+}
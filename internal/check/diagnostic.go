@@ -0,0 +1,75 @@
+package check
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+type Severity byte
+const (
+	Off Severity = iota
+	Info
+	Warning
+	Error
+)
+
+func (s Severity) ToStr() string {
+	switch s {
+	case Off: return "off"
+	case Info: return "info"
+	case Warning: return "warning"
+	case Error: return "error"
+	default: return "???"
+	}
+}
+
+func ParseSeverity(str string) (Severity, bool) {
+	switch str {
+	case "off": return Off, true
+	case "info": return Info, true
+	case "warning", "warn": return Warning, true
+	case "error": return Error, true
+	default: return Off, false
+	}
+}
+
+// Diagnostic is a problem found after parsing; unlike parse.ParserErrorInfo it
+// carries a severity and the name of the rule that raised it.
+type Diagnostic struct {
+	Severity Severity
+	Rule string
+	Line, Col uint64
+	Msg string
+	// for a problem in a declaration expanded from a template, the
+	// instantiation that expanded it; Line and Col are in the template
+	Instantiation *parse.Instantiation
+}
+
+func PrintDiagnostic(d Diagnostic) {
+	fmt.Printf("%s at (%d, %d): %s [%s]\n", d.Severity.ToStr(), d.Line, d.Col, d.Msg, d.Rule)
+	if d.Instantiation != nil {
+		fmt.Printf("\t%s\n", d.Instantiation.Note())
+	}
+}
+
+func HasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+// SortDiagnostics puts diags in the order of where they are in the contract,
+// keeping the order they were raised in for those in the same place
+func SortDiagnostics(diags []Diagnostic) {
+	sort.SliceStable(diags, func(i, j int) bool {
+		if diags[i].Line != diags[j].Line {
+			return diags[i].Line < diags[j].Line
+		}
+		return diags[i].Col < diags[j].Col
+	})
+}
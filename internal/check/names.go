@@ -0,0 +1,33 @@
+package check
+
+import (
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// Names reports the problems parse.GetParseOrder found with the names of a
+// contract: names nothing declares, names declared twice, and dependency
+// cycles. Task references that do not resolve are checkTaskRefs'.
+func Names(po *parse.ParseOrder, rules *SemanticRules) []Diagnostic {
+	var diags []Diagnostic
+	for _, p := range po.GetProblems() {
+		sev, rule := rules.Violation, ""
+		switch p.Kind {
+		case parse.NameUndeclared: rule = "name-undeclared"
+		case parse.NameDuplicate: rule = "name-duplicate"
+		case parse.NameCycle: sev, rule = rules.DependencyCycle, "dependency-cycle"
+		}
+		if sev == Off {
+			continue
+		}
+		node := po.GetNode(p.Node)
+		line, _ := node.GetLines()
+		diags = append(diags, Diagnostic{
+			Severity: sev,
+			Rule: rule,
+			Line: line,
+			Instantiation: node.GetInstantiation(),
+			Msg: p.ToStr(),
+		})
+	}
+	return diags
+}
@@ -0,0 +1,75 @@
+package check
+
+import (
+	"fmt"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// checkNesting keeps nested spaces isolated from the spaces around them: a
+// subspace may not $use() any of its ancestors, nor be the source of a =path
+// into one. Parents reach down into their subspaces, never the other way.
+func checkNesting(c *parse.Contract, po *parse.ParseOrder, rules *SemanticRules) []Diagnostic {
+	var diags []Diagnostic
+	if rules.Violation == Off {
+		return diags
+	}
+	ancestors := func(id parse.Ident) map[parse.Ident]bool {
+		found := make(map[parse.Ident]bool)
+		i, ok := po.Lookup(id)
+		if !ok {
+			return found
+		}
+		for _, a := range po.GetAncestors(i) {
+			found[po.GetNode(a).GetName()] = true
+		}
+		return found
+	}
+
+	for _, s := range c.AllSpaces() {
+		up := ancestors(s.GetName())
+		if len(up) == 0 {
+			continue
+		}
+		vibes := []*parse.VibeBlock{s.GetVibe()}
+		for i := range s.GetAgents() {
+			vibes = append(vibes, s.GetAgents()[i].GetVibe())
+		}
+		for i := range s.GetTasks() {
+			vibes = append(vibes, s.GetTasks()[i].GetVibe())
+		}
+		for _, vb := range vibes {
+			for _, mr := range vb.GetMetaRefs() {
+				use, ok := mr.(*parse.MetaRefUseImport)
+				if !ok || !up[use.GetImported()] {
+					continue
+				}
+				line, _ := use.GetPos()
+				diags = append(diags, Diagnostic{
+					Severity: rules.Violation,
+					Rule: "subspace-isolation",
+					Line: line,
+					Instantiation: s.GetInstantiation(),
+					Msg: fmt.Sprintf("%s is nested in %s and may not $use() it",
+						s.GetName().ToStr(), use.GetImported().ToStr()),
+				})
+			}
+		}
+	}
+
+	for _, p := range c.AllPaths() {
+		if !ancestors(p.GetSource())[p.GetDest()] {
+			continue
+		}
+		line, _ := p.GetLines()
+		diags = append(diags, Diagnostic{
+			Severity: rules.Violation,
+			Rule: "subspace-isolation",
+			Line: line,
+			Instantiation: p.GetInstantiation(),
+			Msg: fmt.Sprintf("%s leads from %s back into %s, which it is nested in",
+				p.GetName().ToStr(), p.GetSource().ToStr(), p.GetDest().ToStr()),
+		})
+	}
+	return diags
+}
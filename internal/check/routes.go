@@ -0,0 +1,48 @@
+package check
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// Routes reports the problems parse.GetRoutingTable found: paths that could
+// reach more than one instance of their destination, and instances no path
+// reaches.
+func Routes(rt *parse.RoutingTable, rules *SemanticRules) []Diagnostic {
+	var diags []Diagnostic
+	for _, p := range rt.GetProblems() {
+		switch p.Kind {
+		case parse.RouteAmbiguous:
+			if rules.AmbiguousRoute == Off {
+				continue
+			}
+			var cands []string
+			for _, c := range p.Candidates {
+				cands = append(cands, c.ToStr())
+			}
+			diags = append(diags, Diagnostic{
+				Severity: rules.AmbiguousRoute,
+				Rule: "route-ambiguous",
+				Line: p.Line,
+				Instantiation: p.Instantiation,
+				Msg: fmt.Sprintf("%s from %s could reach any of %s; $use() the destination in the sending space to pick one",
+					p.Path.ToStr(), p.Instance.ToStr(), strings.Join(cands, ", ")),
+			})
+		case parse.RouteUnreachable:
+			if rules.UnreachableRoute == Off {
+				continue
+			}
+			diags = append(diags, Diagnostic{
+				Severity: rules.UnreachableRoute,
+				Rule: "route-unreachable",
+				Line: p.Line,
+				Instantiation: p.Instantiation,
+				Msg: fmt.Sprintf("%s never reaches %s, although it reaches other instances of %s",
+					p.Path.ToStr(), p.Instance.ToStr(), p.Instance.Space.ToStr()),
+			})
+		}
+	}
+	return diags
+}
@@ -0,0 +1,77 @@
+package check
+
+import (
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// This file is the language's semantic constraint table. It is plain data read
+// by Semantic(); change the rules here rather than in the parser.
+
+// SpaceTypeRule lists what a @space of one SpaceType may contain.
+type SpaceTypeRule struct {
+	AllowAgents bool
+	AllowTasks bool
+	AllowReplicable bool
+}
+
+// PathTypeRule restricts the endpoints of a =path of one PathType. An empty
+// list permits any space type.
+type PathTypeRule struct {
+	SourceTypes []parse.SpaceType
+	DestTypes []parse.SpaceType
+}
+
+// PathAccessRule says which way %data may move over a =path with one access
+// tag: in params carry it into the destination, out params back out. With
+// Request set, in params are only allowed as the request for a task that
+// brings data back out.
+type PathAccessRule struct {
+	AllowIn bool
+	AllowOut bool
+	Request bool
+}
+
+type SemanticRules struct {
+	// severity of a @space declared without a space type tag
+	MissingSpaceType Severity
+	// severity of breaking any rule in Spaces or Paths
+	Violation Severity
+	// severities of routing table problems, see parse.GetRoutingTable
+	AmbiguousRoute Severity
+	UnreachableRoute Severity
+	// severity of a dependency cycle, which nothing in it can be generated past
+	DependencyCycle Severity
+
+	Spaces map[parse.SpaceType]SpaceTypeRule
+	Paths map[parse.PathType]PathTypeRule
+	Access map[parse.PathAccess]PathAccessRule
+}
+
+var DefaultRules = SemanticRules{
+	MissingSpaceType: Warning,
+	Violation: Error,
+	AmbiguousRoute: Error,
+	UnreachableRoute: Warning,
+	DependencyCycle: Warning,
+
+	Spaces: map[parse.SpaceType]SpaceTypeRule{
+		parse.UnknownSpace: {AllowAgents: true, AllowTasks: true, AllowReplicable: true},
+		parse.UI:           {AllowAgents: true, AllowTasks: true, AllowReplicable: true},
+		parse.IO:           {AllowAgents: true, AllowTasks: true, AllowReplicable: false},
+		parse.DATA:         {AllowAgents: false, AllowTasks: true, AllowReplicable: false},
+		parse.CALL:         {AllowAgents: true, AllowTasks: true, AllowReplicable: true},
+		parse.CHAT:         {AllowAgents: true, AllowTasks: true, AllowReplicable: true},
+	},
+
+	Paths: map[parse.PathType]PathTypeRule{
+		parse.INVOKE: {DestTypes: []parse.SpaceType{parse.CALL}},
+		parse.ATTEND: {},
+	},
+
+	Access: map[parse.PathAccess]PathAccessRule{
+		parse.AnyAccess: {AllowIn: true, AllowOut: true},
+		parse.READ:      {AllowIn: true, AllowOut: true, Request: true},
+		parse.WRITE:     {AllowIn: true, AllowOut: false},
+		parse.CONTROL:   {AllowIn: false, AllowOut: false},
+	},
+}
@@ -0,0 +1,36 @@
+package check
+
+import (
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// checkTaskRefs reports each $task a vibe block calls that does not resolve
+// from where it is called: undeclared, private to another space with no =path
+// exposing it, or exposed by several paths and left unqualified. See
+// parse.ParseOrder.ResolveTask for the visibility rules.
+func checkTaskRefs(po *parse.ParseOrder, rules *SemanticRules) []Diagnostic {
+	var diags []Diagnostic
+	if rules.Violation == Off {
+		return diags
+	}
+	for i := 0; i < po.Len(); i++ {
+		node := po.GetNode(uint64(i))
+		for _, mr := range node.GetVibe().GetMetaRefs() {
+			tr, ok := mr.(*parse.MetaRefTask)
+			if !ok {
+				continue
+			}
+			if _, err := po.ResolveTask(uint64(i), tr); err != nil {
+				line, _ := tr.GetPos()
+				diags = append(diags, Diagnostic{
+					Severity: rules.Violation,
+					Rule: "task-visibility",
+					Line: line,
+					Instantiation: node.GetInstantiation(),
+					Msg: err.Error(),
+				})
+			}
+		}
+	}
+	return diags
+}
@@ -0,0 +1,140 @@
+package check
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// Semantic enforces the space-type, path-type and path-access constraints in
+// rules, the isolation of nested spaces and the visibility of tasks, over a
+// contract that has already been through parse.GetParseOrder.
+func Semantic(c *parse.Contract, po *parse.ParseOrder, rules *SemanticRules) []Diagnostic {
+	var diags []Diagnostic
+
+	for _, s := range c.AllSpaces() {
+		diags = append(diags, checkSpace(s, rules)...)
+	}
+	for _, p := range c.AllPaths() {
+		diags = append(diags, checkPath(p, po, rules)...)
+		diags = append(diags, checkAccess(p, po, rules)...)
+	}
+	diags = append(diags, checkNesting(c, po, rules)...)
+	diags = append(diags, checkTaskRefs(po, rules)...)
+	return diags
+}
+
+func checkSpace(s *parse.SpaceDecl, rules *SemanticRules) []Diagnostic {
+	var diags []Diagnostic
+	line, _ := s.GetLines()
+	name := s.GetName().ToStr()
+
+	st := s.GetSpaceType()
+	if st == parse.UnknownSpace && rules.MissingSpaceType != Off {
+		diags = append(diags, Diagnostic{
+			Severity: rules.MissingSpaceType,
+			Rule: "missing-space-type",
+			Line: line,
+			Instantiation: s.GetInstantiation(),
+			Msg: fmt.Sprintf("%s has no space type; expected one of :UI, :IO, :DATA, :CALL, :CHAT", name),
+		})
+	}
+
+	if rules.Violation == Off {
+		return diags
+	}
+	sr, ok := rules.Spaces[st]
+	if !ok {
+		return diags
+	}
+	if !sr.AllowReplicable && s.IsReplicable() {
+		diags = append(diags, Diagnostic{
+			Severity: rules.Violation,
+			Rule: "space-replicable",
+			Line: line,
+			Instantiation: s.GetInstantiation(),
+			Msg: fmt.Sprintf("%s:%s spaces may not be :REPLICABLE", name, st.ToStr()),
+		})
+	}
+	if !sr.AllowAgents {
+		for _, a := range s.GetAgents() {
+			a_line, _ := a.GetLines()
+			diags = append(diags, Diagnostic{
+				Severity: rules.Violation,
+				Rule: "space-agents",
+				Line: a_line,
+				Instantiation: s.GetInstantiation(),
+				Msg: fmt.Sprintf("%s:%s spaces may not contain agents, found %s", name, st.ToStr(), a.GetName().ToStr()),
+			})
+		}
+	}
+	if !sr.AllowTasks {
+		for _, t := range s.GetTasks() {
+			t_line, _ := t.GetLines()
+			diags = append(diags, Diagnostic{
+				Severity: rules.Violation,
+				Rule: "space-tasks",
+				Line: t_line,
+				Instantiation: s.GetInstantiation(),
+				Msg: fmt.Sprintf("%s:%s spaces may not contain tasks, found %s", name, st.ToStr(), t.GetName().ToStr()),
+			})
+		}
+	}
+	return diags
+}
+
+func checkPath(p *parse.PathDecl, po *parse.ParseOrder, rules *SemanticRules) []Diagnostic {
+	var diags []Diagnostic
+	if rules.Violation == Off {
+		return diags
+	}
+	pr, ok := rules.Paths[p.GetPathType()]
+	if !ok {
+		return diags
+	}
+	line, _ := p.GetLines()
+
+	endpoint := func(id parse.Ident, allowed []parse.SpaceType, role string) {
+		if len(allowed) == 0 {
+			return
+		}
+		st, ok := lookupSpaceType(po, id)
+		// undeclared spaces are reported by GetParseOrder, untyped ones by checkSpace
+		if !ok || st == parse.UnknownSpace {
+			return
+		}
+		for _, a := range allowed {
+			if a == st {
+				return
+			}
+		}
+		diags = append(diags, Diagnostic{
+			Severity: rules.Violation,
+			Rule: "path-" + role,
+			Line: line,
+			Instantiation: p.GetInstantiation(),
+			Msg: fmt.Sprintf("%s:%s paths must have a %s of type %s, but %s is :%s",
+				p.GetName().ToStr(), p.GetPathType().ToStr(), role, spaceTypeList(allowed), id.ToStr(), st.ToStr()),
+		})
+	}
+	endpoint(p.GetSource(), pr.SourceTypes, "source")
+	endpoint(p.GetDest(), pr.DestTypes, "destination")
+	return diags
+}
+
+func lookupSpaceType(po *parse.ParseOrder, id parse.Ident) (parse.SpaceType, bool) {
+	s := lookupSpace(po, id)
+	if s == nil {
+		return parse.UnknownSpace, false
+	}
+	return s.GetSpaceType(), true
+}
+
+func spaceTypeList(types []parse.SpaceType) string {
+	strs := make([]string, len(types))
+	for i, t := range types {
+		strs[i] = ":" + t.ToStr()
+	}
+	return strings.Join(strs, " or ")
+}
@@ -0,0 +1,517 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// A contract as JSON or YAML, for tools that design systems but do not write
+// Anglish. The Document mirrors the Contract AST: the same declarations nested
+// the same way, with names without their sigils and vibe prose as the parser
+// normalises it, meta-refs written inline as in source. Comments are kept with
+// the declaration they come before, which is what directives like
+// anglish:ignore apply to.
+//
+// A Document is read back by writing it out as Anglish and parsing that, so it
+// goes through exactly what a .ang file would, GetParseOrder and the checks
+// included, and .ang -> JSON -> .ang -> JSON gives the JSON it started with.
+// See schema.json for the schema.
+
+const Version = 1
+
+type Format byte
+const (
+	JSON Format = iota
+	YAML
+)
+
+type Param struct {
+	Name string `json:"name" yaml:"name"`
+	In bool `json:"in" yaml:"in"`
+}
+
+type Space struct {
+	// the comment lines just before it, without their "//"
+	Comments []string `json:"comments,omitempty" yaml:"comments,omitempty"`
+	Name string `json:"name" yaml:"name"`
+	// "" if untyped
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	Replicable bool `json:"replicable,omitempty" yaml:"replicable,omitempty"`
+	Params []Param `json:"params,omitempty" yaml:"params,omitempty"`
+	Vibe []string `json:"vibe,omitempty" yaml:"vibe,omitempty"`
+	Agents []Agent `json:"agents,omitempty" yaml:"agents,omitempty"`
+	Tasks []Task `json:"tasks,omitempty" yaml:"tasks,omitempty"`
+	Spaces []Space `json:"spaces,omitempty" yaml:"spaces,omitempty"`
+	Paths []Path `json:"paths,omitempty" yaml:"paths,omitempty"`
+}
+
+type Agent struct {
+	Comments []string `json:"comments,omitempty" yaml:"comments,omitempty"`
+	Name string `json:"name" yaml:"name"`
+	Type string `json:"type" yaml:"type"`
+	Params []Param `json:"params,omitempty" yaml:"params,omitempty"`
+	Vibe []string `json:"vibe,omitempty" yaml:"vibe,omitempty"`
+}
+
+type Task struct {
+	Comments []string `json:"comments,omitempty" yaml:"comments,omitempty"`
+	Name string `json:"name" yaml:"name"`
+	Params []Param `json:"params,omitempty" yaml:"params,omitempty"`
+	Vibe []string `json:"vibe,omitempty" yaml:"vibe,omitempty"`
+}
+
+type Path struct {
+	Comments []string `json:"comments,omitempty" yaml:"comments,omitempty"`
+	Name string `json:"name" yaml:"name"`
+	Type string `json:"type" yaml:"type"`
+	// "" if the path may carry anything
+	Access string `json:"access,omitempty" yaml:"access,omitempty"`
+	// names of the spaces it leads between
+	From string `json:"from" yaml:"from"`
+	To string `json:"to" yaml:"to"`
+	Vibe []string `json:"vibe,omitempty" yaml:"vibe,omitempty"`
+}
+
+type Document struct {
+	Version int `json:"version" yaml:"version"`
+	Spaces []Space `json:"spaces,omitempty" yaml:"spaces,omitempty"`
+	Agents []Agent `json:"agents,omitempty" yaml:"agents,omitempty"`
+	Paths []Path `json:"paths,omitempty" yaml:"paths,omitempty"`
+	// the comment lines after the last declaration
+	Comments []string `json:"comments,omitempty" yaml:"comments,omitempty"`
+}
+
+func params(ps []parse.Param) []Param {
+	var out []Param
+	for i := range ps {
+		out = append(out, Param{Name: ps[i].GetDataName(), In: ps[i].IsIn()})
+	}
+	return out
+}
+
+func vibe(vb *parse.VibeBlock) []string {
+	return append([]string(nil), vb.GetProse()...)
+}
+
+// commented is a declaration in the document, with where it starts in source
+type commented struct {
+	line uint64
+	comments *[]string
+}
+
+// FromContract gives the document for a contract that parsed without errors
+func FromContract(c *parse.Contract) Document {
+	d := Document{Version: Version}
+	var decls []commented
+	add := func(unit interface{ GetLines() (uint64, uint64) }, comments *[]string) {
+		line, _ := unit.GetLines()
+		decls = append(decls, commented{line, comments})
+	}
+
+	agent := func(a *parse.AgentDecl) Agent {
+		return Agent{Name: a.GetName().GetIdent(), Type: a.GetAgentType().ToStr(), Params: params(a.GetParams()), Vibe: vibe(a.GetVibe())}
+	}
+	path := func(p *parse.PathDecl) Path {
+		return Path{
+			Name: p.GetName().GetIdent(),
+			Type: p.GetPathType().ToStr(),
+			Access: p.GetAccess().ToStr(),
+			From: p.GetSource().GetIdent(),
+			To: p.GetDest().GetIdent(),
+			Vibe: vibe(p.GetVibe()),
+		}
+	}
+	var space func(s *parse.SpaceDecl) Space
+	space = func(s *parse.SpaceDecl) Space {
+		out := Space{
+			Name: s.GetName().GetIdent(),
+			Type: s.GetSpaceType().ToStr(),
+			Replicable: s.IsReplicable(),
+			Params: params(s.GetParams()),
+			Vibe: vibe(s.GetVibe()),
+		}
+		agents, tasks, spaces, paths := s.GetAgents(), s.GetTasks(), s.GetSpaces(), s.GetPaths()
+		for i := range agents {
+			out.Agents = append(out.Agents, agent(&agents[i]))
+		}
+		for i := range tasks {
+			t := &tasks[i]
+			out.Tasks = append(out.Tasks, Task{Name: t.GetName().GetIdent(), Params: params(t.GetParams()), Vibe: vibe(t.GetVibe())})
+		}
+		for i := range spaces {
+			out.Spaces = append(out.Spaces, space(&spaces[i]))
+		}
+		for i := range paths {
+			out.Paths = append(out.Paths, path(&paths[i]))
+		}
+		return out
+	}
+
+	spaces, agents, paths := c.GetSpaces(), c.GetAgents(), c.GetPaths()
+	for i := range spaces {
+		d.Spaces = append(d.Spaces, space(&spaces[i]))
+	}
+	for i := range agents {
+		d.Agents = append(d.Agents, agent(&agents[i]))
+	}
+	for i := range paths {
+		d.Paths = append(d.Paths, path(&paths[i]))
+	}
+
+	// only now that the slices have stopped growing can they be pointed into
+	var walk func(s *Space, decl *parse.SpaceDecl)
+	walk = func(s *Space, decl *parse.SpaceDecl) {
+		add(decl, &s.Comments)
+		agents, tasks, spaces, paths := decl.GetAgents(), decl.GetTasks(), decl.GetSpaces(), decl.GetPaths()
+		for i := range agents {
+			add(&agents[i], &s.Agents[i].Comments)
+		}
+		for i := range tasks {
+			add(&tasks[i], &s.Tasks[i].Comments)
+		}
+		for i := range spaces {
+			walk(&s.Spaces[i], &spaces[i])
+		}
+		for i := range paths {
+			add(&paths[i], &s.Paths[i].Comments)
+		}
+	}
+	for i := range spaces {
+		walk(&d.Spaces[i], &spaces[i])
+	}
+	for i := range agents {
+		add(&agents[i], &d.Agents[i].Comments)
+	}
+	for i := range paths {
+		add(&paths[i], &d.Paths[i].Comments)
+	}
+
+	sort.Slice(decls, func(i, j int) bool { return decls[i].line < decls[j].line })
+	for _, cm := range c.GetComments() {
+		at := sort.Search(len(decls), func(i int) bool { return decls[i].line > cm.GetLine() })
+		if at == len(decls) {
+			d.Comments = append(d.Comments, cm.GetText())
+		} else {
+			*decls[at].comments = append(*decls[at].comments, cm.GetText())
+		}
+	}
+	return d
+}
+
+// printer writes a document as Anglish, remembering which part of the
+// document each line came from
+type printer struct {
+	b strings.Builder
+	// origins[line] is eg. "spaces[0].tasks[1].vibe[0]"
+	origins []string
+}
+
+func (p *printer) line(indent int, origin, text string) {
+	p.b.WriteString(strings.Repeat(" ", indent))
+	p.b.WriteString(text)
+	p.b.WriteString("\n")
+	p.origins = append(p.origins, origin)
+}
+
+func (p *printer) comments(indent int, origin string, comments []string) {
+	for i, cm := range comments {
+		p.line(indent, field(origin, "comments", i), strings.TrimSpace("// " + cm))
+	}
+}
+
+// field names an entry of a list in the document, eg. "spaces[0].vibe[2]"
+func field(origin, list string, i int) string {
+	if origin == "" {
+		return fmt.Sprintf("%s[%d]", list, i)
+	}
+	return fmt.Sprintf("%s.%s[%d]", origin, list, i)
+}
+
+func (p *printer) vibe(indent int, origin string, lines []string) {
+	for i, l := range lines {
+		p.line(indent, field(origin, "vibe", i), "> " + l)
+	}
+}
+
+func paramList(ps []Param) string {
+	strs := make([]string, len(ps))
+	for i, param := range ps {
+		dir := "out"
+		if param.In {
+			dir = "in"
+		}
+		strs[i] = dir + "=%" + param.Name
+	}
+	return "(" + strings.Join(strs, ", ") + ")"
+}
+
+func (p *printer) agent(indent int, origin string, a *Agent) {
+	p.comments(indent, origin, a.Comments)
+	head := "#" + a.Name + ":" + a.Type
+	if len(a.Params) > 0 {
+		head += paramList(a.Params)
+	}
+	p.line(indent, origin, head)
+	p.vibe(indent, origin, a.Vibe)
+}
+
+func (p *printer) path(indent int, origin string, path *Path) {
+	p.comments(indent, origin, path.Comments)
+	head := "=" + path.Name + ":" + path.Type
+	if path.Access != "" {
+		head += ":" + path.Access
+	}
+	p.line(indent, origin, head + "(@" + path.From + ", @" + path.To + ")")
+	p.vibe(indent, origin, path.Vibe)
+}
+
+// space writes a space with what it declares at its own indentation, and the
+// spaces nested in it further in
+func (p *printer) space(indent int, origin string, s *Space) {
+	p.comments(indent, origin, s.Comments)
+	head := "@" + s.Name
+	if s.Type != "" {
+		head += ":" + s.Type
+	}
+	if s.Replicable {
+		head += ":REPLICABLE"
+	}
+	if len(s.Params) > 0 {
+		head += paramList(s.Params)
+	}
+	p.line(indent, origin, head)
+	p.vibe(indent, origin, s.Vibe)
+	for i := range s.Agents {
+		p.agent(indent, fmt.Sprintf("%s.agents[%d]", origin, i), &s.Agents[i])
+	}
+	for i := range s.Tasks {
+		t := &s.Tasks[i]
+		o := fmt.Sprintf("%s.tasks[%d]", origin, i)
+		p.comments(indent, o, t.Comments)
+		p.line(indent, o, "$" + t.Name + paramList(t.Params))
+		p.vibe(indent, o, t.Vibe)
+	}
+	for i := range s.Spaces {
+		p.space(indent + 2, fmt.Sprintf("%s.spaces[%d]", origin, i), &s.Spaces[i])
+	}
+	for i := range s.Paths {
+		p.path(indent, fmt.Sprintf("%s.paths[%d]", origin, i), &s.Paths[i])
+	}
+}
+
+func (d *Document) print() printer {
+	var p printer
+	blank := func() {
+		if p.b.Len() > 0 {
+			p.line(0, "", "")
+		}
+	}
+	for i := range d.Spaces {
+		blank()
+		p.space(0, fmt.Sprintf("spaces[%d]", i), &d.Spaces[i])
+	}
+	for i := range d.Agents {
+		blank()
+		p.agent(0, fmt.Sprintf("agents[%d]", i), &d.Agents[i])
+	}
+	for i := range d.Paths {
+		blank()
+		p.path(0, fmt.Sprintf("paths[%d]", i), &d.Paths[i])
+	}
+	if len(d.Comments) > 0 {
+		blank()
+		p.comments(0, "", d.Comments)
+	}
+	return p
+}
+
+// Source writes the document as Anglish. It is only sure to parse back to the
+// document if Check finds nothing wrong with it.
+func (d *Document) Source() string {
+	p := d.print()
+	return p.b.String()
+}
+
+var (
+	spaceTypes = []string{"", "UI", "IO", "DATA", "CALL", "CHAT"}
+	agentTypes = []string{"AF", "DF"}
+	pathTypes = []string{"INVOKE", "ATTEND"}
+	pathAccesses = []string{"", "READ", "WRITE", "CONTROL"}
+)
+
+func oneOf(s string, options []string) bool {
+	for _, o := range options {
+		if s == o {
+			return true
+		}
+	}
+	return false
+}
+
+// Check finds what in a document could not be written as Anglish: bad names and
+// tags, and text that would not stay on its line. What parsing then finds is
+// up to ToContract.
+func (d *Document) Check() []error {
+	var errs []error
+	fail := func(origin, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", origin, fmt.Sprintf(format, args...)))
+	}
+	name := func(origin, what, n string) {
+		if !parse.IsIdentifier(n) {
+			fail(origin, "%s %q is not an identifier", what, n)
+		}
+	}
+	tag := func(origin, what, t string, options []string) {
+		if !oneOf(t, options) {
+			fail(origin, "unknown %s %q, expected one of %s", what, t, strings.Join(options, ", "))
+		}
+	}
+	oneLine := func(origin, what string, lines []string) {
+		for i, l := range lines {
+			if strings.ContainsAny(l, "\r\n") {
+				fail(field(origin, what, i), "must be a single line")
+			}
+			if what == "vibe" && strings.TrimSpace(l) == "" {
+				fail(field(origin, what, i), "vibe lines may not be empty")
+			}
+		}
+	}
+	paramsOK := func(origin string, ps []Param) {
+		for i, p := range ps {
+			name(fmt.Sprintf("%s.params[%d]", origin, i), "data name", p.Name)
+		}
+	}
+	agent := func(origin string, a *Agent) {
+		name(origin, "agent name", a.Name)
+		tag(origin, "agent type", a.Type, agentTypes)
+		paramsOK(origin, a.Params)
+		oneLine(origin, "comments", a.Comments)
+		oneLine(origin, "vibe", a.Vibe)
+	}
+	path := func(origin string, p *Path) {
+		name(origin, "path name", p.Name)
+		tag(origin, "path type", p.Type, pathTypes)
+		tag(origin, "path access", p.Access, pathAccesses)
+		name(origin, "space name", p.From)
+		name(origin, "space name", p.To)
+		oneLine(origin, "comments", p.Comments)
+		oneLine(origin, "vibe", p.Vibe)
+	}
+	var space func(origin string, s *Space)
+	space = func(origin string, s *Space) {
+		name(origin, "space name", s.Name)
+		tag(origin, "space type", s.Type, spaceTypes)
+		paramsOK(origin, s.Params)
+		oneLine(origin, "comments", s.Comments)
+		oneLine(origin, "vibe", s.Vibe)
+		for i := range s.Agents {
+			agent(fmt.Sprintf("%s.agents[%d]", origin, i), &s.Agents[i])
+		}
+		for i := range s.Tasks {
+			t := &s.Tasks[i]
+			o := fmt.Sprintf("%s.tasks[%d]", origin, i)
+			name(o, "task name", t.Name)
+			if t.Name == "use" {
+				fail(o, "$use is reserved")
+			}
+			paramsOK(o, t.Params)
+			oneLine(o, "comments", t.Comments)
+			oneLine(o, "vibe", t.Vibe)
+		}
+		for i := range s.Spaces {
+			space(fmt.Sprintf("%s.spaces[%d]", origin, i), &s.Spaces[i])
+		}
+		for i := range s.Paths {
+			path(fmt.Sprintf("%s.paths[%d]", origin, i), &s.Paths[i])
+		}
+	}
+
+	if d.Version != Version {
+		fail("version", "unsupported version %d, expected %d", d.Version, Version)
+	}
+	for i := range d.Spaces {
+		space(fmt.Sprintf("spaces[%d]", i), &d.Spaces[i])
+	}
+	for i := range d.Agents {
+		agent(fmt.Sprintf("agents[%d]", i), &d.Agents[i])
+	}
+	for i := range d.Paths {
+		path(fmt.Sprintf("paths[%d]", i), &d.Paths[i])
+	}
+	oneLine("", "comments", d.Comments)
+	return errs
+}
+
+// ToContract turns a document into the contract its Source parses to. Errors
+// say where in the document they are, eg. "spaces[0].tasks[1].vibe[0]: ...".
+func ToContract(d *Document) (parse.Contract, []error) {
+	if errs := d.Check(); len(errs) > 0 {
+		return parse.Contract{}, errs
+	}
+	p := d.print()
+	c, perrs := parse.ParseFromReader(strings.NewReader(p.b.String()))
+	var errs []error
+	for _, pe := range perrs {
+		line, _ := pe.GetPos()
+		errs = append(errs, fmt.Errorf("%s: %s", p.origin(line), pe.GetError().Message()))
+	}
+	return c, errs
+}
+
+func (p *printer) origin(line uint64) string {
+	if int(line) < len(p.origins) && p.origins[line] != "" {
+		return p.origins[line]
+	}
+	return "document"
+}
+
+// Origin says which part of the document a line of its Source came from, for
+// reporting what is found in the contract ToContract gave
+func (d *Document) Origin(line uint64) string {
+	p := d.print()
+	return p.origin(line)
+}
+
+func Marshal(d *Document, f Format) ([]byte, error) {
+	switch f {
+	case YAML:
+		var b bytes.Buffer
+		enc := yaml.NewEncoder(&b)
+		enc.SetIndent(2)
+		if err := enc.Encode(d); err != nil {
+			return nil, err
+		}
+		enc.Close()
+		return b.Bytes(), nil
+	default:
+		data, err := json.MarshalIndent(d, "", "  ")
+		return append(data, '\n'), err
+	}
+}
+
+// Unmarshal reads a document, refusing fields the schema does not have
+func Unmarshal(data []byte, f Format) (Document, error) {
+	var d Document
+	switch f {
+	case YAML:
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&d); err != nil {
+			return d, err
+		}
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&d); err != nil {
+			return d, err
+		}
+	}
+	return d, nil
+}
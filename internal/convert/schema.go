@@ -0,0 +1,11 @@
+package convert
+
+import (
+	_ "embed"
+)
+
+// Schema is the JSON Schema for a Document, in JSON or YAML. It describes shape
+// only; ToContract also parses and resolves what the document declares.
+//
+//go:embed schema.json
+var Schema []byte
@@ -0,0 +1,333 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// What changed between two versions of a contract, declaration by declaration
+// rather than line by line. Declarations are matched by qualified name, so a
+// rename is a removal and an addition. A changed declaration says which of its
+// tags, params, vibe prose and meta-refs changed; the prose is compared with
+// the meta-refs taken out, so that changing a reference is not also a prose
+// change. Stale lists the declarations that did not change themselves but
+// whose generated output is out of date, because something they depend on,
+// however indirectly, did.
+
+type Decl struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// Delta is what was removed from and added to a list
+type Delta struct {
+	Removed []string `json:"removed,omitempty"`
+	Added []string `json:"added,omitempty"`
+}
+
+type Change struct {
+	Decl
+	// eg. "type: UI -> CALL"
+	Tags []string `json:"tags,omitempty"`
+	Params *Delta `json:"params,omitempty"`
+	Prose *Delta `json:"prose,omitempty"`
+	Refs *Delta `json:"refs,omitempty"`
+}
+
+type Report struct {
+	Added []Decl `json:"added"`
+	Removed []Decl `json:"removed"`
+	Changed []Change `json:"changed"`
+	Stale []Decl `json:"stale"`
+}
+
+func (r *Report) Empty() bool {
+	return len(r.Added) == 0 && len(r.Removed) == 0 && len(r.Changed) == 0
+}
+
+func kindOf(unit parse.ParseUnit) string {
+	switch unit.GetName().GetType() {
+	case parse.SPACE: return "space"
+	case parse.AGENT: return "agent"
+	case parse.TASK: return "task"
+	default: return "path"
+	}
+}
+
+// byName indexes a contract's declarations by qualified name
+func byName(po *parse.ParseOrder) map[string]uint64 {
+	ids := make(map[string]uint64, po.Len())
+	for i := 0; i < po.Len(); i++ {
+		ids[po.GetQualifiedName(uint64(i))] = uint64(i)
+	}
+	return ids
+}
+
+// Compare reports what changed from the contract of old_po to that of new_po
+func Compare(old_po, new_po *parse.ParseOrder) Report {
+	r := Report{Added: []Decl{}, Removed: []Decl{}, Changed: []Change{}, Stale: []Decl{}}
+	old_ids, new_ids := byName(old_po), byName(new_po)
+
+	// declarations whose own artifact is out of date
+	dirty := make(map[uint64]bool)
+	for name, id := range new_ids {
+		old_id, ok := old_ids[name]
+		if !ok {
+			r.Added = append(r.Added, Decl{name, kindOf(new_po.GetNode(id))})
+			dirty[id] = true
+			continue
+		}
+		c := compareDecl(old_po.GetNode(old_id), new_po.GetNode(id))
+		if c.Tags != nil || c.Params != nil || c.Prose != nil || c.Refs != nil {
+			c.Decl = Decl{name, kindOf(new_po.GetNode(id))}
+			r.Changed = append(r.Changed, c)
+			dirty[id] = true
+		}
+	}
+	for name, id := range old_ids {
+		if _, ok := new_ids[name]; !ok {
+			r.Removed = append(r.Removed, Decl{name, kindOf(old_po.GetNode(id))})
+		}
+	}
+
+	// a declaration whose references now lead elsewhere is stale too, eg. when
+	// what it used was removed
+	seeds := make(map[uint64]bool)
+	for name, id := range new_ids {
+		if old_id, ok := old_ids[name]; ok && !dirty[id] && depNames(old_po, old_id) != depNames(new_po, id) {
+			seeds[id] = true
+		}
+	}
+	for id := range dirty {
+		seeds[id] = true
+	}
+
+	dependents := make(map[uint64][]uint64)
+	for i := 0; i < new_po.Len(); i++ {
+		for _, dep := range new_po.GetDeps(uint64(i)) {
+			dependents[dep] = append(dependents[dep], uint64(i))
+		}
+	}
+	stale := make(map[uint64]bool)
+	var queue []uint64
+	for id := range seeds {
+		queue = append(queue, id)
+		stale[id] = true
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, d := range dependents[id] {
+			if !stale[d] {
+				stale[d] = true
+				queue = append(queue, d)
+			}
+		}
+	}
+	for id := range stale {
+		if !dirty[id] {
+			r.Stale = append(r.Stale, Decl{new_po.GetQualifiedName(id), kindOf(new_po.GetNode(id))})
+		}
+	}
+
+	sortDecls(r.Added)
+	sortDecls(r.Removed)
+	sortDecls(r.Stale)
+	sort.Slice(r.Changed, func(i, j int) bool { return r.Changed[i].Name < r.Changed[j].Name })
+	return r
+}
+
+func sortDecls(decls []Decl) {
+	sort.Slice(decls, func(i, j int) bool { return decls[i].Name < decls[j].Name })
+}
+
+func depNames(po *parse.ParseOrder, id uint64) string {
+	var names []string
+	for _, dep := range po.GetDeps(id) {
+		names = append(names, po.GetQualifiedName(dep))
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+func compareDecl(old, new parse.ParseUnit) Change {
+	var c Change
+	tag := func(what, was, is string) {
+		if was != is {
+			c.Tags = append(c.Tags, fmt.Sprintf("%s: %s -> %s", what, was, is))
+		}
+	}
+	var old_params, new_params []parse.Param
+	switch o := old.(type) {
+	case *parse.SpaceDecl:
+		n := new.(*parse.SpaceDecl)
+		tag("type", o.GetSpaceType().ToStr(), n.GetSpaceType().ToStr())
+		tag("replicable", fmt.Sprint(o.IsReplicable()), fmt.Sprint(n.IsReplicable()))
+		old_params, new_params = o.GetParams(), n.GetParams()
+	case *parse.AgentDecl:
+		n := new.(*parse.AgentDecl)
+		tag("type", o.GetAgentType().ToStr(), n.GetAgentType().ToStr())
+		old_params, new_params = o.GetParams(), n.GetParams()
+	case *parse.TaskDecl:
+		old_params, new_params = o.GetParams(), new.(*parse.TaskDecl).GetParams()
+	case *parse.PathDecl:
+		n := new.(*parse.PathDecl)
+		tag("type", o.GetPathType().ToStr(), n.GetPathType().ToStr())
+		tag("access", o.GetAccess().ToStr(), n.GetAccess().ToStr())
+		tag("source", o.GetSource().ToStr(), n.GetSource().ToStr())
+		tag("dest", o.GetDest().ToStr(), n.GetDest().ToStr())
+	}
+
+	c.Params = listDelta(paramStrs(old_params), paramStrs(new_params))
+	c.Prose = proseDelta(old.GetVibe(), new.GetVibe())
+	c.Refs = listDelta(refStrs(old.GetVibe()), refStrs(new.GetVibe()))
+	return c
+}
+
+func paramStrs(params []parse.Param) []string {
+	strs := make([]string, len(params))
+	for i := range params {
+		strs[i] = params[i].ToStr()
+	}
+	return strs
+}
+
+func refStrs(vb *parse.VibeBlock) []string {
+	var strs []string
+	for _, mr := range vb.GetMetaRefs() {
+		strs = append(strs, mr.ToStr())
+	}
+	return strs
+}
+
+// skeleton is a prose line with its meta-refs cut out
+func skeletons(vb *parse.VibeBlock) []string {
+	prose, lines := vb.GetProse(), vb.GetProseLines()
+	refs := vb.GetMetaRefs()
+	out := make([]string, len(prose))
+	for i, p := range prose {
+		for _, mr := range refs {
+			if line, _ := mr.GetPos(); line == lines[i] {
+				p = strings.Replace(p, mr.ToStr(), "…", 1)
+			}
+		}
+		out[i] = p
+	}
+	return out
+}
+
+// proseDelta compares prose lines by skeleton, but reports them whole
+func proseDelta(old, new *parse.VibeBlock) *Delta {
+	removed, added := lineDiff(skeletons(old), skeletons(new))
+	if len(removed) == 0 && len(added) == 0 {
+		return nil
+	}
+	d := &Delta{}
+	for _, i := range removed {
+		d.Removed = append(d.Removed, old.GetProse()[i])
+	}
+	for _, i := range added {
+		d.Added = append(d.Added, new.GetProse()[i])
+	}
+	return d
+}
+
+// listDelta compares two lists in order, as lineDiff does
+func listDelta(old, new []string) *Delta {
+	removed, added := lineDiff(old, new)
+	if len(removed) == 0 && len(added) == 0 {
+		return nil
+	}
+	d := &Delta{}
+	for _, i := range removed {
+		d.Removed = append(d.Removed, old[i])
+	}
+	for _, i := range added {
+		d.Added = append(d.Added, new[i])
+	}
+	return d
+}
+
+// lineDiff gives the indexes of the lines of a not in their longest common
+// subsequence with b, and the other way around
+func lineDiff(a, b []string) ([]int, []int) {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a) + 1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b) + 1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i + 1][j + 1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i + 1][j], lcs[i][j + 1])
+			}
+		}
+	}
+
+	var removed, added []int
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			i++
+			j++
+		case lcs[i + 1][j] >= lcs[i][j + 1]:
+			removed = append(removed, i)
+			i++
+		default:
+			added = append(added, j)
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		removed = append(removed, i)
+	}
+	for ; j < len(b); j++ {
+		added = append(added, j)
+	}
+	return removed, added
+}
+
+// Write prints the report for people: "+" added, "-" removed, "~" changed
+func (r *Report) Write(w io.Writer) {
+	for _, d := range r.Added {
+		fmt.Fprintf(w, "+ %s (%s)\n", d.Name, d.Kind)
+	}
+	for _, d := range r.Removed {
+		fmt.Fprintf(w, "- %s (%s)\n", d.Name, d.Kind)
+	}
+	for _, c := range r.Changed {
+		fmt.Fprintf(w, "~ %s (%s)\n", c.Name, c.Kind)
+		for _, t := range c.Tags {
+			fmt.Fprintf(w, "    tag %s\n", t)
+		}
+		writeDelta(w, "param", c.Params)
+		writeDelta(w, "prose", c.Prose)
+		writeDelta(w, "ref", c.Refs)
+	}
+	if len(r.Stale) > 0 {
+		names := make([]string, len(r.Stale))
+		for i, d := range r.Stale {
+			names[i] = d.Name
+		}
+		fmt.Fprintf(w, "stale: %s\n", strings.Join(names, ", "))
+	}
+}
+
+func writeDelta(w io.Writer, what string, d *Delta) {
+	if d == nil {
+		return
+	}
+	for _, s := range d.Removed {
+		fmt.Fprintf(w, "    %s - %s\n", what, s)
+	}
+	for _, s := range d.Added {
+		fmt.Fprintf(w, "    %s + %s\n", what, s)
+	}
+}
@@ -0,0 +1,472 @@
+package doc
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/anotherLostKitten/Anglish/internal/graph"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+	"github.com/anotherLostKitten/Anglish/internal/refactor"
+)
+
+// A contract as a small static site, for the people who read contracts but not
+// their source: an index with the space-path graph, the top-level agents and
+// paths, and a page for every space with its agents, tasks and paths. Every
+// meta-ref in the vibe prose links to what it resolves to, and every
+// declaration lists what refers to it.
+
+type Format byte
+const (
+	HTML Format = iota
+	Markdown
+)
+
+func (f Format) Ext() string {
+	switch f {
+	case Markdown: return ".md"
+	default: return ".html"
+	}
+}
+
+type Options struct {
+	Format Format
+	// heading of the index, eg. the contract's file name
+	Title string
+	// the graph rendered by Graphviz, "" to show its DOT source instead
+	GraphSVG string
+}
+
+// IndexPage is the name of the site's first page, without its extension
+const IndexPage = "index"
+
+// site holds what every page needs to link anywhere
+type site struct {
+	po *parse.ParseOrder
+	opts Options
+	w writer
+	// the declarations referring to each declaration
+	used_by map[uint64][]uint64
+}
+
+// writer is what the formats differ in. Text passed to it is plain and is
+// escaped by it; the results of link and code are already in the format.
+type writer interface {
+	page(title, body string) string
+	heading(level int, anchor, text string) string
+	para(inline string) string
+	list(items []string) string
+	// eg. a declaration's type, as "label: value"
+	fact(label, value string) string
+	link(text, href string) string
+	anchor(id, inline string) string
+	code(text string) string
+	text(s string) string
+	block(lang, text string) string
+}
+
+// Render gives the site's files by name, its pages and maybe graph.svg. Like
+// graph.DOT, it refuses a contract whose names do not resolve.
+func Render(po *parse.ParseOrder, rt *parse.RoutingTable, opts Options) (map[string]string, error) {
+	dot, err := graph.DOT(po, rt)
+	if err != nil {
+		return nil, err
+	}
+	s := &site{po: po, opts: opts, used_by: make(map[uint64][]uint64)}
+	switch opts.Format {
+	case Markdown: s.w = markdownWriter{}
+	default: s.w = htmlWriter{}
+	}
+	ix := refactor.NewIndex(po)
+	for i := 0; i < po.Len(); i++ {
+		for _, occ := range ix.Occurrences(uint64(i)) {
+			if !occ.IsDecl && !contains(s.used_by[uint64(i)], occ.Node) {
+				s.used_by[uint64(i)] = append(s.used_by[uint64(i)], occ.Node)
+			}
+		}
+	}
+
+	files := make(map[string]string)
+	files[IndexPage + opts.Format.Ext()] = s.index(dot, files)
+	for i := 0; i < po.Len(); i++ {
+		if _, ok := po.GetNode(uint64(i)).(*parse.SpaceDecl); ok {
+			files[s.pageOf(uint64(i))] = s.spacePage(uint64(i))
+		}
+	}
+	return files, nil
+}
+
+func contains(ids []uint64, id uint64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+var sigils = strings.NewReplacer("@", "space.", "#", "agent.", "$", "task.", "=", "path.")
+
+// anchorOf is the id of a declaration's section, eg. "space.store.task.get"
+func (s *site) anchorOf(id uint64) string {
+	return sigils.Replace(s.po.GetQualifiedName(id))
+}
+
+// pageOf is the file a declaration is on: a space's own page, or else the page
+// of the space it is declared in, or else the index
+func (s *site) pageOf(id uint64) string {
+	if _, ok := s.po.GetNode(id).(*parse.SpaceDecl); ok {
+		return s.anchorOf(id) + s.opts.Format.Ext()
+	}
+	if p, ok := s.po.GetParent(id); ok {
+		return s.pageOf(p)
+	}
+	return IndexPage + s.opts.Format.Ext()
+}
+
+func (s *site) href(id uint64) string {
+	if _, ok := s.po.GetNode(id).(*parse.SpaceDecl); ok {
+		return s.pageOf(id)
+	}
+	return s.pageOf(id) + "#" + s.anchorOf(id)
+}
+
+func (s *site) linkTo(id uint64) string {
+	return s.w.link(s.po.GetQualifiedName(id), s.href(id))
+}
+
+func paramAnchor(decl string, p parse.Param) string {
+	return decl + ".data." + p.GetDataName()
+}
+
+func paramsOf(unit parse.ParseUnit) []parse.Param {
+	switch d := unit.(type) {
+	case *parse.SpaceDecl: return d.GetParams()
+	case *parse.AgentDecl: return d.GetParams()
+	case *parse.TaskDecl: return d.GetParams()
+	default: return nil
+	}
+}
+
+// dataHref finds the param a %data ref in node from names, in its own
+// declaration or the nearest enclosing one with it
+func (s *site) dataHref(from uint64, data string) (string, bool) {
+	for _, id := range append([]uint64{from}, s.po.GetAncestors(from)...) {
+		for _, p := range paramsOf(s.po.GetNode(id)) {
+			if p.GetDataName() == data {
+				return s.pageOf(id) + "#" + paramAnchor(s.anchorOf(id), p), true
+			}
+		}
+	}
+	return "", false
+}
+
+// refHref is where a meta-ref in node from leads, if it resolves
+func (s *site) refHref(from uint64, mr parse.MetaRef) (string, bool) {
+	var id uint64
+	var ok bool
+	switch r := mr.(type) {
+	case *parse.MetaRefData: return s.dataHref(from, r.GetIdent())
+	case *parse.MetaRefUseImport: id, ok = s.po.Resolve(from, r.GetImported())
+	case *parse.MetaRefPath: id, ok = s.po.Resolve(from, parse.NewIdent(parse.PATH, r.GetIdent()))
+	case *parse.MetaRefTask:
+		t, err := s.po.ResolveTask(from, r)
+		id, ok = t, err == nil
+	}
+	if !ok {
+		return "", false
+	}
+	return s.href(id), true
+}
+
+// prose renders a vibe block, its meta-refs as links. A prose line holds each
+// of its refs as written, so they are found in it in order.
+func (s *site) prose(id uint64) string {
+	vb := s.po.GetNode(id).GetVibe()
+	lines := vb.GetProseLines()
+	refs := vb.GetMetaRefs()
+	var out []string
+	for i, line := range vb.GetProse() {
+		var b strings.Builder
+		rest := line
+		for _, mr := range refs {
+			if l, _ := mr.GetPos(); l != lines[i] {
+				continue
+			}
+			str := mr.ToStr()
+			at := strings.Index(rest, str)
+			if at < 0 {
+				continue
+			}
+			b.WriteString(s.w.text(rest[:at]))
+			if href, ok := s.refHref(id, mr); ok {
+				b.WriteString(s.w.link(str, href))
+			} else {
+				b.WriteString(s.w.code(str))
+			}
+			rest = rest[at + len(str):]
+		}
+		b.WriteString(s.w.text(rest))
+		out = append(out, b.String())
+	}
+	if len(out) == 0 {
+		return ""
+	}
+	return s.w.para(strings.Join(out, "\n"))
+}
+
+func (s *site) params(id uint64) string {
+	var items []string
+	for _, p := range paramsOf(s.po.GetNode(id)) {
+		dir := "out"
+		if p.IsIn() {
+			dir = "in"
+		}
+		items = append(items, s.w.anchor(paramAnchor(s.anchorOf(id), p), dir + " " + s.w.code("%" + p.GetDataName())))
+	}
+	if len(items) == 0 {
+		return ""
+	}
+	return s.w.fact("Params", strings.Join(items, ", "))
+}
+
+// decl renders a declaration's section, less the sections of what is in it
+func (s *site) decl(id uint64, level int) string {
+	var b strings.Builder
+	unit := s.po.GetNode(id)
+	b.WriteString(s.w.heading(level, s.anchorOf(id), s.po.GetQualifiedName(id)))
+	switch d := unit.(type) {
+	case *parse.SpaceDecl:
+		b.WriteString(s.w.fact("Type", s.w.text(d.GetSpaceType().ToStr())))
+		replicable := "no"
+		if d.IsReplicable() {
+			replicable = "yes"
+		}
+		b.WriteString(s.w.fact("Replicable", replicable))
+		if p, ok := s.po.GetParent(id); ok {
+			b.WriteString(s.w.fact("Inside", s.linkTo(p)))
+		}
+	case *parse.AgentDecl:
+		b.WriteString(s.w.fact("Type", s.w.text(d.GetAgentType().ToStr())))
+	case *parse.PathDecl:
+		b.WriteString(s.w.fact("Type", s.w.text(d.GetPathType().ToStr())))
+		if d.GetAccess() != parse.AnyAccess {
+			b.WriteString(s.w.fact("Access", s.w.text(d.GetAccess().ToStr())))
+		}
+		end := func(label string, space parse.Ident) {
+			if sp, ok := s.po.Resolve(id, space); ok {
+				b.WriteString(s.w.fact(label, s.linkTo(sp)))
+			} else {
+				b.WriteString(s.w.fact(label, s.w.code(space.ToStr())))
+			}
+		}
+		end("From", d.GetSource())
+		end("To", d.GetDest())
+	}
+	b.WriteString(s.params(id))
+	b.WriteString(s.prose(id))
+	if users := s.used_by[id]; len(users) > 0 {
+		links := make([]string, len(users))
+		for i, u := range users {
+			links[i] = s.linkTo(u)
+		}
+		b.WriteString(s.w.fact("Used by", strings.Join(links, ", ")))
+	}
+	return b.String()
+}
+
+// section renders the declarations of one kind under a heading
+func (s *site) section(title string, ids []uint64, level int) string {
+	if len(ids) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(s.w.heading(level, "", title))
+	for _, id := range ids {
+		b.WriteString(s.decl(id, level + 1))
+	}
+	return b.String()
+}
+
+// byKind sorts ids into spaces, agents, tasks and paths, keeping their order
+func (s *site) byKind(ids []uint64) map[parse.MetaType][]uint64 {
+	kinds := make(map[parse.MetaType][]uint64)
+	for _, id := range ids {
+		t := s.po.GetNode(id).GetName().GetType()
+		kinds[t] = append(kinds[t], id)
+	}
+	return kinds
+}
+
+func (s *site) spacePage(id uint64) string {
+	var b strings.Builder
+	b.WriteString(s.w.para(s.w.link("Contract", IndexPage + s.opts.Format.Ext())))
+	b.WriteString(s.decl(id, 1))
+	kinds := s.byKind(s.po.GetChildIDs(id))
+	if subs := kinds[parse.SPACE]; len(subs) > 0 {
+		b.WriteString(s.w.heading(2, "", "Subspaces"))
+		var items []string
+		for _, sub := range subs {
+			items = append(items, s.linkTo(sub))
+		}
+		b.WriteString(s.w.list(items))
+	}
+	b.WriteString(s.section("Agents", kinds[parse.AGENT], 2))
+	b.WriteString(s.section("Tasks", kinds[parse.TASK], 2))
+	b.WriteString(s.section("Paths", kinds[parse.PATH], 2))
+	return s.w.page(s.po.GetQualifiedName(id), b.String())
+}
+
+func (s *site) index(dot string, files map[string]string) string {
+	var b strings.Builder
+	title := s.opts.Title
+	if title == "" {
+		title = "Contract"
+	}
+	b.WriteString(s.w.heading(1, "", title))
+
+	b.WriteString(s.w.heading(2, "graph", "Space-path graph"))
+	switch {
+	case s.opts.GraphSVG != "" && s.opts.Format == Markdown:
+		files["graph.svg"] = s.opts.GraphSVG
+		b.WriteString(s.w.para("![space-path graph](graph.svg)"))
+	case s.opts.GraphSVG != "":
+		// inline, without the XML prolog
+		svg := s.opts.GraphSVG
+		if at := strings.Index(svg, "<svg"); at >= 0 {
+			svg = svg[at:]
+		}
+		b.WriteString("<figure>\n" + svg + "\n</figure>\n")
+	default:
+		b.WriteString(s.w.block("dot", dot))
+	}
+
+	var top []uint64
+	var spaces []uint64
+	for i := 0; i < s.po.Len(); i++ {
+		id := uint64(i)
+		if _, ok := s.po.GetNode(id).(*parse.SpaceDecl); ok {
+			spaces = append(spaces, id)
+		}
+		if _, ok := s.po.GetParent(id); !ok {
+			top = append(top, id)
+		}
+	}
+	if len(spaces) > 0 {
+		b.WriteString(s.w.heading(2, "spaces", "Spaces"))
+		var items []string
+		for _, id := range spaces {
+			item := s.linkTo(id)
+			if st := s.po.GetNode(id).(*parse.SpaceDecl).GetSpaceType().ToStr(); st != "" {
+				item += " " + s.w.text(st)
+			}
+			items = append(items, item)
+		}
+		b.WriteString(s.w.list(items))
+	}
+	kinds := s.byKind(top)
+	b.WriteString(s.section("Agents", kinds[parse.AGENT], 2))
+	b.WriteString(s.section("Paths", kinds[parse.PATH], 2))
+	return s.w.page(title, b.String())
+}
+
+type htmlWriter struct{}
+
+func (htmlWriter) page(title, body string) string {
+	return "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>" + html.EscapeString(title) +
+		"</title>\n<style>body{font-family:sans-serif;max-width:60em;margin:auto}code{background:#eee}</style>\n</head>\n<body>\n" +
+		body + "</body>\n</html>\n"
+}
+
+func (htmlWriter) heading(level int, anchor, text string) string {
+	id := ""
+	if anchor != "" {
+		id = fmt.Sprintf(" id=\"%s\"", html.EscapeString(anchor))
+	}
+	return fmt.Sprintf("<h%d%s>%s</h%d>\n", level, id, html.EscapeString(text), level)
+}
+
+func (htmlWriter) para(inline string) string {
+	return "<p>" + strings.ReplaceAll(inline, "\n", "<br>\n") + "</p>\n"
+}
+
+func (htmlWriter) list(items []string) string {
+	return "<ul>\n<li>" + strings.Join(items, "</li>\n<li>") + "</li>\n</ul>\n"
+}
+
+func (htmlWriter) fact(label, value string) string {
+	return "<p><b>" + html.EscapeString(label) + ":</b> " + value + "</p>\n"
+}
+
+func (htmlWriter) link(text, href string) string {
+	return "<a href=\"" + html.EscapeString(href) + "\">" + html.EscapeString(text) + "</a>"
+}
+
+func (htmlWriter) anchor(id, inline string) string {
+	return "<span id=\"" + html.EscapeString(id) + "\">" + inline + "</span>"
+}
+
+func (htmlWriter) code(text string) string {
+	return "<code>" + html.EscapeString(text) + "</code>"
+}
+
+func (htmlWriter) text(s string) string {
+	return html.EscapeString(s)
+}
+
+func (htmlWriter) block(lang, text string) string {
+	return "<pre class=\"" + lang + "\">" + html.EscapeString(text) + "</pre>\n"
+}
+
+type markdownWriter struct{}
+
+var markdownSpecial = strings.NewReplacer(
+	"\\", "\\\\", "`", "\\`", "*", "\\*", "_", "\\_", "[", "\\[", "]", "\\]", "<", "&lt;", ">", "&gt;", "#", "\\#",
+)
+
+func (markdownWriter) page(title, body string) string {
+	return body
+}
+
+func (markdownWriter) heading(level int, anchor, text string) string {
+	var b strings.Builder
+	b.WriteString("\n")
+	if anchor != "" {
+		fmt.Fprintf(&b, "<a id=\"%s\"></a>\n", anchor)
+	}
+	fmt.Fprintf(&b, "%s %s\n\n", strings.Repeat("#", level), markdownSpecial.Replace(text))
+	return b.String()
+}
+
+func (markdownWriter) para(inline string) string {
+	// a line break within the paragraph
+	return "\n" + strings.ReplaceAll(inline, "\n", "  \n") + "\n\n"
+}
+
+func (markdownWriter) list(items []string) string {
+	return "- " + strings.Join(items, "\n- ") + "\n\n"
+}
+
+func (markdownWriter) fact(label, value string) string {
+	return "**" + label + ":** " + value + "  \n"
+}
+
+func (markdownWriter) link(text, href string) string {
+	return "[" + markdownSpecial.Replace(text) + "](" + href + ")"
+}
+
+func (markdownWriter) anchor(id, inline string) string {
+	return "<a id=\"" + id + "\"></a>" + inline
+}
+
+func (markdownWriter) code(text string) string {
+	return "`" + text + "`"
+}
+
+func (markdownWriter) text(s string) string {
+	return markdownSpecial.Replace(s)
+}
+
+func (markdownWriter) block(lang, text string) string {
+	return "```" + lang + "\n" + strings.TrimRight(text, "\n") + "\n```\n\n"
+}
@@ -0,0 +1,349 @@
+package generate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache keeps artifacts on disk by Key, so that they outlive the process that
+// generated them. Each is a JSON file named by its key, holding a hash of the
+// artifact to catch files that were damaged or edited; a bad entry is a miss.
+// Past the size limit, the entries used longest ago are evicted.
+//
+// An entry is filed as <key[:2]>/<key>.json, key being a Key. Nothing else in
+// the directory is the cache's, so nothing else is listed, evicted or cleared.
+type Cache struct {
+	dir string
+	// 0 for no limit
+	max_bytes int64
+
+	// the size of the entries, kept up to date by Put so that it need not walk
+	// the directory each time; read from disk again once sized is unset
+	mu sync.Mutex
+	size int64
+	sized bool
+}
+
+// evicting past the limit goes down to this fraction of it, so that the next
+// few Puts fit without walking the directory again
+const evictTo = 0.9
+
+type Entry struct {
+	Key string `json:"key"`
+	Subject string `json:"subject"`
+	// the Generator's Config
+	Config string `json:"config"`
+	// sha256 of Text
+	Sum string `json:"sum"`
+	Text string `json:"text"`
+	Created time.Time `json:"created"`
+
+	// of the file, not stored in it
+	Size int64 `json:"-"`
+	Used time.Time `json:"-"`
+}
+
+// Problem is an entry that failed Verify
+type Problem struct {
+	Path string
+	Err error
+}
+
+// DefaultCacheDir is under the user's cache directory, or the working
+// directory if there is none
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ".anglish-cache"
+	}
+	return filepath.Join(dir, "anglish", "generate")
+}
+
+func OpenCache(dir string, max_bytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Cache{dir: dir, max_bytes: max_bytes}, nil
+}
+
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key + ".json")
+}
+
+// isHex says if s is all lower case hex digits, as Key gives them
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !('0' <= s[i] && s[i] <= '9' || 'a' <= s[i] && s[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// validKey says if key is one Key could have made
+func validKey(key string) bool {
+	return len(key) == sha256.Size * 2 && isHex(key)
+}
+
+func textSum(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// readEntry loads and checks the entry in a file
+func readEntry(path string) (Entry, error) {
+	var e Entry
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return e, err
+	}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return e, fmt.Errorf("malformed entry: %w", err)
+	}
+	if filepath.Base(path) != e.Key + ".json" {
+		return e, fmt.Errorf("entry for %s is filed under the wrong key", e.Key)
+	}
+	if textSum(e.Text) != e.Sum {
+		return e, fmt.Errorf("artifact for %s does not match its checksum", e.Subject)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return e, err
+	}
+	e.Size, e.Used = info.Size(), info.ModTime()
+	return e, nil
+}
+
+// Get finds an entry and marks it used. A damaged entry is removed.
+func (c *Cache) Get(key string) (Entry, bool) {
+	if !validKey(key) {
+		return Entry{}, false
+	}
+	path := c.path(key)
+	e, err := readEntry(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			os.Remove(path)
+			c.unsize()
+		}
+		return Entry{}, false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	e.Used = now
+	return e, true
+}
+
+// unsize forgets the size, after entries were removed other than by Evict
+func (c *Cache) unsize() {
+	c.mu.Lock()
+	c.sized = false
+	c.mu.Unlock()
+}
+
+// Put stores an entry, filling in its Sum and Created, then evicts what no
+// longer fits
+func (c *Cache) Put(e Entry) error {
+	if !validKey(e.Key) {
+		return fmt.Errorf("generate: %q is not a cache key", e.Key)
+	}
+	e.Sum = textSum(e.Text)
+	e.Created = time.Now().UTC()
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := c.path(e.Key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	old_size := int64(0)
+	if info, err := os.Stat(path); err == nil {
+		old_size = info.Size()
+	}
+	// through a temporary file, so that a crash never leaves half an entry
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".put-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if c.max_bytes <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sized {
+		c.size += int64(len(data)) - old_size
+	} else if c.size, err = c.total(); err != nil {
+		return err
+	}
+	c.sized = true
+	if c.size > c.max_bytes {
+		_, err = c.evict(int64(float64(c.max_bytes) * evictTo))
+	}
+	return err
+}
+
+// total adds up the size of every entry file
+func (c *Cache) total() (int64, error) {
+	total := int64(0)
+	err := c.walk(func(_ string, info fs.FileInfo) error {
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
+
+// walk calls fn for every entry file: <key>.json in a directory named for its
+// first two characters, right under the cache's
+func (c *Cache) walk(fn func(path string, info fs.FileInfo) error) error {
+	subdirs, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	for _, d := range subdirs {
+		if !d.IsDir() || len(d.Name()) != 2 || !isHex(d.Name()) {
+			continue
+		}
+		dir := filepath.Join(c.dir, d.Name())
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			key, ok := strings.CutSuffix(f.Name(), ".json")
+			if !ok || !f.Type().IsRegular() || !validKey(key) || key[:2] != d.Name() {
+				continue
+			}
+			info, err := f.Info()
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := fn(filepath.Join(dir, f.Name()), info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// List gives every good entry, most recently used first
+func (c *Cache) List() ([]Entry, error) {
+	var entries []Entry
+	err := c.walk(func(path string, _ fs.FileInfo) error {
+		if e, err := readEntry(path); err == nil {
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Used.After(entries[j].Used) })
+	return entries, err
+}
+
+// Evict removes the entries used longest ago until the rest take up no more
+// than max_bytes, and says how many it removed
+func (c *Cache) Evict(max_bytes int64) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.evict(max_bytes)
+}
+
+func (c *Cache) evict(max_bytes int64) (int, error) {
+	c.sized = false
+	type file struct {
+		path string
+		size int64
+		used time.Time
+	}
+	var files []file
+	total := int64(0)
+	err := c.walk(func(path string, info fs.FileInfo) error {
+		files = append(files, file{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].used.Before(files[j].used) })
+
+	removed := 0
+	for _, f := range files {
+		if total <= max_bytes {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		total -= f.size
+		removed++
+	}
+	c.size, c.sized = total, true
+	return removed, nil
+}
+
+// Clear removes every entry, and nothing else that may be in the directory
+func (c *Cache) Clear() error {
+	c.unsize()
+	err := c.walk(func(path string, _ fs.FileInfo) error {
+		return os.Remove(path)
+	})
+	if err != nil {
+		return err
+	}
+	subdirs, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	for _, d := range subdirs {
+		if d.IsDir() && len(d.Name()) == 2 && isHex(d.Name()) {
+			// fails, harmlessly, unless empty
+			os.Remove(filepath.Join(c.dir, d.Name()))
+		}
+	}
+	return nil
+}
+
+// Verify checks every entry, removing the bad ones if prune is set
+func (c *Cache) Verify(prune bool) ([]Problem, error) {
+	var problems []Problem
+	err := c.walk(func(path string, _ fs.FileInfo) error {
+		if _, err := readEntry(path); err != nil {
+			problems = append(problems, Problem{Path: path, Err: err})
+			if prune {
+				c.unsize()
+				return os.Remove(path)
+			}
+		}
+		return nil
+	})
+	return problems, err
+}
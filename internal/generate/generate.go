@@ -0,0 +1,180 @@
+package generate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// Generation turns each declaration of a contract into an artifact, in
+// dependency order, so that a declaration is generated knowing what the ones it
+// depends on came out as. A declaration is only generated again when its text,
+// or the text of anything it depends on, directly or not, has changed since the
+// last build, or the generator's prompt or model has: see Key. With a Cache,
+// that holds across processes too.
+
+type Artifact struct {
+	// qualified name of the declaration, eg. "@store.$get"
+	Subject string
+	Text string
+}
+
+type Request struct {
+	Subject string
+	// the declaration as written, nested declarations and all
+	Declaration string
+	// artifacts of the declarations it depends on directly
+	Deps []Artifact
+}
+
+// Generator produces one artifact. ModelGenerator asks an LLM; tests use a
+// scripted fake.
+type Generator interface {
+	Generate(ctx context.Context, req Request) (string, error)
+	// Config describes whatever, besides the request, decides what Generate
+	// answers, such as the prompt version and the model; it is part of every Key
+	Config() string
+}
+
+type Result struct {
+	Artifact
+	Key string
+	// the artifact is the one an earlier build made
+	Reused bool
+	// generation failed, or was skipped because a dependency's did
+	Err error
+}
+
+// Builder remembers what it generated, so that building the same contract again
+// after an edit only generates what the edit affects
+type Builder struct {
+	gen Generator
+	// artifacts of the last build by Key
+	outputs map[string]string
+	// nil if there is none
+	cache *Cache
+}
+
+// NewBuilder makes a Builder, with or without a cache
+func NewBuilder(gen Generator, cache *Cache) *Builder {
+	return &Builder{
+		gen: gen,
+		outputs: make(map[string]string),
+		cache: cache,
+	}
+}
+
+// Key identifies a declaration's artifact: a hash of its normalised text, the
+// keys of its dependencies, which are hashed the same way, and the generator's
+// config
+func Key(text string, dep_keys []string, config string) string {
+	h := sha256.New()
+	norm := Normalise(text)
+	fmt.Fprintf(h, "%d:%s|%d:%s", len(config), config, len(norm), norm)
+	for _, k := range dep_keys {
+		fmt.Fprintf(h, "|%s", k)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Normalise drops what does not change a declaration's meaning: comment lines,
+// blank lines, indentation, trailing space and runs of spaces
+func Normalise(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Build generates an artifact for every declaration in po, reusing those from
+// earlier builds or in the cache whose key has not changed. A declaration in a
+// dependency cycle cannot be generated, nor can anything depending on one that
+// failed. Build only returns an error if ctx is done; other failures are in the
+// results, which are in the order the declarations were generated.
+func (b *Builder) Build(ctx context.Context, c *parse.Contract, po *parse.ParseOrder) ([]Result, error) {
+	tree := c.GetSyntaxTree()
+	keys := make(map[uint64]string)
+	failed := make(map[uint64]bool)
+	done := make(map[uint64]bool)
+	outputs := make(map[string]string)
+	var results []Result
+
+	build := func(id uint64) Result {
+		node := po.GetNode(id)
+		res := Result{Artifact: Artifact{Subject: po.GetQualifiedName(id)}}
+		req := Request{Subject: res.Subject, Declaration: tree.Text(node.GetSpan())}
+		if inst := node.GetInstantiation(); inst != nil {
+			// the text is the template's, the same for all its instances
+			req.Declaration = inst.ToStr() + "\n" + req.Declaration
+		}
+		var dep_keys []string
+		for _, dep := range po.GetDeps(id) {
+			if failed[dep] {
+				res.Err = fmt.Errorf("%s was not generated", po.GetQualifiedName(dep))
+				return res
+			}
+			if !done[dep] {
+				res.Err = fmt.Errorf("%s is in a dependency cycle", res.Subject)
+				return res
+			}
+			dep_keys = append(dep_keys, keys[dep])
+			req.Deps = append(req.Deps, Artifact{Subject: po.GetQualifiedName(dep), Text: outputs[keys[dep]]})
+		}
+		res.Key = Key(req.Declaration, dep_keys, b.gen.Config())
+
+		if text, ok := b.outputs[res.Key]; ok {
+			res.Text, res.Reused = text, true
+			return res
+		}
+		if b.cache != nil {
+			if e, ok := b.cache.Get(res.Key); ok {
+				res.Text, res.Reused = e.Text, true
+				return res
+			}
+		}
+		res.Text, res.Err = b.gen.Generate(ctx, req)
+		if res.Err == nil && b.cache != nil {
+			// a cache that cannot be written to only costs the next build time
+			b.cache.Put(Entry{Key: res.Key, Subject: res.Subject, Config: b.gen.Config(), Text: res.Text})
+		}
+		return res
+	}
+
+	for _, id := range po.GetSorted() {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		res := build(id)
+		if res.Err != nil {
+			failed[id] = true
+		} else {
+			keys[id] = res.Key
+			outputs[res.Key] = res.Text
+		}
+		done[id] = true
+		results = append(results, res)
+	}
+	// GetSorted has every node, cycles and all, but anything it left out
+	// could not have been ordered
+	for i := 0; i < po.Len(); i++ {
+		if id := uint64(i); !done[id] {
+			results = append(results, Result{
+				Artifact: Artifact{Subject: po.GetQualifiedName(id)},
+				Err: fmt.Errorf("%s could not be ordered, there is a dependency cycle", po.GetQualifiedName(id)),
+			})
+		}
+	}
+
+	// what this build did not use is out of date
+	b.outputs = outputs
+	return results, nil
+}
@@ -0,0 +1,86 @@
+package generate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+
+	"github.com/anotherLostKitten/Anglish/internal/llm"
+)
+
+// PromptVersion goes up whenever the prompt changes, so that cached artifacts
+// made with the old one are not reused
+const PromptVersion = 1
+
+const generateSystemPrompt = `You are generating code from an Anglish contract.
+You are given one declaration from the contract, and the code already generated for the declarations it depends on.
+Write the code for this declaration only, consistent with the code it depends on.
+Answer with the code and nothing else.`
+
+// ModelGenerator asks an LLM for each artifact.
+type ModelGenerator struct {
+	model llms.Model
+	// which model it is, eg. "gpt-4o at https://api.openai.com"
+	name string
+}
+
+func NewModelGenerator(model llms.Model, name string) *ModelGenerator {
+	return &ModelGenerator{
+		model: model,
+		name: name,
+	}
+}
+
+// NewOpenAIGenerator builds a ModelGenerator on the model configured for llm.NewOpenAI.
+func NewOpenAIGenerator() (*ModelGenerator, error) {
+	model, err := llm.NewOpenAI()
+	if err != nil {
+		return nil, err
+	}
+	name := os.Getenv("OPENAI_MODEL")
+	if base := os.Getenv("OPENAI_BASE_URL"); base != "" {
+		name += " at " + base
+	}
+	return NewModelGenerator(model, name), nil
+}
+
+func (mg *ModelGenerator) Config() string {
+	return fmt.Sprintf("prompt=%d model=%s temperature=0", PromptVersion, mg.name)
+}
+
+func (mg *ModelGenerator) Generate(ctx context.Context, req Request) (string, error) {
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "Declaration %s:\n```\n%s\n```\n", req.Subject, req.Declaration)
+	for _, dep := range req.Deps {
+		fmt.Fprintf(&prompt, "\nGenerated for %s:\n```\n%s\n```\n", dep.Subject, dep.Text)
+	}
+	resp, err := mg.model.GenerateContent(ctx, []llms.MessageContent{
+		llms.TextParts(schema.ChatMessageTypeSystem, generateSystemPrompt),
+		llms.TextParts(schema.ChatMessageTypeHuman, prompt.String()),
+	}, llms.WithTemperature(0))
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("empty response from generator model")
+	}
+	return stripFence(resp.Choices[0].Content), nil
+}
+
+// stripFence takes the code out of a reply that wrapped it in a code fence
+func stripFence(reply string) string {
+	trimmed := strings.TrimSpace(reply)
+	if !strings.HasPrefix(trimmed, "```") || !strings.HasSuffix(trimmed, "```") || len(trimmed) < 6 {
+		return reply
+	}
+	body := strings.TrimSuffix(trimmed, "```")
+	// drop the opening fence and any language tag after it
+	if nl := strings.IndexByte(body, '\n'); nl >= 0 {
+		return body[nl + 1:]
+	}
+	return reply
+}
@@ -0,0 +1,151 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// The space-path graph of a contract, as Graphviz DOT. Nodes are space
+// instances from the routing table, so a space $use()d in two places shows up
+// twice. Solid edges are routes, labelled with their =path; dashed edges run
+// from a space to the instances its $use() makes; ambiguous routes are drawn
+// dotted and red to every instance they might reach. Spaces with subspaces are
+// drawn as clusters around them.
+
+func quote(s string) string {
+	return "\"" + strings.ReplaceAll(s, "\"", "\\\"") + "\""
+}
+
+func instanceID(si parse.SpaceInstance) string {
+	return quote(si.ToStr())
+}
+
+// DOT writes the graph of a resolved contract. It refuses one whose names do
+// not resolve, as the routing table it draws would be wrong.
+func DOT(po *parse.ParseOrder, rt *parse.RoutingTable) (string, error) {
+	for _, p := range po.GetProblems() {
+		if p.Kind != parse.NameCycle {
+			return "", fmt.Errorf("graph: %s: %s", po.GetQualifiedName(p.Node), p.ToStr())
+		}
+	}
+
+	spaces := make(map[parse.Ident]*parse.SpaceDecl)
+	paths := make(map[parse.Ident]*parse.PathDecl)
+	// top level spaces, then the subspaces of each, in declaration order; by
+	// node id, as a subspace may share its name with its parent or a cousin
+	var roots []uint64
+	subspaces := make(map[uint64][]uint64)
+	for i := 0; i < po.Len(); i++ {
+		id := uint64(i)
+		switch d := po.GetNode(id).(type) {
+		case *parse.SpaceDecl:
+			if _, ok := spaces[d.GetName()]; !ok {
+				spaces[d.GetName()] = d
+			}
+			if p, ok := po.GetParent(id); ok {
+				subspaces[p] = append(subspaces[p], id)
+			} else {
+				roots = append(roots, id)
+			}
+		case *parse.PathDecl: paths[d.GetName()] = d
+		}
+	}
+	instances := make(map[parse.Ident][]parse.SpaceInstance)
+	for _, inst := range rt.GetInstances() {
+		instances[inst.Space] = append(instances[inst.Space], inst)
+	}
+	pathLabel := func(p parse.Ident) string {
+		label := p.ToStr()
+		if d, ok := paths[p]; ok {
+			label += ":" + d.GetPathType().ToStr()
+			if d.GetAccess() != parse.AnyAccess {
+				label += ":" + d.GetAccess().ToStr()
+			}
+		}
+		return quote(label)
+	}
+
+	var b strings.Builder
+	b.WriteString("digraph contract {\n")
+	b.WriteString("\tnode [shape=box];\n")
+	// the routing table knows spaces by name, so each name's instances are
+	// drawn once, with the first space of that name
+	visited := make(map[uint64]bool)
+	drawn := make(map[parse.Ident]bool)
+	clusters := make(map[string]bool)
+	var writeSpace func(id uint64, indent string)
+	writeSpace = func(id uint64, indent string) {
+		if visited[id] {
+			return
+		}
+		visited[id] = true
+		space := po.GetNode(id).GetName()
+		cluster := len(subspaces[id]) > 0
+		if cluster {
+			name := "cluster_" + space.ToStr()
+			if clusters[name] {
+				name += fmt.Sprintf("_%d", id)
+			}
+			clusters[name] = true
+			fmt.Fprintf(&b, "%s\tsubgraph %s {\n", indent, quote(name))
+			indent += "\t"
+			fmt.Fprintf(&b, "%s\tlabel=%s;\n", indent, quote(space.ToStr()))
+		}
+		var insts []parse.SpaceInstance
+		if !drawn[space] {
+			drawn[space] = true
+			insts = instances[space]
+		}
+		for _, inst := range insts {
+			label := inst.Space.ToStr()
+			if s, ok := spaces[inst.Space]; ok {
+				if st := s.GetSpaceType().ToStr(); st != "" {
+					label += ":" + st
+				}
+				if s.IsReplicable() {
+					label += ":REPLICABLE"
+				}
+			}
+			if !inst.IsTopLevel() {
+				label += "\\nin " + inst.Site.ToStr()
+			}
+			fmt.Fprintf(&b, "%s\t%s [label=%s];\n", indent, instanceID(inst), quote(label))
+		}
+		for _, sub := range subspaces[id] {
+			writeSpace(sub, indent)
+		}
+		if cluster {
+			fmt.Fprintf(&b, "%s}\n", indent)
+		}
+	}
+	for _, space := range roots {
+		writeSpace(space, "")
+	}
+
+	for _, inst := range rt.GetInstances() {
+		if inst.IsTopLevel() {
+			continue
+		}
+		// the importer's own instances all hold this one
+		for _, site := range rt.GetInstances() {
+			if site.Space == inst.Site {
+				fmt.Fprintf(&b, "\t%s -> %s [style=dashed, label=\"$use\"];\n", instanceID(site), instanceID(inst))
+			}
+		}
+	}
+	for _, r := range rt.GetRoutes() {
+		fmt.Fprintf(&b, "\t%s -> %s [label=%s];\n", instanceID(r.From), instanceID(r.To), pathLabel(r.Path))
+	}
+	for _, p := range rt.GetProblems() {
+		if p.Kind != parse.RouteAmbiguous {
+			continue
+		}
+		for _, c := range p.Candidates {
+			fmt.Fprintf(&b, "\t%s -> %s [style=dotted, color=red, label=%s];\n", instanceID(p.Instance), instanceID(c), pathLabel(p.Path))
+		}
+	}
+	b.WriteString("}\n")
+	return b.String(), nil
+}
@@ -0,0 +1,90 @@
+package lint
+
+import (
+	"sort"
+
+	"github.com/anotherLostKitten/Anglish/internal/check"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// Context is what every Rule gets to look at: the parsed contract and its
+// resolved ParseOrder.
+type Context struct {
+	Contract *parse.Contract
+	Order *parse.ParseOrder
+}
+
+// Rule is a single lint check. Check only reports where and what; the Linter
+// fills in the rule name and the configured severity.
+type Rule interface {
+	Name() string
+	DefaultSeverity() check.Severity
+	Check(ctx *Context) []check.Diagnostic
+}
+
+// Config maps rule names to severities, overriding each rule's default.
+type Config map[string]check.Severity
+
+type Linter struct {
+	rules []Rule
+	config Config
+}
+
+func NewLinter(rules []Rule, config Config) *Linter {
+	return &Linter{
+		rules: rules,
+		config: config,
+	}
+}
+
+func (l *Linter) severity(r Rule) check.Severity {
+	if sev, ok := l.config[r.Name()]; ok {
+		return sev
+	}
+	return r.DefaultSeverity()
+}
+
+// Run checks every enabled rule and drops anything suppressed in the source,
+// returning diagnostics ordered by position.
+func (l *Linter) Run(c *parse.Contract, po *parse.ParseOrder) []check.Diagnostic {
+	ctx := Context{
+		Contract: c,
+		Order: po,
+	}
+	sup := collectSuppressions(c, po)
+
+	var diags []check.Diagnostic
+	for _, r := range l.rules {
+		sev := l.severity(r)
+		if sev == check.Off {
+			continue
+		}
+		for _, d := range r.Check(&ctx) {
+			d.Rule = r.Name()
+			d.Severity = sev
+			if sup.suppressed(d) {
+				continue
+			}
+			diags = append(diags, d)
+		}
+	}
+
+	sort.SliceStable(diags, func(i, j int) bool {
+		if diags[i].Line != diags[j].Line {
+			return diags[i].Line < diags[j].Line
+		}
+		return diags[i].Col < diags[j].Col
+	})
+	return diags
+}
+
+func DefaultRules() []Rule {
+	return []Rule{
+		UnusedTask{},
+		NoInboundPath{},
+		EmptyVibe{},
+		AgentWithoutUse{},
+		SelfPath{},
+		IdentCase{},
+	}
+}
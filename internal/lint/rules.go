@@ -0,0 +1,212 @@
+package lint
+
+import (
+	"fmt"
+	"unicode"
+
+	"github.com/anotherLostKitten/Anglish/internal/check"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+func declLine(u parse.ParseUnit) uint64 {
+	line, _ := u.GetLines()
+	return line
+}
+
+// UnusedTask: a $task that no other declaration's vibe block calls.
+type UnusedTask struct{}
+
+func (UnusedTask) Name() string { return "unused-task" }
+func (UnusedTask) DefaultSeverity() check.Severity { return check.Warning }
+
+func (UnusedTask) Check(ctx *Context) []check.Diagnostic {
+	po := ctx.Order
+	called := make(map[uint64]bool)
+	for i := 0; i < po.Len(); i++ {
+		u := po.GetNode(uint64(i))
+		for _, mr := range u.GetVibe().GetMetaRefs() {
+			t_ref, ok := mr.(*parse.MetaRefTask)
+			if !ok {
+				continue
+			}
+			if t, err := po.ResolveTask(uint64(i), t_ref); err == nil && t != uint64(i) {
+				called[t] = true
+			}
+		}
+	}
+
+	var diags []check.Diagnostic
+	for i := 0; i < po.Len(); i++ {
+		t, ok := po.GetNode(uint64(i)).(*parse.TaskDecl)
+		if !ok || called[uint64(i)] {
+			continue
+		}
+		diags = append(diags, check.Diagnostic{
+			Line: declLine(t),
+			Instantiation: t.GetInstantiation(),
+			Msg: fmt.Sprintf("%s is never called", po.GetQualifiedName(uint64(i))),
+		})
+	}
+	return diags
+}
+
+// NoInboundPath: a @space that no =path leads into.
+type NoInboundPath struct{}
+
+func (NoInboundPath) Name() string { return "no-inbound-path" }
+func (NoInboundPath) DefaultSeverity() check.Severity { return check.Warning }
+
+func (NoInboundPath) Check(ctx *Context) []check.Diagnostic {
+	inbound := make(map[parse.Ident]bool)
+	for _, p := range ctx.Contract.AllPaths() {
+		inbound[p.GetDest()] = true
+	}
+
+	var diags []check.Diagnostic
+	for _, s := range ctx.Contract.AllSpaces() {
+		if inbound[s.GetName()] {
+			continue
+		}
+		diags = append(diags, check.Diagnostic{
+			Line: declLine(s),
+			Instantiation: s.GetInstantiation(),
+			Msg: fmt.Sprintf("%s has no inbound =path", s.GetName().ToStr()),
+		})
+	}
+	return diags
+}
+
+// EmptyVibe: a @space, #agent or $task whose vibe block has no prose. Paths
+// are exempt since the grammar makes their vibe block optional.
+type EmptyVibe struct{}
+
+func (EmptyVibe) Name() string { return "empty-vibe" }
+func (EmptyVibe) DefaultSeverity() check.Severity { return check.Warning }
+
+func (EmptyVibe) Check(ctx *Context) []check.Diagnostic {
+	po := ctx.Order
+	var diags []check.Diagnostic
+	for i := 0; i < po.Len(); i++ {
+		u := po.GetNode(uint64(i))
+		if _, ok := u.(*parse.PathDecl); ok {
+			continue
+		}
+		if len(u.GetVibe().GetProse()) > 0 {
+			continue
+		}
+		diags = append(diags, check.Diagnostic{
+			Line: declLine(u),
+			Instantiation: u.GetInstantiation(),
+			Msg: fmt.Sprintf("%s has an empty vibe block", u.GetName().ToStr()),
+		})
+	}
+	return diags
+}
+
+// AgentWithoutUse: an #agent whose vibe block never $use()s anything.
+type AgentWithoutUse struct{}
+
+func (AgentWithoutUse) Name() string { return "agent-without-use" }
+func (AgentWithoutUse) DefaultSeverity() check.Severity { return check.Warning }
+
+func (AgentWithoutUse) Check(ctx *Context) []check.Diagnostic {
+	po := ctx.Order
+	var diags []check.Diagnostic
+	for i := 0; i < po.Len(); i++ {
+		a, ok := po.GetNode(uint64(i)).(*parse.AgentDecl)
+		if !ok {
+			continue
+		}
+		uses := false
+		for _, mr := range a.GetVibe().GetMetaRefs() {
+			if _, ok := mr.(*parse.MetaRefUseImport); ok {
+				uses = true
+				break
+			}
+		}
+		if uses {
+			continue
+		}
+		diags = append(diags, check.Diagnostic{
+			Line: declLine(a),
+			Instantiation: a.GetInstantiation(),
+			Msg: fmt.Sprintf("%s never $use()s a space or agent", a.GetName().ToStr()),
+		})
+	}
+	return diags
+}
+
+// SelfPath: a =path whose source and destination are the same space.
+type SelfPath struct{}
+
+func (SelfPath) Name() string { return "self-path" }
+func (SelfPath) DefaultSeverity() check.Severity { return check.Warning }
+
+func (SelfPath) Check(ctx *Context) []check.Diagnostic {
+	var diags []check.Diagnostic
+	for _, p := range ctx.Contract.AllPaths() {
+		if p.GetSource() != p.GetDest() {
+			continue
+		}
+		diags = append(diags, check.Diagnostic{
+			Line: declLine(p),
+			Instantiation: p.GetInstantiation(),
+			Msg: fmt.Sprintf("%s leads from %s back to itself", p.GetName().ToStr(), p.GetSource().ToStr()),
+		})
+	}
+	return diags
+}
+
+// IdentCase: declaration and data names should be lower snake_case.
+type IdentCase struct{}
+
+func (IdentCase) Name() string { return "ident-case" }
+func (IdentCase) DefaultSeverity() check.Severity { return check.Warning }
+
+// isSnakeCase only looks for capitals: identifiers may be in any script, and
+// most have no case at all
+func isSnakeCase(ident string) bool {
+	for _, ch := range ident {
+		if unicode.IsUpper(ch) || unicode.IsTitle(ch) {
+			return false
+		}
+	}
+	return true
+}
+
+func paramsOf(u parse.ParseUnit) []parse.Param {
+	switch d := u.(type) {
+	case *parse.SpaceDecl: return d.GetParams()
+	case *parse.AgentDecl: return d.GetParams()
+	case *parse.TaskDecl: return d.GetParams()
+	default: return nil
+	}
+}
+
+func (IdentCase) Check(ctx *Context) []check.Diagnostic {
+	po := ctx.Order
+	var diags []check.Diagnostic
+	for i := 0; i < po.Len(); i++ {
+		u := po.GetNode(uint64(i))
+		if !isSnakeCase(u.GetName().GetIdent()) {
+			diags = append(diags, check.Diagnostic{
+				Line: declLine(u),
+				Instantiation: u.GetInstantiation(),
+				Msg: fmt.Sprintf("%s should be lower snake_case", u.GetName().ToStr()),
+			})
+		}
+		for _, p := range paramsOf(u) {
+			if isSnakeCase(p.GetDataName()) {
+				continue
+			}
+			line, col := p.GetPos()
+			diags = append(diags, check.Diagnostic{
+				Line: line,
+				Col: col,
+				Instantiation: u.GetInstantiation(),
+				Msg: fmt.Sprintf("%%%s should be lower snake_case", p.GetDataName()),
+			})
+		}
+	}
+	return diags
+}
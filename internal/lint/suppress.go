@@ -0,0 +1,94 @@
+package lint
+
+import (
+	"strings"
+
+	"github.com/anotherLostKitten/Anglish/internal/check"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// Suppression comments:
+//
+//	// anglish:ignore rule-a rule-b    silences the rules for the next declaration
+//	// anglish:ignore-file rule-a      silences the rules for the whole contract
+//
+// "all" matches every rule.
+const (
+	ignoreDirective = "anglish:ignore"
+	ignoreFileDirective = "anglish:ignore-file"
+)
+
+type lineRange struct {
+	start, end uint64
+}
+
+func (lr lineRange) contains(line uint64) bool {
+	return line == lr.start || line >= lr.start && line < lr.end
+}
+
+type suppressions struct {
+	file map[string]bool
+	decls map[string][]lineRange
+}
+
+func collectSuppressions(c *parse.Contract, po *parse.ParseOrder) suppressions {
+	sup := suppressions{
+		file: make(map[string]bool),
+		decls: make(map[string][]lineRange),
+	}
+
+	for _, cm := range c.GetComments() {
+		fields := strings.FieldsFunc(cm.GetText(), func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ','
+		})
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case ignoreFileDirective:
+			for _, rule := range fields[1:] {
+				sup.file[rule] = true
+			}
+		case ignoreDirective:
+			lr, ok := nextDecl(po, cm.GetLine())
+			if !ok {
+				continue
+			}
+			for _, rule := range fields[1:] {
+				sup.decls[rule] = append(sup.decls[rule], lr)
+			}
+		}
+	}
+	return sup
+}
+
+// nextDecl finds the first declaration starting after line
+func nextDecl(po *parse.ParseOrder, line uint64) (lineRange, bool) {
+	var best lineRange
+	found := false
+	for i := 0; i < po.Len(); i++ {
+		start, end := po.GetNode(uint64(i)).GetLines()
+		if start <= line {
+			continue
+		}
+		if !found || start < best.start {
+			best = lineRange{start, end}
+			found = true
+		}
+	}
+	return best, found
+}
+
+func (sup *suppressions) suppressed(d check.Diagnostic) bool {
+	if sup.file[d.Rule] || sup.file["all"] {
+		return true
+	}
+	for _, rule := range []string{d.Rule, "all"} {
+		for _, lr := range sup.decls[rule] {
+			if lr.contains(d.Line) {
+				return true
+			}
+		}
+	}
+	return false
+}
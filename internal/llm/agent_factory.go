@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/tmc/langchaingo/agents"
+	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/schema"
 	"github.com/tmc/langchaingo/tools"
 )
@@ -28,6 +29,24 @@ func NewAgentExecutor(systemPrompt string, toolList []tools.Tool, mem schema.Mem
 		return agents.Executor{}, err
 	}
 
+	return NewAgentExecutorWithModel(llmClient, systemPrompt, toolList, mem)
+}
+
+// NewAgentExecutorWithModel is NewAgentExecutor on a caller-supplied model
+// instead of the one configured in the environment, eg. a fake in tests.
+func NewAgentExecutorWithModel(llmClient llms.Model, systemPrompt string, toolList []tools.Tool, mem schema.Memory) (agents.Executor, error) {
+	if llmClient == nil {
+		return agents.Executor{}, fmt.Errorf("model is nil")
+	}
+
+	if systemPrompt == "" {
+		return agents.Executor{}, fmt.Errorf("systemPrompt is empty")
+	}
+
+	if toolList == nil {
+		return agents.Executor{}, fmt.Errorf("toolList is nil")
+	}
+
 	// Create an OpenAI Functions-style agent configured with the system prompt.
 	agent := agents.NewOpenAIFunctionsAgent(
 		llmClient,
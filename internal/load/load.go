@@ -0,0 +1,110 @@
+package load
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// Loading many contract files at once, each its own contract. A pool of
+// workers reads and parses the files; the parser keeps all its state in the
+// call, so they share nothing but the list of paths. Name resolution waits
+// until every file is parsed and then runs once per file, in the order the
+// files were given, so what it reports comes out in that order whatever the
+// number of workers.
+
+type File struct {
+	Path string
+	Contract parse.Contract
+	Order parse.ParseOrder
+	Errors []parse.ParserErrorInfo
+	// the file could not be read; the rest is empty
+	Err error
+}
+
+type Config struct {
+	// how many files to parse at once, GOMAXPROCS if 0
+	Workers int
+	Parse parse.ParseConfig
+}
+
+// Paths lists the contract files named by args: files as they are, and the .ang
+// files anywhere under directories, sorted
+func Paths(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		var found []string
+		err = filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && filepath.Ext(path) == ".ang" {
+				found = append(found, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(found)
+		paths = append(paths, found...)
+	}
+	return paths, nil
+}
+
+// Files reads, parses and resolves the files at paths, in the same order
+func Files(paths []string, cfg Config) []File {
+	files := make([]File, len(paths))
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, len(paths))
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				files[i] = parseFile(paths[i], cfg.Parse)
+			}
+		}()
+	}
+	for i := range paths {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for i := range files {
+		if files[i].Err == nil {
+			files[i].Order = parse.GetParseOrder(&files[i].Contract)
+		}
+	}
+	return files
+}
+
+func parseFile(path string, cfg parse.ParseConfig) File {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return File{Path: path, Err: err}
+	}
+	c, errors := parse.ParseFromReaderWith(strings.NewReader(string(src)), cfg)
+	return File{Path: path, Contract: c, Errors: errors}
+}
@@ -0,0 +1,289 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+	"github.com/anotherLostKitten/Anglish/internal/refactor"
+)
+
+// A language server for contracts, speaking LSP over JSON-RPC on a pair of
+// streams, usually stdin and stdout. Documents are synced whole, and positions
+// are in UTF-16 units as LSP expects. So far it only renames.
+
+const (
+	codeMethodNotFound = -32601
+	codeInvalidParams = -32602
+	codeRequestFailed = -32803
+)
+
+type message struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID *json.RawMessage `json:"id,omitempty"`
+	Method string `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+type responseError struct {
+	Code int `json:"code"`
+	Message string `json:"message"`
+}
+
+type Position struct {
+	Line uint64 `json:"line"`
+	Character uint64 `json:"character"`
+}
+
+type Range struct {
+	Start Position `json:"start"`
+	End Position `json:"end"`
+}
+
+type TextEdit struct {
+	Range Range `json:"range"`
+	NewText string `json:"newText"`
+}
+
+type WorkspaceEdit struct {
+	Changes map[string][]TextEdit `json:"changes"`
+}
+
+type textDocument struct {
+	URI string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type positionParams struct {
+	TextDocument textDocument `json:"textDocument"`
+	Position Position `json:"position"`
+	NewName string `json:"newName"`
+}
+
+type Server struct {
+	in *bufio.Reader
+	out io.Writer
+	out_mu sync.Mutex
+
+	// open documents, kept parsed as they change
+	docs map[string]*parse.Document
+	// the names in each, indexed when first asked for after a change
+	indexes map[string]*refactor.Index
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in: bufio.NewReader(in),
+		out: out,
+		docs: make(map[string]*parse.Document),
+		indexes: make(map[string]*refactor.Index),
+	}
+}
+
+func (s *Server) read() (*message, error) {
+	headers, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(headers.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("bad Content-Length: %v", err)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (s *Server) write(v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.out_mu.Lock()
+	defer s.out_mu.Unlock()
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (s *Server) reply(id *json.RawMessage, result any, rerr *responseError) error {
+	if rerr != nil {
+		return s.write(struct {
+			JSONRPC string `json:"jsonrpc"`
+			ID *json.RawMessage `json:"id"`
+			Error *responseError `json:"error"`
+		}{"2.0", id, rerr})
+	}
+	return s.write(struct {
+		JSONRPC string `json:"jsonrpc"`
+		ID *json.RawMessage `json:"id"`
+		Result any `json:"result"`
+	}{"2.0", id, result})
+}
+
+// Serve handles messages until the client sends exit or the input ends
+func (s *Server) Serve() error {
+	for {
+		msg, err := s.read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, rerr := s.handle(msg)
+		// notifications get no reply
+		if msg.ID == nil {
+			continue
+		}
+		if err := s.reply(msg.ID, result, rerr); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(msg *message) (any, *responseError) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"positionEncoding": "utf-16",
+				// whole documents on every change
+				"textDocumentSync": 1,
+				"renameProvider": map[string]bool{"prepareProvider": true},
+			},
+			"serverInfo": map[string]string{"name": "anglish"},
+		}, nil
+	case "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		var p struct{ TextDocument textDocument `json:"textDocument"` }
+		if json.Unmarshal(msg.Params, &p) == nil {
+			s.docs[p.TextDocument.URI] = parse.NewDocument(p.TextDocument.Text, parse.ParseConfig{Columns: parse.UTF16Units})
+			delete(s.indexes, p.TextDocument.URI)
+		}
+		return nil, nil
+	case "textDocument/didChange":
+		var p struct {
+			TextDocument textDocument `json:"textDocument"`
+			ContentChanges []struct{ Text string `json:"text"` } `json:"contentChanges"`
+		}
+		if json.Unmarshal(msg.Params, &p) == nil && len(p.ContentChanges) > 0 {
+			text := p.ContentChanges[len(p.ContentChanges) - 1].Text
+			delete(s.indexes, p.TextDocument.URI)
+			if doc, ok := s.docs[p.TextDocument.URI]; ok {
+				// only what changed is parsed again
+				doc.Update(text)
+			} else {
+				s.docs[p.TextDocument.URI] = parse.NewDocument(text, parse.ParseConfig{Columns: parse.UTF16Units})
+			}
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var p struct{ TextDocument textDocument `json:"textDocument"` }
+		if json.Unmarshal(msg.Params, &p) == nil {
+			delete(s.docs, p.TextDocument.URI)
+			delete(s.indexes, p.TextDocument.URI)
+		}
+		return nil, nil
+	case "textDocument/prepareRename":
+		return s.prepareRename(msg.Params)
+	case "textDocument/rename":
+		return s.rename(msg.Params)
+	default:
+		if strings.HasPrefix(msg.Method, "$/") || msg.ID == nil {
+			return nil, nil
+		}
+		return nil, &responseError{codeMethodNotFound, "unsupported method " + msg.Method}
+	}
+}
+
+// document is an open document and the declaration named at a position
+type document struct {
+	src string
+	contract *parse.Contract
+	errs int
+	index *refactor.Index
+	target uint64
+	span parse.Span
+}
+
+func (s *Server) documentAt(raw json.RawMessage) (*positionParams, *document, *responseError) {
+	var p positionParams
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, nil, &responseError{codeInvalidParams, err.Error()}
+	}
+	doc, ok := s.docs[p.TextDocument.URI]
+	if !ok {
+		return nil, nil, &responseError{codeRequestFailed, p.TextDocument.URI + " is not open"}
+	}
+
+	ix, ok := s.indexes[p.TextDocument.URI]
+	if !ok {
+		ix = refactor.NewIndex(doc.Order())
+		s.indexes[p.TextDocument.URI] = ix
+	}
+	d := document{src: doc.Source(), contract: doc.Contract(), errs: len(doc.Errors()), index: ix}
+	offset, ok := d.contract.GetSyntaxTree().Offset(p.Position.Line, p.Position.Character)
+	if !ok {
+		return &p, nil, nil
+	}
+	d.target, d.span, ok = ix.At(offset)
+	if !ok {
+		return &p, nil, nil
+	}
+	return &p, &d, nil
+}
+
+func (d *document) lspRange(s parse.Span) Range {
+	tree := d.contract.GetSyntaxTree()
+	var r Range
+	r.Start.Line, r.Start.Character = tree.Position(s.Start)
+	r.End.Line, r.End.Character = tree.Position(s.End)
+	return r
+}
+
+func (s *Server) prepareRename(raw json.RawMessage) (any, *responseError) {
+	_, d, rerr := s.documentAt(raw)
+	if rerr != nil || d == nil {
+		return nil, rerr
+	}
+	return map[string]any{
+		"range": d.lspRange(d.span),
+		"placeholder": d.contract.GetSyntaxTree().Text(d.span),
+	}, nil
+}
+
+func (s *Server) rename(raw json.RawMessage) (any, *responseError) {
+	p, d, rerr := s.documentAt(raw)
+	if rerr != nil {
+		return nil, rerr
+	}
+	if d == nil {
+		return nil, &responseError{codeRequestFailed, "nothing to rename here"}
+	}
+	edits, err := d.index.Rename(d.src, d.errs, d.target, p.NewName)
+	if err != nil {
+		return nil, &responseError{codeRequestFailed, err.Error()}
+	}
+	changes := make([]TextEdit, len(edits))
+	for i, e := range edits {
+		changes[i] = TextEdit{Range: d.lspRange(e.Span), NewText: e.NewText}
+	}
+	return WorkspaceEdit{Changes: map[string][]TextEdit{p.TextDocument.URI: changes}}, nil
+}
@@ -1,9 +1,5 @@
 package parse
 
-import (
-	"strings"
-)
-
 type MetaType byte
 const (
 	SPACE MetaType = iota
@@ -16,6 +12,25 @@ type Ident struct {
 	n string
 }
 
+func NewIdent(t MetaType, n string) Ident {
+	return Ident{
+		t: t,
+		n: n,
+	}
+}
+
+func (id Ident) GetType() MetaType {
+	return id.t
+}
+
+func (id Ident) GetIdent() string {
+	return id.n
+}
+
+func (id Ident) ToStr() string {
+	return id.toString()
+}
+
 func (id Ident) toString() string {
 	var str string
 	switch id.t {
@@ -32,6 +47,79 @@ type Contract struct {
 	spaces []SpaceDecl
 	agents []AgentDecl
 	paths []PathDecl
+	// not part of the contract but for their instances, which are among spaces
+	templates []Template
+
+	comments []Comment
+	tree *SyntaxTree
+}
+
+// line comments are kept out of the declarations; tools read them for directives
+type Comment struct {
+	text string
+	line uint64
+}
+
+func (cm *Comment) GetText() string {
+	return cm.text
+}
+
+func (cm *Comment) GetLine() uint64 {
+	return cm.line
+}
+
+func (c *Contract) GetSpaces() []SpaceDecl {
+	return c.spaces
+}
+
+func (c *Contract) GetAgents() []AgentDecl {
+	return c.agents
+}
+
+func (c *Contract) GetPaths() []PathDecl {
+	return c.paths
+}
+
+// AllSpaces lists every space, nested ones right after their parent
+func (c *Contract) AllSpaces() []*SpaceDecl {
+	var all []*SpaceDecl
+	var walk func(spaces []SpaceDecl)
+	walk = func(spaces []SpaceDecl) {
+		for i := range spaces {
+			all = append(all, &spaces[i])
+			walk(spaces[i].spaces)
+		}
+	}
+	walk(c.spaces)
+	return all
+}
+
+// AllPaths lists the top-level paths, then those declared inside spaces
+func (c *Contract) AllPaths() []*PathDecl {
+	var all []*PathDecl
+	for i := range c.paths {
+		all = append(all, &c.paths[i])
+	}
+	for _, s := range c.AllSpaces() {
+		for i := range s.paths {
+			all = append(all, &s.paths[i])
+		}
+	}
+	return all
+}
+
+func (c *Contract) GetTemplates() []Template {
+	return c.templates
+}
+
+func (c *Contract) GetComments() []Comment {
+	return c.comments
+}
+
+// GetSyntaxTree gives the tokens of the source the contract was parsed from;
+// every Span in the AST is a byte offset into it
+func (c *Contract) GetSyntaxTree() *SyntaxTree {
+	return c.tree
 }
 
 type SpaceDecl struct {
@@ -44,9 +132,15 @@ type SpaceDecl struct {
 	// inner decls
 	agents []AgentDecl
 	tasks []TaskDecl
+	// nested @spaces, and the =paths that can see them
+	spaces []SpaceDecl
+	paths []PathDecl
 	// data []DatumDecl
 
 	line_start, line_end uint64
+	span, name_span Span
+	// what it was expanded from, nil if it was written out
+	instance *Instantiation
 }
 
 func (me *SpaceDecl) GetName() Ident {
@@ -56,14 +150,73 @@ func (me *SpaceDecl) GetName() Ident {
 	}
 }
 
+func (me *SpaceDecl) GetSpaceType() SpaceType {
+	return me.space_type
+}
+
+func (me *SpaceDecl) IsReplicable() bool {
+	return me.replicable
+}
+
+func (me *SpaceDecl) GetParams() []Param {
+	return me.params
+}
+
+func (me *SpaceDecl) GetVibe() *VibeBlock {
+	return &me.vibe_desc
+}
+
+func (me *SpaceDecl) GetAgents() []AgentDecl {
+	return me.agents
+}
+
+func (me *SpaceDecl) GetTasks() []TaskDecl {
+	return me.tasks
+}
+
+func (me *SpaceDecl) GetSpaces() []SpaceDecl {
+	return me.spaces
+}
+
+func (me *SpaceDecl) GetPaths() []PathDecl {
+	return me.paths
+}
+
+func (me *SpaceDecl) GetLines() (uint64, uint64) {
+	return me.line_start, me.line_end
+}
+
+// GetSpan covers the declaration, its vibe block and everything declared inside
+// it, up to the end of its last line
+func (me *SpaceDecl) GetSpan() Span {
+	return me.span
+}
+
+// GetNameSpan covers the identifier, without its sigil
+func (me *SpaceDecl) GetNameSpan() Span {
+	return me.name_span
+}
+
+// GetInstantiation is the instantiation of a template the declaration was
+// expanded from, nil if it was written out. Its lines and spans are those of
+// the template, but for the name of the instance itself.
+func (me *SpaceDecl) GetInstantiation() *Instantiation {
+	return me.instance
+}
+
 func (me *SpaceDecl) GetChildren() []ParseUnit {
-	a_len := len(me.agents)
-	children := make([]ParseUnit, a_len + len(me.tasks))
-	for i, a := range me.agents {
-		children[i] = &a
+	children := make([]ParseUnit, 0, len(me.agents) + len(me.tasks) + len(me.spaces) + len(me.paths))
+	for i := range me.agents {
+		children = append(children, &me.agents[i])
+	}
+	for i := range me.tasks {
+		children = append(children, &me.tasks[i])
+	}
+	for i := range me.spaces {
+		children = append(children, &me.spaces[i])
 	}
-	for i, t := range me.tasks {
-		children[i + a_len] = &t
+	for i := range me.paths {
+		children = append(children, &me.paths[i])
 	}
 	// DatumDecls?
 	return children
@@ -76,6 +229,10 @@ func (me *SpaceDecl) GetDeps(deps *map[uint64]bool, scope *Scope) bool {
 	}
 
 	for _, c := range me.GetChildren() {
+		// paths lead between spaces, they are not part of this one
+		if _, ok := c.(*PathDecl); ok {
+			continue
+		}
 		id := c.GetName()
 		if !scope.tryAddDep(id, deps) {
 			return false
@@ -94,6 +251,29 @@ const (
 	CHAT
 )
 
+func (st SpaceType) ToStr() string {
+	switch st {
+	case UI: return "UI"
+	case IO: return "IO"
+	case DATA: return "DATA"
+	case CALL: return "CALL"
+	case CHAT: return "CHAT"
+	default: return ""
+	}
+}
+
+// spaceTypeOf reads a space type tag, UnknownSpace if it is not one
+func spaceTypeOf(tag string) SpaceType {
+	switch tag {
+	case "UI": return UI
+	case "IO": return IO
+	case "DATA": return DATA
+	case "CALL": return CALL
+	case "CHAT": return CHAT
+	default: return UnknownSpace
+	}
+}
+
 type AgentDecl struct {
 	ident string
 	agent_type AgentType
@@ -101,6 +281,8 @@ type AgentDecl struct {
 	vibe_desc VibeBlock
 
 	line_start, line_end uint64
+	span, name_span Span
+	instance *Instantiation
 }
 
 
@@ -111,6 +293,34 @@ func (me *AgentDecl) GetName() Ident {
 	}
 }
 
+func (me *AgentDecl) GetAgentType() AgentType {
+	return me.agent_type
+}
+
+func (me *AgentDecl) GetParams() []Param {
+	return me.params
+}
+
+func (me *AgentDecl) GetVibe() *VibeBlock {
+	return &me.vibe_desc
+}
+
+func (me *AgentDecl) GetLines() (uint64, uint64) {
+	return me.line_start, me.line_end
+}
+
+func (me *AgentDecl) GetSpan() Span {
+	return me.span
+}
+
+func (me *AgentDecl) GetNameSpan() Span {
+	return me.name_span
+}
+
+func (me *AgentDecl) GetInstantiation() *Instantiation {
+	return me.instance
+}
+
 func (me *AgentDecl) GetChildren() []ParseUnit {
 	return []ParseUnit{}
 }
@@ -127,14 +337,26 @@ const (
 	DF
 )
 
+func (at AgentType) ToStr() string {
+	switch at {
+	case AF: return "AF"
+	case DF: return "DF"
+	default: return ""
+	}
+}
+
 type PathDecl struct {
 	ident string
 	path_type PathType
+	access PathAccess
 	space_source Ident
 	space_dest Ident
 	vibe_desc VibeBlock
 
 	line_start, line_end uint64
+	span, name_span Span
+	source_span, dest_span Span
+	instance *Instantiation
 }
 
 func (me *PathDecl) GetName() Ident {
@@ -144,6 +366,51 @@ func (me *PathDecl) GetName() Ident {
 	}
 }
 
+func (me *PathDecl) GetPathType() PathType {
+	return me.path_type
+}
+
+func (me *PathDecl) GetAccess() PathAccess {
+	return me.access
+}
+
+func (me *PathDecl) GetSource() Ident {
+	return me.space_source
+}
+
+func (me *PathDecl) GetDest() Ident {
+	return me.space_dest
+}
+
+func (me *PathDecl) GetVibe() *VibeBlock {
+	return &me.vibe_desc
+}
+
+func (me *PathDecl) GetLines() (uint64, uint64) {
+	return me.line_start, me.line_end
+}
+
+func (me *PathDecl) GetSpan() Span {
+	return me.span
+}
+
+func (me *PathDecl) GetNameSpan() Span {
+	return me.name_span
+}
+
+func (me *PathDecl) GetInstantiation() *Instantiation {
+	return me.instance
+}
+
+// GetSourceSpan and GetDestSpan cover the names of the spaces the path joins
+func (me *PathDecl) GetSourceSpan() Span {
+	return me.source_span
+}
+
+func (me *PathDecl) GetDestSpan() Span {
+	return me.dest_span
+}
+
 func (me *PathDecl) GetChildren() []ParseUnit {
 	return []ParseUnit{}
 }
@@ -165,12 +432,44 @@ const (
 	ATTEND
 )
 
+func (pt PathType) ToStr() string {
+	switch pt {
+	case INVOKE: return "INVOKE"
+	case ATTEND: return "ATTEND"
+	default: return ""
+	}
+}
+
+// PathAccess limits what a path may carry into its destination. Untagged paths
+// carry anything.
+type PathAccess byte
+const (
+	AnyAccess PathAccess = iota
+	// requests that read data back: in params only as the request
+	READ
+	// writes that read nothing back: no out params
+	WRITE
+	// signals that carry no data at all
+	CONTROL
+)
+
+func (pa PathAccess) ToStr() string {
+	switch pa {
+	case READ: return "READ"
+	case WRITE: return "WRITE"
+	case CONTROL: return "CONTROL"
+	default: return ""
+	}
+}
+
 type TaskDecl struct {
 	ident string
 	params []Param
 	vibe_desc VibeBlock
 
 	line_start, line_end uint64
+	span, name_span Span
+	instance *Instantiation
 }
 
 
@@ -181,6 +480,30 @@ func (me *TaskDecl) GetName() Ident {
 	}
 }
 
+func (me *TaskDecl) GetParams() []Param {
+	return me.params
+}
+
+func (me *TaskDecl) GetVibe() *VibeBlock {
+	return &me.vibe_desc
+}
+
+func (me *TaskDecl) GetLines() (uint64, uint64) {
+	return me.line_start, me.line_end
+}
+
+func (me *TaskDecl) GetSpan() Span {
+	return me.span
+}
+
+func (me *TaskDecl) GetNameSpan() Span {
+	return me.name_span
+}
+
+func (me *TaskDecl) GetInstantiation() *Instantiation {
+	return me.instance
+}
+
 func (me *TaskDecl) GetChildren() []ParseUnit {
 	return []ParseUnit{}
 }
@@ -199,21 +522,78 @@ type Param struct {
 	data_name string
 
 	line, col uint64
+	span, name_span Span
+}
+
+func (p *Param) IsIn() bool {
+	return p.in_param
+}
+
+func (p *Param) GetDataName() string {
+	return p.data_name
+}
+
+func (p *Param) GetPos() (uint64, uint64) {
+	return p.line, p.col
+}
+
+func (p *Param) GetSpan() Span {
+	return p.span
+}
+
+// GetNameSpan covers the data name, without its '%'
+func (p *Param) GetNameSpan() Span {
+	return p.name_span
 }
 
 func (p *Param) ToStr() string {
+	return string(p.appendStr(nil))
+}
+
+// appendStr is ToStr into a buffer, which the parser reuses for vibe lines
+func (p *Param) appendStr(b []byte) []byte {
 	if p.in_param {
-		return "in=%" + p.data_name
+		b = append(b, "in=%"...)
 	} else {
-		return "out=%" + p.data_name
+		b = append(b, "out=%"...)
 	}
+	return append(b, p.data_name...)
 }
 
 type VibeBlock struct {
 	vibe_prose []string
+	vibe_lines []uint64 // source line of each vibe_prose entry
 	meta_refs []MetaRef
 
 	line_start, line_end uint64
+	// from the first '>' to the end of the last vibe line
+	span Span
+	// the text of each vibe_prose entry as written, before normalising
+	prose_spans []Span
+}
+
+func (vb *VibeBlock) GetProse() []string {
+	return vb.vibe_prose
+}
+
+func (vb *VibeBlock) GetProseLines() []uint64 {
+	return vb.vibe_lines
+}
+
+func (vb *VibeBlock) GetMetaRefs() []MetaRef {
+	return vb.meta_refs
+}
+
+func (vb *VibeBlock) GetLines() (uint64, uint64) {
+	return vb.line_start, vb.line_end
+}
+
+func (vb *VibeBlock) GetSpan() Span {
+	return vb.span
+}
+
+func (vb *VibeBlock) GetProseSpans() []Span {
+	return vb.prose_spans
 }
 
 func (vb *VibeBlock) getDeps(deps *map[uint64]bool, scope *Scope) bool {
@@ -230,19 +610,43 @@ type ParseDepGetter interface {
 
 // can do meta_ref.(type) to get type
 type MetaRef interface {
-	// Line() int
-	// Col() int
+	GetPos() (uint64, uint64)
+	// GetSpan covers the reference from its sigil, GetNameSpan just the name it refers to
+	GetSpan() Span
+	GetNameSpan() Span
 	ToStr() string
+	appendStr(b []byte) []byte
 	ParseDepGetter
 }
 
 type MetaRefData struct {
 	ident string
 	line, col uint64
+	span, name_span Span
+}
+
+func (mr *MetaRefData) GetIdent() string {
+	return mr.ident
+}
+
+func (mr *MetaRefData) GetPos() (uint64, uint64) {
+	return mr.line, mr.col
+}
+
+func (mr *MetaRefData) GetSpan() Span {
+	return mr.span
+}
+
+func (mr *MetaRefData) GetNameSpan() Span {
+	return mr.name_span
 }
 
 func (mr *MetaRefData) ToStr() string {
-	return "%" + mr.ident
+	return string(mr.appendStr(nil))
+}
+
+func (mr *MetaRefData) appendStr(b []byte) []byte {
+	return append(append(b, '%'), mr.ident...)
 }
 
 func (mr *MetaRefData) GetDeps(deps *map[uint64]bool, scope *Scope) bool {
@@ -259,6 +663,27 @@ type MetaRefUseImport struct {
 	imported string
 	import_type UseImportType
 	line, col uint64
+	span, name_span Span
+}
+
+func (mr *MetaRefUseImport) GetImported() Ident {
+	switch mr.import_type {
+	case UseImportSpace: return Ident{t: SPACE, n: mr.imported}
+	case UseImportAgent: return Ident{t: AGENT, n: mr.imported}
+	default: panic(-1)
+	}
+}
+
+func (mr *MetaRefUseImport) GetPos() (uint64, uint64) {
+	return mr.line, mr.col
+}
+
+func (mr *MetaRefUseImport) GetSpan() Span {
+	return mr.span
+}
+
+func (mr *MetaRefUseImport) GetNameSpan() Span {
+	return mr.name_span
 }
 
 func (mr *MetaRefUseImport) GetDeps(deps *map[uint64]bool, scope *Scope) bool {
@@ -275,43 +700,110 @@ func (mr *MetaRefUseImport) GetDeps(deps *map[uint64]bool, scope *Scope) bool {
 }
 
 func (mr *MetaRefUseImport) ToStr() string {
-	var joiner string
+	return string(mr.appendStr(nil))
+}
+
+func (mr *MetaRefUseImport) appendStr(b []byte) []byte {
+	var joiner byte
 	switch mr.import_type {
-	case UseImportSpace: joiner = "@"
-	case UseImportAgent: joiner = "#"
+	case UseImportSpace: joiner = '@'
+	case UseImportAgent: joiner = '#'
 	default: panic(-1)
 	}
-	return "$use(" + joiner + mr.imported + ")"
+	b = append(append(b, "$use("...), joiner)
+	return append(append(b, mr.imported...), ')')
 }
 
 type MetaRefTask struct {
+	// the space qualifying the reference, "" if unqualified
+	space string
 	ident string
 	line, col uint64
 	args []Param
+	span, name_span Span
+	// the qualifying space's name, empty if unqualified
+	space_span Span
+}
+
+func (mr *MetaRefTask) GetIdent() string {
+	return mr.ident
+}
+
+func (mr *MetaRefTask) GetSpace() string {
+	return mr.space
+}
+
+func (mr *MetaRefTask) GetSpaceSpan() Span {
+	return mr.space_span
+}
+
+func (mr *MetaRefTask) GetArgs() []Param {
+	return mr.args
+}
+
+func (mr *MetaRefTask) GetPos() (uint64, uint64) {
+	return mr.line, mr.col
+}
+
+func (mr *MetaRefTask) GetSpan() Span {
+	return mr.span
+}
+
+func (mr *MetaRefTask) GetNameSpan() Span {
+	return mr.name_span
 }
 
 func (mr *MetaRefTask) ToStr() string {
-	arg_strs := make([]string, len(mr.args), len(mr.args))
+	return string(mr.appendStr(nil))
+}
+
+func (mr *MetaRefTask) appendStr(b []byte) []byte {
+	if mr.space != "" {
+		b = append(append(append(b, '@'), mr.space...), '.')
+	}
+	b = append(append(append(b, '$'), mr.ident...), '(')
 	for i := 0; i < len(mr.args); i++ {
-		arg_strs[i] = mr.args[i].ToStr()
+		if i > 0 {
+			b = append(b, ", "...)
+		}
+		b = mr.args[i].appendStr(b)
 	}
-	return "$" + mr.ident + "(" + strings.Join(arg_strs, ", ") + ")"
+	return append(b, ')')
 }
 
+// task references are resolved by GetParseOrder, see ParseOrder.resolveTask
 func (mr *MetaRefTask) GetDeps(deps *map[uint64]bool, scope *Scope) bool {
-	return scope.tryAddDep(Ident{
-		t: TASK,
-		n: mr.ident,
-	}, deps)
+	return true
 }
 
 type MetaRefPath struct {
 	ident string
 	line, col uint64
+	span, name_span Span
+}
+
+func (mr *MetaRefPath) GetIdent() string {
+	return mr.ident
+}
+
+func (mr *MetaRefPath) GetPos() (uint64, uint64) {
+	return mr.line, mr.col
+}
+
+func (mr *MetaRefPath) GetSpan() Span {
+	return mr.span
+}
+
+func (mr *MetaRefPath) GetNameSpan() Span {
+	return mr.name_span
 }
 
 func (mr *MetaRefPath) ToStr() string {
-	return "=" + mr.ident
+	return string(mr.appendStr(nil))
+}
+
+func (mr *MetaRefPath) appendStr(b []byte) []byte {
+	return append(append(b, '='), mr.ident...)
 }
 
 func (mr *MetaRefPath) GetDeps(deps *map[uint64]bool, scope *Scope) bool {
@@ -0,0 +1,89 @@
+package parse
+
+import (
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Source text is UTF-8 throughout. Offsets (see Span) are always in bytes,
+// but line and column numbers are for people and editors, which count columns
+// differently: most in code points, LSP clients in UTF-16 units.
+type ColumnUnit byte
+const (
+	CodePoints ColumnUnit = iota
+	UTF16Units
+)
+
+func (cu ColumnUnit) width(ch rune) uint64 {
+	if cu == UTF16Units {
+		if n := utf16.RuneLen(ch); n > 0 {
+			return uint64(n)
+		}
+	}
+	return 1
+}
+
+// ParseConfig changes how ParseFromReaderWith counts positions
+type ParseConfig struct {
+	Columns ColumnUnit
+}
+
+func (pi *ParserInfo) advance(ch rune) {
+	pi.col += pi.columns.width(ch)
+}
+
+// advanceText moves past a whole token, with a shortcut for ASCII
+func (pi *ParserInfo) advanceText(s string) {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			for _, ch := range s[i:] {
+				pi.advance(ch)
+			}
+			return
+		}
+		pi.col++
+	}
+}
+
+// identifiers follow Unicode's default identifier syntax (UAX #31), with '_'
+// as a letter: a letter, then letters, marks, digits and connectors. The
+// lexer asks of every character, so ASCII goes by a table.
+func identStart(ch rune) bool {
+	if ch < utf8.RuneSelf {
+		return asciiIdent[ch] & asciiIdentStart != 0
+	}
+	return unicode.In(ch, unicode.L, unicode.Nl)
+}
+
+func identPart(ch rune) bool {
+	if ch < utf8.RuneSelf {
+		return asciiIdent[ch] != 0
+	}
+	return unicode.In(ch, unicode.L, unicode.Nl, unicode.Mn, unicode.Mc, unicode.Nd, unicode.Pc)
+}
+
+const (
+	asciiIdentStart = 1 << iota
+	asciiIdentPart
+)
+
+var asciiIdent = func() (table [utf8.RuneSelf]byte) {
+	for ch := range table {
+		switch {
+		case ch == '_', 'a' <= ch && ch <= 'z', 'A' <= ch && ch <= 'Z': table[ch] = asciiIdentStart | asciiIdentPart
+		case '0' <= ch && ch <= '9': table[ch] = asciiIdentPart
+		}
+	}
+	return
+}()
+
+// IsIdentifier says whether s could name a declaration
+func IsIdentifier(s string) bool {
+	for i, ch := range s {
+		if i == 0 && !identStart(ch) || !identPart(ch) {
+			return false
+		}
+	}
+	return s != ""
+}
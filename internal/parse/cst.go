@@ -0,0 +1,392 @@
+package parse
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// The concrete syntax tree sits under the AST: the whole source cut into
+// tokens, each carrying the whitespace, newlines and comments before it as
+// trivia, so that nothing is lost. AST nodes point into it by byte offset (see
+// Span), which is what refactoring tools and editors need to change the text
+// in place instead of printing the contract back out.
+
+// Span is a half-open range of byte offsets into the source
+type Span struct {
+	Start, End uint64
+}
+
+func (s Span) Len() uint64 {
+	return s.End - s.Start
+}
+
+func (s Span) Contains(offset uint64) bool {
+	return s.Start <= offset && offset < s.End
+}
+
+type TokenKind byte
+const (
+	TokenEOF TokenKind = iota
+	TokenIdent
+	// any other run of characters, mostly vibe prose
+	TokenText
+	TokenAt
+	TokenHash
+	TokenDollar
+	TokenEquals
+	TokenPercent
+	TokenColon
+	TokenDot
+	TokenLParen
+	TokenRParen
+	TokenLBracket
+	TokenRBracket
+	// ',' or ';'
+	TokenComma
+	// the '>' that starts a vibe line
+	TokenVibe
+)
+
+type TriviaKind byte
+const (
+	TriviaSpace TriviaKind = iota
+	TriviaNewline
+	TriviaComment
+)
+
+type Trivia struct {
+	Kind TriviaKind
+	Span Span
+}
+
+type Token struct {
+	Kind TokenKind
+	// where the trivia after the previous token and before this one ends in
+	// the tree's; see SyntaxTree.Leading
+	trivia uint32
+	Span Span
+	Line, Col uint64
+}
+
+type SyntaxTree struct {
+	src string
+	tokens []Token
+	// the whitespace, newlines and comments between the tokens, in order
+	trivia []Trivia
+	// byte offset of the start of each line
+	lines []uint64
+	columns ColumnUnit
+}
+
+func punctKind(ch byte) (TokenKind, bool) {
+	switch ch {
+	case '@': return TokenAt, true
+	case '#': return TokenHash, true
+	case '$': return TokenDollar, true
+	case '=': return TokenEquals, true
+	case '%': return TokenPercent, true
+	case ':': return TokenColon, true
+	case '.': return TokenDot, true
+	case '(': return TokenLParen, true
+	case ')': return TokenRParen, true
+	case '[': return TokenLBracket, true
+	case ']': return TokenRBracket, true
+	case ',', ';': return TokenComma, true
+	default: return TokenEOF, false
+	}
+}
+
+// Lex cuts src into tokens, counting columns in code points. It never fails:
+// whatever the parser would reject still lexes, as TokenText if nothing else.
+// A comment is "//" at the start of a line, as in the parser, so a URL in vibe
+// prose is not one.
+func Lex(src string) *SyntaxTree {
+	return LexWith(src, ParseConfig{})
+}
+
+func LexWith(src string, cfg ParseConfig) *SyntaxTree {
+	t := &SyntaxTree{src: src, lines: []uint64{0}, columns: cfg.Columns}
+	// a token every few bytes is about right for contracts, which are mostly prose
+	t.tokens = make([]Token, 0, len(src) / 3 + 1)
+	t.trivia = make([]Trivia, 0, len(src) / 5 + 1)
+	lx := lexer{t: t, line_start: true}
+	lx.lex(0, len(src))
+	lx.eof()
+	return t
+}
+
+// lexer cuts tokens from a tree's source, appending them and their trivia to it
+type lexer struct {
+	t *SyntaxTree
+	line_start bool
+	// line and col are where col_off is, moved along as tokens are cut, so
+	// that placing each token costs only the bytes since the one before
+	line, col uint64
+	col_off int
+}
+
+func (lx *lexer) position(offset int) (uint64, uint64) {
+	for _, ch := range lx.t.src[lx.col_off:offset] {
+		lx.col += lx.t.columns.width(ch)
+	}
+	lx.col_off = offset
+	return lx.line, lx.col
+}
+
+// lex cuts src[i:end] into tokens. end is the start of a line or the end of
+// src, as no token runs past a newline.
+func (lx *lexer) lex(i, end int) {
+	t, src := lx.t, lx.t.src
+	for i < end {
+		start := i
+		ch := src[i]
+		switch {
+		case ch == '\n':
+			i++
+			t.trivia = append(t.trivia, Trivia{Kind: TriviaNewline, Span: Span{uint64(start), uint64(i)}})
+			t.lines = append(t.lines, uint64(i))
+			lx.line, lx.col, lx.col_off = lx.line + 1, 0, i
+			lx.line_start = true
+			continue
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			for i < len(src) && (src[i] == ' ' || src[i] == '\t' || src[i] == '\r') {
+				i++
+			}
+			t.trivia = append(t.trivia, Trivia{Kind: TriviaSpace, Span: Span{uint64(start), uint64(i)}})
+			continue
+		case lx.line_start && strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			t.trivia = append(t.trivia, Trivia{Kind: TriviaComment, Span: Span{uint64(start), uint64(i)}})
+			continue
+		}
+
+		var kind TokenKind
+		if k, ok := punctKind(ch); ok {
+			kind = k
+			i++
+		} else if ch == '>' && lx.line_start {
+			kind = TokenVibe
+			i++
+		} else if r, size := utf8.DecodeRuneInString(src[i:]); identStart(r) {
+			kind = TokenIdent
+			for i += size; i < len(src); i += size {
+				r, size = rune(src[i]), 1
+				if r >= utf8.RuneSelf {
+					r, size = utf8.DecodeRuneInString(src[i:])
+				}
+				if !identPart(r) {
+					break
+				}
+			}
+		} else {
+			kind = TokenText
+			for i < len(src) {
+				c := src[i]
+				r, size := rune(c), 1
+				if c >= utf8.RuneSelf {
+					r, size = utf8.DecodeRuneInString(src[i:])
+				}
+				if _, ok := punctKind(c); ok || c == ' ' || c == '\t' || c == '\r' || c == '\n' || identStart(r) {
+					break
+				}
+				i += size
+			}
+			if i == start {
+				// a '>' in the middle of a line
+				i++
+			}
+		}
+		tok_line, tok_col := lx.position(start)
+		t.tokens = append(t.tokens, Token{
+			Kind: kind,
+			Span: Span{uint64(start), uint64(i)},
+			Line: tok_line,
+			Col: tok_col,
+			trivia: uint32(len(t.trivia)),
+		})
+		lx.line_start = false
+	}
+}
+
+func (lx *lexer) eof() {
+	t := lx.t
+	line, col := lx.position(len(t.src))
+	t.tokens = append(t.tokens, Token{
+		Kind: TokenEOF,
+		Span: Span{uint64(len(t.src)), uint64(len(t.src))},
+		Line: line,
+		Col: col,
+		trivia: uint32(len(t.trivia)),
+	})
+}
+
+// edit changes the tree to match src, which is its source with e made. Only
+// the lines the edit touches are lexed again; the tokens after them are moved
+// along, and the tokens and trivia arrays are changed in place.
+func (t *SyntaxTree) edit(src string, e TextEdit) {
+	old_src := t.src
+	start := strings.LastIndexByte(old_src[:e.Span.Start], '\n') + 1
+	end := len(old_src)
+	if nl := strings.IndexByte(old_src[e.Span.End:], '\n'); nl >= 0 {
+		end = int(e.Span.End) + nl + 1
+	}
+	bytes := len(src) - len(old_src)
+
+	// what lies in the lines, old and new
+	first := sort.Search(len(t.tokens), func(i int) bool { return t.tokens[i].Span.Start >= uint64(start) })
+	last := len(t.tokens)
+	first_trivia := sort.Search(len(t.trivia), func(i int) bool { return t.trivia[i].Span.Start >= uint64(start) })
+	last_trivia := len(t.trivia)
+	if end < len(old_src) {
+		last = sort.Search(len(t.tokens), func(i int) bool { return t.tokens[i].Span.Start >= uint64(end) })
+		last_trivia = sort.Search(len(t.trivia), func(i int) bool { return t.trivia[i].Span.Start >= uint64(end) })
+	}
+	line := sort.Search(len(t.lines), func(i int) bool { return t.lines[i] >= uint64(start) })
+	last_line := sort.Search(len(t.lines), func(i int) bool { return t.lines[i] > uint64(end) })
+
+	part := &SyntaxTree{src: src, columns: t.columns}
+	lx := lexer{t: part, line_start: true, line: uint64(line), col_off: start}
+	lx.lex(start, end + bytes)
+	if end == len(old_src) {
+		lx.eof()
+	}
+
+	lines := len(part.lines) - (last_line - line - 1)
+	trivia := len(part.trivia) - (last_trivia - first_trivia)
+	for i := range part.tokens {
+		part.tokens[i].trivia += uint32(first_trivia)
+	}
+	t.src = src
+	t.tokens = slices.Replace(t.tokens, first, last, part.tokens...)
+	t.trivia = slices.Replace(t.trivia, first_trivia, last_trivia, part.trivia...)
+	t.lines = slices.Replace(t.lines, line + 1, last_line, part.lines...)
+	for i := first + len(part.tokens); i < len(t.tokens); i++ {
+		t.tokens[i].Span = Span{uint64(int(t.tokens[i].Span.Start) + bytes), uint64(int(t.tokens[i].Span.End) + bytes)}
+		t.tokens[i].Line = uint64(int(t.tokens[i].Line) + lines)
+		t.tokens[i].trivia = uint32(int(t.tokens[i].trivia) + trivia)
+	}
+	for i := first_trivia + len(part.trivia); i < len(t.trivia); i++ {
+		t.trivia[i].Span = Span{uint64(int(t.trivia[i].Span.Start) + bytes), uint64(int(t.trivia[i].Span.End) + bytes)}
+	}
+	for i := line + 1 + len(part.lines); i < len(t.lines); i++ {
+		t.lines[i] = uint64(int(t.lines[i]) + bytes)
+	}
+}
+
+func (t *SyntaxTree) Source() string {
+	return t.src
+}
+
+// Tokens lists every token, ending with TokenEOF, whose trivia is whatever
+// trails the last real token
+func (t *SyntaxTree) Tokens() []Token {
+	return t.tokens
+}
+
+// Leading gives the whitespace, newlines and comments between token i and the
+// one before it
+func (t *SyntaxTree) Leading(i int) []Trivia {
+	start := uint32(0)
+	if i > 0 {
+		start = t.tokens[i - 1].trivia
+	}
+	return t.trivia[start:t.tokens[i].trivia]
+}
+
+func (t *SyntaxTree) Text(s Span) string {
+	return t.src[s.Start:s.End]
+}
+
+// String puts the tokens and their trivia back together, giving the source
+func (t *SyntaxTree) String() string {
+	var b strings.Builder
+	for i, tok := range t.tokens {
+		for _, tr := range t.Leading(i) {
+			b.WriteString(t.Text(tr.Span))
+		}
+		b.WriteString(t.Text(tok.Span))
+	}
+	return b.String()
+}
+
+// TokenAt finds the index of the token covering a byte offset; trivia is
+// covered by no token
+func (t *SyntaxTree) TokenAt(offset uint64) (int, bool) {
+	i := sort.Search(len(t.tokens), func(i int) bool { return t.tokens[i].Span.End > offset })
+	if i == len(t.tokens) || !t.tokens[i].Span.Contains(offset) {
+		return 0, false
+	}
+	return i, true
+}
+
+// TokensIn lists the tokens lying wholly inside a span, such as an AST node's
+func (t *SyntaxTree) TokensIn(s Span) []Token {
+	lo := sort.Search(len(t.tokens), func(i int) bool { return t.tokens[i].Span.Start >= s.Start })
+	hi := lo
+	for hi < len(t.tokens) && t.tokens[hi].Span.End <= s.End && t.tokens[hi].Kind != TokenEOF {
+		hi++
+	}
+	return t.tokens[lo:hi]
+}
+
+// Position gives the 0-based line and column of a byte offset, with columns
+// counted as the tree was lexed with
+func (t *SyntaxTree) Position(offset uint64) (uint64, uint64) {
+	line := sort.Search(len(t.lines), func(i int) bool { return t.lines[i] > offset }) - 1
+	col := uint64(0)
+	for _, ch := range t.src[t.lines[line]:offset] {
+		col += t.columns.width(ch)
+	}
+	return uint64(line), col
+}
+
+// Offset gives the byte offset of a 0-based line and column; a column in the
+// middle of a character is not an offset
+func (t *SyntaxTree) Offset(line, col uint64) (uint64, bool) {
+	if line >= uint64(len(t.lines)) {
+		return 0, false
+	}
+	offset := t.lines[line]
+	for i, ch := range t.src[offset:] {
+		if col == 0 || ch == '\n' {
+			return offset + uint64(i), col == 0
+		}
+		w := t.columns.width(ch)
+		if w > col {
+			return 0, false
+		}
+		col -= w
+	}
+	return uint64(len(t.src)), col == 0
+}
+
+// TextEdit replaces the text in Span with NewText
+type TextEdit struct {
+	Span Span
+	NewText string
+}
+
+// ApplyEdits makes a set of edits to src at once. Spans are into the original
+// src, and may not overlap.
+func ApplyEdits(src string, edits []TextEdit) (string, error) {
+	sorted := append([]TextEdit{}, edits...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Span.Start < sorted[j].Span.Start })
+
+	var b strings.Builder
+	last := uint64(0)
+	for _, e := range sorted {
+		if e.Span.Start < last || e.Span.End < e.Span.Start || e.Span.End > uint64(len(src)) {
+			return "", fmt.Errorf("edit at bytes %d-%d overlaps another or is out of range", e.Span.Start, e.Span.End)
+		}
+		b.WriteString(src[last:e.Span.Start])
+		b.WriteString(e.NewText)
+		last = e.Span.End
+	}
+	b.WriteString(src[last:])
+	return b.String(), nil
+}
@@ -7,6 +7,7 @@ import (
 type ParserError uint64
 const (
 	UnexpectedMetachar ParserError = iota
+	// no longer reported, non-ASCII text is fine; see InvalidUTF8
 	NonAsciiChar
 	ExpectedOuterDecl
 	ExpectedInnerDecl
@@ -27,15 +28,46 @@ const (
 	UseUnsupportedImport
 	IllegalDeclarationInsideSpaceScope
 	IncorrectNumberPathSpaces
+	ExpectedComment
+	TooManyErrors
+	InvalidUTF8
+	MismatchedBrackets
+	NestedTemplate
+	DuplicateTemplateParam
+	DuplicateTemplate
+	ExpectedTemplate
+	UnknownTemplate
+	TemplateArgCount
+	ExpectedSpaceTypeArg
+	TemplateCycle
 )
 
+// after this many errors the parser stops reporting them; by then they are
+// mostly knock-on errors from the first few
+const maxParserErrors = 50
+
 type ParserErrorInfo struct {
 	err ParserError
 	line, col uint64
+	// the instantiation being expanded, for an error in a template that only
+	// its arguments bring about
+	instance *Instantiation
+}
+
+func (errinf *ParserErrorInfo) GetError() ParserError {
+	return errinf.err
+}
+
+func (errinf *ParserErrorInfo) GetPos() (uint64, uint64) {
+	return errinf.line, errinf.col
+}
+
+func (errinf *ParserErrorInfo) GetInstantiation() *Instantiation {
+	return errinf.instance
 }
 
 func (pi *ParserInfo) addError(errno ParserError) {
-	pi.errors = append(pi.errors, ParserErrorInfo{
+	pi.appendError(ParserErrorInfo{
 		err: errno,
 		line: pi.line,
 		col: pi.col,
@@ -43,37 +75,71 @@ func (pi *ParserInfo) addError(errno ParserError) {
 }
 
 func (pi *ParserInfo) addErrorTagged(errno ParserError, location locationTaggedString) {
-	pi.errors = append(pi.errors, ParserErrorInfo{
+	pi.appendError(ParserErrorInfo{
 		err: errno,
 		line: location.line,
 		col: location.col,
 	})
 }
 
+// appendError drops an error at the same place as the one before, which can
+// only be a knock-on from it, and gives up with TooManyErrors past maxParserErrors
+func (pi *ParserInfo) appendError(errinf ParserErrorInfo) {
+	n := len(pi.errors)
+	if n > 0 && pi.errors[n-1].line == errinf.line && pi.errors[n-1].col == errinf.col && pi.errors[n-1].instance == errinf.instance {
+		return
+	}
+	if n > maxParserErrors {
+		return
+	}
+	if n == maxParserErrors {
+		errinf.err = TooManyErrors
+	}
+	pi.errors = append(pi.errors, errinf)
+}
+
 func PrintErrorInfo(errinf ParserErrorInfo) {
-	fmt.Printf("Error at (%d, %d): ", errinf.line, errinf.col)
-	switch errinf.err {
-	case UnexpectedMetachar: fmt.Printf("Unexpected meta-character")
-	case NonAsciiChar: fmt.Printf("Unexpected non-ASCII character")
-	case ExpectedOuterDecl: fmt.Printf("Expected Declaration: @space, #agent, $task, =path")
-	case ExpectedInnerDecl: fmt.Printf("Expected Declaration inside @space scope: #agent, $task")
-	case ExpectedSpaceDecl: fmt.Printf("Expected Space Declaration: @space")
-	case ExpectedAgentDecl: fmt.Printf("Expected Agent Declaration: #agent")
-	case ExpectedTaskDecl: fmt.Printf("Expected Task Declaration: $task")
-	case ExpectedPathDecl: fmt.Printf("Expected Path Declaration: =path")
-	case ExpectedDataName: fmt.Printf("Expected Data Name: %%data")
-	case ExpectedIdentifier: fmt.Printf("Expected Identifier: ident")
-	case ExpectedInOut: fmt.Printf("Expected in or out")
-	case ExpectedEquals: fmt.Printf("Expected =")
-	case MissingRequiredTag: fmt.Printf("Missing Required Tag Definition")
-	case DuplicateTag: fmt.Printf("Duplicate or Contradictory Tag Definition")
-	case UnknownTag: fmt.Printf("Unknown Tag Name")
-	case MismatchedParens: fmt.Printf("Mismatched Parentheses")
-	case UseMissingImport: fmt.Printf("Missing import for $use expression: should take the form $use(element), where element is a @space or #agent.")
-	case UseUnsupportedImport: fmt.Printf("Cannot import this element. Expression should take the form $use(element), where element is a @space or #agent.")
-	case IllegalDeclarationInsideSpaceScope: fmt.Printf("Illegal declaration inside @space scope. Should be: #agent, $task")
-	default: fmt.Printf("???")
+	fmt.Printf("Error at (%d, %d): %s\n", errinf.line, errinf.col, errinf.err.Message())
+	if errinf.instance != nil {
+		fmt.Printf("\t%s\n", errinf.instance.Note())
 	}
-	fmt.Printf("\n")
+}
 
+func (err ParserError) Message() string {
+	switch err {
+	case UnexpectedMetachar: return "Unexpected meta-character"
+	case NonAsciiChar: return "Unexpected non-ASCII character"
+	case ExpectedOuterDecl: return "Expected Declaration: @space, #agent, $task, =path"
+	case ExpectedInnerDecl: return "Expected Declaration inside @space scope: #agent, $task, =path, @space"
+	case ExpectedSpaceDecl: return "Expected Space Declaration: @space"
+	case ExpectedAgentDecl: return "Expected Agent Declaration: #agent"
+	case ExpectedTaskDecl: return "Expected Task Declaration: $task"
+	case ExpectedPathDecl: return "Expected Path Declaration: =path"
+	case ExpectedDataName: return "Expected Data Name: %data"
+	case ExpectedIdentifier: return "Expected Identifier: ident"
+	case ExpectedInOut: return "Expected in or out"
+	case ExpectedEquals: return "Expected ="
+	case MissingRequiredTag: return "Missing Required Tag Definition"
+	case DuplicateTag: return "Duplicate or Contradictory Tag Definition"
+	case UnknownTag: return "Unknown Tag Name"
+	case MismatchedParens: return "Mismatched Parentheses"
+	case UseMissingImport: return "Missing import for $use expression: should take the form $use(element), where element is a @space or #agent."
+	case UseUnsupportedImport: return "Cannot import this element. Expression should take the form $use(element), where element is a @space or #agent."
+	case IllegalDeclarationInsideSpaceScope: return "Illegal declaration inside @space scope. Should be: #agent, $task, =path, or an indented @space"
+	case ExpectedSpaceName: return "Expected Space Name: @space"
+	case IncorrectNumberPathSpaces: return "Path must connect exactly two spaces: (@source, @dest)"
+	case ExpectedComment: return "Expected Comment: // comment"
+	case TooManyErrors: return "Too many errors, giving up on reporting the rest"
+	case InvalidUTF8: return "Invalid UTF-8"
+	case MismatchedBrackets: return "Mismatched Brackets"
+	case NestedTemplate: return "Templates can only be declared at the top level"
+	case DuplicateTemplateParam: return "Duplicate Template Parameter"
+	case DuplicateTemplate: return "Duplicate Template: another template has this name"
+	case ExpectedTemplate: return "Expected Template Instantiation: @instance = @template[arguments]"
+	case UnknownTemplate: return "Undeclared Template"
+	case TemplateArgCount: return "Wrong number of template arguments"
+	case ExpectedSpaceTypeArg: return "Expected a space type for this template parameter: UI, IO, DATA, CALL or CHAT"
+	case TemplateCycle: return "Template instantiates itself"
+	default: return "???"
+	}
 }
@@ -0,0 +1,645 @@
+package parse
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// A Document keeps a contract parsed while it is edited, as an editor or a
+// watcher needs. An edit re-parses only the top-level declarations whose lines
+// it touches; the ones after it are kept and moved along. The ParseOrder is
+// updated the same way: only nodes whose dependencies could have changed look
+// them up again. What comes out is exactly what parsing the new source from
+// scratch gives. The syntax tree is edited in place, so one taken from the
+// Contract before an edit is changed by it.
+type Document struct {
+	cfg ParseConfig
+	src string
+	chunks []chunk
+
+	contract Contract
+	errors []ParserErrorInfo
+	order ParseOrder
+	// top-level chunks the last edit re-parsed
+	reparsed int
+}
+
+func NewDocument(src string, cfg ParseConfig) *Document {
+	d := &Document{cfg: cfg, src: src}
+	tree := LexWith(src, cfg)
+	tr := newTokenReader(tree, 0)
+	pi := ParserInfo{columns: cfg.Columns, detach: true}
+	for tr.Len() > 0 {
+		d.chunks = append(d.chunks, parseChunk(tr, &pi))
+	}
+	d.reparsed = len(d.chunks)
+
+	d.contract, d.errors = assemble(d.chunks, tree)
+	d.order = GetParseOrder(&d.contract)
+	return d
+}
+
+func (d *Document) Source() string {
+	return d.src
+}
+
+func (d *Document) Contract() *Contract {
+	return &d.contract
+}
+
+func (d *Document) Errors() []ParserErrorInfo {
+	return d.errors
+}
+
+func (d *Document) Order() *ParseOrder {
+	return &d.order
+}
+
+// Reparsed is how many top-level declarations, comments and bad lines the last
+// edit parsed again
+func (d *Document) Reparsed() int {
+	return d.reparsed
+}
+
+// Update replaces the whole source, as editors that send full documents do. The
+// text the old and new sources share at either end is left alone.
+func (d *Document) Update(src string) {
+	prefix := 0
+	for prefix < len(d.src) && prefix < len(src) && d.src[prefix] == src[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(d.src) - prefix && suffix < len(src) - prefix && d.src[len(d.src) - 1 - suffix] == src[len(src) - 1 - suffix] {
+		suffix++
+	}
+	// never split a character
+	for prefix > 0 && !isCharStart(d.src, prefix) {
+		prefix--
+	}
+	for suffix > 0 && !isCharStart(d.src, len(d.src) - suffix) {
+		suffix--
+	}
+	d.Edit(TextEdit{
+		Span: Span{uint64(prefix), uint64(len(d.src) - suffix)},
+		NewText: src[prefix:len(src) - suffix],
+	})
+}
+
+func isCharStart(s string, i int) bool {
+	return i >= len(s) || s[i] & 0xC0 != 0x80
+}
+
+func (d *Document) Edit(e TextEdit) error {
+	if e.Span.Start > e.Span.End || e.Span.End > uint64(len(d.src)) {
+		return fmt.Errorf("edit at bytes %d-%d is out of range", e.Span.Start, e.Span.End)
+	}
+	old_src := d.src
+	src := old_src[:e.Span.Start] + e.NewText + old_src[e.Span.End:]
+	shift := shift{
+		bytes: int64(len(e.NewText)) - int64(e.Span.Len()),
+		lines: int64(strings.Count(e.NewText, "\n")) - int64(strings.Count(old_src[e.Span.Start:e.Span.End], "\n")),
+	}
+	edit_end := e.Span.Start + uint64(len(e.NewText))
+
+	// the parser looks ahead as far as the first character of the next line, so
+	// a chunk ending at the start of the edited line may have seen the edit
+	line_start := uint64(strings.LastIndexByte(old_src[:e.Span.Start], '\n') + 1)
+	first := 0
+	for first < len(d.chunks) && d.chunks[first].span.End < line_start {
+		first++
+	}
+
+	// re-parse until a chunk ends, past the edit, where an old one started on a
+	// fresh line; from there on the old chunks parse as before
+	tree := d.contract.tree
+	tree.edit(src, e)
+	pi := ParserInfo{columns: d.cfg.Columns, detach: true}
+	start := uint64(0)
+	if first < len(d.chunks) {
+		start = d.chunks[first].span.Start
+		pi.line, pi.col = d.chunks[first].line, d.chunks[first].col
+	}
+	tr := newTokenReader(tree, start)
+	chunks := append([]chunk{}, d.chunks[:first]...)
+	resume := len(d.chunks)
+	for tr.Len() > 0 {
+		ck := parseChunk(tr, &pi)
+		chunks = append(chunks, ck)
+		if ck.span.End < edit_end || pi.col != 0 {
+			continue
+		}
+		j, ok := d.chunkAt(int64(ck.span.End) - shift.bytes, e.Span.End)
+		if ok && d.chunks[j].col == 0 && int64(d.chunks[j].line) + shift.lines == int64(pi.line) {
+			resume = j
+			break
+		}
+	}
+	d.reparsed = len(chunks) - first
+
+	// an instance is only the same as before if its template is
+	templates := false
+	for _, ck := range d.chunks[first:resume] {
+		templates = templates || ck.template != nil
+	}
+	for _, ck := range chunks[first:] {
+		templates = templates || ck.template != nil
+	}
+	same := func(ck chunk) bool {
+		s, ok := ck.decl.(*SpaceDecl)
+		return !templates || !ok || !hasInstantiations(s)
+	}
+
+	// which units are the same declarations as before, keyed by new unit index
+	clean := make(map[int]int)
+	old_units := unitIndexes(d.chunks)
+	for i := 0; i < first; i++ {
+		if old_units[i] >= 0 && same(d.chunks[i]) {
+			clean[i] = old_units[i]
+		}
+	}
+	for j := resume; j < len(d.chunks); j++ {
+		if old_units[j] >= 0 && same(d.chunks[j]) {
+			clean[len(chunks)] = old_units[j]
+		}
+		chunks = append(chunks, shift.chunk(d.chunks[j]))
+	}
+	new_units := unitIndexes(chunks)
+	clean_units := make(map[int]int, len(clean))
+	for i, ou := range clean {
+		clean_units[new_units[i]] = ou
+	}
+
+	d.src = src
+	d.chunks = chunks
+	old_order := d.order
+	d.contract, d.errors = assemble(chunks, tree)
+	d.order = updateParseOrder(&old_order, &d.contract, clean_units)
+	return nil
+}
+
+// chunkAt finds the old chunk starting at offset, which must be at or after
+// the end of the edit
+func (d *Document) chunkAt(offset int64, edit_end uint64) (int, bool) {
+	if offset < int64(edit_end) {
+		return 0, false
+	}
+	lo, hi := 0, len(d.chunks)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if int64(d.chunks[mid].span.Start) < offset {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, lo < len(d.chunks) && int64(d.chunks[lo].span.Start) == offset
+}
+
+// unitIndexes gives the position in contractUnits of each chunk's
+// declaration, -1 for a chunk without one
+func unitIndexes(chunks []chunk) []int {
+	n_spaces, n_agents := 0, 0
+	for _, ck := range chunks {
+		switch ck.decl.(type) {
+		case *SpaceDecl: n_spaces++
+		case *AgentDecl: n_agents++
+		}
+	}
+	idx := make([]int, len(chunks))
+	spaces, agents, paths := 0, n_spaces, n_spaces + n_agents
+	for i, ck := range chunks {
+		switch ck.decl.(type) {
+		case *SpaceDecl: idx[i] = spaces; spaces++
+		case *AgentDecl: idx[i] = agents; agents++
+		case *PathDecl: idx[i] = paths; paths++
+		default: idx[i] = -1
+		}
+	}
+	return idx
+}
+
+// unitStarts gives the first node id of each top-level declaration, and Len()
+// at the end; a declaration's nodes have consecutive ids
+func (po *ParseOrder) unitStarts() []uint64 {
+	var starts []uint64
+	for i, n := range po.nodes_underlying {
+		if n.parent < 0 {
+			starts = append(starts, uint64(i))
+		}
+	}
+	return append(starts, uint64(len(po.nodes_underlying)))
+}
+
+// scopedName names a node by the spaces around it, which is what decides what
+// a lookup by its name finds
+func (po *ParseOrder) scopedName(id uint64) string {
+	name := po.nodes_underlying[id].ast_node.GetName().toString()
+	for p, ok := po.GetParent(id); ok; p, ok = po.GetParent(p) {
+		name = po.nodes_underlying[p].ast_node.GetName().toString() + "/" + name
+	}
+	return name
+}
+
+// updateParseOrder builds the ParseOrder of c from old, the one for the
+// contract before an edit, which it uses up. clean maps each top-level
+// declaration the edit left alone to its index before. The nodes of those are
+// carried over to their new ids, and keep their dependencies unless a name
+// they looked up is now declared differently, one they depended on was parsed
+// again, or a task they refer to may now be found elsewhere. Only the
+// declarations parsed again are named and resolved from scratch, and the nodes
+// are only sorted again if a dependency changed.
+func updateParseOrder(old *ParseOrder, c *Contract, clean map[int]int) ParseOrder {
+	old_starts := old.unitStarts()
+	po := ParseOrder{scope: old.scope, index: old.index, nodes_underlying: make([]ParseNode, 0, old.Len())}
+	clear(po.scope.names)
+	clear(po.index)
+
+	// where the nodes of each old declaration went, -1 if it was parsed again
+	moved_to := make([]int64, len(old_starts) - 1)
+	for i := range moved_to {
+		moved_to[i] = -1
+	}
+	var starts []uint64
+	for u, unit := range contractUnits(c) {
+		starts = append(starts, uint64(len(po.nodes_underlying)))
+		if ou, ok := clean[u]; ok {
+			moved_to[ou] = int64(len(po.nodes_underlying))
+			po.carryNames(old, unit, old_starts[ou])
+		} else {
+			po.addNames(unit, po.scope, -1)
+		}
+	}
+	starts = append(starts, uint64(len(po.nodes_underlying)))
+	po.indexPaths()
+	moved := func(old_id uint64) (uint64, bool) {
+		ou := sort.Search(len(old_starts), func(i int) bool { return old_starts[i] > old_id }) - 1
+		if moved_to[ou] < 0 {
+			return 0, false
+		}
+		return uint64(moved_to[ou]) + old_id - old_starts[ou], true
+	}
+
+	// names declared differently, and whether any =path changed
+	counts := make(map[string]int)
+	names := make(map[string]Ident)
+	paths := false
+	for u := 0; u + 1 < len(starts); u++ {
+		if _, ok := clean[u]; ok {
+			continue
+		}
+		for id := starts[u]; id < starts[u + 1]; id++ {
+			name := po.scopedName(id)
+			counts[name]++
+			names[name] = po.nodes_underlying[id].ast_node.GetName()
+			_, is_path := po.nodes_underlying[id].ast_node.(*PathDecl)
+			paths = paths || is_path
+		}
+	}
+	for ou := 0; ou + 1 < len(old_starts); ou++ {
+		if moved_to[ou] >= 0 {
+			continue
+		}
+		for id := old_starts[ou]; id < old_starts[ou + 1]; id++ {
+			name := old.scopedName(id)
+			counts[name]--
+			names[name] = old.nodes_underlying[id].ast_node.GetName()
+			_, is_path := old.nodes_underlying[id].ast_node.(*PathDecl)
+			paths = paths || is_path
+		}
+	}
+	changed := make(map[Ident]bool)
+	tasks := make(map[string]bool)
+	for name, n := range counts {
+		if n == 0 {
+			continue
+		}
+		id := names[name]
+		changed[id] = true
+		// spaces and paths decide where tasks are seen from
+		switch id.t {
+		case SPACE, PATH: paths = true
+		case TASK: tasks[id.n] = true
+		}
+	}
+	// and which tasks a path exposes depends on which of its destination's it names
+	for _, ps := range po.paths_from {
+		for _, p := range ps {
+			paths = paths || refersToTasks(po.nodes_underlying[p].ast_node, tasks, false)
+		}
+	}
+
+	same := po.Len() == old.Len()
+	for u := 0; u + 1 < len(starts); u++ {
+		ou, is_clean := clean[u]
+		for id := starts[u]; id < starts[u + 1]; id++ {
+			kept, unmoved := false, false
+			if is_clean {
+				kept, unmoved = po.keepDeps(id, moved, changed, tasks, paths)
+			}
+			if !kept {
+				po.nodes_underlying[id].deps = make(map[uint64]bool)
+				po.resolveDeps(id)
+			}
+			// dependencies kept where they were are the ones before
+			if same && !(unmoved && starts[u] == old_starts[ou]) && !sameDeps(po.nodes_underlying[id].deps, old.nodes_underlying[id].deps) {
+				same = false
+			}
+		}
+	}
+
+	if same {
+		po.nodes_sorted = old.nodes_sorted
+		po.nodes_sorted_index = old.nodes_sorted_index
+		po.cycles = old.cycles
+	} else {
+		po.sort()
+	}
+	return po
+}
+
+// carryNames copies the nodes of a declaration left alone from old, where they
+// started at old_start, to the end of po, giving them the new declaration's
+// AST. The scopes of its spaces are kept, with the ids in them moved.
+func (po *ParseOrder) carryNames(old *ParseOrder, unit ParseUnit, old_start uint64) {
+	delta := int64(len(po.nodes_underlying)) - int64(old_start)
+	old_id := old_start
+	eachUnit(unit, func(u ParseUnit) {
+		n := old.nodes_underlying[old_id]
+		old_id++
+		n.ast_node = u
+		n.visited, n.temp = false, false
+		if delta != 0 {
+			if n.parent >= 0 {
+				n.parent += delta
+			}
+			for i := range n.children {
+				n.children[i] = uint64(int64(n.children[i]) + delta)
+			}
+			if _, ok := u.(*SpaceDecl); ok {
+				for name, i := range n.scope.names {
+					n.scope.names[name] = uint64(int64(i) + delta)
+				}
+			}
+		}
+
+		// a name of the contract's may clash with one declared anew, or no longer
+		id := uint64(len(po.nodes_underlying))
+		ident := u.GetName()
+		if n.parent < 0 {
+			po.scope.names[ident] = id
+		}
+		if !isPrivate(u, n.parent) {
+			_, n.duplicate = po.index[ident]
+			po.index[ident] = id
+		}
+		po.nodes_underlying = append(po.nodes_underlying, n)
+	})
+}
+
+// eachUnit calls f on unit and everything declared inside it, in id order
+func eachUnit(unit ParseUnit, f func(ParseUnit)) {
+	f(unit)
+	s, ok := unit.(*SpaceDecl)
+	if !ok {
+		return
+	}
+	for i := range s.agents {
+		f(&s.agents[i])
+	}
+	for i := range s.tasks {
+		f(&s.tasks[i])
+	}
+	for i := range s.spaces {
+		eachUnit(&s.spaces[i], f)
+	}
+	for i := range s.paths {
+		f(&s.paths[i])
+	}
+}
+
+// keepDeps lets a carried over node keep its dependencies, moved to their new
+// ids, if they still hold; and says whether none of them moved
+func (po *ParseOrder) keepDeps(id uint64, moved func(uint64) (uint64, bool), changed map[Ident]bool, tasks map[string]bool, paths bool) (bool, bool) {
+	n := &po.nodes_underlying[id]
+	if refersToTasks(n.ast_node, tasks, paths) {
+		return false, false
+	}
+	for ref := range n.refs {
+		if changed[ref] {
+			return false, false
+		}
+	}
+	unmoved := true
+	for dep := range n.deps {
+		i, ok := moved(dep)
+		if !ok {
+			return false, false
+		}
+		unmoved = unmoved && i == dep
+	}
+	if !unmoved {
+		deps := make(map[uint64]bool, len(n.deps))
+		for dep := range n.deps {
+			i, _ := moved(dep)
+			deps[i] = true
+		}
+		n.deps = deps
+	}
+	return true, unmoved
+}
+
+// refersToTasks says whether a node's vibe block names any of tasks, or any
+// task at all if all is set
+func refersToTasks(unit ParseUnit, tasks map[string]bool, all bool) bool {
+	for _, mr := range unit.GetVibe().meta_refs {
+		if t, ok := mr.(*MetaRefTask); ok && (all || tasks[t.ident]) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameDeps(a, b map[uint64]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for dep := range a {
+		if !b[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+// shift moves what a chunk parsed to where it sits after an edit before it
+type shift struct {
+	bytes, lines int64
+}
+
+func (sh shift) span(s Span) Span {
+	return Span{uint64(int64(s.Start) + sh.bytes), uint64(int64(s.End) + sh.bytes)}
+}
+
+func (sh shift) line(l uint64) uint64 {
+	return uint64(int64(l) + sh.lines)
+}
+
+func (sh shift) chunk(ck chunk) chunk {
+	ck.span = sh.span(ck.span)
+	ck.line = sh.line(ck.line)
+	switch decl := ck.decl.(type) {
+	case *SpaceDecl: s := sh.space(*decl); ck.decl = &s
+	case *AgentDecl: a := sh.agent(*decl); ck.decl = &a
+	case *PathDecl: p := sh.path(*decl); ck.decl = &p
+	}
+	if ck.template != nil {
+		t := sh.template(*ck.template)
+		ck.template = &t
+	}
+	ck.comments = slices.Clone(ck.comments)
+	for i := range ck.comments {
+		ck.comments[i].line = sh.line(ck.comments[i].line)
+	}
+	ck.errors = slices.Clone(ck.errors)
+	for i := range ck.errors {
+		ck.errors[i].line = sh.line(ck.errors[i].line)
+	}
+	return ck
+}
+
+func (sh shift) space(d SpaceDecl) SpaceDecl {
+	d.params = sh.params(d.params)
+	d.vibe_desc = sh.vibe(d.vibe_desc)
+	d.agents = slices.Clone(d.agents)
+	for i := range d.agents {
+		d.agents[i] = sh.agent(d.agents[i])
+	}
+	d.tasks = slices.Clone(d.tasks)
+	for i := range d.tasks {
+		d.tasks[i] = sh.task(d.tasks[i])
+	}
+	d.spaces = slices.Clone(d.spaces)
+	for i := range d.spaces {
+		d.spaces[i] = sh.space(d.spaces[i])
+	}
+	d.paths = slices.Clone(d.paths)
+	for i := range d.paths {
+		d.paths[i] = sh.path(d.paths[i])
+	}
+	d.line_start, d.line_end = sh.line(d.line_start), sh.line(d.line_end)
+	d.span, d.name_span = sh.span(d.span), sh.span(d.name_span)
+	if d.instance != nil {
+		inst := sh.instantiation(*d.instance)
+		d.instance = &inst
+	}
+	return d
+}
+
+func (sh shift) template(t Template) Template {
+	t.body = sh.space(t.body)
+	t.line = sh.line(t.line)
+	t.params = slices.Clone(t.params)
+	for i := range t.params {
+		t.params[i].line = sh.line(t.params[i].line)
+		t.params[i].span = sh.span(t.params[i].span)
+	}
+	t.errors = slices.Clone(t.errors)
+	for i := range t.errors {
+		t.errors[i].line = sh.line(t.errors[i].line)
+	}
+	return t
+}
+
+func (sh shift) instantiation(inst Instantiation) Instantiation {
+	inst.line = sh.line(inst.line)
+	inst.span, inst.name_span, inst.template_span = sh.span(inst.span), sh.span(inst.name_span), sh.span(inst.template_span)
+	inst.args = slices.Clone(inst.args)
+	for i := range inst.args {
+		inst.args[i].line = sh.line(inst.args[i].line)
+		inst.args[i].span = sh.span(inst.args[i].span)
+	}
+	return inst
+}
+
+func (sh shift) agent(d AgentDecl) AgentDecl {
+	d.params = sh.params(d.params)
+	d.vibe_desc = sh.vibe(d.vibe_desc)
+	d.line_start, d.line_end = sh.line(d.line_start), sh.line(d.line_end)
+	d.span, d.name_span = sh.span(d.span), sh.span(d.name_span)
+	return d
+}
+
+func (sh shift) task(d TaskDecl) TaskDecl {
+	d.params = sh.params(d.params)
+	d.vibe_desc = sh.vibe(d.vibe_desc)
+	d.line_start, d.line_end = sh.line(d.line_start), sh.line(d.line_end)
+	d.span, d.name_span = sh.span(d.span), sh.span(d.name_span)
+	return d
+}
+
+func (sh shift) path(d PathDecl) PathDecl {
+	d.vibe_desc = sh.vibe(d.vibe_desc)
+	d.line_start, d.line_end = sh.line(d.line_start), sh.line(d.line_end)
+	d.span, d.name_span = sh.span(d.span), sh.span(d.name_span)
+	d.source_span, d.dest_span = sh.span(d.source_span), sh.span(d.dest_span)
+	return d
+}
+
+func (sh shift) params(ps []Param) []Param {
+	ps = slices.Clone(ps)
+	for i := range ps {
+		ps[i].line = sh.line(ps[i].line)
+		ps[i].span, ps[i].name_span = sh.span(ps[i].span), sh.span(ps[i].name_span)
+	}
+	return ps
+}
+
+func (sh shift) vibe(vb VibeBlock) VibeBlock {
+	vb.vibe_lines = slices.Clone(vb.vibe_lines)
+	for i := range vb.vibe_lines {
+		vb.vibe_lines[i] = sh.line(vb.vibe_lines[i])
+	}
+	vb.meta_refs = slices.Clone(vb.meta_refs)
+	for i, mr := range vb.meta_refs {
+		vb.meta_refs[i] = sh.metaRef(mr)
+	}
+	vb.line_start, vb.line_end = sh.line(vb.line_start), sh.line(vb.line_end)
+	vb.span = sh.span(vb.span)
+	vb.prose_spans = slices.Clone(vb.prose_spans)
+	for i := range vb.prose_spans {
+		vb.prose_spans[i] = sh.span(vb.prose_spans[i])
+	}
+	return vb
+}
+
+func (sh shift) metaRef(mr MetaRef) MetaRef {
+	switch mr := mr.(type) {
+	case *MetaRefData:
+		m := *mr
+		m.line = sh.line(m.line)
+		m.span, m.name_span = sh.span(m.span), sh.span(m.name_span)
+		return &m
+	case *MetaRefUseImport:
+		m := *mr
+		m.line = sh.line(m.line)
+		m.span, m.name_span = sh.span(m.span), sh.span(m.name_span)
+		return &m
+	case *MetaRefTask:
+		m := *mr
+		m.line = sh.line(m.line)
+		m.args = sh.params(m.args)
+		m.span, m.name_span = sh.span(m.span), sh.span(m.name_span)
+		if m.space != "" {
+			m.space_span = sh.span(m.space_span)
+		}
+		return &m
+	case *MetaRefPath:
+		m := *mr
+		m.line = sh.line(m.line)
+		m.span, m.name_span = sh.span(m.span), sh.span(m.name_span)
+		return &m
+	}
+	return mr
+}
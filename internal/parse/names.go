@@ -1,17 +1,49 @@
 package parse
 
 import (
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
 )
 
+// Scope is one level of lexical scope: the contract's, or a @space's, holding
+// the agents, tasks, subspaces and paths declared in it. A name is looked up in
+// the innermost scope first, then outward.
+//
+// Space, path and top-level agent names must be unique across the contract,
+// since the runtime addresses them by name. The agents and tasks of a space are
+// private to it: two spaces may each have a $init. From outside a space they
+// are named @space.$task or @space.#agent (see GetQualifiedName), and a task
+// is only visible outside its space through a =path that exposes it (see
+// resolveTask).
 type Scope struct {
 	names map[Ident]uint64
+	// enclosing scope, nil for the contract's
+	parent *Scope
+	// if set, records every name tryAddDep looks up; see resolveDeps
+	refs map[Ident]bool
+	// the names tryAddDep found nothing by, in the order it looked them up
+	missing []Ident
+}
+
+func (scope *Scope) lookup(id Ident) (uint64, bool) {
+	for s := scope; s != nil; s = s.parent {
+		if i, ok := s.names[id]; ok {
+			return i, true
+		}
+	}
+	return 0, false
 }
 
 func (scope *Scope) tryAddDep(id Ident, deps *map[uint64]bool) bool {
-	i, ok := scope.names[id]
+	if scope.refs != nil {
+		scope.refs[id] = true
+	}
+	i, ok := scope.lookup(id)
 	if !ok {
-		fmt.Printf("Undeclared Identifier: %s\n", id.toString())
+		scope.missing = append(scope.missing, id)
 		return false
 	}
 	(*deps)[i] = true
@@ -23,20 +55,215 @@ type ParseNode struct {
 	visited bool
 	temp bool
 
+	// scope the node's references resolve in; a space's own
+	scope *Scope
+	// enclosing space, -1 at the top level
+	parent int64
+	children []uint64
+	// names its dependencies were looked up by, found or not
+	refs map[Ident]bool
+	// and the ones that found nothing
+	missing []Ident
+	// another node of the same kind in the same scope has its name
+	duplicate bool
+
 	ast_node ParseUnit
 }
 
 type ParseUnit interface {
 	GetName() Ident
 	GetChildren() []ParseUnit
+	GetVibe() *VibeBlock
+	GetLines() (uint64, uint64)
+	GetSpan() Span
+	GetNameSpan() Span
+	GetInstantiation() *Instantiation
 	ParseDepGetter
 }
 
 type ParseOrder struct {
-	scope Scope
+	scope *Scope
+	// every space, path and top-level agent, wherever it is declared
+	index map[Ident]uint64
 	nodes_underlying []ParseNode
 	nodes_sorted []uint64
 	nodes_sorted_index int
+	// nodes the sort found back at while it was still visiting them
+	cycles []uint64
+	// the =paths leading out of each space, in id order
+	paths_from map[uint64][]uint64
+}
+
+type NameProblemKind byte
+const (
+	NameUndeclared NameProblemKind = iota
+	NameDuplicate
+	NameCycle
+)
+
+// NameProblem is something wrong with the names of a contract that
+// GetParseOrder found. Task references that do not resolve are not among them;
+// see ResolveTask.
+type NameProblem struct {
+	Kind NameProblemKind
+	// the node it was found in
+	Node uint64
+	// what was not declared, or was declared twice; the node's own name for a cycle
+	Name Ident
+}
+
+func (p NameProblem) ToStr() string {
+	switch p.Kind {
+	case NameUndeclared: return fmt.Sprintf("Undeclared Identifier: %s", p.Name.toString())
+	case NameDuplicate: return fmt.Sprintf("Duplicate Identifier: %s", p.Name.toString())
+	case NameCycle: return fmt.Sprintf("%s is in a dependency cycle", p.Name.toString())
+	default: return "???"
+	}
+}
+
+// GetProblems lists the undeclared and duplicate names in node order, then the
+// cycles
+func (po *ParseOrder) GetProblems() []NameProblem {
+	var problems []NameProblem
+	for i, n := range po.nodes_underlying {
+		if n.duplicate {
+			problems = append(problems, NameProblem{Kind: NameDuplicate, Node: uint64(i), Name: n.ast_node.GetName()})
+		}
+		for _, id := range n.missing {
+			problems = append(problems, NameProblem{Kind: NameUndeclared, Node: uint64(i), Name: id})
+		}
+	}
+	for _, id := range po.cycles {
+		problems = append(problems, NameProblem{Kind: NameCycle, Node: id, Name: po.nodes_underlying[id].ast_node.GetName()})
+	}
+	return problems
+}
+
+func (po *ParseOrder) Len() int {
+	return len(po.nodes_underlying)
+}
+
+func (po *ParseOrder) GetNode(id uint64) ParseUnit {
+	return po.nodes_underlying[id].ast_node
+}
+
+// dependencies of a node, in ascending id order
+func (po *ParseOrder) GetDeps(id uint64) []uint64 {
+	deps := make([]uint64, 0, len(po.nodes_underlying[id].deps))
+	for dep := range po.nodes_underlying[id].deps {
+		deps = append(deps, dep)
+	}
+	sort.Slice(deps, func(i, j int) bool { return deps[i] < deps[j] })
+	return deps
+}
+
+// every node id once, in dependency order. The nodes of a cycle are in it too,
+// with the dependency that closes the cycle left unmet; see GetProblems.
+func (po *ParseOrder) GetSorted() []uint64 {
+	return po.nodes_sorted
+}
+
+// Lookup finds a space, path or top-level agent by name anywhere in the
+// contract, ignoring scopes. The agents and tasks of a space need
+// LookupQualified.
+func (po *ParseOrder) Lookup(id Ident) (uint64, bool) {
+	i, ok := po.index[id]
+	return i, ok
+}
+
+// IdentFromStr reads a sigiled name such as "@space", "#agent", "$task" or "=path"
+func IdentFromStr(s string) (Ident, bool) {
+	if len(s) < 2 {
+		return Ident{}, false
+	}
+	var t MetaType
+	switch s[0] {
+	case '@': t = SPACE
+	case '#': t = AGENT
+	case '$': t = TASK
+	case '=': t = PATH
+	default: return Ident{}, false
+	}
+	return Ident{t: t, n: s[1:]}, true
+}
+
+func isPrivate(unit ParseUnit, parent int64) bool {
+	switch unit.(type) {
+	case *TaskDecl, *AgentDecl: return parent >= 0
+	default: return false
+	}
+}
+
+// GetQualifiedName names a node uniquely: "@space.$task" or "@space.#agent" for
+// those private to a space, its plain name otherwise
+func (po *ParseOrder) GetQualifiedName(id uint64) string {
+	n := &po.nodes_underlying[id]
+	name := n.ast_node.GetName().toString()
+	if isPrivate(n.ast_node, n.parent) {
+		return po.nodes_underlying[n.parent].ast_node.GetName().toString() + "." + name
+	}
+	return name
+}
+
+// LookupQualified finds a node by the name GetQualifiedName gives it. A task or
+// agent may also be named plainly when no other shares its name.
+func (po *ParseOrder) LookupQualified(name string) (uint64, bool) {
+	if dot := strings.LastIndex(name, "."); dot > 0 {
+		space, ok := IdentFromStr(name[:dot])
+		if !ok || space.t != SPACE {
+			return 0, false
+		}
+		id, ok := IdentFromStr(name[dot+1:])
+		s, found := po.index[space]
+		if !ok || !found {
+			return 0, false
+		}
+		i, ok := po.nodes_underlying[s].scope.names[id]
+		return i, ok
+	}
+
+	id, ok := IdentFromStr(name)
+	if !ok {
+		return 0, false
+	}
+	if i, ok := po.index[id]; ok {
+		return i, true
+	}
+	var found []uint64
+	for i, n := range po.nodes_underlying {
+		if n.ast_node.GetName() == id {
+			found = append(found, uint64(i))
+		}
+	}
+	if len(found) != 1 {
+		return 0, false
+	}
+	return found[0], true
+}
+
+// GetChildIDs lists the nodes declared directly inside a space
+func (po *ParseOrder) GetChildIDs(id uint64) []uint64 {
+	return po.nodes_underlying[id].children
+}
+
+// Resolve looks a name up as the node from would see it, innermost scope first
+func (po *ParseOrder) Resolve(from uint64, id Ident) (uint64, bool) {
+	return po.nodes_underlying[from].scope.lookup(id)
+}
+
+// GetParent gives the space a node is declared in
+func (po *ParseOrder) GetParent(id uint64) (uint64, bool) {
+	p := po.nodes_underlying[id].parent
+	return uint64(p), p >= 0
+}
+
+// GetAncestors lists the spaces a node is nested in, innermost first
+func (po *ParseOrder) GetAncestors(id uint64) []uint64 {
+	var ancestors []uint64
+	for p, ok := po.GetParent(id); ok; p, ok = po.GetParent(p) {
+		ancestors = append(ancestors, p)
+	}
+	return ancestors
 }
 
 func (po *ParseOrder) topSortVisit(id uint64) bool {
@@ -45,11 +272,17 @@ func (po *ParseOrder) topSortVisit(id uint64) bool {
 		return true
 	}
 	if n.temp { // cycle !
-		fmt.Printf("Node %s is in a cycle\n", n.ast_node.GetName().toString())
+		for _, c := range po.cycles {
+			if c == id {
+				return false
+			}
+		}
+		po.cycles = append(po.cycles, id)
 		return false
 	}
 	n.temp = true
-	for dep_id := range n.deps {
+	// in id order, so that the same contract always sorts the same way
+	for _, dep_id := range po.GetDeps(id) {
 		po.topSortVisit(dep_id)
 	}
 	// fmt.Printf("adding node %s\n", n.ast_node.GetName().toString())
@@ -59,71 +292,289 @@ func (po *ParseOrder) topSortVisit(id uint64) bool {
 	return true
 }
 
-func (po *ParseOrder) addNames(unit ParseUnit) bool {
+// addNames registers unit, declared in scope inside the space parent, and its
+// children
+func (po *ParseOrder) addNames(unit ParseUnit, scope *Scope, parent int64) uint64 {
 	ident := unit.GetName()
-	_, dupes := po.scope.names[ident]
-
-	if dupes {
-		fmt.Printf("Duplicate Identifier: %s\n", ident.toString())
+	private := isPrivate(unit, parent)
+	var dupes bool
+	if private {
+		_, dupes = scope.names[ident]
+	} else {
+		_, dupes = po.index[ident]
 	}
 
-	my_id := len(po.nodes_underlying)
+	my_id := uint64(len(po.nodes_underlying))
+	scope.names[ident] = my_id
+	if !private {
+		po.index[ident] = my_id
+	}
+	inner := scope
+	if _, ok := unit.(*SpaceDecl); ok {
+		inner = &Scope{
+			names: make(map[Ident]uint64),
+			parent: scope,
+		}
+	}
 	po.nodes_underlying = append(po.nodes_underlying, ParseNode{
 		deps: make(map[uint64]bool),
+		scope: inner,
+		parent: parent,
+		duplicate: dupes,
 		ast_node: unit,
 	})
-	po.scope.names[ident] = uint64(my_id)
 
+	var children []uint64
 	for _, c := range unit.GetChildren() {
-		dupes = po.addNames(c) || dupes
+		children = append(children, po.addNames(c, inner, int64(my_id)))
 	}
-	return dupes
+	po.nodes_underlying[my_id].children = children
+	return my_id
+}
+
+// viewpoints lists the spaces a node sees tasks from, innermost first: the ones
+// it is in, those a top-level #agent $use()s, and the source of a =path
+func (po *ParseOrder) viewpoints(from uint64) []uint64 {
+	var spaces []uint64
+	switch d := po.nodes_underlying[from].ast_node.(type) {
+	case *SpaceDecl: spaces = append(spaces, from)
+	case *PathDecl:
+		if s, ok := po.Resolve(from, d.space_source); ok {
+			spaces = append(spaces, s)
+		}
+	case *AgentDecl:
+		if po.nodes_underlying[from].parent >= 0 {
+			break
+		}
+		for _, mr := range d.vibe_desc.meta_refs {
+			use, ok := mr.(*MetaRefUseImport)
+			if !ok || use.import_type != UseImportSpace {
+				continue
+			}
+			if s, ok := po.Resolve(from, use.GetImported()); ok {
+				spaces = append(spaces, s)
+			}
+		}
+	}
+	if len(spaces) > 0 {
+		spaces = append(spaces, po.GetAncestors(spaces[0])...)
+	} else {
+		spaces = po.GetAncestors(from)
+	}
+	return spaces
+}
+
+// exposes gives the task named by a =path's destination that the path lets its
+// source call. A path whose vibe block names tasks of its destination exposes
+// only those; one that names none exposes them all.
+func (po *ParseOrder) exposes(path uint64, task string) (uint64, bool) {
+	p := po.nodes_underlying[path].ast_node.(*PathDecl)
+	dest, ok := po.Resolve(path, p.space_dest)
+	if !ok {
+		return 0, false
+	}
+	id, ok := po.nodes_underlying[dest].scope.names[Ident{t: TASK, n: task}]
+	if !ok {
+		return 0, false
+	}
+
+	listed := false
+	for _, mr := range p.vibe_desc.meta_refs {
+		t, ok := mr.(*MetaRefTask)
+		if !ok || (t.space != "" && t.space != p.space_dest.n) {
+			continue
+		}
+		if _, ok := po.nodes_underlying[dest].scope.names[Ident{t: TASK, n: t.ident}]; !ok {
+			continue
+		}
+		if t.ident == task {
+			return id, true
+		}
+		listed = true
+	}
+	return id, !listed
+}
+
+// indexPaths files every =path under the space it leads out of
+func (po *ParseOrder) indexPaths() {
+	po.paths_from = make(map[uint64][]uint64)
+	for i, n := range po.nodes_underlying {
+		p, ok := n.ast_node.(*PathDecl)
+		if !ok {
+			continue
+		}
+		if src, ok := po.Resolve(uint64(i), p.space_source); ok {
+			po.paths_from[src] = append(po.paths_from[src], uint64(i))
+		}
+	}
+}
+
+// pathsFrom lists the =paths leading out of any of spaces
+func (po *ParseOrder) pathsFrom(spaces []uint64) []uint64 {
+	var paths []uint64
+	for i, s := range spaces {
+		if slices.Contains(spaces[:i], s) {
+			continue
+		}
+		paths = append(paths, po.paths_from[s]...)
+	}
+	return paths
+}
+
+// resolveTask finds the $task a vibe reference in node from names.
+//
+// A plain $task is looked up lexically, then in the spaces from works in (see
+// viewpoints), then among the tasks exposed by =paths out of those spaces,
+// where it must be exposed by only one. A path's own vibe block names tasks of
+// its destination first. @space.$task names the task of that space, which must
+// be one from works in or one a path from there exposes it from.
+func (po *ParseOrder) resolveTask(from uint64, mr *MetaRefTask) (uint64, string) {
+	id := Ident{t: TASK, n: mr.ident}
+	views := po.viewpoints(from)
+
+	if mr.space != "" {
+		s, ok := po.index[Ident{t: SPACE, n: mr.space}]
+		if !ok {
+			return 0, fmt.Sprintf("Undeclared Identifier: @%s", mr.space)
+		}
+		t, ok := po.nodes_underlying[s].scope.names[id]
+		if !ok {
+			return 0, fmt.Sprintf("Undeclared Identifier: %s", mr.ToStr())
+		}
+		for _, v := range views {
+			if v == s {
+				return t, ""
+			}
+		}
+		for _, p := range po.pathsFrom(views) {
+			if e, ok := po.exposes(p, mr.ident); ok && e == t {
+				return t, ""
+			}
+		}
+		return 0, fmt.Sprintf("Invisible Identifier: $%s is private to @%s, and no =path from here exposes it", mr.ident, mr.space)
+	}
+
+	if p, ok := po.nodes_underlying[from].ast_node.(*PathDecl); ok {
+		if dest, ok := po.Resolve(from, p.space_dest); ok {
+			if t, ok := po.nodes_underlying[dest].scope.names[id]; ok {
+				return t, ""
+			}
+		}
+	}
+	if t, ok := po.nodes_underlying[from].scope.lookup(id); ok {
+		return t, ""
+	}
+	for _, v := range views {
+		if t, ok := po.nodes_underlying[v].scope.lookup(id); ok {
+			return t, ""
+		}
+	}
+
+	found := make(map[uint64]bool)
+	var first uint64
+	for _, p := range po.pathsFrom(views) {
+		if t, ok := po.exposes(p, mr.ident); ok {
+			if len(found) == 0 {
+				first = t
+			}
+			found[t] = true
+		}
+	}
+	switch len(found) {
+	case 0: return 0, fmt.Sprintf("Undeclared Identifier: $%s", mr.ident)
+	case 1: return first, ""
+	default: return 0, fmt.Sprintf("Ambiguous Identifier: $%s is exposed by several =paths, qualify it as @space.$%s", mr.ident, mr.ident)
+	}
+}
+
+// ResolveTask finds the $task a reference in node from's vibe block names, or
+// says why it is undeclared, ambiguous or not visible from there.
+func (po *ParseOrder) ResolveTask(from uint64, mr *MetaRefTask) (uint64, error) {
+	t, err := po.resolveTask(from, mr)
+	if err != "" {
+		return 0, errors.New(err)
+	}
+	return t, nil
 }
 
 func GetParseOrder(c *Contract) ParseOrder {
+	po := newParseOrder(c)
+	for i := range po.nodes_underlying {
+		po.resolveDeps(uint64(i))
+	}
+
+	// po.printDeps()
+
+	po.sort()
+	return po
+}
+
+// newParseOrder gives every declaration in c an id, in order: each space with
+// everything inside it, then the top-level agents, then the top-level paths.
+// No dependencies are resolved yet.
+func newParseOrder(c *Contract) ParseOrder {
 	po := ParseOrder{
-		scope: Scope{
+		scope: &Scope{
 			names: make(map[Ident]uint64),
 		},
+		index: make(map[Ident]uint64),
 	}
-	dupes := false
-	for _, s := range c.spaces {
-		dupes = po.addNames(&s) || dupes
+	for _, u := range contractUnits(c) {
+		po.addNames(u, po.scope, -1)
 	}
-	for _, a := range c.agents {
-		dupes = po.addNames(&a) || dupes
+	po.indexPaths()
+	return po
+}
+
+// contractUnits lists the top-level declarations in the order they get ids
+func contractUnits(c *Contract) []ParseUnit {
+	var units []ParseUnit
+	for i := range c.spaces {
+		units = append(units, &c.spaces[i])
 	}
-	for _, p := range c.paths {
-		dupes = po.addNames(&p) || dupes
+	for i := range c.agents {
+		units = append(units, &c.agents[i])
 	}
-
-	if dupes {
-		fmt.Println("there were duplicate identifiers")
-		// todo idk
+	for i := range c.paths {
+		units = append(units, &c.paths[i])
 	}
+	return units
+}
 
-	for _, n := range po.nodes_underlying {
-		n.ast_node.GetDeps(&n.deps, &po.scope)
+func (po *ParseOrder) resolveDeps(id uint64) {
+	n := &po.nodes_underlying[id]
+	n.refs = make(map[Ident]bool)
+	scope := &Scope{parent: n.scope, refs: n.refs}
+	n.ast_node.GetDeps(&n.deps, scope)
+	n.missing = scope.missing
+	// task references need to know where they are made from, see resolveTask;
+	// the ones that do not resolve are the checker's to report
+	for _, mr := range n.ast_node.GetVibe().meta_refs {
+		t_ref, ok := mr.(*MetaRefTask)
+		if !ok {
+			continue
+		}
+		if t, err := po.resolveTask(id, t_ref); err == "" {
+			n.deps[t] = true
+		}
 	}
+}
 
-	// po.printDeps()
-
-	// topological sort
+// sort orders every node topologically, visiting them and their dependencies
+// in id order. A dependency that would close a cycle is skipped and the node it
+// leads back to is noted in po.cycles; the sort carries on past it.
+func (po *ParseOrder) sort() {
 	po.nodes_sorted_index = 0
+	po.cycles = nil
 	po.nodes_sorted = make([]uint64, len(po.nodes_underlying))
 
 	for i, n := range po.nodes_underlying {
 		if !n.visited {
-			if !po.topSortVisit(uint64(i)) {
-				po.nodes_sorted = po.nodes_sorted[:po.nodes_sorted_index]
-				return po
-			}
+			po.topSortVisit(uint64(i))
 		}
 	}
 
-	po.printDepsOrdered()
-
-	return po
+	// po.printDepsOrdered()
 }
 
 // func (po *ParseOrder) printDeps() {
@@ -1,216 +1,450 @@
 package parse
 
 import (
-	// "fmt"
-	// "io"
+	"io"
+	"sort"
 	"strings"
+	"unicode/utf8"
 )
 
 type ParserInfo struct {
 	line, col uint64
+	columns ColumnUnit
 
 	errors []ParserErrorInfo
+	comments []Comment
+
+	// copy names, prose and comments out of the source rather than slicing
+	// it, for a Document, whose declarations outlive the source they came from
+	detach bool
+	// scratch space, reused from one declaration to the next
+	tags []locationTaggedString
+	prose []byte
+
+	// the template being parsed, and when expanding an instance of it, the
+	// instantiation and what its arguments replace
+	template *Template
+	instance *Instantiation
+	subst map[string]string
+
+	// where the AST's slices and references come from
+	params slab[Param]
+	vibe_lines slab[uint64]
+	vibe_prose slab[string]
+	prose_spans slab[Span]
+	meta_refs slab[MetaRef]
+	data_refs slab[MetaRefData]
+	task_refs slab[MetaRefTask]
+	path_refs slab[MetaRefPath]
+	agents declStack[AgentDecl]
+	tasks declStack[TaskDecl]
+	spaces declStack[SpaceDecl]
+	paths declStack[PathDecl]
 }
 
 type locationTaggedString struct {
 	val string
 	line, col uint64
+	span Span
 }
 
+// own is s as the AST may keep it
+func (pi *ParserInfo) own(s string) string {
+	if pi.detach {
+		return strings.Clone(s)
+	}
+	return s
+}
+
+// The parser reads the tokens Lex cuts the source into, one declaration at a
+// time. Layout is not in the tokens but in the trivia between them, so the
+// reader keeps a byte offset as well: indentation, blank lines and the rest of
+// a line skipped after an error are read a byte at a time, names, punctuation
+// and prose a token at a time.
+type tokenReader struct {
+	src string
+	tokens []Token
+	off uint64
+	// the first token ending after off
+	next int
+}
+
+func newTokenReader(tree *SyntaxTree, off uint64) *tokenReader {
+	tr := &tokenReader{src: tree.src, tokens: tree.tokens, off: off}
+	tr.next = sort.Search(len(tr.tokens) - 1, func(i int) bool { return tr.tokens[i].Span.End > off })
+	return tr
+}
+
+// Len is how many bytes are left to read
+func (tr *tokenReader) Len() int {
+	return len(tr.src) - int(tr.off)
+}
+
+// peekByte is the byte at the reader's offset, 0 at the end
+func (tr *tokenReader) peekByte() byte {
+	if tr.off >= uint64(len(tr.src)) {
+		return 0
+	}
+	return tr.src[tr.off]
+}
+
+// atLineEnd says if the reader is at a "\n" or the end of the source, either
+// of which ends a vibe line
+func atLineEnd(tr *tokenReader) bool {
+	return tr.Len() == 0 || tr.peekByte() == '\n'
+}
+
+func (tr *tokenReader) token() *Token {
+	for tr.next < len(tr.tokens) - 1 && tr.tokens[tr.next].Span.End <= tr.off {
+		tr.next++
+	}
+	return &tr.tokens[tr.next]
+}
+
+// peek is the kind of the token starting at the reader's offset, TokenEOF if
+// the offset is in trivia
+func (tr *tokenReader) peek() TokenKind {
+	tok := tr.token()
+	if tok.Span.Start != tr.off {
+		return TokenEOF
+	}
+	return tok.Kind
+}
+
+// take reads the token peek saw
+func (tr *tokenReader) take() string {
+	tok := tr.token()
+	tr.off = tok.Span.End
+	tr.next++
+	return tr.src[tok.Span.Start:tok.Span.End]
+}
+
+// trimmedOffset is the reader's offset with any whitespace just read taken
+// back off, so that spans end with the last character that means something
+func trimmedOffset(tr *tokenReader, start uint64) uint64 {
+	end := tr.off
+	for end > start {
+		ch := tr.src[end - 1]
+		if ch != ' ' && ch != '\t' && ch != '\r' && ch != '\n' {
+			break
+		}
+		end--
+	}
+	return end
+}
+
+// ParseFromReader parses a contract, counting columns in code points
 func ParseFromReader(reader *strings.Reader) (Contract, []ParserErrorInfo) {
+	return ParseFromReaderWith(reader, ParseConfig{})
+}
+
+func ParseFromReaderWith(reader *strings.Reader, cfg ParseConfig) (Contract, []ParserErrorInfo) {
 	pi := ParserInfo{
 		line: 0,
 		col: 0,
+		columns: cfg.Columns,
 	}
 
-	var c Contract
-	for reader.Len() > 0 {
-		consumeBlankLines(reader, &pi)
-		ch, size, _ := reader.ReadRune()
-		if size != 1 {
-			pi.addError(NonAsciiChar)
-			pi.col += uint64(size)
+	// lex the whole source, so that offsets in the AST and the tree agree, and
+	// parse it from where the reader was
+	pos, _ := reader.Seek(0, io.SeekCurrent)
+	reader.Seek(0, io.SeekStart)
+	var src strings.Builder
+	src.Grow(int(reader.Size()))
+	reader.WriteTo(&src)
+	tree := LexWith(src.String(), cfg)
+
+	tr := newTokenReader(tree, uint64(pos))
+	if pos > 0 {
+		// the parser takes the reader to be at the start of a line, which
+		// lexes differently from the middle of one
+		rest := LexWith(tree.src[pos:], cfg)
+		for i := range rest.tokens {
+			rest.tokens[i].Span.Start += uint64(pos)
+			rest.tokens[i].Span.End += uint64(pos)
 		}
-		switch ch {
+		rest.src = tree.src
+		tr = newTokenReader(rest, uint64(pos))
+	}
+	var chunks []chunk
+	for tr.Len() > 0 {
+		chunks = append(chunks, parseChunk(tr, &pi))
+	}
+
+	return assemble(chunks, tree)
+}
+
+// chunk is what one turn of the top-level loop reads: any blank lines, then a
+// declaration, a comment or a line that is neither. Nothing carries over from
+// one chunk to the next but the position, which is what lets a Document
+// re-parse only the chunks an edit touches.
+type chunk struct {
+	span Span
+	line, col uint64
+	// *SpaceDecl, *AgentDecl, *PathDecl or nil
+	decl ParseUnit
+	// or a template, which is not a declaration until it is instantiated
+	template *Template
+	comments []Comment
+	errors []ParserErrorInfo
+}
+
+func parseChunk(tr *tokenReader, pi *ParserInfo) chunk {
+	ck := chunk{line: pi.line, col: pi.col}
+	ck.span.Start = tr.off
+	pi.errors, pi.comments = nil, nil
+
+	consumeBlankLines(tr, pi)
+	if tr.Len() > 0 {
+		switch tr.peekByte() {
 		case '@':
-			reader.UnreadRune()
-			spacey := parseSpaceDecl(reader, &pi)
-			if spacey != nil {
-				c.spaces = append(c.spaces, *spacey)
+			if afterName(tr) == TokenLBracket {
+				if tmpl, ok := parseTemplate(tr, pi); ok {
+					ck.template = &tmpl
+				} else {
+					syncToDecl(tr, pi, outerDeclStarts)
+				}
+			} else if spacey, ok := parseSpaceDecl(tr, pi, false); ok {
+				ck.decl = &spacey
+			} else {
+				syncToDecl(tr, pi, outerDeclStarts)
 			}
 		case '#':
-			reader.UnreadRune()
-			ref := parseAgentDecl(reader, &pi)
-			if ref != nil {
-				c.agents = append(c.agents, *ref)
+			if ref, ok := parseAgentDecl(tr, pi); ok {
+				ck.decl = &ref
+			} else {
+				syncToDecl(tr, pi, outerDeclStarts)
 			}
 		case '=':
-			reader.UnreadRune()
-			ref := parsePathDecl(reader, &pi)
-			if ref != nil {
-				c.paths = append(c.paths, *ref)
+			if ref, ok := parsePathDecl(tr, pi); ok {
+				ck.decl = &ref
+			} else {
+				syncToDecl(tr, pi, outerDeclStarts)
 			}
+		case '/':
+			parseComment(tr, pi)
 		default:
-			pi.addError(ExpectedOuterDecl)
-			pi.col++
+			ch, size := utf8.DecodeRuneInString(tr.src[tr.off:])
+			if ch == utf8.RuneError && size == 1 {
+				pi.addError(InvalidUTF8)
+			} else {
+				pi.addError(ExpectedOuterDecl)
+			}
+			tr.off += uint64(size)
+			pi.advance(ch)
+			syncToDecl(tr, pi, outerDeclStarts)
 		}
 	}
 
-	return c, pi.errors
+	ck.span.End = tr.off
+	ck.comments, ck.errors = pi.comments, pi.errors
+	pi.errors, pi.comments = nil, nil
+	return ck
 }
 
-func consumeBlankLines(reader *strings.Reader, pi *ParserInfo) {
-	for reader.Len() > 0 {
-		ch, size, _ := reader.ReadRune()
-		if size != 1 {
-			reader.UnreadRune()
-			return
+// assemble puts a contract together from its chunks, expanding the
+// instantiations of templates. The errors go through appendError again, so
+// that the limit and the dropping of knock-on errors work across chunks as
+// they do within one.
+func assemble(chunks []chunk, tree *SyntaxTree) (Contract, []ParserErrorInfo) {
+	c := Contract{tree: tree}
+	var pi ParserInfo
+	x := expander{tree: tree, templates: make(map[string]*Template), pi: &pi}
+	for i := range chunks {
+		if t := chunks[i].template; t != nil {
+			if _, ok := x.templates[t.body.ident]; !ok {
+				x.templates[t.body.ident] = t
+			}
 		}
-		switch ch {
+	}
+
+	n_spaces, n_agents, n_paths := 0, 0, 0
+	for _, ck := range chunks {
+		switch ck.decl.(type) {
+		case *SpaceDecl: n_spaces++
+		case *AgentDecl: n_agents++
+		case *PathDecl: n_paths++
+		}
+	}
+	// left nil if there are none, as appending nothing would
+	if n_spaces > 0 {
+		c.spaces = make([]SpaceDecl, 0, n_spaces)
+	}
+	if n_agents > 0 {
+		c.agents = make([]AgentDecl, 0, n_agents)
+	}
+	if n_paths > 0 {
+		c.paths = make([]PathDecl, 0, n_paths)
+	}
+
+	for _, ck := range chunks {
+		c.comments = append(c.comments, ck.comments...)
+		for _, errinf := range ck.errors {
+			pi.appendError(errinf)
+		}
+		if t := ck.template; t != nil {
+			c.templates = append(c.templates, *t)
+			if x.templates[t.body.ident] != t {
+				pi.appendError(ParserErrorInfo{err: DuplicateTemplate, line: t.line, col: t.col})
+			}
+		}
+		switch decl := ck.decl.(type) {
+		case *SpaceDecl: c.spaces = append(c.spaces, x.space(decl, nil))
+		case *AgentDecl: c.agents = append(c.agents, *decl)
+		case *PathDecl: c.paths = append(c.paths, *decl)
+		}
+	}
+	return c, pi.errors
+}
+
+func consumeBlankLines(tr *tokenReader, pi *ParserInfo) {
+	for tr.Len() > 0 {
+		switch tr.src[tr.off] {
 		case '\n':
 			pi.line++
 			pi.col = 0
 		case ' ', '\t':
 			pi.col++
 		default:
-			reader.UnreadRune()
 			return
 		}
+		tr.off++
 	}
 }
 
-func consumeLineRemainder(reader *strings.Reader, pi *ParserInfo) {
-	for reader.Len() > 0 {
-		ch, _, _ := reader.ReadRune()
-		if ch == '\n' {
-			pi.col = 0
-			pi.line++
-			return
-		}
+func consumeLineRemainder(tr *tokenReader, pi *ParserInfo) {
+	i := strings.IndexByte(tr.src[tr.off:], '\n')
+	if i < 0 {
+		tr.off = uint64(len(tr.src))
+		return
 	}
+	tr.off += uint64(i + 1)
+	pi.line++
+	pi.col = 0
 }
 
-func consumeSpaces(reader *strings.Reader, pi *ParserInfo) {
-	for reader.Len() > 0 {
-		ch, size, _ := reader.ReadRune()
-		if size != 1 {
-			reader.UnreadRune()
-			return
-		}
-		switch ch {
-		case ' ', '\t':
-			pi.col++
-		default:
-			reader.UnreadRune()
-			return
+// the characters a declaration line may start with, at the top level and
+// inside a @space; a comment counts, so that recovery does not swallow one
+const (
+	outerDeclStarts = "@#=/"
+	innerDeclStarts = "@#$=/"
+)
+
+// lineStart peeks at the first byte on the current line after any
+// indentation, without consuming anything; it is '\n' for a blank line
+func lineStart(tr *tokenReader) byte {
+	for i := tr.off; i < uint64(len(tr.src)); i++ {
+		if ch := tr.src[i]; ch != ' ' && ch != '\t' {
+			return ch
 		}
 	}
+	return '\n'
 }
 
-func tryParseRune(reader *strings.Reader, pi *ParserInfo, ch_goal ...rune) bool {
-	ch, size, _ := reader.ReadRune()
-	for i := 0; i < len(ch_goal); i++ {
-		if ch == ch_goal[i] {
-			pi.col += uint64(size)
-			return true
+// syncToDecl is panic-mode error recovery. Once a declaration has gone wrong,
+// the rest of its line and any lines after it up to the next one that starts
+// a declaration, or a blank line, are skipped. Their vibe lines go with them,
+// so one mistake gives one error rather than one per character.
+func syncToDecl(tr *tokenReader, pi *ParserInfo, starts string) {
+	// col is 0 if the broken declaration already ate its newline
+	if pi.col > 0 {
+		consumeLineRemainder(tr, pi)
+	}
+	for tr.Len() > 0 {
+		ch := lineStart(tr)
+		if ch == '\n' || strings.IndexByte(starts, ch) >= 0 {
+			return
 		}
+		consumeLineRemainder(tr, pi)
 	}
-	reader.UnreadRune()
-	return false
 }
 
-func identStart(ch rune) bool {
-	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch == '_'
+func consumeSpaces(tr *tokenReader, pi *ParserInfo) {
+	for tr.Len() > 0 {
+		if ch := tr.src[tr.off]; ch != ' ' && ch != '\t' {
+			return
+		}
+		tr.off++
+		pi.col++
+	}
 }
 
-func identPart(ch rune) bool {
-	return identStart(ch) || ch >= '0' && ch <= '9'
+// tryToken reads the next token if it is of the given kind, which must be one
+// of the single-character ones
+func tryToken(tr *tokenReader, pi *ParserInfo, kind TokenKind) bool {
+	if tr.peek() != kind {
+		return false
+	}
+	tr.take()
+	pi.col++
+	return true
 }
 
-func parseIdentifier(reader *strings.Reader, pi *ParserInfo) string {
-	var ident strings.Builder
-	ch, size, _ := reader.ReadRune()
-	if identStart(ch) {
-		ident.WriteRune(ch)
-		pi.col += uint64(size)
-	} else {
-		reader.UnreadRune()
-		return ident.String()
+func parseIdentifier(tr *tokenReader, pi *ParserInfo) string {
+	if tr.peek() != TokenIdent {
+		return ""
 	}
-
-	for reader.Len() > 0 {
-		ch, size, _ := reader.ReadRune()
-		if identPart(ch) {
-			ident.WriteRune(ch)
-			pi.col += uint64(size)
-		} else {
-			reader.UnreadRune()
-			return ident.String()
-		}
-	}
-	return ident.String()
+	ident := tr.take()
+	pi.advanceText(ident)
+	return pi.own(ident)
 }
 
-func parseTags(reader *strings.Reader, pi *ParserInfo) []locationTaggedString {
-	var tags []locationTaggedString
-
-	for reader.Len() > 0 {
-		consumeSpaces(reader, pi)
+// parseTags reads the tags after a declaration's name into pi.tags, which the
+// next declaration reuses
+func parseTags(tr *tokenReader, pi *ParserInfo) []locationTaggedString {
+	tags := pi.tags[:0]
+	defer func() { pi.tags = tags }()
 
+	for tr.Len() > 0 {
+		consumeSpaces(tr, pi)
 
-		if !tryParseRune(reader, pi, ':') {
+		if !tryToken(tr, pi, TokenColon) {
 			return tags
 		}
 
-		consumeSpaces(reader, pi)
+		consumeSpaces(tr, pi)
 
 		old_col := pi.col
-		ident := parseIdentifier(reader, pi)
+		start := tr.off
+		ident := parseIdentifier(tr, pi)
 		if ident == "" {
 			pi.addError(ExpectedIdentifier)
 			return tags
 		}
 		tags = append(tags, locationTaggedString{
-			val: strings.ToUpper(ident),
+			val: strings.ToUpper(pi.name(ident)),
 			line: pi.line,
 			col: old_col,
+			span: Span{start, tr.off},
 		})
 	}
 	return tags
 }
 
-func parseParams(reader *strings.Reader, pi *ParserInfo) []Param {
-	consumeSpaces(reader, pi)
+func parseParams(tr *tokenReader, pi *ParserInfo) []Param {
+	consumeSpaces(tr, pi)
 
-	var params []Param
-
-	// todo -- if we always return when we don't have an opening param we don't need this
-	has_open_paren := false
-
-	if tryParseRune(reader, pi, '(') {
-		has_open_paren = true
-		consumeSpaces(reader, pi)
-	} else {
-		return params
+	if !tryToken(tr, pi, TokenLParen) {
+		return nil
 	}
+	consumeSpaces(tr, pi)
 
 	last_param_in := true
-	for reader.Len() > 0 {
-		ch, size, _ := reader.ReadRune()
+	for tr.Len() > 0 {
+		ch := tr.peekByte()
 		if ch == '\n' || ch == ')' {
-			reader.UnreadRune()
 			break
 		}
 
 		var p Param
 		p.line = pi.line
 		p.col = pi.col
+		p.span.Start = tr.off
 		if ch != '%' {
-			reader.UnreadRune()
-			in_out := parseIdentifier(reader, pi)
+			in_out := parseIdentifier(tr, pi)
 			if in_out == "" {
 				pi.addError(ExpectedIdentifier)
-				return params
+				return pi.params.cut()
 			}
 
 			expected_in_out_error := false
@@ -226,10 +460,10 @@ func parseParams(reader *strings.Reader, pi *ParserInfo) []Param {
 				expected_in_out_error = true
 				p.in_param = last_param_in
 			}
-			consumeSpaces(reader, pi)
+			consumeSpaces(tr, pi)
 
-			if tryParseRune(reader, pi, '=') {
-				consumeSpaces(reader, pi)
+			if tryToken(tr, pi, TokenEquals) {
+				consumeSpaces(tr, pi)
 				if expected_in_out_error {
 					pi.addError(ExpectedInOut)
 				}
@@ -237,59 +471,83 @@ func parseParams(reader *strings.Reader, pi *ParserInfo) []Param {
 				if expected_in_out_error {
 					pi.addError(ExpectedDataName)
 					p.data_name = in_out
-					params = append(params, p)
+					p.span.End = trimmedOffset(tr, p.span.Start)
+					p.name_span = p.span
+					pi.params.add(p)
 					continue
 				} else {
 					pi.addError(ExpectedEquals)
 				}
 			}
 
-			if !tryParseRune(reader, pi, '%') {
+			if !tryToken(tr, pi, TokenPercent) {
 				pi.addError(ExpectedDataName)
 			}
 		} else {
 			p.in_param = last_param_in
-			pi.col += uint64(size)
+			tryToken(tr, pi, TokenPercent)
 		}
-		p.data_name = parseIdentifier(reader, pi)
+		p.name_span.Start = tr.off
+		p.data_name = pi.name(parseIdentifier(tr, pi))
 		if p.data_name == "" {
 			pi.addError(ExpectedIdentifier)
-			return params
+			return pi.params.cut()
 		}
+		p.name_span.End = tr.off
+		p.span.End = p.name_span.End
 
-		consumeSpaces(reader, pi)
+		consumeSpaces(tr, pi)
 
-		params = append(params, p)
+		pi.params.add(p)
 
-		if tryParseRune(reader, pi, ',', ';') {
-			consumeSpaces(reader, pi)
+		if tryToken(tr, pi, TokenComma) {
+			consumeSpaces(tr, pi)
 		}
 	}
 
-	if tryParseRune(reader, pi, ')') != has_open_paren {
+	if !tryToken(tr, pi, TokenRParen) {
 		pi.addError(MismatchedParens)
 	}
 
-	return params
+	return pi.params.cut()
 }
 
-func parseSpaceDecl(reader *strings.Reader, pi *ParserInfo) *SpaceDecl {
-	if !tryParseRune(reader, pi, '@') {
+// Spaces nest by indentation: an @space indented further than its parent's @
+// is declared inside it, and a declaration indented less than the space's own
+// @ belongs to an enclosing space. A blank line closes every open space.
+func parseSpaceDecl(tr *tokenReader, pi *ParserInfo, nested bool) (SpaceDecl, bool) {
+	switch afterName(tr) {
+	case TokenEquals:
+		return parseInstantiation(tr, pi)
+	case TokenLBracket:
+		if nested || pi.template == nil {
+			pi.addError(NestedTemplate)
+			return SpaceDecl{}, false
+		}
+	}
+
+	indent := pi.col
+	start := tr.off
+	if !tryToken(tr, pi, TokenAt) {
 		pi.addError(ExpectedSpaceDecl)
-		return nil
+		return SpaceDecl{}, false
 	}
 
 	var decl SpaceDecl
 	decl.line_start = pi.line
 
-	decl.ident = parseIdentifier(reader, pi)
+	decl.name_span.Start = tr.off
+	decl.ident = pi.name(parseIdentifier(tr, pi))
+	decl.name_span.End = tr.off
 	if decl.ident == "" {
 		pi.addError(ExpectedIdentifier)
-		consumeLineRemainder(reader, pi)
-		return nil
+		return SpaceDecl{}, false
+	}
+	if pi.template != nil && !nested {
+		pi.template.params = parseTemplateParams(tr, pi)
 	}
 
-	tags := parseTags(reader, pi)
+	tags := parseTags(tr, pi)
 	for i := 0; i < len(tags); i++ {
 		switch tags[i].val {
 		case "REPLICABLE":
@@ -328,70 +586,109 @@ func parseSpaceDecl(reader *strings.Reader, pi *ParserInfo) *SpaceDecl {
 				decl.space_type = CHAT
 			}
 		default:
-			pi.addErrorTagged(UnknownTag, tags[i])
+			// in a template, its type parameters stand for a type
+			if p := pi.template.param(tags[i].val); p != nil {
+				p.is_type = true
+			} else {
+				pi.addErrorTagged(UnknownTag, tags[i])
+			}
 		}
 	}
 
-	decl.params = parseParams(reader, pi)
+	decl.params = parseParams(tr, pi)
 
-	consumeLineRemainder(reader, pi)
+	consumeLineRemainder(tr, pi)
 
-	decl.vibe_desc = parseVibeBlock(reader, pi)
+	decl.vibe_desc = parseVibeBlock(tr, pi)
 
+	agents, tasks, spaces, paths := len(pi.agents.stack), len(pi.tasks.stack), len(pi.spaces.stack), len(pi.paths.stack)
 InnerDeclLoop:
-	for reader.Len() > 0 {
-		consumeSpaces(reader, pi)
+	for tr.Len() > 0 {
+		consumeSpaces(tr, pi)
+		if tr.Len() == 0 {
+			break InnerDeclLoop
+		}
 
-		ch, _, _ := reader.ReadRune()
-		reader.UnreadRune()
+		ch := tr.peekByte()
+		if ch != '\n' && nested && (pi.col < indent || (ch == '@' && pi.col == indent)) {
+			break InnerDeclLoop
+		}
 		switch ch {
+		case '\n': // a blank line closes the space scope
+			break InnerDeclLoop
+		case '/':
+			parseComment(tr, pi)
 		case '#':
-			ref := parseAgentDecl(reader, pi)
-			if ref != nil {
-				decl.agents = append(decl.agents, *ref)
+			if ref, ok := parseAgentDecl(tr, pi); ok {
+				pi.agents.push(ref)
+			} else {
+				syncToDecl(tr, pi, innerDeclStarts)
 			}
 		case '$':
-			ref := parseTaskDecl(reader, pi)
-			if ref != nil {
-				decl.tasks = append(decl.tasks, *ref)
+			if ref, ok := parseTaskDecl(tr, pi); ok {
+				pi.tasks.push(ref)
+			} else {
+				syncToDecl(tr, pi, innerDeclStarts)
 			}
 		case '@':
-			pi.addError(IllegalDeclarationInsideSpaceScope)
-			break InnerDeclLoop
+			if pi.col <= indent {
+				pi.addError(IllegalDeclarationInsideSpaceScope)
+				break InnerDeclLoop
+			}
+			if ref, ok := parseSpaceDecl(tr, pi, true); ok {
+				pi.spaces.push(ref)
+			} else {
+				syncToDecl(tr, pi, innerDeclStarts)
+			}
 		case '=':
-			pi.addError(IllegalDeclarationInsideSpaceScope)
-			break InnerDeclLoop
+			if ref, ok := parsePathDecl(tr, pi); ok {
+				pi.paths.push(ref)
+			} else {
+				syncToDecl(tr, pi, innerDeclStarts)
+			}
 		default:
+			// skip the bad line and keep going; the space and whatever it
+			// has declared so far are still good
 			pi.addError(ExpectedInnerDecl)
-			break InnerDeclLoop
+			ch, size := utf8.DecodeRuneInString(tr.src[tr.off:])
+			tr.off += uint64(size)
+			pi.advance(ch)
+			syncToDecl(tr, pi, innerDeclStarts)
 		}
 	}
 
+	decl.agents, decl.tasks = pi.agents.popFrom(agents), pi.tasks.popFrom(tasks)
+	decl.spaces, decl.paths = pi.spaces.popFrom(spaces), pi.paths.popFrom(paths)
 	decl.line_end = pi.line
-	return &decl
+	decl.span = Span{start, trimmedOffset(tr, start)}
+	decl.instance = pi.instance
+	return decl, true
 }
 
-func parseAgentDecl(reader *strings.Reader, pi *ParserInfo) *AgentDecl {
-	if !tryParseRune(reader, pi, '#') {
+func parseAgentDecl(tr *tokenReader, pi *ParserInfo) (AgentDecl, bool) {
+	start := tr.off
+	if !tryToken(tr, pi, TokenHash) {
 		pi.addError(ExpectedAgentDecl)
-		return nil
+		return AgentDecl{}, false
 	}
 
 	var agent AgentDecl
 	agent.line_start = pi.line
 
-	agent.ident = parseIdentifier(reader, pi)
+	agent.name_span.Start = tr.off
+	agent.ident = pi.name(parseIdentifier(tr, pi))
+	agent.name_span.End = tr.off
 	if agent.ident == "" {
 		pi.addError(ExpectedIdentifier)
-		return nil
+		return AgentDecl{}, false
 	}
 
-	consumeSpaces(reader, pi)
+	consumeSpaces(tr, pi)
 
-	tags := parseTags(reader, pi)
+	tags := parseTags(tr, pi)
 
 	for i := 0; i < len(tags); i++ {
-		switch strings.ToUpper(tags[i].val) {
+		switch tags[i].val {
 		case "DF":
 			if agent.agent_type != UnknownAgent {
 				pi.addErrorTagged(DuplicateTag, tags[i])
@@ -410,56 +707,67 @@ func parseAgentDecl(reader *strings.Reader, pi *ParserInfo) *AgentDecl {
 		pi.addError(MissingRequiredTag)
 	}
 
-	agent.params = parseParams(reader, pi)
+	agent.params = parseParams(tr, pi)
 
-	consumeLineRemainder(reader, pi)
+	consumeLineRemainder(tr, pi)
 
-	agent.vibe_desc = parseVibeBlock(reader, pi)
+	agent.vibe_desc = parseVibeBlock(tr, pi)
 
 	agent.line_end = pi.line
-	return &agent
+	agent.span = Span{start, trimmedOffset(tr, start)}
+	agent.instance = pi.instance
+	return agent, true
 }
 
-func parseTaskDecl(reader *strings.Reader, pi *ParserInfo) *TaskDecl {
-	if !tryParseRune(reader, pi, '$') {
+func parseTaskDecl(tr *tokenReader, pi *ParserInfo) (TaskDecl, bool) {
+	start := tr.off
+	if !tryToken(tr, pi, TokenDollar) {
 		pi.addError(ExpectedTaskDecl)
-		return nil
+		return TaskDecl{}, false
 	}
 
 	var task TaskDecl
 	task.line_start = pi.line
 
-	task.ident = parseIdentifier(reader, pi)
+	task.name_span.Start = tr.off
+	task.ident = pi.name(parseIdentifier(tr, pi))
+	task.name_span.End = tr.off
 	if task.ident == "" {
 		pi.addError(ExpectedIdentifier)
-		return nil
+		return TaskDecl{}, false
 	}
 
 	// todo check if identifier is reserved -- viz., $use
 
-	task.params = parseParams(reader, pi)
+	task.params = parseParams(tr, pi)
 
-	consumeLineRemainder(reader, pi)
+	consumeLineRemainder(tr, pi)
 
-	task.vibe_desc = parseVibeBlock(reader, pi)
+	task.vibe_desc = parseVibeBlock(tr, pi)
 
 	task.line_end = pi.line
-	return &task
+	task.span = Span{start, trimmedOffset(tr, start)}
+	task.instance = pi.instance
+	return task, true
 }
 
-func parseSpaceParams(reader *strings.Reader, pi *ParserInfo) []locationTaggedString {
-	consumeSpaces(reader, pi)
+func parseSpaceParams(tr *tokenReader, pi *ParserInfo) []locationTaggedString {
+	consumeSpaces(tr, pi)
 
 	var spaces []locationTaggedString
 
-	if !tryParseRune(reader, pi, '(') {
+	if !tryToken(tr, pi, TokenLParen) {
 		return spaces
 	}
 
-	for reader.Len() > 0 {
-		consumeSpaces(reader, pi)
+	for tr.Len() > 0 {
+		consumeSpaces(tr, pi)
+
+		if tr.peekByte() == ')' {
+			break
+		}
 
-		if !tryParseRune(reader, pi, '@') {
+		if !tryToken(tr, pi, TokenAt) {
 			pi.addError(ExpectedSpaceName)
 			break
 		}
@@ -468,46 +776,51 @@ func parseSpaceParams(reader *strings.Reader, pi *ParserInfo) []locationTaggedSt
 			line: pi.line,
 			col: pi.col,
 		}
-		next_space.val = parseIdentifier(reader, pi)
+		next_space.span.Start = tr.off
+		next_space.val = pi.name(parseIdentifier(tr, pi))
+		next_space.span.End = tr.off
 
 		if next_space.val == "" {
 			pi.addError(ExpectedSpaceName)
 		} else {
 			spaces = append(spaces, next_space)
-			consumeSpaces(reader, pi)
+			consumeSpaces(tr, pi)
 		}
 
-		if tryParseRune(reader, pi, ',', ';') {
-			consumeSpaces(reader, pi)
+		if tryToken(tr, pi, TokenComma) {
+			consumeSpaces(tr, pi)
 		}
 	}
 
-	if !tryParseRune(reader, pi, ')') {
+	if !tryToken(tr, pi, TokenRParen) {
 		pi.addError(MismatchedParens)
 	}
 
 	return spaces
 }
 
-func parsePathDecl(reader *strings.Reader, pi *ParserInfo) *PathDecl {
-	if !tryParseRune(reader, pi, '=') {
+func parsePathDecl(tr *tokenReader, pi *ParserInfo) (PathDecl, bool) {
+	start := tr.off
+	if !tryToken(tr, pi, TokenEquals) {
 		pi.addError(ExpectedPathDecl)
-		return nil
+		return PathDecl{}, false
 	}
 
 	var path PathDecl
 	path.line_start = pi.line
 
-	path.ident = parseIdentifier(reader, pi)
+	path.name_span.Start = tr.off
+	path.ident = pi.name(parseIdentifier(tr, pi))
+	path.name_span.End = tr.off
 	if path.ident == "" {
 		pi.addError(ExpectedIdentifier)
-		return nil
+		return PathDecl{}, false
 	}
 
-	tags := parseTags(reader, pi)
+	tags := parseTags(tr, pi)
 
 	for i := 0; i < len(tags); i++ {
-		switch strings.ToUpper(tags[i].val) {
+		switch tags[i].val {
 		case "INVOKE":
 			if path.path_type != UnknownPath {
 				pi.addErrorTagged(DuplicateTag, tags[i])
@@ -518,6 +831,15 @@ func parsePathDecl(reader *strings.Reader, pi *ParserInfo) *PathDecl {
 				pi.addErrorTagged(DuplicateTag, tags[i])
 			}
 			path.path_type = ATTEND
+		case "READ", "WRITE", "CONTROL":
+			if path.access != AnyAccess {
+				pi.addErrorTagged(DuplicateTag, tags[i])
+			}
+			switch tags[i].val {
+			case "READ": path.access = READ
+			case "WRITE": path.access = WRITE
+			case "CONTROL": path.access = CONTROL
+			}
 		default:
 			pi.addErrorTagged(UnknownTag, tags[i])
 		}
@@ -526,11 +848,11 @@ func parsePathDecl(reader *strings.Reader, pi *ParserInfo) *PathDecl {
 		pi.addError(MissingRequiredTag)
 	}
 
-	path_spaces := parseSpaceParams(reader, pi)
+	path_spaces := parseSpaceParams(tr, pi)
 
 	if len(path_spaces) != 2 {
 		pi.addError(IncorrectNumberPathSpaces)
-		return nil
+		return PathDecl{}, false
 	}
 
 	path.space_source = Ident{
@@ -541,154 +863,271 @@ func parsePathDecl(reader *strings.Reader, pi *ParserInfo) *PathDecl {
 		t: SPACE,
 		n: path_spaces[1].val,
 	}
+	path.source_span = path_spaces[0].span
+	path.dest_span = path_spaces[1].span
 
-	consumeLineRemainder(reader, pi)
+	consumeLineRemainder(tr, pi)
 
-	path.vibe_desc = parseVibeBlock(reader, pi)
+	path.vibe_desc = parseVibeBlock(tr, pi)
 
 	path.line_end = pi.line
-	return &path
+	path.span = Span{start, trimmedOffset(tr, start)}
+	path.instance = pi.instance
+	return path, true
+}
+
+// comments run to the end of the line; they may appear wherever a declaration may
+func parseComment(tr *tokenReader, pi *ParserInfo) {
+	line := pi.line
+	for i := 0; i < 2; i++ {
+		if tr.peekByte() != '/' {
+			pi.addError(ExpectedComment)
+			consumeLineRemainder(tr, pi)
+			return
+		}
+		tr.off++
+		pi.col++
+	}
+
+	text := tr.src[tr.off:]
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+		tr.off += uint64(i + 1)
+		pi.line++
+		pi.col = 0
+	} else {
+		tr.off = uint64(len(tr.src))
+	}
+	pi.comments = append(pi.comments, Comment{
+		text: pi.own(strings.TrimSpace(validText(text))),
+		line: line,
+	})
+}
+
+// validText replaces each byte of s that is not UTF-8 with U+FFFD, as reading
+// s a rune at a time does
+func validText(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	var b strings.Builder
+	for _, ch := range s {
+		b.WriteRune(ch)
+	}
+	return b.String()
 }
 
-func parseVibeBlock(reader *strings.Reader, pi *ParserInfo) VibeBlock {
+func parseVibeBlock(tr *tokenReader, pi *ParserInfo) VibeBlock {
 	var vb VibeBlock
 	vb.line_start = pi.line
+	vb.span = Span{tr.off, tr.off}
 BlockLoop:
-	for reader.Len() > 0 {
-		consumeSpaces(reader, pi)
+	for tr.Len() > 0 {
+		consumeSpaces(tr, pi)
 
-		if !tryParseRune(reader, pi, '>') {
+		if !tryToken(tr, pi, TokenVibe) {
 			break BlockLoop
 		}
+		if vb.span.Len() == 0 {
+			vb.span.Start = tr.off - 1
+		}
+		vb.span.End = tr.off
 
-		consumeSpaces(reader, pi)
+		consumeSpaces(tr, pi)
 
-		if tryParseRune(reader, pi, '\n') {
-			pi.line++
-			pi.col = 0
+		// an empty line is no prose, whether it ends the file or not
+		if atLineEnd(tr) {
+			if tr.Len() > 0 {
+				tr.off++
+				pi.line++
+				pi.col = 0
+			}
 			continue BlockLoop
 		}
 
-		var vl strings.Builder
+		pi.vibe_lines.add(pi.line)
+		prose_start := tr.off
+		pi.prose = pi.prose[:0]
 	LineLoop:
-		for reader.Len() > 0 {
-			ch, size, _ := reader.ReadRune()
-			pi.col += uint64(size)
-			switch ch {
+		for tr.Len() > 0 {
+			switch tr.peekByte() {
 			case ' ', '\t': // normalize any amount of whitespace into a single space
-				consumeSpaces(reader, pi)
-				ch2, _, _ := reader.ReadRune()
-				if ch2 != '\n' { // trims trailing whitespaces
-					vl.WriteRune(' ')
+				consumeSpaces(tr, pi)
+				if !atLineEnd(tr) { // trims trailing whitespaces
+					pi.prose = append(pi.prose, ' ')
 				}
-				reader.UnreadRune()
+				continue LineLoop
 			case '\n':
+				tr.off++
 				pi.line++
 				pi.col = 0
 				break LineLoop
-			case '%':
-				ref := parseMetaRefData(reader, pi)
+			case '\r':
+				tr.off++
+				pi.col++
+				pi.prose = append(pi.prose, '\r')
+				continue LineLoop
+			}
+
+			switch tr.peek() {
+			case TokenPercent:
+				tryToken(tr, pi, TokenPercent)
+				ref := parseMetaRefData(tr, pi)
 				if ref != nil {
-					vl.WriteString(ref.ToStr())
-					vb.meta_refs = append(vb.meta_refs, ref)
+					pi.prose = ref.appendStr(pi.prose)
+					pi.meta_refs.add(ref)
 				} else {
-					vl.WriteRune(ch)
+					pi.prose = append(pi.prose, '%')
 				}
-			case '$':
-				ref := parseMetaRefTask(reader, pi)
+			case TokenDollar:
+				tryToken(tr, pi, TokenDollar)
+				ref := parseMetaRefTask(tr, pi)
 				if ref != nil {
-					vl.WriteString(ref.ToStr())
-					vb.meta_refs = append(vb.meta_refs, ref)
+					pi.prose = ref.appendStr(pi.prose)
+					pi.meta_refs.add(ref)
 				} else {
-					vl.WriteRune(ch)
+					pi.prose = append(pi.prose, '$')
 				}
 
 				// because we can consume spaces while parsing the task params in a non-hygenic way,
 				// we make sure there is a space afterwards before remainder of vibe line
-				consumeSpaces(reader, pi)
-				ch2, _, _ := reader.ReadRune()
-				reader.UnreadRune()
-				if ch2 != '\n' { // trims trailing whitespaces
-					vl.WriteRune(' ')
+				consumeSpaces(tr, pi)
+				if !atLineEnd(tr) { // trims trailing whitespaces
+					pi.prose = append(pi.prose, ' ')
 				}
-			case '=':
-				ref := parseMetaRefData(reader, pi)
+			case TokenEquals:
+				tryToken(tr, pi, TokenEquals)
+				ref := parseMetaRefPath(tr, pi)
 				if ref != nil {
-					vl.WriteString(ref.ToStr())
-					vb.meta_refs = append(vb.meta_refs, ref)
+					pi.prose = ref.appendStr(pi.prose)
+					pi.meta_refs.add(ref)
 				} else {
-					vl.WriteRune(ch)
+					pi.prose = append(pi.prose, '=')
+				}
+			case TokenAt:
+				tryToken(tr, pi, TokenAt)
+				ref := parseMetaRefQualified(tr, pi)
+				if ref == nil {
+					pi.prose = append(pi.prose, '@')
+					break
+				}
+				pi.prose = ref.appendStr(pi.prose)
+				pi.meta_refs.add(ref)
+
+				// see TokenDollar above
+				consumeSpaces(tr, pi)
+				if !atLineEnd(tr) {
+					pi.prose = append(pi.prose, ' ')
 				}
 			default:
-				vl.WriteRune(ch)
+				appendProse(tr.take(), pi)
 			}
 		}
 
-		vb.vibe_prose = append(vb.vibe_prose, vl.String())
+		end := trimmedOffset(tr, prose_start)
+		prose := tr.src[prose_start:end]
+		if string(pi.prose) == prose {
+			prose = pi.own(prose)
+		} else {
+			prose = string(pi.prose)
+		}
+		pi.vibe_prose.add(prose)
+		pi.prose_spans.add(Span{prose_start, end})
+		vb.span.End = end
 	}
+	vb.vibe_lines = pi.vibe_lines.cut()
+	vb.vibe_prose = pi.vibe_prose.cut()
+	vb.prose_spans = pi.prose_spans.cut()
+	vb.meta_refs = pi.meta_refs.cut()
 	vb.line_end = pi.line
 	return vb
 }
 
-func parseMetaRefData(reader *strings.Reader, pi *ParserInfo) *MetaRefData {
-	tryParseRune(reader, pi, '%')
+// appendProse adds a token's text to the vibe line being read, with each byte
+// that is not UTF-8 an error and a U+FFFD
+func appendProse(text string, pi *ParserInfo) {
+	for i := 0; i < len(text); {
+		if text[i] < utf8.RuneSelf {
+			pi.prose = append(pi.prose, text[i])
+			pi.col++
+			i++
+			continue
+		}
+		ch, size := utf8.DecodeRuneInString(text[i:])
+		if ch == utf8.RuneError && size == 1 {
+			pi.addError(InvalidUTF8)
+		}
+		pi.prose = utf8.AppendRune(pi.prose, ch)
+		pi.advance(ch)
+		i += size
+	}
+}
+
+func parseMetaRefData(tr *tokenReader, pi *ParserInfo) *MetaRefData {
+	tryToken(tr, pi, TokenPercent)
 
 	var mrd MetaRefData
 	mrd.line = pi.line
 	mrd.col = pi.col
 
-	mrd.ident = parseIdentifier(reader, pi)
+	mrd.name_span.Start = tr.off
+	mrd.ident = pi.name(parseIdentifier(tr, pi))
 	if mrd.ident == "" {
 		return nil
 	}
-	return &mrd
+	mrd.name_span.End = tr.off
+	mrd.span = Span{mrd.name_span.Start - 1, mrd.name_span.End}
+	ref := pi.data_refs.alloc()
+	*ref = mrd
+	return ref
 }
 
-func parseMetaRefTask(reader *strings.Reader, pi *ParserInfo) MetaRef {
-	tryParseRune(reader, pi, '$')
+func parseMetaRefTask(tr *tokenReader, pi *ParserInfo) MetaRef {
+	tryToken(tr, pi, TokenDollar)
 
 	// todo this gives us line & col in the source file -- do we want line / col in the vibe block ?
 	col := pi.col
 	line := pi.line
 
-	ident := parseIdentifier(reader, pi)
+	name_start := tr.off
+	ident := parseIdentifier(tr, pi)
 	if ident == "" {
 		return nil
 	}
+	name_span := Span{name_start, tr.off}
 	if ident == "use" {
-		consumeSpaces(reader, pi)
+		consumeSpaces(tr, pi)
 
-		if !tryParseRune(reader, pi, '(') {
+		if !tryToken(tr, pi, TokenLParen) {
 			pi.addError(UseMissingImport)
 			// todo? do we want a way to ROLL BACK if we don't have a valid "use" defn. ?
 			return nil
 		}
 
-		consumeSpaces(reader, pi)
+		consumeSpaces(tr, pi)
 		mru := MetaRefUseImport{
 			line: line,
 			col: col,
 		}
-		ch, size, _ := reader.ReadRune()
-		switch ch {
-		case '@':
+		switch {
+		case tryToken(tr, pi, TokenAt):
 			mru.import_type = UseImportSpace
-		case '#':
+		case tryToken(tr, pi, TokenHash):
 			mru.import_type = UseImportAgent
 		default:
-			reader.UnreadRune()
 			// todo maybe consume until end parenthesis ... ?
 			// or roll back... :/
 			pi.addError(UseUnsupportedImport)
 			return nil
 		}
-		pi.col += uint64(size)
 
-		mru.imported = parseIdentifier(reader, pi)
+		mru.name_span.Start = tr.off
+		mru.imported = pi.name(parseIdentifier(tr, pi))
+		mru.name_span.End = tr.off
 
-		consumeSpaces(reader, pi)
+		consumeSpaces(tr, pi)
 
-		if !tryParseRune(reader, pi, ')') {
+		if !tryToken(tr, pi, TokenRParen) {
 			pi.addError(MismatchedParens)
 		}
 
@@ -697,29 +1136,76 @@ func parseMetaRefTask(reader *strings.Reader, pi *ParserInfo) MetaRef {
 			return nil
 		}
 
+		mru.span = Span{name_start - 1, tr.off}
 		return &mru
 	} else {
-		consumeSpaces(reader, pi)
-		mrt := MetaRefTask{
-			ident: ident,
+		consumeSpaces(tr, pi)
+		mrt := pi.task_refs.alloc()
+		*mrt = MetaRefTask{
+			ident: pi.name(ident),
 			line: line,
 			col: col,
-			args: parseParams(reader, pi),
+			args: parseParams(tr, pi),
+			name_span: name_span,
 		}
-		return &mrt
+		mrt.span = Span{name_start - 1, trimmedOffset(tr, name_start)}
+		return mrt
+	}
+}
+
+// parseMetaRefQualified reads a task reference qualified by the space that
+// declares it, "@space.$task(...)", just after the '@'. Anything else is left
+// unread, as prose.
+func parseMetaRefQualified(tr *tokenReader, pi *ParserInfo) *MetaRefTask {
+	start := *tr
+	line, col := pi.line, pi.col
+	rollback := func() *MetaRefTask {
+		*tr = start
+		pi.col = col
+		return nil
+	}
+
+	space := parseIdentifier(tr, pi)
+	space_span := Span{start.off, tr.off}
+	if space == "" || !tryToken(tr, pi, TokenDot) || !tryToken(tr, pi, TokenDollar) {
+		return rollback()
+	}
+	name_start := tr.off
+	ident := parseIdentifier(tr, pi)
+	if ident == "" || ident == "use" {
+		return rollback()
+	}
+	name_span := Span{name_start, tr.off}
+	consumeSpaces(tr, pi)
+	mrt := pi.task_refs.alloc()
+	*mrt = MetaRefTask{
+		space: pi.name(space),
+		ident: pi.name(ident),
+		line: line,
+		col: col,
+		args: parseParams(tr, pi),
+		name_span: name_span,
+		space_span: space_span,
 	}
+	mrt.span = Span{start.off - 1, trimmedOffset(tr, start.off)}
+	return mrt
 }
 
-func parseMetaRefPath(reader *strings.Reader, pi *ParserInfo) *MetaRefPath {
-	tryParseRune(reader, pi, '=')
+func parseMetaRefPath(tr *tokenReader, pi *ParserInfo) *MetaRefPath {
+	tryToken(tr, pi, TokenEquals)
 
 	var mrp MetaRefPath
 	mrp.line = pi.line
 	mrp.col = pi.col
 
-	mrp.ident = parseIdentifier(reader, pi)
+	mrp.name_span.Start = tr.off
+	mrp.ident = pi.name(parseIdentifier(tr, pi))
 	if mrp.ident == "" {
 		return nil
 	}
-	return &mrp
+	mrp.name_span.End = tr.off
+	mrp.span = Span{mrp.name_span.Start - 1, mrp.name_span.End}
+	ref := pi.path_refs.alloc()
+	*ref = mrp
+	return ref
 }
@@ -0,0 +1,251 @@
+package parse
+
+import (
+	"sort"
+)
+
+// Every $use(@space) inside another space's scope (its vibe block, or those of
+// its own agents and tasks) is an import site: the importer gets its own
+// instance of the imported space. A space that nobody imports has one top-level
+// instance. $use(@space) in a top-level #agent only says where the agent works
+// and makes no instance.
+//
+// A =path names spaces, not instances, so each one is routed per source
+// instance. For a path from @a to @b, an instance of @a sends to the first of:
+//
+//  1. the top-level @b, if nothing imports @b
+//  2. the @b that this @a imports
+//  3. the @b imported by the same space that imported this @a (a sibling)
+//  4. the only imported @b
+//
+// and otherwise the route is ambiguous. An instance of @b that no route reaches,
+// while other instances of @b do get traffic, is unreachable.
+
+// SpaceInstance is one instance of a space; Site is the importing space, or the
+// zero Ident for the top-level instance.
+type SpaceInstance struct {
+	Space Ident
+	Site Ident
+}
+
+func (si SpaceInstance) IsTopLevel() bool {
+	return si.Site.n == ""
+}
+
+func (si SpaceInstance) ToStr() string {
+	if si.IsTopLevel() {
+		return si.Space.toString()
+	}
+	return si.Space.toString() + " in " + si.Site.toString()
+}
+
+type Route struct {
+	Path Ident
+	From SpaceInstance
+	To SpaceInstance
+}
+
+type RouteProblemKind byte
+const (
+	RouteAmbiguous RouteProblemKind = iota
+	RouteUnreachable
+)
+
+type RouteProblem struct {
+	Kind RouteProblemKind
+	Path Ident
+	// the sender for RouteAmbiguous, the instance never reached for RouteUnreachable
+	Instance SpaceInstance
+	// the instances an ambiguous route could go to
+	Candidates []SpaceInstance
+	Line uint64
+	// of the path, if it was expanded from a template
+	Instantiation *Instantiation
+}
+
+type RoutingTable struct {
+	instances []SpaceInstance
+	routes []Route
+	problems []RouteProblem
+}
+
+func (rt *RoutingTable) GetInstances() []SpaceInstance {
+	return rt.instances
+}
+
+func (rt *RoutingTable) GetRoutes() []Route {
+	return rt.routes
+}
+
+func (rt *RoutingTable) GetProblems() []RouteProblem {
+	return rt.problems
+}
+
+// Lookup finds where a path sent from an instance goes
+func (rt *RoutingTable) Lookup(path Ident, from SpaceInstance) (SpaceInstance, bool) {
+	for _, r := range rt.routes {
+		if r.Path == path && r.From == from {
+			return r.To, true
+		}
+	}
+	return SpaceInstance{}, false
+}
+
+func identLess(a, b Ident) bool {
+	if a.t != b.t {
+		return a.t < b.t
+	}
+	return a.n < b.n
+}
+
+// importSites maps each space to the spaces that import it, in name order
+func importSites(po *ParseOrder) map[Ident][]Ident {
+	found := make(map[Ident]map[Ident]bool)
+	addUses := func(site Ident, scope *Scope, vb *VibeBlock) {
+		for _, mr := range vb.meta_refs {
+			use, ok := mr.(*MetaRefUseImport)
+			if !ok || use.import_type != UseImportSpace {
+				continue
+			}
+			imported := use.GetImported()
+			// a space using itself refers to itself
+			if imported == site {
+				continue
+			}
+			if _, declared := scope.lookup(imported); !declared {
+				continue
+			}
+			if found[imported] == nil {
+				found[imported] = make(map[Ident]bool)
+			}
+			found[imported][site] = true
+		}
+	}
+
+	for _, n := range po.nodes_underlying {
+		s, ok := n.ast_node.(*SpaceDecl)
+		if !ok {
+			continue
+		}
+		// n.scope is the space's own, which its agents and tasks share
+		addUses(s.GetName(), n.scope, &s.vibe_desc)
+		for i := range s.agents {
+			addUses(s.GetName(), n.scope, &s.agents[i].vibe_desc)
+		}
+		for i := range s.tasks {
+			addUses(s.GetName(), n.scope, &s.tasks[i].vibe_desc)
+		}
+	}
+
+	sites := make(map[Ident][]Ident)
+	for imported, set := range found {
+		for site := range set {
+			sites[imported] = append(sites[imported], site)
+		}
+		sort.Slice(sites[imported], func(i, j int) bool { return identLess(sites[imported][i], sites[imported][j]) })
+	}
+	return sites
+}
+
+func instancesOf(space Ident, sites map[Ident][]Ident) []SpaceInstance {
+	if len(sites[space]) == 0 {
+		return []SpaceInstance{{Space: space}}
+	}
+	insts := make([]SpaceInstance, len(sites[space]))
+	for i, site := range sites[space] {
+		insts[i] = SpaceInstance{Space: space, Site: site}
+	}
+	return insts
+}
+
+func imports(sites map[Ident][]Ident, importer Ident, imported Ident) bool {
+	for _, s := range sites[imported] {
+		if s == importer {
+			return true
+		}
+	}
+	return false
+}
+
+func GetRoutingTable(po *ParseOrder) RoutingTable {
+	var rt RoutingTable
+	sites := importSites(po)
+
+	var spaces []Ident
+	var paths []*PathDecl
+	for _, n := range po.nodes_underlying {
+		switch d := n.ast_node.(type) {
+		case *SpaceDecl: spaces = append(spaces, d.GetName())
+		case *PathDecl:
+			_, src_ok := n.scope.lookup(d.space_source)
+			_, dst_ok := n.scope.lookup(d.space_dest)
+			// undeclared endpoints are reported by GetParseOrder
+			if src_ok && dst_ok {
+				paths = append(paths, d)
+			}
+		}
+	}
+	for _, s := range spaces {
+		rt.instances = append(rt.instances, instancesOf(s, sites)...)
+	}
+
+	reached := make(map[SpaceInstance]bool)
+	for _, p := range paths {
+		dests := instancesOf(p.space_dest, sites)
+		for _, from := range instancesOf(p.space_source, sites) {
+			var to SpaceInstance
+			switch {
+			case dests[0].IsTopLevel():
+				to = dests[0]
+			case imports(sites, from.Space, p.space_dest):
+				to = SpaceInstance{Space: p.space_dest, Site: from.Space}
+			case !from.IsTopLevel() && imports(sites, from.Site, p.space_dest):
+				to = SpaceInstance{Space: p.space_dest, Site: from.Site}
+			case len(dests) == 1:
+				to = dests[0]
+			default:
+				rt.problems = append(rt.problems, RouteProblem{
+					Kind: RouteAmbiguous,
+					Path: p.GetName(),
+					Instance: from,
+					Candidates: dests,
+					Line: p.line_start,
+					Instantiation: p.instance,
+				})
+				continue
+			}
+			rt.routes = append(rt.routes, Route{
+				Path: p.GetName(),
+				From: from,
+				To: to,
+			})
+			reached[to] = true
+		}
+	}
+
+	for _, p := range paths {
+		dests := instancesOf(p.space_dest, sites)
+		any_reached := false
+		for _, d := range dests {
+			any_reached = any_reached || reached[d]
+		}
+		if !any_reached {
+			continue
+		}
+		for _, d := range dests {
+			if reached[d] {
+				continue
+			}
+			rt.problems = append(rt.problems, RouteProblem{
+				Kind: RouteUnreachable,
+				Path: p.GetName(),
+				Instance: d,
+				Line: p.line_start,
+				Instantiation: p.instance,
+			})
+			// report each instance once, against the first path into its space
+			reached[d] = true
+		}
+	}
+	return rt
+}
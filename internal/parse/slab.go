@@ -0,0 +1,70 @@
+package parse
+
+// A slab hands out the many small slices and nodes an AST is made of from a
+// few larger arrays, so that a declaration costs a handful of allocations per
+// hundred rather than several of its own. A slice is built up at the end of
+// the current array and cut off when it is done; an array that fills up is
+// left to the slices already cut from it, and a new one, twice the size, is
+// started. The first is small, since a Document's edits mostly re-parse a
+// declaration or two.
+type slab[T any] struct {
+	buf []T
+	// where the slice being built starts
+	mark int
+}
+
+const (
+	minSlab = 8
+	maxSlab = 512
+)
+
+func (s *slab[T]) add(v T) {
+	if len(s.buf) == cap(s.buf) {
+		building := s.buf[s.mark:]
+		size := min(max(2 * cap(s.buf), minSlab), maxSlab)
+		buf := make([]T, len(building), max(size, 2 * len(building)))
+		copy(buf, building)
+		s.buf, s.mark = buf, 0
+	}
+	s.buf = append(s.buf, v)
+}
+
+// cut ends the slice being built, nil if nothing was added to it
+func (s *slab[T]) cut() []T {
+	if s.mark == len(s.buf) {
+		return nil
+	}
+	out := s.buf[s.mark:len(s.buf):len(s.buf)]
+	s.mark = len(s.buf)
+	return out
+}
+
+// alloc is new(T) from the slab
+func (s *slab[T]) alloc() *T {
+	var zero T
+	s.add(zero)
+	s.mark = len(s.buf)
+	return &s.buf[len(s.buf) - 1]
+}
+
+// A declStack gathers the declarations of one kind inside a space while it is
+// parsed, those of the spaces inside it going on top, and cuts them from a
+// slab together once the space is done.
+type declStack[T any] struct {
+	stack []T
+	slab slab[T]
+}
+
+func (ds *declStack[T]) push(v T) {
+	ds.stack = append(ds.stack, v)
+}
+
+// popFrom takes everything pushed since the stack was mark long, nil if nothing
+func (ds *declStack[T]) popFrom(mark int) []T {
+	for _, v := range ds.stack[mark:] {
+		ds.slab.add(v)
+	}
+	clear(ds.stack[mark:])
+	ds.stack = ds.stack[:mark]
+	return ds.slab.cut()
+}
@@ -0,0 +1,381 @@
+package parse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Templates are @spaces declared with parameters in brackets after the name,
+//
+//	@store[K, item]:K(in=%item, out=%item)
+//	> keeps every %item it is given
+//	$get(out=%item)
+//	> finds an %item
+//
+// which are not part of the contract themselves but are instantiated in it,
+// at the top level or inside a space, under names of their own:
+//
+//	@users = @store[DATA, user]
+//
+// An instantiation is replaced by the template's declaration, parsed again with
+// the template's name replaced by the instance's and each parameter by its
+// argument, wherever a name or a tag is written. A parameter written as the
+// type of a space, as K is, takes UI, IO, DATA, CALL or CHAT; the others take
+// names. This happens as the contract is put together, before GetParseOrder
+// sees it, so everything after the parser sees ordinary declarations. They keep
+// the template's lines and spans, that being where their text is, but for the
+// instance's own name, and point back at the Instantiation, so that a problem
+// with one can be reported at both.
+
+type Template struct {
+	params []TemplateParam
+	// as written, with the parameters in place
+	body SpaceDecl
+	line, col uint64
+	// parsing it as written found these; its instances would only repeat them
+	errors []ParserErrorInfo
+}
+
+type TemplateParam struct {
+	ident string
+	// written as the type of a space, ":K"
+	is_type bool
+	line, col uint64
+	span Span
+}
+
+type Instantiation struct {
+	ident string
+	template string
+	args []locationTaggedString
+	line, col uint64
+	span, name_span, template_span Span
+
+	// set once the template is found
+	expanded bool
+	decl_line, decl_col uint64
+}
+
+func (t *Template) GetName() Ident {
+	return Ident{t: SPACE, n: t.body.ident}
+}
+
+func (t *Template) GetParams() []TemplateParam {
+	return t.params
+}
+
+// GetBody is the declaration as written, parameters and all
+func (t *Template) GetBody() *SpaceDecl {
+	return &t.body
+}
+
+func (t *Template) GetPos() (uint64, uint64) {
+	return t.line, t.col
+}
+
+// param finds the parameter a tag names, nil if there is none
+func (t *Template) param(tag string) *TemplateParam {
+	if t == nil {
+		return nil
+	}
+	for i := range t.params {
+		if strings.ToUpper(t.params[i].ident) == tag {
+			return &t.params[i]
+		}
+	}
+	return nil
+}
+
+func (p *TemplateParam) GetIdent() string {
+	return p.ident
+}
+
+func (p *TemplateParam) IsType() bool {
+	return p.is_type
+}
+
+func (p *TemplateParam) GetPos() (uint64, uint64) {
+	return p.line, p.col
+}
+
+func (p *TemplateParam) GetSpan() Span {
+	return p.span
+}
+
+func (inst *Instantiation) GetName() Ident {
+	return Ident{t: SPACE, n: inst.ident}
+}
+
+func (inst *Instantiation) GetTemplate() Ident {
+	return Ident{t: SPACE, n: inst.template}
+}
+
+func (inst *Instantiation) GetArgs() []string {
+	args := make([]string, len(inst.args))
+	for i, arg := range inst.args {
+		args[i] = arg.val
+	}
+	return args
+}
+
+func (inst *Instantiation) GetPos() (uint64, uint64) {
+	return inst.line, inst.col
+}
+
+func (inst *Instantiation) GetSpan() Span {
+	return inst.span
+}
+
+func (inst *Instantiation) GetNameSpan() Span {
+	return inst.name_span
+}
+
+// GetTemplateSpan covers the name of the template where it is instantiated
+func (inst *Instantiation) GetTemplateSpan() Span {
+	return inst.template_span
+}
+
+// GetTemplatePos is where the template is declared; ok is false if there is no
+// such template
+func (inst *Instantiation) GetTemplatePos() (uint64, uint64, bool) {
+	return inst.decl_line, inst.decl_col, inst.expanded
+}
+
+func (inst *Instantiation) ToStr() string {
+	return fmt.Sprintf("@%s = @%s[%s]", inst.ident, inst.template, strings.Join(inst.GetArgs(), ", "))
+}
+
+// Note says where a problem found in a declaration expanded from inst comes
+// from, for a line under the problem itself
+func (inst *Instantiation) Note() string {
+	note := fmt.Sprintf("in %s at (%d, %d)", inst.ToStr(), inst.line, inst.col)
+	if inst.expanded {
+		note += fmt.Sprintf(", @%s declared at (%d, %d)", inst.template, inst.decl_line, inst.decl_col)
+	}
+	return note
+}
+
+// name is an identifier as it is declared: in a template being expanded, the
+// parameters are replaced by the instance's arguments and the template's name
+// by the instance's
+func (pi *ParserInfo) name(ident string) string {
+	if to, ok := pi.subst[ident]; ok {
+		return to
+	}
+	return ident
+}
+
+// afterName is the kind of the token after "@name" on the same line, which
+// tells a template, "@name[", and an instantiation, "@name =", from a space
+func afterName(tr *tokenReader) TokenKind {
+	tr.token()
+	i := tr.next
+	if i + 2 >= len(tr.tokens) || tr.tokens[i].Span.Start != tr.off || tr.tokens[i + 1].Kind != TokenIdent {
+		return TokenEOF
+	}
+	if tr.tokens[i + 2].Line != tr.tokens[i].Line {
+		return TokenEOF
+	}
+	return tr.tokens[i + 2].Kind
+}
+
+func parseTemplate(tr *tokenReader, pi *ParserInfo) (Template, bool) {
+	tmpl := Template{line: pi.line, col: pi.col}
+	pi.template = &tmpl
+	body, ok := parseSpaceDecl(tr, pi, false)
+	pi.template = nil
+	tmpl.body = body
+	tmpl.errors = append([]ParserErrorInfo(nil), pi.errors...)
+	return tmpl, ok
+}
+
+// parseTemplateList reads "[a, b, ...]", the parameters of a template or the
+// arguments of an instantiation
+func parseTemplateList(tr *tokenReader, pi *ParserInfo) []locationTaggedString {
+	consumeSpaces(tr, pi)
+	if !tryToken(tr, pi, TokenLBracket) {
+		pi.addError(MismatchedBrackets)
+		return nil
+	}
+	var list []locationTaggedString
+	for tr.Len() > 0 {
+		consumeSpaces(tr, pi)
+		if tr.peek() != TokenIdent {
+			break
+		}
+		item := locationTaggedString{line: pi.line, col: pi.col}
+		item.span.Start = tr.off
+		item.val = pi.name(parseIdentifier(tr, pi))
+		item.span.End = tr.off
+		list = append(list, item)
+
+		consumeSpaces(tr, pi)
+		if !tryToken(tr, pi, TokenComma) {
+			break
+		}
+	}
+	if !tryToken(tr, pi, TokenRBracket) {
+		pi.addError(MismatchedBrackets)
+	}
+	return list
+}
+
+func parseTemplateParams(tr *tokenReader, pi *ParserInfo) []TemplateParam {
+	list := parseTemplateList(tr, pi)
+	params := make([]TemplateParam, 0, len(list))
+	for _, item := range list {
+		for _, p := range params {
+			if p.ident == item.val {
+				pi.addErrorTagged(DuplicateTemplateParam, item)
+			}
+		}
+		params = append(params, TemplateParam{
+			ident: item.val,
+			line: item.line,
+			col: item.col,
+			span: item.span,
+		})
+	}
+	return params
+}
+
+// parseInstantiation reads "@name = @template[args]", which stands for a space
+// until the contract is put together and it is expanded
+func parseInstantiation(tr *tokenReader, pi *ParserInfo) (SpaceDecl, bool) {
+	start := tr.off
+	inst := &Instantiation{line: pi.line, col: pi.col}
+	tryToken(tr, pi, TokenAt)
+	inst.name_span.Start = tr.off
+	inst.ident = pi.name(parseIdentifier(tr, pi))
+	inst.name_span.End = tr.off
+
+	consumeSpaces(tr, pi)
+	tryToken(tr, pi, TokenEquals)
+	consumeSpaces(tr, pi)
+	if !tryToken(tr, pi, TokenAt) {
+		pi.addError(ExpectedTemplate)
+		return SpaceDecl{}, false
+	}
+	inst.template_span.Start = tr.off
+	inst.template = parseIdentifier(tr, pi)
+	inst.template_span.End = tr.off
+	if inst.template == "" {
+		pi.addError(ExpectedIdentifier)
+		return SpaceDecl{}, false
+	}
+	inst.args = parseTemplateList(tr, pi)
+	inst.span = Span{start, tr.off}
+
+	consumeLineRemainder(tr, pi)
+	return SpaceDecl{
+		ident: inst.ident,
+		// empty, where a vibe block would start
+		vibe_desc: VibeBlock{line_start: pi.line, line_end: pi.line, span: Span{tr.off, tr.off}},
+		line_start: inst.line,
+		line_end: pi.line,
+		span: inst.span,
+		name_span: inst.name_span,
+		instance: inst,
+	}, true
+}
+
+// expander replaces instantiations with what their templates expand to
+type expander struct {
+	tree *SyntaxTree
+	templates map[string]*Template
+	// where the errors go
+	pi *ParserInfo
+}
+
+func isPlaceholder(s *SpaceDecl) bool {
+	return s.instance != nil && !s.instance.expanded
+}
+
+func hasInstantiations(s *SpaceDecl) bool {
+	if isPlaceholder(s) {
+		return true
+	}
+	for i := range s.spaces {
+		if hasInstantiations(&s.spaces[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// space is s with every instantiation in it expanded, s itself included; s is
+// left as it is. stack holds the templates being expanded around it.
+func (x *expander) space(s *SpaceDecl, stack []*Template) SpaceDecl {
+	if isPlaceholder(s) {
+		return x.instantiate(s, stack)
+	}
+	if !hasInstantiations(s) {
+		return *s
+	}
+	out := *s
+	out.spaces = make([]SpaceDecl, len(s.spaces))
+	for i := range s.spaces {
+		out.spaces[i] = x.space(&s.spaces[i], stack)
+	}
+	return out
+}
+
+// instantiate expands a single instantiation. Whatever is wrong with it is
+// reported where it is written, and it is left as a space with nothing in it.
+func (x *expander) instantiate(s *SpaceDecl, stack []*Template) SpaceDecl {
+	inst := *s.instance
+	placeholder := *s
+	tmpl, ok := x.templates[inst.template]
+	if !ok {
+		x.pi.appendError(ParserErrorInfo{err: UnknownTemplate, line: inst.line, col: inst.col})
+		return placeholder
+	}
+	inst.expanded, inst.decl_line, inst.decl_col = true, tmpl.line, tmpl.col
+	placeholder.instance = &inst
+	for _, t := range stack {
+		if t == tmpl {
+			x.pi.appendError(ParserErrorInfo{err: TemplateCycle, line: inst.line, col: inst.col, instance: &inst})
+			return placeholder
+		}
+	}
+	if len(inst.args) != len(tmpl.params) {
+		x.pi.appendError(ParserErrorInfo{err: TemplateArgCount, line: inst.line, col: inst.col, instance: &inst})
+		return placeholder
+	}
+
+	subst := map[string]string{tmpl.body.ident: inst.ident}
+	for i, p := range tmpl.params {
+		arg := inst.args[i]
+		if p.is_type && spaceTypeOf(strings.ToUpper(arg.val)) == UnknownSpace {
+			x.pi.appendError(ParserErrorInfo{err: ExpectedSpaceTypeArg, line: arg.line, col: arg.col, instance: &inst})
+			return placeholder
+		}
+		subst[p.ident] = arg.val
+	}
+
+	pi := ParserInfo{
+		columns: x.tree.columns,
+		line: tmpl.line,
+		col: tmpl.col,
+		subst: subst,
+		template: &Template{},
+		instance: &inst,
+	}
+	tr := newTokenReader(x.tree, tmpl.body.span.Start)
+	body, ok := parseSpaceDecl(tr, &pi, false)
+ErrorLoop:
+	for _, errinf := range pi.errors {
+		for _, known := range tmpl.errors {
+			if errinf.err == known.err && errinf.line == known.line && errinf.col == known.col {
+				continue ErrorLoop
+			}
+		}
+		errinf.instance = &inst
+		x.pi.appendError(errinf)
+	}
+	if !ok {
+		return placeholder
+	}
+	body.name_span = inst.name_span
+	return x.space(&body, append(stack, tmpl))
+}
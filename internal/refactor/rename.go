@@ -0,0 +1,218 @@
+package refactor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// Occurrence is one place a declaration is named: the declaration itself, a
+// =path endpoint, $use(@x), $use(#x), $task(...), @space.$task(...) or =path.
+type Occurrence struct {
+	// the node whose declaration or vibe block it is in
+	Node uint64
+	// just the name, without its sigil
+	Span parse.Span
+	IsDecl bool
+}
+
+// Index is every occurrence of every declaration in a contract, found in one
+// pass over it. Each reference is resolved from where it is made so that, say,
+// one space's $init is not confused with another's.
+type Index struct {
+	po *parse.ParseOrder
+	// by declaration, the declaration first
+	occs [][]Occurrence
+	// every occurrence by where it starts
+	spans []located
+}
+
+type located struct {
+	target uint64
+	// its place in occs[target]
+	nth int
+	span parse.Span
+}
+
+func NewIndex(po *parse.ParseOrder) *Index {
+	ix := &Index{po: po, occs: make([][]Occurrence, po.Len())}
+	for i := range ix.occs {
+		ix.occs[i] = []Occurrence{{Node: uint64(i), Span: po.GetNode(uint64(i)).GetNameSpan(), IsDecl: true}}
+	}
+	add := func(target uint64, kind parse.MetaType, from uint64, span parse.Span) {
+		if po.GetNode(target).GetName().GetType() == kind {
+			ix.occs[target] = append(ix.occs[target], Occurrence{Node: from, Span: span})
+		}
+	}
+
+	for i := 0; i < po.Len(); i++ {
+		from := uint64(i)
+		u := po.GetNode(from)
+		if p, ok := u.(*parse.PathDecl); ok {
+			if n, ok := po.Resolve(from, p.GetSource()); ok {
+				add(n, parse.SPACE, from, p.GetSourceSpan())
+			}
+			if n, ok := po.Resolve(from, p.GetDest()); ok {
+				add(n, parse.SPACE, from, p.GetDestSpan())
+			}
+		}
+
+		for _, mr := range u.GetVibe().GetMetaRefs() {
+			switch r := mr.(type) {
+			case *parse.MetaRefUseImport:
+				if n, ok := po.Resolve(from, r.GetImported()); ok {
+					add(n, r.GetImported().GetType(), from, r.GetNameSpan())
+				}
+			case *parse.MetaRefPath:
+				if n, ok := po.Resolve(from, parse.NewIdent(parse.PATH, r.GetIdent())); ok {
+					add(n, parse.PATH, from, r.GetNameSpan())
+				}
+			case *parse.MetaRefTask:
+				if r.GetSpace() != "" {
+					// qualified references name spaces contract-wide
+					if s, ok := po.Lookup(parse.NewIdent(parse.SPACE, r.GetSpace())); ok {
+						add(s, parse.SPACE, from, r.GetSpaceSpan())
+					}
+				}
+				if t, err := po.ResolveTask(from, r); err == nil {
+					add(t, parse.TASK, from, r.GetNameSpan())
+				}
+			}
+		}
+	}
+
+	for target, occs := range ix.occs {
+		for nth, occ := range occs {
+			ix.spans = append(ix.spans, located{target: uint64(target), nth: nth, span: occ.Span})
+		}
+	}
+	sort.SliceStable(ix.spans, func(i, j int) bool { return ix.spans[i].span.Start < ix.spans[j].span.Start })
+	return ix
+}
+
+// Occurrences finds every place the declaration target is named
+func (ix *Index) Occurrences(target uint64) []Occurrence {
+	return ix.occs[target]
+}
+
+// Occurrences finds every place the declaration target is named, indexing the
+// whole contract to do so; use an Index to ask more than once.
+func Occurrences(po *parse.ParseOrder, target uint64) []Occurrence {
+	return NewIndex(po).Occurrences(target)
+}
+
+// At finds the declaration named at a byte offset, by its declaration or by a
+// reference to it. An offset just past the end of a name counts, as that is
+// where an editor's cursor sits after typing it. Where a name is shared, as by
+// the instances of a template, the first declaration wins.
+func (ix *Index) At(offset uint64) (uint64, parse.Span, bool) {
+	var best *located
+	// names do not overlap, so only those starting last at or before offset can
+	// hold it
+	for i := sort.Search(len(ix.spans), func(i int) bool { return ix.spans[i].span.Start > offset }) - 1; i >= 0; i-- {
+		l := &ix.spans[i]
+		if l.span.End < offset {
+			break
+		}
+		if !l.span.Contains(offset) && !(l.span.End == offset && l.span.Len() > 0) {
+			continue
+		}
+		if best == nil || l.target < best.target || l.target == best.target && l.nth < best.nth {
+			best = l
+		}
+	}
+	if best == nil {
+		return 0, parse.Span{}, false
+	}
+	return best.target, best.span, true
+}
+
+// At finds the declaration named at a byte offset, as Index.At does
+func At(po *parse.ParseOrder, offset uint64) (uint64, parse.Span, bool) {
+	return NewIndex(po).At(offset)
+}
+
+// clash finds an existing declaration that new_name would collide with: for
+// spaces, paths and top-level agents any of the same kind, for the agents and
+// tasks of a space a sibling.
+func clash(po *parse.ParseOrder, target uint64, id parse.Ident) (uint64, bool) {
+	if parent, ok := po.GetParent(target); ok && id.GetType() != parse.SPACE && id.GetType() != parse.PATH {
+		for _, c := range po.GetChildIDs(parent) {
+			if po.GetNode(c).GetName() == id {
+				return c, true
+			}
+		}
+		return 0, false
+	}
+	return po.Lookup(id)
+}
+
+// Rename gives the text edits renaming a declaration and every reference to
+// it. new_name may carry its sigil. It refuses a name that is not an
+// identifier, that is already declared where it would clash, or that would
+// change what any reference resolves to, and a declaration named inside a
+// template's expansion. src is the source the index's contract was parsed
+// from, with old_errs parse errors.
+func (ix *Index) Rename(src string, old_errs int, target uint64, new_name string) ([]parse.TextEdit, error) {
+	po := ix.po
+	old := po.GetNode(target).GetName()
+	sigil := old.ToStr()[:1]
+	new_name = strings.TrimPrefix(new_name, sigil)
+	if !parse.IsIdentifier(new_name) {
+		return nil, fmt.Errorf("%q is not an identifier", new_name)
+	}
+	if old.GetType() == parse.TASK && new_name == "use" {
+		return nil, fmt.Errorf("$use is reserved")
+	}
+	if new_name == old.GetIdent() {
+		return nil, nil
+	}
+
+	id := parse.NewIdent(old.GetType(), new_name)
+	if other, ok := clash(po, target, id); ok {
+		return nil, fmt.Errorf("cannot rename %s to %s: %s is already declared", po.GetQualifiedName(target), id.ToStr(), po.GetQualifiedName(other))
+	}
+
+	occs := ix.Occurrences(target)
+	target_inst := po.GetNode(target).GetInstantiation()
+	var edits []parse.TextEdit
+	for _, occ := range occs {
+		// text expanded from a template is the template's, shared by every
+		// instance of it, but for the name at the instantiation. Where the
+		// template names itself it names the instance, whatever that is called.
+		if inst := po.GetNode(occ.Node).GetInstantiation(); inst != nil && !(occ.IsDecl && occ.Span == inst.GetNameSpan()) {
+			if inst == target_inst && src[occ.Span.Start:occ.Span.End] == inst.GetTemplate().GetIdent() {
+				continue
+			}
+			return nil, fmt.Errorf("cannot rename %s: it is named in template %s; rename it there", po.GetQualifiedName(target), inst.GetTemplate().ToStr())
+		}
+		edits = append(edits, parse.TextEdit{Span: occ.Span, NewText: new_name})
+	}
+
+	// resolving the result again catches a renamed reference now finding some
+	// other declaration first, or another reference now finding this one
+	renamed, err := parse.ApplyEdits(src, edits)
+	if err != nil {
+		return nil, err
+	}
+	c, errs := parse.ParseFromReader(strings.NewReader(renamed))
+	if len(errs) > old_errs {
+		return nil, fmt.Errorf("renaming %s to %s would not parse", old.ToStr(), id.ToStr())
+	}
+	new_po := parse.GetParseOrder(&c)
+	new_target, ok := targetAfter(&new_po, po, target, new_name)
+	if !ok || len(Occurrences(&new_po, new_target)) != len(occs) {
+		return nil, fmt.Errorf("renaming %s to %s would change what other references resolve to", po.GetQualifiedName(target), id.ToStr())
+	}
+	return edits, nil
+}
+
+// targetAfter finds the renamed declaration in the contract after the rename
+func targetAfter(new_po *parse.ParseOrder, po *parse.ParseOrder, target uint64, new_name string) (uint64, bool) {
+	name := po.GetQualifiedName(target)
+	// the last sigil starts the declaration's own name
+	cut := strings.LastIndexAny(name, "@#$=")
+	return new_po.LookupQualified(name[:cut+1] + new_name)
+}
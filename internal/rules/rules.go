@@ -0,0 +1,182 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// Obligations are the deterministic, machine-checkable rules we can pull out of
+// a contract without asking a model. Later stages check generated code (or
+// runtime traces) against them; each one points back at the vibe block that
+// gave rise to it.
+
+type Kind string
+const (
+	// a =path must be used by some vibe block inside its source @space
+	PathUsed Kind = "path-used"
+	// the subject's output must send over the referenced =path
+	PathSend Kind = "path-send"
+	// the subject's output must call the referenced $task with these args
+	TaskCall Kind = "task-call"
+	// the subject's output must import the referenced @space or #agent
+	UseImport Kind = "use-import"
+	// the subject's output must read or write the referenced %data
+	DataAccess Kind = "data-access"
+	// the subject's output must take exactly the declared in / out params
+	Signature Kind = "signature"
+)
+
+// Span is where a vibe block is in the source, as 0-based lines and columns:
+// from its first ">" up to, but not including, the end of its last prose
+type Span struct {
+	LineStart uint64 `json:"line_start"`
+	ColStart uint64 `json:"col_start"`
+	LineEnd uint64 `json:"line_end"`
+	ColEnd uint64 `json:"col_end"`
+}
+
+type Obligation struct {
+	Kind Kind `json:"kind"`
+	// declaration whose generated output must satisfy the obligation, by
+	// qualified name, eg. "@store.$fetch"
+	Subject string `json:"subject"`
+	// element the obligation is about, eg. "=to_store"; empty for Signature
+	Target string `json:"target,omitempty"`
+	Args []string `json:"args,omitempty"`
+	// the vibe block the obligation came from
+	Span Span `json:"span"`
+}
+
+const FormatVersion = 2
+
+type document struct {
+	Version int `json:"version"`
+	Obligations []Obligation `json:"obligations"`
+}
+
+func vibeSpan(tree *parse.SyntaxTree, vb *parse.VibeBlock) Span {
+	var s Span
+	s.LineStart, s.ColStart = tree.Position(vb.GetSpan().Start)
+	s.LineEnd, s.ColEnd = tree.Position(vb.GetSpan().End)
+	return s
+}
+
+// paramStrs is nil for no params, as Import reads an omitted Args back
+func paramStrs(params []parse.Param) []string {
+	if len(params) == 0 {
+		return nil
+	}
+	strs := make([]string, len(params))
+	for i := range params {
+		strs[i] = params[i].ToStr()
+	}
+	return strs
+}
+
+// Extract derives every obligation from a resolved contract, declaration by
+// declaration in ParseOrder id order. Subjects and targets are qualified
+// names, as the spaces may each have a $task of the same name.
+func Extract(c *parse.Contract, po *parse.ParseOrder) []Obligation {
+	tree := c.GetSyntaxTree()
+	var obls []Obligation
+	for i := 0; i < po.Len(); i++ {
+		id := uint64(i)
+		if p, ok := po.GetNode(id).(*parse.PathDecl); ok {
+			obls = append(obls, Obligation{
+				Kind: PathUsed,
+				Subject: resolvedName(po, id, p.GetSource()),
+				Target: po.GetQualifiedName(id),
+				Span: vibeSpan(tree, p.GetVibe()),
+			})
+		}
+		obls = append(obls, fromUnit(tree, po, id)...)
+	}
+	return obls
+}
+
+// resolvedName qualifies a name as node from sees it, leaving it as written if
+// it is not declared
+func resolvedName(po *parse.ParseOrder, from uint64, ident parse.Ident) string {
+	if i, ok := po.Resolve(from, ident); ok {
+		return po.GetQualifiedName(i)
+	}
+	return ident.ToStr()
+}
+
+func fromUnit(tree *parse.SyntaxTree, po *parse.ParseOrder, id uint64) []Obligation {
+	var obls []Obligation
+	u := po.GetNode(id)
+	subject := po.GetQualifiedName(id)
+	span := vibeSpan(tree, u.GetVibe())
+
+	var params []parse.Param
+	switch d := u.(type) {
+	case *parse.SpaceDecl: params = d.GetParams()
+	case *parse.AgentDecl: params = d.GetParams()
+	case *parse.TaskDecl: params = d.GetParams()
+	}
+	if len(params) > 0 {
+		obls = append(obls, Obligation{
+			Kind: Signature,
+			Subject: subject,
+			Args: paramStrs(params),
+			Span: span,
+		})
+	}
+
+	for _, mr := range u.GetVibe().GetMetaRefs() {
+		o := Obligation{
+			Subject: subject,
+			Span: span,
+		}
+		switch ref := mr.(type) {
+		case *parse.MetaRefTask:
+			o.Kind = TaskCall
+			o.Args = paramStrs(ref.GetArgs())
+			if t, err := po.ResolveTask(id, ref); err == nil {
+				o.Target = po.GetQualifiedName(t)
+			} else if ref.GetSpace() != "" {
+				o.Target = "@" + ref.GetSpace() + ".$" + ref.GetIdent()
+			} else {
+				o.Target = "$" + ref.GetIdent()
+			}
+		case *parse.MetaRefUseImport:
+			o.Kind = UseImport
+			o.Target = resolvedName(po, id, ref.GetImported())
+		case *parse.MetaRefData:
+			o.Kind = DataAccess
+			o.Target = ref.ToStr()
+		case *parse.MetaRefPath:
+			o.Kind = PathSend
+			o.Target = resolvedName(po, id, parse.NewIdent(parse.PATH, ref.GetIdent()))
+		default:
+			continue
+		}
+		obls = append(obls, o)
+	}
+	return obls
+}
+
+func Export(obls []Obligation) ([]byte, error) {
+	if obls == nil {
+		obls = []Obligation{}
+	}
+	return json.MarshalIndent(document{
+		Version: FormatVersion,
+		Obligations: obls,
+	}, "", "  ")
+}
+
+// Import reads what Export wrote, refusing any format version but this one
+func Import(data []byte) ([]Obligation, error) {
+	var doc document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Version != FormatVersion {
+		return nil, fmt.Errorf("rules: unsupported format version %d, expected %d", doc.Version, FormatVersion)
+	}
+	return doc.Obligations, nil
+}
@@ -0,0 +1,167 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/tmc/langchaingo/llms"
+
+	"github.com/anotherLostKitten/Anglish/internal/air"
+)
+
+// The reference runtime runs a contract on a single machine, in process. Every
+// @space is an actor: a goroutine draining a mailbox, handling one task at a
+// time. Spaces talk only over their =paths:
+//
+//   - INVOKE: the sender blocks until the destination has run the task and
+//     gets its out params back.
+//   - ATTEND: the message is queued on the destination and the sender carries
+//     on; nothing comes back.
+//
+// Start plays the part of the main space: it spawns the instances of every
+// space that is not :REPLICABLE, one for each site in the module's routing
+// table (see parse.GetRoutingTable). A message to such a space goes to the
+// instance its route names. See spawn.go for the rest.
+//
+// A task runs the first way that applies: a TaskFunc registered for it, the
+// built-in state semantics of a :DATA space, or an #agent of the space driven
+// by a model through llm.NewAgentExecutor.
+
+var ErrStopped = errors.New("runtime: stopped")
+
+type Config struct {
+	// model the #agents run on; nil uses the one configured for llm.NewOpenAI
+	Model llms.Model
+	// task implementations, keyed by HandlerKey(space, task)
+	Handlers map[string]TaskFunc
+	// messages a space can have queued before senders block; 0 uses 16
+	MailboxSize int
+}
+
+func HandlerKey(space string, task string) string {
+	return "@" + space + ".$" + task
+}
+
+type Runtime struct {
+	m *air.Module
+	cfg Config
+
+	spaces map[uint32]*space
+
+	ctx context.Context
+	cancel context.CancelFunc
+	wg sync.WaitGroup
+	started bool
+}
+
+// New prepares a runtime for a validated module; Start sets it running.
+func New(m *air.Module, cfg Config) (*Runtime, error) {
+	if errs := air.Validate(m); len(errs) > 0 {
+		return nil, fmt.Errorf("runtime: invalid module: %w", errors.Join(errs...))
+	}
+	if cfg.MailboxSize <= 0 {
+		cfg.MailboxSize = 16
+	}
+
+	rt := Runtime{
+		m: m,
+		cfg: cfg,
+		spaces: make(map[uint32]*space),
+	}
+	for i := range m.Spaces {
+		s := &m.Spaces[i]
+		rt.spaces[s.ID] = &space{
+			rt: &rt,
+			decl: s,
+			instances: make(map[uint64]*instance),
+		}
+	}
+	return &rt, nil
+}
+
+// Start spawns the instances of every space that is not :REPLICABLE, running
+// their SpawnSignal tasks in declaration order.
+func (rt *Runtime) Start(ctx context.Context) error {
+	if rt.started {
+		return nil
+	}
+	rt.started = true
+	rt.ctx, rt.cancel = context.WithCancel(ctx)
+	for i := range rt.m.Spaces {
+		sp := rt.spaces[rt.m.Spaces[i].ID]
+		if sp.decl.Replicable {
+			continue
+		}
+		for _, site := range rt.m.InstancesOf(sp.decl.ID) {
+			if _, err := rt.spawn(ctx, sp, site, nil); err != nil {
+				rt.Stop()
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Stop cancels every space and waits for them to finish their current task.
+func (rt *Runtime) Stop() {
+	if !rt.started {
+		return
+	}
+	rt.cancel()
+	rt.wg.Wait()
+}
+
+func (rt *Runtime) space(name string) (*space, error) {
+	id, ok := rt.m.Lookup(air.KindSpace, name)
+	if !ok {
+		return nil, fmt.Errorf("runtime: no space @%s", name)
+	}
+	return rt.spaces[id], nil
+}
+
+func (rt *Runtime) instance(name string, id *uint64) (*instance, error) {
+	if !rt.started {
+		return nil, fmt.Errorf("runtime: not started")
+	}
+	sp, err := rt.space(name)
+	if err != nil {
+		return nil, err
+	}
+	if id != nil {
+		return sp.get(*id)
+	}
+	return sp.route(nil)
+}
+
+// Call runs a task in a space from outside the contract, eg. on behalf of a
+// user, and waits for its out params. The space must have a single instance;
+// use CallInstance otherwise.
+func (rt *Runtime) Call(ctx context.Context, space string, task string, args map[string]string) (map[string]string, error) {
+	inst, err := rt.instance(space, nil)
+	if err != nil {
+		return nil, err
+	}
+	return inst.deliver(ctx, message{task: task, args: args}, true)
+}
+
+func (rt *Runtime) CallInstance(ctx context.Context, inst Instance, task string, args map[string]string) (map[string]string, error) {
+	live, err := rt.instance(inst.Space, &inst.ID)
+	if err != nil {
+		return nil, err
+	}
+	return live.deliver(ctx, message{task: task, args: args}, true)
+}
+
+// Data returns a copy of the state of a :DATA space with a single instance.
+func (rt *Runtime) Data(space string) (map[string]string, error) {
+	inst, err := rt.instance(space, nil)
+	if err != nil {
+		return nil, err
+	}
+	if inst.sp.decl.Type != "DATA" {
+		return nil, fmt.Errorf("runtime: @%s is not a :DATA space", space)
+	}
+	return inst.snapshot(), nil
+}
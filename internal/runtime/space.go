@@ -0,0 +1,207 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/anotherLostKitten/Anglish/internal/air"
+)
+
+var ErrDespawned = errors.New("runtime: instance was despawned")
+
+type result struct {
+	out map[string]string
+	err error
+}
+
+type message struct {
+	task string
+	args map[string]string
+	// sending instance, nil from outside the contract
+	from *instance
+	// nil for ATTEND
+	reply chan result
+}
+
+// space holds the live instances of one @space. Spaces that are not
+// :REPLICABLE have one per site in the routing table, spawned by Start.
+type space struct {
+	rt *Runtime
+	decl *air.Space
+
+	mu sync.Mutex
+	instances map[uint64]*instance
+	next_id uint64
+}
+
+// instance is the actor: a goroutine draining a mailbox, one task at a time
+type instance struct {
+	sp *space
+	id uint64
+	// space whose $use() made this instance, air.NoSite at the top level
+	site int32
+	// instance whose task spawned this one, nil if spawned by the runtime
+	spawner *instance
+	mailbox chan message
+
+	// :DATA state; written by the actor goroutine, read by snapshot
+	state_mu sync.Mutex
+	state map[string]string
+
+	cancel context.CancelFunc
+	done chan struct{}
+}
+
+func (inst *instance) ref() Instance {
+	ref := Instance{
+		Space: inst.sp.decl.Name,
+		ID: inst.id,
+	}
+	if site := inst.sp.rt.m.Space(uint32(inst.site)); inst.site != air.NoSite && site != nil {
+		ref.Site = site.Name
+	}
+	return ref
+}
+
+func (inst *instance) run(ctx context.Context) {
+	defer close(inst.done)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-inst.mailbox:
+			out, err := inst.runTask(ctx, msg)
+			if msg.reply != nil {
+				msg.reply <- result{out, err}
+			}
+		}
+	}
+}
+
+// deliver queues msg and, if wait is set, blocks for the result
+func (inst *instance) deliver(ctx context.Context, msg message, wait bool) (map[string]string, error) {
+	if _, err := inst.sp.task(msg.task); err != nil {
+		return nil, err
+	}
+	if wait {
+		msg.reply = make(chan result, 1)
+	}
+
+	select {
+	case inst.mailbox <- msg:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-inst.done:
+		return nil, ErrDespawned
+	}
+	if !wait {
+		return nil, nil
+	}
+
+	select {
+	case res := <-msg.reply:
+		return res.out, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-inst.done:
+		return nil, ErrDespawned
+	}
+}
+
+func (inst *instance) snapshot() map[string]string {
+	inst.state_mu.Lock()
+	defer inst.state_mu.Unlock()
+	out := make(map[string]string, len(inst.state))
+	for k, v := range inst.state {
+		out[k] = v
+	}
+	return out
+}
+
+// task finds a $task declared in this space
+func (sp *space) task(name string) (*air.Task, error) {
+	for _, id := range sp.decl.Tasks {
+		if t := sp.rt.m.Task(id); t != nil && t.Name == name {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("runtime: @%s has no task $%s", sp.decl.Name, name)
+}
+
+func (sp *space) hasTask(name string) bool {
+	_, err := sp.task(name)
+	return err == nil
+}
+
+func (sp *space) ids() []uint64 {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	ids := make([]uint64, 0, len(sp.instances))
+	for id := range sp.instances {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (sp *space) get(id uint64) (*instance, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	inst, ok := sp.instances[id]
+	if !ok {
+		return nil, fmt.Errorf("runtime: @%s has no instance %d", sp.decl.Name, id)
+	}
+	return inst, nil
+}
+
+// atSite finds the live instance made by site
+func (sp *space) atSite(site int32) (*instance, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	for _, inst := range sp.instances {
+		if inst.site == site {
+			return inst, nil
+		}
+	}
+	if site == air.NoSite {
+		return nil, fmt.Errorf("runtime: @%s has no top-level instance", sp.decl.Name)
+	}
+	return nil, fmt.Errorf("runtime: @%s has no instance imported by @%s", sp.decl.Name, sp.rt.m.Space(uint32(site)).Name)
+}
+
+// route picks the instance of sp a message from from should go to: the one the
+// sender was spawned by, if it is in sp, otherwise the only live instance.
+func (sp *space) route(from *instance) (*instance, error) {
+	if from != nil && from.spawner != nil && from.spawner.sp == sp {
+		return from.spawner, nil
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	switch len(sp.instances) {
+	case 0:
+		return nil, fmt.Errorf("runtime: @%s has no live instances", sp.decl.Name)
+	case 1:
+		for _, inst := range sp.instances {
+			return inst, nil
+		}
+	}
+	// several instances, but only one spawned by the sender
+	var found *instance
+	for _, inst := range sp.instances {
+		if from != nil && inst.spawner == from {
+			if found != nil {
+				found = nil
+				break
+			}
+			found = inst
+		}
+	}
+	if found != nil {
+		return found, nil
+	}
+	return nil, fmt.Errorf("runtime: @%s has %d live instances, name the one to send to", sp.decl.Name, len(sp.instances))
+}
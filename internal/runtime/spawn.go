@@ -0,0 +1,198 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/anotherLostKitten/Anglish/internal/air"
+)
+
+// Scaling is never automatic: :REPLICABLE spaces get instances only when a task
+// asks the runtime for them, through Call.Spawn / Call.Despawn or the spawn and
+// despawn tools given to agents. Spaces that are not :REPLICABLE have one
+// instance per site in the routing table for the lifetime of the runtime.
+//
+// A space may declare the signal tasks below; the runtime runs them first thing
+// in a new instance and last thing before tearing one down.
+const (
+	SpawnSignal = "on_spawn"
+	DespawnSignal = "on_despawn"
+)
+
+// Instance names one live instance of a space; Site, when set, is the space
+// whose $use() made it
+type Instance struct {
+	Space string `json:"space"`
+	ID uint64 `json:"instance"`
+	Site string `json:"site,omitempty"`
+}
+
+// spawnSite picks the site of a new :REPLICABLE instance: the spawner's space if
+// that imports it, otherwise the first in the routing table
+func (rt *Runtime) spawnSite(sp *space, spawner *instance) int32 {
+	sites := rt.m.InstancesOf(sp.decl.ID)
+	for _, site := range sites {
+		if spawner != nil && site == int32(spawner.sp.decl.ID) {
+			return site
+		}
+	}
+	if len(sites) == 0 {
+		return air.NoSite
+	}
+	return sites[0]
+}
+
+func (rt *Runtime) spawn(ctx context.Context, sp *space, site int32, spawner *instance) (*instance, error) {
+	if rt.ctx == nil || rt.ctx.Err() != nil {
+		return nil, ErrStopped
+	}
+	inst_ctx, cancel := context.WithCancel(rt.ctx)
+
+	sp.mu.Lock()
+	inst := &instance{
+		sp: sp,
+		id: sp.next_id,
+		site: site,
+		spawner: spawner,
+		mailbox: make(chan message, rt.cfg.MailboxSize),
+		state: make(map[string]string),
+		cancel: cancel,
+		done: make(chan struct{}),
+	}
+	sp.next_id++
+	sp.instances[inst.id] = inst
+	sp.mu.Unlock()
+
+	rt.wg.Add(1)
+	go func() {
+		defer rt.wg.Done()
+		inst.run(inst_ctx)
+	}()
+
+	if sp.hasTask(SpawnSignal) {
+		if _, err := inst.deliver(ctx, message{task: SpawnSignal, from: spawner}, true); err != nil {
+			rt.teardown(inst)
+			return nil, fmt.Errorf("runtime: @%s.$%s: %w", sp.decl.Name, SpawnSignal, err)
+		}
+	}
+	return inst, nil
+}
+
+func (rt *Runtime) despawn(ctx context.Context, inst *instance) error {
+	var err error
+	if inst.sp.hasTask(DespawnSignal) {
+		_, err = inst.deliver(ctx, message{task: DespawnSignal}, true)
+	}
+	rt.teardown(inst)
+	return err
+}
+
+func (rt *Runtime) teardown(inst *instance) {
+	inst.sp.mu.Lock()
+	delete(inst.sp.instances, inst.id)
+	inst.sp.mu.Unlock()
+	inst.cancel()
+	<-inst.done
+}
+
+func (rt *Runtime) replicable(name string) (*space, error) {
+	sp, err := rt.space(name)
+	if err != nil {
+		return nil, err
+	}
+	if !sp.decl.Replicable {
+		return nil, fmt.Errorf("runtime: @%s is not :REPLICABLE, so it cannot be spawned or despawned", name)
+	}
+	return sp, nil
+}
+
+// Spawn starts a new instance of a :REPLICABLE space from outside the contract.
+func (rt *Runtime) Spawn(ctx context.Context, space string) (Instance, error) {
+	return rt.spawnFrom(ctx, space, nil)
+}
+
+// Despawn tears down an instance of a :REPLICABLE space.
+func (rt *Runtime) Despawn(ctx context.Context, inst Instance) error {
+	sp, err := rt.replicable(inst.Space)
+	if err != nil {
+		return err
+	}
+	live, err := sp.get(inst.ID)
+	if err != nil {
+		return err
+	}
+	return rt.despawn(ctx, live)
+}
+
+func (rt *Runtime) spawnFrom(ctx context.Context, space string, spawner *instance) (Instance, error) {
+	sp, err := rt.replicable(space)
+	if err != nil {
+		return Instance{}, err
+	}
+	inst, err := rt.spawn(ctx, sp, rt.spawnSite(sp, spawner), spawner)
+	if err != nil {
+		return Instance{}, err
+	}
+	return inst.ref(), nil
+}
+
+// Instances lists the live instance ids of a space.
+func (rt *Runtime) Instances(space string) ([]uint64, error) {
+	sp, err := rt.space(space)
+	if err != nil {
+		return nil, err
+	}
+	return sp.ids(), nil
+}
+
+// spawnTool and despawnTool give agents the runtime's scaling tasks
+type spawnTool struct {
+	call *Call
+}
+
+func (st *spawnTool) Name() string {
+	return "spawn"
+}
+
+func (st *spawnTool) Description() string {
+	return `Start a new instance of a :REPLICABLE space. Input is JSON: {"space": "<space name>"}. Returns the new instance.`
+}
+
+func (st *spawnTool) Call(ctx context.Context, input string) (string, error) {
+	var in Instance
+	if err := json.Unmarshal([]byte(input), &in); err != nil {
+		return "error: " + err.Error(), nil
+	}
+	inst, err := st.call.Spawn(ctx, strings.TrimPrefix(in.Space, "@"))
+	if err != nil {
+		return "error: " + err.Error(), nil
+	}
+	data, _ := json.Marshal(inst)
+	return string(data), nil
+}
+
+type despawnTool struct {
+	call *Call
+}
+
+func (dt *despawnTool) Name() string {
+	return "despawn"
+}
+
+func (dt *despawnTool) Description() string {
+	return `Tear down an instance of a :REPLICABLE space. Input is JSON: {"space": "<space name>", "instance": <id>}.`
+}
+
+func (dt *despawnTool) Call(ctx context.Context, input string) (string, error) {
+	var in Instance
+	if err := json.Unmarshal([]byte(input), &in); err != nil {
+		return "error: " + err.Error(), nil
+	}
+	in.Space = strings.TrimPrefix(in.Space, "@")
+	if err := dt.call.Despawn(ctx, in); err != nil {
+		return "error: " + err.Error(), nil
+	}
+	return "despawned", nil
+}
@@ -0,0 +1,416 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tmc/langchaingo/agents"
+	"github.com/tmc/langchaingo/chains"
+	"github.com/tmc/langchaingo/tools"
+
+	"github.com/anotherLostKitten/Anglish/internal/air"
+	"github.com/anotherLostKitten/Anglish/internal/llm"
+)
+
+// TaskFunc implements a $task. It returns the task's out params by name.
+type TaskFunc func(ctx context.Context, call *Call) (map[string]string, error)
+
+// Call is one running task, as seen by its TaskFunc.
+type Call struct {
+	inst *instance
+	task *air.Task
+	Args map[string]string
+}
+
+func (c *Call) Space() string {
+	return c.inst.sp.decl.Name
+}
+
+func (c *Call) Instance() Instance {
+	return c.inst.ref()
+}
+
+func (c *Call) Task() string {
+	return c.task.Name
+}
+
+// Send runs task in the space at the far end of the named =path, which must
+// lead out of this space. INVOKE waits for the out params; ATTEND returns nil
+// as soon as the message is queued. If the destination is :REPLICABLE the
+// message goes to the instance that spawned this one, or else the one instance
+// this one spawned there, or else the only live one; SendTo names it instead.
+// Otherwise it goes where the module's routing table says.
+func (c *Call) Send(ctx context.Context, path string, task string, args map[string]string) (map[string]string, error) {
+	return c.inst.send(ctx, path, nil, task, args)
+}
+
+func (c *Call) SendTo(ctx context.Context, path string, instance uint64, task string, args map[string]string) (map[string]string, error) {
+	return c.inst.send(ctx, path, &instance, task, args)
+}
+
+// Spawn starts a new instance of a :REPLICABLE space, remembering this one as
+// its spawner for routing.
+func (c *Call) Spawn(ctx context.Context, space string) (Instance, error) {
+	return c.inst.sp.rt.spawnFrom(ctx, space, c.inst)
+}
+
+func (c *Call) Despawn(ctx context.Context, inst Instance) error {
+	if inst.Space == c.Space() && inst.ID == c.inst.id {
+		return fmt.Errorf("runtime: @%s instance %d cannot despawn itself", inst.Space, inst.ID)
+	}
+	return c.inst.sp.rt.Despawn(ctx, inst)
+}
+
+// Get reads the state of a :DATA space
+func (c *Call) Get(name string) (string, bool) {
+	c.inst.state_mu.Lock()
+	defer c.inst.state_mu.Unlock()
+	v, ok := c.inst.state[name]
+	return v, ok
+}
+
+// Set writes the state of a :DATA space; other spaces have no state to write
+func (c *Call) Set(name string, value string) error {
+	if c.inst.sp.decl.Type != "DATA" {
+		return fmt.Errorf("runtime: @%s is not a :DATA space", c.Space())
+	}
+	c.inst.state_mu.Lock()
+	c.inst.state[name] = value
+	c.inst.state_mu.Unlock()
+	return nil
+}
+
+func (inst *instance) send(ctx context.Context, path_name string, dest_id *uint64, task string, args map[string]string) (map[string]string, error) {
+	rt := inst.sp.rt
+	id, ok := rt.m.Lookup(air.KindPath, path_name)
+	if !ok {
+		return nil, fmt.Errorf("runtime: no path =%s", path_name)
+	}
+	p := rt.m.Path(id)
+	if p.Source != inst.sp.decl.ID {
+		return nil, fmt.Errorf("runtime: =%s does not lead out of @%s", path_name, inst.sp.decl.Name)
+	}
+
+	dest_sp := rt.spaces[p.Dest]
+	t, err := dest_sp.task(task)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAccess(p, t, args); err != nil {
+		return nil, err
+	}
+
+	var dest *instance
+	switch {
+	case dest_id != nil:
+		dest, err = dest_sp.get(*dest_id)
+	case dest_sp.decl.Replicable:
+		dest, err = dest_sp.route(inst)
+	default:
+		site, ok := rt.m.Route(id, inst.site)
+		if !ok {
+			err = fmt.Errorf("runtime: no route from this instance of @%s, it is ambiguous or unresolved", inst.sp.decl.Name)
+			break
+		}
+		dest, err = dest_sp.atSite(site)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("runtime: routing =%s: %w", path_name, err)
+	}
+	msg := message{
+		task: task,
+		args: args,
+		from: inst,
+	}
+
+	switch p.Type {
+	case "INVOKE":
+		// the instance is busy running us, so it could never answer
+		if dest == inst {
+			return nil, fmt.Errorf("runtime: =%s INVOKEs @%s from inside itself", path_name, inst.sp.decl.Name)
+		}
+		return dest.deliver(ctx, msg, true)
+	case "ATTEND":
+		_, err := dest.deliver(ctx, msg, false)
+		return nil, err
+	default:
+		return nil, fmt.Errorf("runtime: =%s has unknown path type %q", path_name, p.Type)
+	}
+}
+
+// checkAccess refuses a message its path's access tag does not allow, by the
+// same rules as check.DefaultRules.Access: :READ paths carry data in only as
+// the request for a task that reads some back, :WRITE paths bring nothing
+// back, :CONTROL paths carry no data at all.
+func checkAccess(p *air.Path, t *air.Task, args map[string]string) error {
+	allow_in, allow_out := true, true
+	switch p.Access {
+	case "READ":
+		allow_in = false
+		for _, param := range t.Params {
+			allow_in = allow_in || !param.In
+		}
+	case "WRITE": allow_out = false
+	case "CONTROL": allow_in, allow_out = false, false
+	}
+	for _, param := range t.Params {
+		if param.In && !allow_in {
+			return fmt.Errorf("runtime: =%s is :%s, but $%s writes %%%s", p.Name, p.Access, t.Name, param.Name)
+		}
+		if !param.In && !allow_out {
+			return fmt.Errorf("runtime: =%s is :%s, but $%s reads %%%s", p.Name, p.Access, t.Name, param.Name)
+		}
+	}
+	if !allow_in && len(args) > 0 {
+		return fmt.Errorf("runtime: =%s is :%s and cannot carry data to $%s", p.Name, p.Access, t.Name)
+	}
+	return nil
+}
+
+func (inst *instance) runTask(ctx context.Context, msg message) (map[string]string, error) {
+	sp := inst.sp
+	t, err := sp.task(msg.task)
+	if err != nil {
+		return nil, err
+	}
+	call := Call{
+		inst: inst,
+		task: t,
+		Args: msg.args,
+	}
+	if call.Args == nil {
+		call.Args = map[string]string{}
+	}
+
+	if h, ok := sp.rt.cfg.Handlers[HandlerKey(sp.decl.Name, t.Name)]; ok {
+		return h(ctx, &call)
+	}
+	if sp.decl.Type == "DATA" {
+		return runDataTask(&call)
+	}
+	// signals are optional to implement
+	if t.Name == SpawnSignal || t.Name == DespawnSignal {
+		if sp.agentFor(t) == nil {
+			return nil, nil
+		}
+	}
+	return runAgentTask(ctx, &call)
+}
+
+// runDataTask gives :DATA tasks their default meaning: in params are stored,
+// out params are loaded.
+func runDataTask(call *Call) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, p := range call.task.Params {
+		if !p.In {
+			continue
+		}
+		v, ok := call.Args[p.Name]
+		if !ok {
+			return nil, fmt.Errorf("runtime: $%s is missing in=%%%s", call.task.Name, p.Name)
+		}
+		call.Set(p.Name, v)
+	}
+	for _, p := range call.task.Params {
+		if p.In {
+			continue
+		}
+		v, ok := call.Get(p.Name)
+		if !ok {
+			return nil, fmt.Errorf("runtime: @%s has no %%%s stored", call.Space(), p.Name)
+		}
+		out[p.Name] = v
+	}
+	return out, nil
+}
+
+// agentFor picks the #agent that runs a task: one the task $use()s, or else the
+// first declared in its space
+func (sp *space) agentFor(t *air.Task) *air.Agent {
+	for _, id := range t.Imports {
+		if a := sp.rt.m.Agent(id); a != nil {
+			return a
+		}
+	}
+	if len(sp.decl.Agents) > 0 {
+		return sp.rt.m.Agent(sp.decl.Agents[0])
+	}
+	return nil
+}
+
+func runAgentTask(ctx context.Context, call *Call) (map[string]string, error) {
+	sp := call.inst.sp
+	rt := sp.rt
+	agent := sp.agentFor(call.task)
+	if agent == nil {
+		return nil, fmt.Errorf("runtime: no handler for @%s.$%s and no #agent to run it", sp.decl.Name, call.task.Name)
+	}
+
+	tool_list := []tools.Tool{}
+	replicable := false
+	for i := range rt.m.Paths {
+		p := &rt.m.Paths[i]
+		if p.Source == sp.decl.ID {
+			tool_list = append(tool_list, &pathTool{call: call, path: p})
+		}
+	}
+	for _, s := range rt.m.Spaces {
+		replicable = replicable || s.Replicable
+	}
+	if replicable {
+		tool_list = append(tool_list, &spawnTool{call: call}, &despawnTool{call: call})
+	}
+
+	system := agentPrompt(sp, agent)
+	var exec agents.Executor
+	var err error
+	if rt.cfg.Model != nil {
+		exec, err = llm.NewAgentExecutorWithModel(rt.cfg.Model, system, tool_list, nil)
+	} else {
+		exec, err = llm.NewAgentExecutor(system, tool_list, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	answer, err := chains.Run(ctx, exec, taskPrompt(call))
+	if err != nil {
+		return nil, fmt.Errorf("runtime: #%s running $%s: %w", agent.Name, call.task.Name, err)
+	}
+	return taskOutputs(call.task, answer), nil
+}
+
+func agentPrompt(sp *space, agent *air.Agent) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "You are the agent #%s.\n", agent.Name)
+	for _, line := range agent.Vibe {
+		b.WriteString(line + "\n")
+	}
+	fmt.Fprintf(&b, "\nYou work inside the space @%s.\n", sp.decl.Name)
+	for _, line := range sp.decl.Vibe {
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("\nYou can only reach other spaces through the path tools you are given.")
+	return b.String()
+}
+
+func taskPrompt(call *Call) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Run the task $%s.\n", call.task.Name)
+	for _, line := range call.task.Vibe {
+		b.WriteString(line + "\n")
+	}
+
+	var outs []string
+	for _, p := range call.task.Params {
+		if p.In {
+			fmt.Fprintf(&b, "\n%%%s = %s", p.Name, call.Args[p.Name])
+		} else {
+			outs = append(outs, fmt.Sprintf("%q", p.Name))
+		}
+	}
+	if len(outs) > 0 {
+		fmt.Fprintf(&b, "\n\nWhen you are done, answer with a JSON object with the keys %s.", strings.Join(outs, ", "))
+	}
+	return b.String()
+}
+
+// taskOutputs maps an agent's answer onto the task's out params: a JSON object
+// is read key by key, anything else is the value of a lone out param.
+func taskOutputs(t *air.Task, answer string) map[string]string {
+	out := make(map[string]string)
+	var names []string
+	for _, p := range t.Params {
+		if !p.In {
+			names = append(names, p.Name)
+		}
+	}
+
+	start := strings.Index(answer, "{")
+	end := strings.LastIndex(answer, "}")
+	if start >= 0 && end > start {
+		var obj map[string]any
+		if json.Unmarshal([]byte(answer[start:end+1]), &obj) == nil {
+			for _, name := range names {
+				switch v := obj[name].(type) {
+				case nil:
+				case string: out[name] = v
+				default:
+					data, _ := json.Marshal(v)
+					out[name] = string(data)
+				}
+			}
+			return out
+		}
+	}
+	if len(names) == 1 {
+		out[names[0]] = strings.TrimSpace(answer)
+	}
+	return out
+}
+
+// pathTool lets an agent send over one of its space's outgoing =paths
+type pathTool struct {
+	call *Call
+	path *air.Path
+}
+
+type pathToolInput struct {
+	Task string `json:"task"`
+	Args map[string]string `json:"args"`
+	// optional, for :REPLICABLE destinations
+	Instance *uint64 `json:"instance"`
+}
+
+func (pt *pathTool) Name() string {
+	return pt.path.Name
+}
+
+func (pt *pathTool) Description() string {
+	m := pt.call.inst.sp.rt.m
+	dest := m.Space(pt.path.Dest)
+	var tasks []string
+	for _, id := range dest.Tasks {
+		tasks = append(tasks, "$"+m.Task(id).Name)
+	}
+	tags := pt.path.Type
+	if pt.path.Access != "" {
+		tags += ":" + pt.path.Access
+	}
+	desc := fmt.Sprintf("Path =%s:%s to @%s, which has the tasks %s. "+
+		`Input is JSON: {"task": "<task name>", "args": {"<data name>": "<value>"}}.`,
+		pt.path.Name, tags, dest.Name, strings.Join(tasks, ", "))
+	if dest.Replicable {
+		desc += ` @` + dest.Name + ` is replicable; add "instance": <id> to pick which instance.`
+	}
+	if len(pt.path.Vibe) > 0 {
+		desc += " " + strings.Join(pt.path.Vibe, " ")
+	}
+	return desc
+}
+
+func (pt *pathTool) Call(ctx context.Context, input string) (string, error) {
+	var in pathToolInput
+	if err := json.Unmarshal([]byte(input), &in); err != nil {
+		return fmt.Sprintf("error: input must be JSON of the form {\"task\": ..., \"args\": {...}}: %v", err), nil
+	}
+	in.Task = strings.TrimPrefix(in.Task, "$")
+	var out map[string]string
+	var err error
+	if in.Instance != nil {
+		out, err = pt.call.SendTo(ctx, pt.path.Name, *in.Instance, in.Task, in.Args)
+	} else {
+		out, err = pt.call.Send(ctx, pt.path.Name, in.Task, in.Args)
+	}
+	if err != nil {
+		// errors go back to the agent so it can try again
+		return "error: " + err.Error(), nil
+	}
+	if pt.path.Type == "ATTEND" {
+		return "sent", nil
+	}
+	data, _ := json.Marshal(out)
+	return string(data), nil
+}
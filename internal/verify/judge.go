@@ -0,0 +1,68 @@
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+
+	"github.com/anotherLostKitten/Anglish/internal/llm"
+)
+
+const judgeSystemPrompt = `You are reviewing code generated from an Anglish contract.
+You are given one requirement taken from the contract and the generated artifact.
+Decide whether the artifact satisfies the requirement. Only judge this one requirement.
+Answer with a single JSON object and nothing else: {"pass": true|false, "reason": "<one sentence>"}`
+
+// ModelJudge asks an LLM for each verdict.
+type ModelJudge struct {
+	model llms.Model
+}
+
+func NewModelJudge(model llms.Model) *ModelJudge {
+	return &ModelJudge{
+		model: model,
+	}
+}
+
+// NewOpenAIJudge builds a ModelJudge on the model configured for llm.NewOpenAI.
+func NewOpenAIJudge() (*ModelJudge, error) {
+	model, err := llm.NewOpenAI()
+	if err != nil {
+		return nil, err
+	}
+	return NewModelJudge(model), nil
+}
+
+func (mj *ModelJudge) Judge(ctx context.Context, q Question) (Verdict, error) {
+	prompt := fmt.Sprintf("Declaration: %s\nRequirement: %s\n\nArtifact:\n```\n%s\n```", q.Subject, q.Requirement, q.Artifact)
+	resp, err := mj.model.GenerateContent(ctx, []llms.MessageContent{
+		llms.TextParts(schema.ChatMessageTypeSystem, judgeSystemPrompt),
+		llms.TextParts(schema.ChatMessageTypeHuman, prompt),
+	}, llms.WithTemperature(0))
+	if err != nil {
+		return Verdict{}, err
+	}
+	if len(resp.Choices) == 0 {
+		return Verdict{}, fmt.Errorf("empty response from judge model")
+	}
+	return parseVerdict(resp.Choices[0].Content)
+}
+
+// parseVerdict reads the JSON verdict out of a model reply, tolerating prose or
+// code fences around it.
+func parseVerdict(reply string) (Verdict, error) {
+	start := strings.Index(reply, "{")
+	end := strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return Verdict{}, fmt.Errorf("judge reply has no verdict: %q", reply)
+	}
+	var v Verdict
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &v); err != nil {
+		return Verdict{}, fmt.Errorf("judge reply has a malformed verdict: %w", err)
+	}
+	return v, nil
+}
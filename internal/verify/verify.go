@@ -0,0 +1,85 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// Some obligations can't be extracted deterministically (see internal/rules):
+// whether an artifact follows the vibe policy of the declaration it was
+// generated from needs a judge. Verify asks a Judge about each prose line of the
+// declaration's vibe block and collects the answers into a Report.
+
+type Question struct {
+	// the declaration the artifact was generated from, eg. "=fetch"
+	Subject string
+	// one line of vibe prose, with meta-refs written out
+	Requirement string
+	Artifact string
+}
+
+type Verdict struct {
+	Pass bool `json:"pass"`
+	Reason string `json:"reason"`
+}
+
+// Judge decides one Question. ModelJudge asks an LLM; tests use a scripted fake.
+type Judge interface {
+	Judge(ctx context.Context, q Question) (Verdict, error)
+}
+
+type LineResult struct {
+	Line uint64 `json:"line"`
+	Requirement string `json:"requirement"`
+	Pass bool `json:"pass"`
+	Reason string `json:"reason"`
+}
+
+type Report struct {
+	Subject string `json:"subject"`
+	Pass bool `json:"pass"`
+	Lines []LineResult `json:"lines"`
+}
+
+func (r *Report) Failures() []LineResult {
+	var failed []LineResult
+	for _, l := range r.Lines {
+		if !l.Pass {
+			failed = append(failed, l)
+		}
+	}
+	return failed
+}
+
+// Verify judges artifact against every prose line of decl's vibe block. On a
+// judge error it returns the lines decided so far along with the error.
+func Verify(ctx context.Context, judge Judge, decl parse.ParseUnit, artifact string) (Report, error) {
+	vb := decl.GetVibe()
+	report := Report{
+		Subject: decl.GetName().ToStr(),
+		Pass: true,
+	}
+
+	lines := vb.GetProseLines()
+	for i, prose := range vb.GetProse() {
+		v, err := judge.Judge(ctx, Question{
+			Subject: report.Subject,
+			Requirement: prose,
+			Artifact: artifact,
+		})
+		if err != nil {
+			report.Pass = false
+			return report, fmt.Errorf("judging %s line %d: %w", report.Subject, lines[i], err)
+		}
+		report.Lines = append(report.Lines, LineResult{
+			Line: lines[i],
+			Requirement: prose,
+			Pass: v.Pass,
+			Reason: v.Reason,
+		})
+		report.Pass = report.Pass && v.Pass
+	}
+	return report, nil
+}
@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/check"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+	"github.com/anotherLostKitten/Anglish/internal/runtime"
+)
+
+const accessSrc = `@front:UI
+> the front desk
+$ask(in=%q, out=%a)
+> looks %a up with $get(out=%fact) over =lookup
+> and files %q with $put(in=%fact) over =file
+$recall(in=%q, out=%a)
+> asks for %a with $find(in=%key, out=%fact) over =lookup
+$poke(in=%q)
+> wakes the store with $wake over =nudge
+
+@store:CALL
+> remembers facts
+$put(in=%fact)
+> stores %fact
+$get(out=%fact)
+> returns %fact
+$find(in=%key, out=%fact)
+> returns the %fact under %key
+$wake()
+> wakes up
+
+=lookup:INVOKE:READ(@front, @store)
+> reads facts
+
+=file:WRITE:ATTEND(@front, @store)
+> writes facts
+
+=nudge:INVOKE:CONTROL(@front, @store)
+> wakes the store
+`
+
+func accessDiags(t *testing.T, src string) []check.Diagnostic {
+	t.Helper()
+	c, errs := parse.ParseFromReader(strings.NewReader(src))
+	require.Empty(t, errs)
+	po := parse.GetParseOrder(&c)
+	var diags []check.Diagnostic
+	for _, d := range check.Semantic(&c, &po, &check.DefaultRules) {
+		if d.Rule == "path-access" {
+			diags = append(diags, d)
+		}
+	}
+	return diags
+}
+
+func TestPathAccessTags(t *testing.T) {
+	c, errs := parse.ParseFromReader(strings.NewReader(accessSrc))
+	require.Empty(t, errs)
+	access := make(map[string]parse.PathAccess)
+	for _, p := range c.GetPaths() {
+		access[p.GetName().GetIdent()] = p.GetAccess()
+	}
+	require.Equal(t, map[string]parse.PathAccess{
+		"lookup": parse.READ,
+		"file": parse.WRITE,
+		"nudge": parse.CONTROL,
+	}, access)
+
+	_, errs = parse.ParseFromReader(strings.NewReader("=p:INVOKE:READ:WRITE(@a, @b)\n> p\n"))
+	require.NotEmpty(t, errs)
+}
+
+func TestPathAccessCheck(t *testing.T) {
+	require.Empty(t, accessDiags(t, accessSrc))
+
+	// a write over the read-only path, and a read over the write-only one
+	src := strings.Replace(accessSrc, "$get(out=%fact) over =lookup", "$put(in=%fact) over =lookup", 1)
+	src = strings.Replace(src, "$put(in=%fact) over =file", "$get(out=%fact) over =file", 1)
+	diags := accessDiags(t, src)
+	require.Len(t, diags, 2)
+	require.Contains(t, diags[0].Msg, "=lookup is :READ, but $put writes %fact into @store")
+	require.Contains(t, diags[1].Msg, "=file is :WRITE, but $get reads %fact from @store")
+	require.Equal(t, check.Error, diags[0].Severity)
+
+	// a keyed read is fine, a keyed call that reads nothing back is a write
+	src = strings.Replace(accessSrc, "$find(in=%key, out=%fact) over =lookup", "$find(in=%key) over =lookup", 1)
+	src = strings.Replace(src, "$find(in=%key, out=%fact)\n", "$find(in=%key)\n", 1)
+	diags = accessDiags(t, src)
+	require.Len(t, diags, 1)
+	require.Contains(t, diags[0].Msg, "=lookup is :READ, but $find writes %key into @store")
+
+	// control paths carry no data either way
+	src = strings.Replace(accessSrc, "$wake over =nudge", "$get over =nudge", 1)
+	require.Len(t, accessDiags(t, src), 1)
+}
+
+func TestRuntimePathAccess(t *testing.T) {
+	var sent error
+	rt := startRuntimeSrc(t, accessSrc, runtime.Config{Handlers: map[string]runtime.TaskFunc{
+		runtime.HandlerKey("front", "ask"): func(ctx context.Context, call *runtime.Call) (map[string]string, error) {
+			_, sent = call.Send(ctx, "lookup", "put", map[string]string{"fact": call.Args["q"]})
+			return call.Send(ctx, "lookup", "get", nil)
+		},
+		runtime.HandlerKey("front", "recall"): func(ctx context.Context, call *runtime.Call) (map[string]string, error) {
+			return call.Send(ctx, "lookup", "find", map[string]string{"key": call.Args["q"]})
+		},
+		runtime.HandlerKey("front", "poke"): func(ctx context.Context, call *runtime.Call) (map[string]string, error) {
+			return call.Send(ctx, "nudge", "wake", map[string]string{"q": call.Args["q"]})
+		},
+		runtime.HandlerKey("store", "get"): func(_ context.Context, call *runtime.Call) (map[string]string, error) {
+			return map[string]string{"fact": "sky is blue"}, nil
+		},
+		runtime.HandlerKey("store", "find"): func(_ context.Context, call *runtime.Call) (map[string]string, error) {
+			return map[string]string{"fact": call.Args["key"] + " is blue"}, nil
+		},
+	}})
+
+	ctx := context.Background()
+	out, err := rt.Call(ctx, "front", "ask", map[string]string{"q": "sky?"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"fact": "sky is blue"}, out)
+	require.ErrorContains(t, sent, "=lookup is :READ, but $put writes %fact")
+
+	// a :READ path carries the request key in, as long as data comes back
+	out, err = rt.Call(ctx, "front", "recall", map[string]string{"q": "sky"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"fact": "sky is blue"}, out)
+
+	_, err = rt.Call(ctx, "front", "poke", map[string]string{"q": "up"})
+	require.ErrorContains(t, err, "=nudge is :CONTROL and cannot carry data")
+}
@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/air"
+	"github.com/anotherLostKitten/Anglish/internal/air/lower"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+const airSrc = `@front:UI:REPLICABLE(in=%user)
+> the front $use(@store)
+#helper:AF
+> helps $use(@store)
+$ask(in=%q, out=%a)
+> sends %q over =fetch and gets %a
+
+@store:CALL
+> the store
+
+=fetch:INVOKE(@front, @store)
+> reads
+`
+
+func lowerSrc(t *testing.T, src string) *air.Module {
+	t.Helper()
+	c, errs := parse.ParseFromReader(strings.NewReader(src))
+	require.Empty(t, errs)
+	po := parse.GetParseOrder(&c)
+	m, lower_errs := lower.Lower(&po)
+	require.Empty(t, lower_errs)
+	require.Empty(t, air.Validate(m))
+	return m
+}
+
+func TestAirLower(t *testing.T) {
+	m := lowerSrc(t, airSrc)
+
+	front, ok := m.Lookup(air.KindSpace, "front")
+	require.True(t, ok)
+	store, ok := m.Lookup(air.KindSpace, "store")
+	require.True(t, ok)
+	s := m.Space(front)
+	require.Equal(t, "UI", s.Type)
+	require.True(t, s.Replicable)
+	require.Equal(t, []air.Param{{Name: "user", In: true}}, s.Params)
+	require.Equal(t, []uint32{store}, s.Imports)
+	require.Len(t, s.Agents, 1)
+	require.Equal(t, int32(front), m.Agent(s.Agents[0]).Parent)
+
+	fetch, ok := m.Lookup(air.KindPath, "fetch")
+	require.True(t, ok)
+	require.Equal(t, front, m.Path(fetch).Source)
+	require.Equal(t, store, m.Path(fetch).Dest)
+	require.Equal(t, air.NoParent, m.Path(fetch).Parent)
+	require.Len(t, m.Order, len(m.Kinds))
+}
+
+func TestAirRoundTrip(t *testing.T) {
+	m := lowerSrc(t, airSrc)
+
+	bin, err := m.MarshalBinary()
+	require.NoError(t, err)
+	from_bin, err := air.Decode(bin)
+	require.NoError(t, err)
+	require.Equal(t, m, from_bin)
+
+	js, err := json.Marshal(m)
+	require.NoError(t, err)
+	from_json, err := air.Decode(js)
+	require.NoError(t, err)
+	require.Equal(t, m, from_json)
+
+	_, err = air.DecodeBinary(bin[:len(bin)-1])
+	require.Error(t, err)
+}
+
+func TestAirValidateBadRefs(t *testing.T) {
+	m := lowerSrc(t, airSrc)
+	m.Paths[0].Dest = m.Paths[0].ID
+	m.Order = m.Order[1:]
+	errs := air.Validate(m)
+	require.Len(t, errs, 2)
+}
+
+func TestAirNesting(t *testing.T) {
+	m := lowerSrc(t, nestedSrc)
+	space := func(name string) *air.Space {
+		id, ok := m.Lookup(air.KindSpace, name)
+		require.True(t, ok)
+		return m.Space(id)
+	}
+	app, login, captcha, cart := space("app"), space("login"), space("captcha"), space("cart")
+	require.Equal(t, air.NoParent, app.Parent)
+	require.Equal(t, air.NoParent, space("shop").Parent)
+	require.Equal(t, int32(app.ID), login.Parent)
+	require.Equal(t, int32(login.ID), captcha.Parent)
+	require.Equal(t, int32(app.ID), cart.Parent)
+	require.Equal(t, []uint32{login.ID, cart.ID}, app.Spaces)
+	require.Equal(t, []uint32{captcha.ID}, login.Spaces)
+
+	verify, ok := m.Lookup(air.KindPath, "verify")
+	require.True(t, ok)
+	open, ok := m.Lookup(air.KindPath, "open")
+	require.True(t, ok)
+	require.Equal(t, int32(login.ID), m.Path(verify).Parent)
+	require.Equal(t, int32(app.ID), m.Path(open).Parent)
+	require.Equal(t, []uint32{verify}, login.Paths)
+	require.Equal(t, []uint32{open}, app.Paths)
+
+	// both forms keep the nesting
+	bin, err := m.MarshalBinary()
+	require.NoError(t, err)
+	from_bin, err := air.Decode(bin)
+	require.NoError(t, err)
+	require.Equal(t, m, from_bin)
+	js, err := json.Marshal(m)
+	require.NoError(t, err)
+	from_json, err := air.Decode(js)
+	require.NoError(t, err)
+	require.Equal(t, m, from_json)
+
+	// a subspace its parent does not list, and a space nested in itself
+	cart.Parent = int32(login.ID)
+	require.Len(t, air.Validate(m), 1)
+	cart.Parent = int32(app.ID)
+	app.Parent, captcha.Spaces = int32(captcha.ID), []uint32{app.ID}
+	errs := air.Validate(m)
+	require.NotEmpty(t, errs)
+	require.ErrorContains(t, errs[0], "nested in itself")
+}
@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+	"github.com/anotherLostKitten/Anglish/internal/refactor"
+)
+
+// go test ./tests -run '^$' -bench . -benchmem
+
+// genContract writes a contract of about n declarations, made the way real
+// ones are: spaces of tasks and agents, some nested, paths between them and a
+// few lines of vibe apiece, with references in the prose
+func genContract(n int) string {
+	var b strings.Builder
+	b.WriteString("#helper:DF\n> a hand for every keeper\n\n")
+	spaces := 0
+	for decls := 0; decls < n; spaces++ {
+		fmt.Fprintf(&b, "// the %dth space\n", spaces)
+		fmt.Fprintf(&b, "@space%d:DATA(in=%%input%d, out=%%output%d)\n", spaces, spaces, spaces)
+		b.WriteString("> keeps the records for one part of the service, and answers\n")
+		fmt.Fprintf(&b, ">   questions about %%input%d from the spaces around it\n", spaces)
+		fmt.Fprintf(&b, "#keeper%d:AF\n> looks after the records, with $use(#helper)\n", spaces)
+		for task := 0; task < 6; task++ {
+			fmt.Fprintf(&b, "$task%d(in=%%input%d, out=%%result%d)\n", task, spaces, task)
+			fmt.Fprintf(&b, "> takes %%input%d and works out %%result%d, then hands it on\n", spaces, task)
+			if task > 0 {
+				fmt.Fprintf(&b, "> with $task%d(in=%%result%d) once that is done\n", task - 1, task - 1)
+			}
+		}
+		fmt.Fprintf(&b, "  @inner%d:IO\n  > the part that talks to the outside\n  $fetch(out=%%page)\n  > fetches a page\n", spaces)
+		b.WriteString("\n")
+		decls += 10
+		if spaces > 0 {
+			fmt.Fprintf(&b, "=link%d:INVOKE:READ(@space%d, @space%d)\n> asks for the records of %%input%d\n\n", spaces, spaces, spaces - 1, spaces - 1)
+			decls++
+		}
+	}
+	return b.String()
+}
+
+func benchmarkParse(b *testing.B, n int) {
+	src := genContract(n)
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parse.ParseFromReader(strings.NewReader(src))
+	}
+}
+
+func BenchmarkParse100(b *testing.B) { benchmarkParse(b, 100) }
+func BenchmarkParse1k(b *testing.B) { benchmarkParse(b, 1000) }
+func BenchmarkParse10k(b *testing.B) { benchmarkParse(b, 10000) }
+func BenchmarkParse100k(b *testing.B) { benchmarkParse(b, 100000) }
+
+func BenchmarkLex10k(b *testing.B) {
+	src := genContract(10000)
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parse.Lex(src)
+	}
+}
+
+// an edit in the middle of a large contract, as an editor makes on each keystroke
+func BenchmarkDocumentEdit10k(b *testing.B) {
+	src := genContract(10000)
+	doc := parse.NewDocument(src, parse.ParseConfig{})
+	at := uint64(strings.Index(src, "@space500:"))
+	at += uint64(strings.Index(src[at:], "answers"))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		text := "x"
+		if i % 2 == 1 {
+			text = ""
+		}
+		doc.Edit(parse.TextEdit{Span: parse.Span{Start: at, End: at + uint64(1 - len(text))}, NewText: text})
+	}
+}
+
+func TestGenContract(t *testing.T) {
+	src := genContract(1000)
+	c, errs := parse.ParseFromReader(strings.NewReader(src))
+	require.Empty(t, errs)
+	po := parse.GetParseOrder(&c)
+	require.GreaterOrEqual(t, po.Len(), 1000)
+}
+
+// Parsing takes time in proportion to the source, whatever is on its lines.
+// These used to take seconds, each token costing as much as the line before it.
+// Twenty times the source should take about twenty times as long, nowhere near
+// the four hundred that would be; a ratio of times is as slow on a busy machine
+// as on an idle one.
+func TestParseLinear(t *testing.T) {
+	if testing.Short() {
+		t.Skip("times parsing")
+	}
+	units := []string{"$a(", "@a.$b(", "> $a(in=%x,", "a ", "%x "}
+	parseAll := func(reps int) testing.BenchmarkResult {
+		var srcs []string
+		for _, unit := range units {
+			srcs = append(srcs, strings.Repeat(unit, reps))
+		}
+		return testing.Benchmark(func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, src := range srcs {
+					parse.ParseFromReader(strings.NewReader(src))
+				}
+			}
+		})
+	}
+	small, large := parseAll(2000), parseAll(40000)
+	ratio := float64(large.NsPerOp()) / float64(small.NsPerOp())
+	require.Less(t, ratio, 60.0, "%v against %v", large, small)
+}
+
+func benchmarkParseOrder(b *testing.B, n int) {
+	c, _ := parse.ParseFromReader(strings.NewReader(genContract(n)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parse.GetParseOrder(&c)
+	}
+}
+
+func BenchmarkParseOrder1k(b *testing.B) { benchmarkParseOrder(b, 1000) }
+func BenchmarkParseOrder10k(b *testing.B) { benchmarkParseOrder(b, 10000) }
+
+// what an editor asks before renaming, on a contract it has open
+func BenchmarkRenameAt10k(b *testing.B) {
+	src := genContract(10000)
+	c, _ := parse.ParseFromReader(strings.NewReader(src))
+	po := parse.GetParseOrder(&c)
+	at := uint64(strings.Index(src, "$task2(in=%result2)") + 2)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ix := refactor.NewIndex(&po)
+		ix.At(at)
+		ix.Rename(src, 0, 1, "aide")
+	}
+}
@@ -0,0 +1,196 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/generate"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// otherModel is recordingGenerator on another model
+type otherModel struct {
+	recordingGenerator
+}
+
+func (om *otherModel) Config() string {
+	return "other"
+}
+
+func TestBuildCache(t *testing.T) {
+	ctx := context.Background()
+	cache, err := generate.OpenCache(t.TempDir(), 0)
+	require.NoError(t, err)
+	doc := parse.NewDocument(generateSrc, parse.ParseConfig{})
+
+	gen := &recordingGenerator{}
+	_, err = generate.NewBuilder(gen, cache).Build(ctx, doc.Contract(), doc.Order())
+	require.NoError(t, err)
+	require.Len(t, gen.subjects(), doc.Order().Len())
+
+	// a new builder, as in a new process, finds it all in the cache
+	results, err := generate.NewBuilder(gen, cache).Build(ctx, doc.Contract(), doc.Order())
+	require.NoError(t, err)
+	require.Empty(t, gen.subjects())
+	for _, res := range results {
+		require.True(t, res.Reused)
+		require.Equal(t, "code for " + res.Subject, res.Text)
+	}
+
+	// nor does spacing or a comment change the key
+	editDoc(t, doc, "> returns %fact", ">   returns   %fact  \n  // why\n")
+	_, err = generate.NewBuilder(gen, cache).Build(ctx, doc.Contract(), doc.Order())
+	require.NoError(t, err)
+	require.Empty(t, gen.subjects())
+
+	// but another model does
+	other := &otherModel{}
+	_, err = generate.NewBuilder(other, cache).Build(ctx, doc.Contract(), doc.Order())
+	require.NoError(t, err)
+	require.Len(t, other.subjects(), doc.Order().Len())
+
+	entries, err := cache.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 2 * doc.Order().Len())
+}
+
+func TestCacheIntegrity(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := generate.OpenCache(dir, 0)
+	require.NoError(t, err)
+	key := generate.Key("$t()\n> t", nil, "test")
+	require.NoError(t, cache.Put(generate.Entry{Key: key, Subject: "$t", Text: "code"}))
+	e, ok := cache.Get(key)
+	require.True(t, ok)
+	require.Equal(t, "code", e.Text)
+
+	problems, err := cache.Verify(false)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+
+	// someone edits the artifact
+	paths, _ := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+	require.Len(t, paths, 1)
+	data, err := os.ReadFile(paths[0])
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(paths[0], []byte(strings.Replace(string(data), `"code"`, `"c0de"`, 1)), 0644))
+
+	problems, err = cache.Verify(false)
+	require.NoError(t, err)
+	require.Len(t, problems, 1)
+	require.Contains(t, problems[0].Err.Error(), "checksum")
+
+	// a bad entry is a miss, and is dropped
+	_, ok = cache.Get(key)
+	require.False(t, ok)
+	problems, err = cache.Verify(false)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+}
+
+func TestCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	keys := make(map[string]string)
+	for _, name := range []string{"aa01", "bb02", "cc03", "dd04", "ee05", "ff06"} {
+		keys[name] = generate.Key(name, nil, "test")
+	}
+	cache, err := generate.OpenCache(dir, 0)
+	require.NoError(t, err)
+	text := strings.Repeat("x", 1000)
+	put := func(name string) {
+		require.NoError(t, cache.Put(generate.Entry{Key: keys[name], Subject: name, Text: text}))
+	}
+	put("aa01")
+	put("bb02")
+	entries, err := cache.List()
+	require.NoError(t, err)
+	size := entries[0].Size
+
+	// room for two; aa01 was used since bb02 was made, so bb02 goes
+	_, ok := cache.Get(keys["aa01"])
+	require.True(t, ok)
+	limited, err := generate.OpenCache(dir, 2 * size + size / 2)
+	require.NoError(t, err)
+	require.NoError(t, limited.Put(generate.Entry{Key: keys["cc03"], Subject: "cc03", Text: text}))
+	_, ok = cache.Get(keys["bb02"])
+	require.False(t, ok)
+	_, ok = cache.Get(keys["aa01"])
+	require.True(t, ok)
+	_, ok = cache.Get(keys["cc03"])
+	require.True(t, ok)
+
+	// writing an entry again replaces its size rather than adding to it
+	for i := 0; i < 5; i++ {
+		require.NoError(t, limited.Put(generate.Entry{Key: keys["cc03"], Subject: "cc03", Text: text}))
+	}
+	entries, err = cache.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	// past the limit, eviction leaves room for the next entries
+	for _, name := range []string{"dd04", "ee05", "ff06"} {
+		require.NoError(t, limited.Put(generate.Entry{Key: keys[name], Subject: name, Text: text}))
+		entries, err = cache.List()
+		require.NoError(t, err)
+		total := int64(0)
+		var subjects []string
+		for _, e := range entries {
+			total += e.Size
+			subjects = append(subjects, e.Subject)
+		}
+		require.LessOrEqual(t, total, 2 * size + size / 2)
+		require.Contains(t, subjects, name)
+	}
+
+	// clear leaves alone what is not the cache's
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("mine"), 0644))
+	require.NoError(t, cache.Clear())
+	entries, err = cache.List()
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	_, err = os.Stat(filepath.Join(dir, "notes.txt"))
+	require.NoError(t, err)
+}
+
+// only <key[:2]>/<key>.json is an entry; other .json files are never listed,
+// evicted or cleared
+func TestCacheForeignFiles(t *testing.T) {
+	dir := t.TempDir()
+	key := generate.Key("$t()\n> t", nil, "test")
+	foreign := []string{
+		"package.json",
+		filepath.Join(key[:2], "package.json"),
+		filepath.Join(key[:2], "ab.json"),
+		filepath.Join("zz", key + ".json"),
+		filepath.Join("node_modules", key[:2], key + ".json"),
+	}
+	for _, name := range foreign {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(`{"name": "mine"}`), 0644))
+	}
+
+	// a one byte limit evicts every entry as soon as it is put
+	cache, err := generate.OpenCache(dir, 1)
+	require.NoError(t, err)
+	require.Error(t, cache.Put(generate.Entry{Key: "ab", Subject: "$t", Text: "code"}))
+	require.NoError(t, cache.Put(generate.Entry{Key: key, Subject: "$t", Text: "code"}))
+	entries, err := cache.List()
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	problems, err := cache.Verify(true)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+	_, err = cache.Evict(0)
+	require.NoError(t, err)
+	require.NoError(t, cache.Clear())
+	for _, name := range foreign {
+		_, err = os.Stat(filepath.Join(dir, name))
+		require.NoError(t, err, name)
+	}
+}
@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/convert"
+	"github.com/anotherLostKitten/Anglish/internal/diff"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+const commentedSrc = `// anglish:ignore-file ident-case
+@front:UI:REPLICABLE(in=%user)
+> the front desk,    for %user
+// anglish:ignore unused-task
+$ask(in=%q, out=%a)
+> asks @store.$get(in=%q) first
+  // the inner one
+  @inner:DATA
+  > inner
+#clerk:AF(out=%note)
+> writes %note
+
+@store:CALL
+$get(in=%q, out=%fact)
+> returns %fact
+
+#boss:DF
+> runs things
+
+// the only path
+=lookup:INVOKE:READ(@front, @store)
+> looks up
+
+// the end
+`
+
+func TestConvertRoundTrip(t *testing.T) {
+	// the last few end without a newline, where the parser keeps an empty
+	// vibe line or a trailing space
+	for _, src := range []string{commentedSrc, nestedSrc, accessSrc, airSrc, scopeSrc, routesSrc, unicodeSrc,
+		"@A0\n>", "#A:AF\n> ", "#A:AF\n>$"} {
+		c, errs := parse.ParseFromReader(strings.NewReader(src))
+		require.Empty(t, errs)
+		d := convert.FromContract(&c)
+
+		for _, f := range []convert.Format{convert.JSON, convert.YAML} {
+			data, err := convert.Marshal(&d, f)
+			require.NoError(t, err)
+			back, err := convert.Unmarshal(data, f)
+			require.NoError(t, err)
+			require.Equal(t, d, back)
+
+			c2, errs := convert.ToContract(&back)
+			require.Empty(t, errs, d.Source())
+			require.Equal(t, d, convert.FromContract(&c2))
+
+			// and it resolves the same
+			po, po2 := parse.GetParseOrder(&c), parse.GetParseOrder(&c2)
+			require.Equal(t, po.Len(), po2.Len())
+			report := diff.Compare(&po, &po2)
+			require.True(t, report.Empty())
+			for i := 0; i < po.Len(); i++ {
+				require.Equal(t, po.GetQualifiedName(uint64(i)), po2.GetQualifiedName(uint64(i)))
+				require.Equal(t, po.GetDeps(uint64(i)), po2.GetDeps(uint64(i)))
+			}
+			require.Equal(t, po.GetSorted(), po2.GetSorted())
+		}
+	}
+}
+
+func TestConvertComments(t *testing.T) {
+	c, errs := parse.ParseFromReader(strings.NewReader(commentedSrc))
+	require.Empty(t, errs)
+	d := convert.FromContract(&c)
+	require.Equal(t, []string{"anglish:ignore-file ident-case"}, d.Spaces[0].Comments)
+	require.Equal(t, []string{"anglish:ignore unused-task"}, d.Spaces[0].Tasks[0].Comments)
+	require.Equal(t, []string{"the inner one"}, d.Spaces[0].Spaces[0].Comments)
+	require.Equal(t, []string{"the only path"}, d.Paths[0].Comments)
+	require.Equal(t, []string{"the end"}, d.Comments)
+	require.Equal(t, []string{"the front desk, for %user"}, d.Spaces[0].Vibe)
+}
+
+func TestConvertErrors(t *testing.T) {
+	_, err := convert.Unmarshal([]byte(`{"version": 1, "spaces": [{"name": "a", "kind": "UI"}]}`), convert.JSON)
+	require.Error(t, err)
+	_, err = convert.Unmarshal([]byte("version: 1\nspaces:\n  - name: a\n    kind: UI\n"), convert.YAML)
+	require.Error(t, err)
+
+	d, err := convert.Unmarshal([]byte(`
+version: 1
+spaces:
+  - name: front
+    type: GUI
+    tasks:
+      - name: ask
+        vibe: ["asks", "and\nmore"]
+paths:
+  - name: p
+    type: INVOKE
+    from: front
+    to: 2back
+`), convert.YAML)
+	require.NoError(t, err)
+	_, errs := convert.ToContract(&d)
+	var msgs []string
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+	require.Equal(t, []string{
+		`spaces[0]: unknown space type "GUI", expected one of , UI, IO, DATA, CALL, CHAT`,
+		`spaces[0].tasks[0].vibe[1]: must be a single line`,
+		`paths[0]: space name "2back" is not an identifier`,
+	}, msgs)
+
+	// what only the parser finds is reported where it is in the document
+	d = convert.Document{Version: convert.Version, Spaces: []convert.Space{{
+		Name: "front",
+		Vibe: []string{"fine"},
+		Tasks: []convert.Task{{Name: "ask", Vibe: []string{"uses $use(%x)"}}},
+	}}}
+	_, errs = convert.ToContract(&d)
+	require.NotEmpty(t, errs)
+	require.True(t, strings.HasPrefix(errs[0].Error(), "spaces[0].tasks[0].vibe[0]: "), errs[0].Error())
+}
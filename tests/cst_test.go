@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+const cstSrc = `// a contract with    odd spacing
+@front:UI
+>   the front desk,   see http://example.com
+$ask( in=%q ,out=%a )
+> asks @store.$get(out=%fact)   then $use(@store) and =lookup with %q
+
+@store:CALL
+> remembers facts
+$get(out=%fact)
+> returns %fact
+
+=lookup:INVOKE( @front , @store )
+`
+
+func TestSyntaxTreeLossless(t *testing.T) {
+	for _, src := range []string{cstSrc, halfTypedSrc, nestedSrc, runtimeSrc, "", "\n\n  \t", "@a\r\n> a\r\n"} {
+		tree := parse.Lex(src)
+		require.Equal(t, src, tree.String())
+
+		c, _ := parse.ParseFromReader(strings.NewReader(src))
+		require.Equal(t, src, c.GetSyntaxTree().String())
+	}
+}
+
+func TestSyntaxTreeTokens(t *testing.T) {
+	tree := parse.Lex(cstSrc)
+	toks := tree.Tokens()
+
+	// the comment is trivia of the first token
+	require.Equal(t, parse.TokenAt, toks[0].Kind)
+	require.Equal(t, parse.TriviaComment, tree.Leading(0)[0].Kind)
+	require.Equal(t, uint64(1), toks[0].Line)
+	require.Equal(t, parse.TokenEOF, toks[len(toks)-1].Kind)
+
+	// "//" inside a vibe line is prose, not a comment
+	offset := uint64(strings.Index(cstSrc, "http"))
+	i, ok := tree.TokenAt(offset)
+	require.True(t, ok)
+	require.Equal(t, parse.TokenIdent, toks[i].Kind)
+	require.Equal(t, parse.TokenColon, toks[i+1].Kind)
+
+	line, col := tree.Position(offset)
+	require.Equal(t, uint64(2), line)
+	back, ok := tree.Offset(line, col)
+	require.True(t, ok)
+	require.Equal(t, offset, back)
+}
+
+func TestAstSpans(t *testing.T) {
+	c, errs := parse.ParseFromReader(strings.NewReader(cstSrc))
+	require.Empty(t, errs)
+	tree := c.GetSyntaxTree()
+
+	front := &c.GetSpaces()[0]
+	require.Equal(t, "front", tree.Text(front.GetNameSpan()))
+	require.True(t, strings.HasPrefix(tree.Text(front.GetSpan()), "@front:UI\n"))
+	require.True(t, strings.HasSuffix(tree.Text(front.GetSpan()), "with %q"))
+
+	// vibe prose is normalised, but the spans give it back as written
+	vb := front.GetVibe()
+	require.Equal(t, "the front desk, see http://example.com", vb.GetProse()[0])
+	require.Equal(t, "the front desk,   see http://example.com", tree.Text(vb.GetProseSpans()[0]))
+	require.Equal(t, ">   the front desk,   see http://example.com", tree.Text(vb.GetSpan()))
+
+	ask := &front.GetTasks()[0]
+	require.Equal(t, "$ask( in=%q ,out=%a )\n> asks @store.$get(out=%fact)   then $use(@store) and =lookup with %q", tree.Text(ask.GetSpan()))
+	params := ask.GetParams()
+	require.Equal(t, "in=%q", tree.Text(params[0].GetSpan()))
+	require.Equal(t, "a", tree.Text(params[1].GetNameSpan()))
+
+	var texts, names []string
+	for _, mr := range ask.GetVibe().GetMetaRefs() {
+		texts = append(texts, tree.Text(mr.GetSpan()))
+		names = append(names, tree.Text(mr.GetNameSpan()))
+	}
+	require.Equal(t, []string{"@store.$get(out=%fact)", "$use(@store)", "=lookup", "%q"}, texts)
+	require.Equal(t, []string{"get", "store", "lookup", "q"}, names)
+	get := ask.GetVibe().GetMetaRefs()[0].(*parse.MetaRefTask)
+	require.Equal(t, "store", tree.Text(get.GetSpaceSpan()))
+
+	lookup := &c.GetPaths()[0]
+	require.Equal(t, "=lookup:INVOKE( @front , @store )", tree.Text(lookup.GetSpan()))
+	require.Equal(t, "front", tree.Text(lookup.GetSourceSpan()))
+	require.Equal(t, "store", tree.Text(lookup.GetDestSpan()))
+}
+
+func TestApplyEdits(t *testing.T) {
+	c, errs := parse.ParseFromReader(strings.NewReader(cstSrc))
+	require.Empty(t, errs)
+	lookup := &c.GetPaths()[0]
+	edited, err := parse.ApplyEdits(cstSrc, []parse.TextEdit{
+		{Span: lookup.GetDestSpan(), NewText: "cache"},
+		{Span: lookup.GetNameSpan(), NewText: "fetch"},
+	})
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(edited, "=fetch:INVOKE( @front , @cache )\n"))
+	// the rest is untouched, odd spacing and all
+	require.True(t, strings.HasPrefix(edited, cstSrc[:lookup.GetSpan().Start]))
+
+	_, err = parse.ApplyEdits(cstSrc, []parse.TextEdit{
+		{Span: parse.Span{Start: 2, End: 6}},
+		{Span: parse.Span{Start: 4, End: 8}},
+	})
+	require.Error(t, err)
+}
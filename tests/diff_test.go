@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/diff"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+func compareSrc(t *testing.T, old, new string) diff.Report {
+	old_c, errs := parse.ParseFromReader(strings.NewReader(old))
+	require.Empty(t, errs)
+	new_c, errs := parse.ParseFromReader(strings.NewReader(new))
+	require.Empty(t, errs)
+	old_po, new_po := parse.GetParseOrder(&old_c), parse.GetParseOrder(&new_c)
+	return diff.Compare(&old_po, &new_po)
+}
+
+func TestDiffSame(t *testing.T) {
+	// spacing and comments are not differences
+	r := compareSrc(t, generateSrc, strings.NewReplacer("> returns %fact", ">   returns   %fact ", "@store:CALL", "// why\n@store:CALL").Replace(generateSrc))
+	require.True(t, r.Empty())
+	require.Empty(t, r.Stale)
+}
+
+func TestDiffChanges(t *testing.T) {
+	new := strings.NewReplacer(
+		"@other:CALL\n> unrelated\n", "#clerk:AF\n> new\n",
+		"$get(out=%fact)", "$get(in=%k, out=%fact)",
+		"> returns %fact", "> returns %fact quickly",
+		"=lookup:INVOKE(", "=lookup:ATTEND(",
+	).Replace(generateSrc)
+	r := compareSrc(t, generateSrc, new)
+
+	require.Equal(t, []diff.Decl{{Name: "#clerk", Kind: "agent"}}, r.Added)
+	require.Equal(t, []diff.Decl{{Name: "@other", Kind: "space"}}, r.Removed)
+	require.Len(t, r.Changed, 2)
+
+	lookup := r.Changed[0]
+	require.Equal(t, "=lookup", lookup.Name)
+	require.Equal(t, []string{"type: INVOKE -> ATTEND"}, lookup.Tags)
+	require.Nil(t, lookup.Params)
+
+	get := r.Changed[1]
+	require.Equal(t, "@store.$get", get.Name)
+	require.Empty(t, get.Tags)
+	require.Equal(t, []string{"in=%k"}, get.Params.Added)
+	require.Equal(t, []string{"returns %fact"}, get.Prose.Removed)
+	require.Equal(t, []string{"returns %fact quickly"}, get.Prose.Added)
+	require.Nil(t, get.Refs)
+
+	// $ask uses $get, and @store holds it
+	var stale []string
+	for _, d := range r.Stale {
+		stale = append(stale, d.Name)
+	}
+	require.Contains(t, stale, "@front.$ask")
+	require.NotContains(t, stale, "@store.$get")
+}
+
+func TestDiffRefsAreNotProse(t *testing.T) {
+	src := "@a:UI\n> uses %x here\n\n@b:UI\n> b\n"
+	r := compareSrc(t, src, strings.Replace(src, "%x", "%y", 1))
+	require.Len(t, r.Changed, 1)
+	c := r.Changed[0]
+	require.Nil(t, c.Prose)
+	require.Equal(t, &diff.Delta{Removed: []string{"%x"}, Added: []string{"%y"}}, c.Refs)
+
+	out, err := json.Marshal(r)
+	require.NoError(t, err)
+	require.Contains(t, string(out), `"refs":{"removed":["%x"],"added":["%y"]}`)
+	require.NotContains(t, string(out), `"prose"`)
+}
+
+// buildAnglish builds the anglish command into a temporary directory
+func buildAnglish(t *testing.T) string {
+	t.Helper()
+	bin := filepath.Join(t.TempDir(), "anglish")
+	out, err := exec.Command("go", "build", "-o", bin, "../cmd/anglish").CombinedOutput()
+	require.NoError(t, err, string(out))
+	return bin
+}
+
+// stdout is for the report alone, even when resolving the contracts finds a
+// cycle, as basic.ang's $get and @front are in
+func TestDiffJSONCommand(t *testing.T) {
+	bin := buildAnglish(t)
+	old_path := filepath.Join("testdata", "parse", "basic.ang")
+	src, err := os.ReadFile(old_path)
+	require.NoError(t, err)
+	new_path := filepath.Join(t.TempDir(), "b2.ang")
+	require.NoError(t, os.WriteFile(new_path, []byte(strings.Replace(string(src), "remembers facts", "forgets facts", 1)), 0644))
+
+	c, _ := parse.ParseFromReader(strings.NewReader(string(src)))
+	po := parse.GetParseOrder(&c)
+	require.NotEmpty(t, po.GetProblems())
+
+	cmd := exec.Command(bin, "diff", "-json", old_path, new_path)
+	out, err := cmd.Output()
+	var exit *exec.ExitError
+	require.ErrorAs(t, err, &exit)
+	require.Equal(t, 1, exit.ExitCode())
+
+	var r diff.Report
+	require.NoError(t, json.Unmarshal(out, &r), string(out))
+	require.Len(t, r.Changed, 1)
+	require.Equal(t, "@store", r.Changed[0].Name)
+}
@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/doc"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+const docSrc = `@front:UI
+> the front desk
+$ask(in=%q, out=%a)
+> asks @store.$get() with %q
+
+@store:CALL:REPLICABLE
+$get(out=%fact)
+> returns %fact <fast>
+
+=lookup:INVOKE(@front, @store)
+> lookups
+
+@log:IO
+> records =lookup
+`
+
+func renderDoc(t *testing.T, format doc.Format) map[string]string {
+	c, errs := parse.ParseFromReader(strings.NewReader(docSrc))
+	require.Empty(t, errs)
+	po := parse.GetParseOrder(&c)
+	rt := parse.GetRoutingTable(&po)
+	files, err := doc.Render(&po, &rt, doc.Options{Format: format, Title: "shop"})
+	require.NoError(t, err)
+	return files
+}
+
+func TestDocHTML(t *testing.T) {
+	files := renderDoc(t, doc.HTML)
+	require.Len(t, files, 4)
+	index, front, store := files["index.html"], files["space.front.html"], files["space.store.html"]
+	log := files["space.log.html"]
+
+	// the graph, as DOT without Graphviz
+	require.Contains(t, index, `<pre class="dot">digraph contract`)
+	require.Contains(t, index, `<a href="space.front.html">@front</a>`)
+	require.Contains(t, index, `<h3 id="path.lookup">=lookup</h3>`)
+
+	require.Contains(t, store, "<b>Replicable:</b> yes")
+	// meta-refs link to their declarations, and data to its param
+	require.Contains(t, front, `<a href="space.store.html#space.store.task.get">@store.$get()</a>`)
+	require.Contains(t, log, `<a href="index.html#path.lookup">=lookup</a>`)
+	require.Contains(t, front, `<a href="space.front.html#space.front.task.ask.data.q">%q</a>`)
+	require.Contains(t, store, `<span id="space.store.task.get.data.fact">out <code>%fact</code></span>`)
+	// prose is escaped
+	require.Contains(t, store, "&lt;fast&gt;")
+
+	// used by
+	require.Contains(t, store, `<b>Used by:</b> <a href="space.front.html#space.front.task.ask">@front.$ask</a>, <a href="index.html#path.lookup">=lookup</a>`)
+}
+
+func TestDocMarkdown(t *testing.T) {
+	files := renderDoc(t, doc.Markdown)
+	require.Len(t, files, 4)
+	require.Contains(t, files["index.md"], "```dot\ndigraph contract")
+	front := files["space.front.md"]
+	require.Contains(t, front, "<a id=\"space.front.task.ask\"></a>\n### @front.$ask")
+	require.Contains(t, front, "asks [@store.$get()](space.store.md#space.store.task.get) with [%q](space.front.md#space.front.task.ask.data.q)")
+	require.Contains(t, files["index.md"], "**Used by:** [@log](space.log.md)")
+}
@@ -0,0 +1,37 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// go test ./tests -run '^$' -fuzz FuzzParse -fuzztime 1m
+func FuzzParse(f *testing.F) {
+	paths, _ := filepath.Glob(filepath.Join("testdata", "parse", "*.ang"))
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		require.NoError(f, err)
+		f.Add(string(src))
+	}
+	for _, snippet := range incrementalSnippets {
+		f.Add(snippet)
+	}
+	f.Add("")
+	f.Add("@")
+	f.Add("@a(in=%")
+	f.Add("> $t(in=%x,")
+	f.Add("@a\n> @b.$")
+	f.Add("\xff@a\n> \xfe")
+
+	f.Fuzz(func(t *testing.T, src string) {
+		c, errs := parse.ParseFromReader(strings.NewReader(src))
+		requireInBounds(t, src, &c, errs)
+		require.Equal(t, src, c.GetSyntaxTree().String())
+	})
+}
@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/generate"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+const generateSrc = `@front:UI
+> the front desk
+$ask(in=%q, out=%a)
+> asks @store.$get()
+
+@store:CALL
+$get(out=%fact)
+> returns %fact
+
+@other:CALL
+> unrelated
+
+=lookup:INVOKE(@front, @store)
+`
+
+// recordingGenerator makes up an artifact for each request, failing for the
+// subjects in fail
+type recordingGenerator struct {
+	asked []generate.Request
+	fail map[string]bool
+}
+
+func (rg *recordingGenerator) Generate(_ context.Context, req generate.Request) (string, error) {
+	rg.asked = append(rg.asked, req)
+	if rg.fail[req.Subject] {
+		return "", errors.New("no")
+	}
+	return "code for " + req.Subject, nil
+}
+
+func (rg *recordingGenerator) Config() string {
+	return "recording"
+}
+
+func (rg *recordingGenerator) subjects() []string {
+	var subjects []string
+	for _, req := range rg.asked {
+		subjects = append(subjects, req.Subject)
+	}
+	sort.Strings(subjects)
+	rg.asked = nil
+	return subjects
+}
+
+func editDoc(t *testing.T, doc *parse.Document, old, new string) {
+	at := strings.Index(doc.Source(), old)
+	require.GreaterOrEqual(t, at, 0, old)
+	require.NoError(t, doc.Edit(parse.TextEdit{Span: parse.Span{Start: uint64(at), End: uint64(at + len(old))}, NewText: new}))
+}
+
+func TestBuildRegeneratesWhatChanged(t *testing.T) {
+	ctx := context.Background()
+	gen := &recordingGenerator{}
+	b := generate.NewBuilder(gen, nil)
+	doc := parse.NewDocument(generateSrc, parse.ParseConfig{})
+
+	results, err := b.Build(ctx, doc.Contract(), doc.Order())
+	require.NoError(t, err)
+	require.Len(t, results, doc.Order().Len())
+	require.Equal(t, []string{"=lookup", "@front", "@front.$ask", "@other", "@store", "@store.$get"}, gen.subjects())
+
+	// dependencies come first, and are handed over
+	order := map[string]int{}
+	for i, res := range results {
+		require.NoError(t, res.Err)
+		require.False(t, res.Reused)
+		order[res.Subject] = i
+	}
+	require.Less(t, order["@store.$get"], order["@front.$ask"])
+	require.Less(t, order["@front"], order["=lookup"])
+
+	// nothing changed, nothing generated
+	_, err = b.Build(ctx, doc.Contract(), doc.Order())
+	require.NoError(t, err)
+	require.Empty(t, gen.subjects())
+
+	// $get changes, and with it everything that depends on it however indirectly
+	editDoc(t, doc, "> returns %fact", "> returns %fact, fresh")
+	results, err = b.Build(ctx, doc.Contract(), doc.Order())
+	require.NoError(t, err)
+	require.Equal(t, []string{"=lookup", "@front", "@front.$ask", "@store", "@store.$get"}, gen.subjects())
+	for _, res := range results {
+		require.Equal(t, res.Subject == "@other", res.Reused, res.Subject)
+	}
+
+	// an unrelated space regenerates alone; moving declarations about does not count
+	editDoc(t, doc, "> unrelated", "> unrelated\n> really")
+	editDoc(t, doc, "@front:UI\n", "\n\n@front:UI\n")
+	_, err = b.Build(ctx, doc.Contract(), doc.Order())
+	require.NoError(t, err)
+	require.Equal(t, []string{"@other"}, gen.subjects())
+}
+
+func TestBuildHandsOverDeps(t *testing.T) {
+	gen := &recordingGenerator{}
+	doc := parse.NewDocument(generateSrc, parse.ParseConfig{})
+	_, err := generate.NewBuilder(gen, nil).Build(context.Background(), doc.Contract(), doc.Order())
+	require.NoError(t, err)
+
+	for _, req := range gen.asked {
+		if req.Subject == "@front.$ask" {
+			require.Equal(t, "$ask(in=%q, out=%a)\n> asks @store.$get()", req.Declaration)
+			require.Equal(t, []generate.Artifact{{Subject: "@store.$get", Text: "code for @store.$get"}}, req.Deps)
+			return
+		}
+	}
+	t.Fatal("$ask was not generated")
+}
+
+func TestBuildFailures(t *testing.T) {
+	gen := &recordingGenerator{fail: map[string]bool{"@store.$get": true}}
+	doc := parse.NewDocument(generateSrc, parse.ParseConfig{})
+	results, err := generate.NewBuilder(gen, nil).Build(context.Background(), doc.Contract(), doc.Order())
+	require.NoError(t, err)
+
+	failed := map[string]bool{}
+	for _, res := range results {
+		if res.Err != nil {
+			failed[res.Subject] = true
+		}
+	}
+	// what depends on $get is not even tried
+	require.Equal(t, map[string]bool{"@store.$get": true, "@store": true, "@front.$ask": true, "@front": true, "=lookup": true}, failed)
+	require.Equal(t, []string{"@other", "@store.$get"}, gen.subjects())
+
+	// a cycle is reported, not generated
+	doc = parse.NewDocument("@a:UI\n> uses $use(@b)\n\n@b:UI\n> uses $use(@a)\n", parse.ParseConfig{})
+	results, err = generate.NewBuilder(gen, nil).Build(context.Background(), doc.Contract(), doc.Order())
+	require.NoError(t, err)
+	for _, res := range results {
+		require.Error(t, res.Err)
+	}
+}
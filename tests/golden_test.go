@@ -0,0 +1,240 @@
+package tests
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// go test ./tests -run Golden -update rewrites the expectations from what the
+// parser does now; review the diff before committing it
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// dumper writes out everything the parser records about a contract, positions
+// and spans included, one fact per line
+type dumper struct {
+	b strings.Builder
+	depth int
+}
+
+func (d *dumper) line(format string, args ...any) {
+	d.b.WriteString(strings.Repeat("  ", d.depth))
+	fmt.Fprintf(&d.b, format, args...)
+	d.b.WriteString("\n")
+}
+
+func spanStr(s parse.Span) string {
+	return fmt.Sprintf("%d-%d", s.Start, s.End)
+}
+
+func (d *dumper) params(params []parse.Param) {
+	for i := range params {
+		p := &params[i]
+		line, col := p.GetPos()
+		d.line("param %s at %d:%d span %s name %s", p.ToStr(), line, col, spanStr(p.GetSpan()), spanStr(p.GetNameSpan()))
+	}
+}
+
+func (d *dumper) vibe(vb *parse.VibeBlock) {
+	start, end := vb.GetLines()
+	d.line("vibe lines %d-%d span %s", start, end, spanStr(vb.GetSpan()))
+	d.depth++
+	lines, spans := vb.GetProseLines(), vb.GetProseSpans()
+	for i, prose := range vb.GetProse() {
+		d.line("prose %q line %d span %s", prose, lines[i], spanStr(spans[i]))
+	}
+	for _, mr := range vb.GetMetaRefs() {
+		line, col := mr.GetPos()
+		d.line("ref %s at %d:%d span %s name %s", mr.ToStr(), line, col, spanStr(mr.GetSpan()), spanStr(mr.GetNameSpan()))
+		if t, ok := mr.(*parse.MetaRefTask); ok {
+			d.depth++
+			if t.GetSpace() != "" {
+				d.line("space %s span %s", t.GetSpace(), spanStr(t.GetSpaceSpan()))
+			}
+			d.params(t.GetArgs())
+			d.depth--
+		}
+	}
+	d.depth--
+}
+
+func (d *dumper) decl(unit parse.ParseUnit) {
+	start, end := unit.GetLines()
+	head := unit.GetName().ToStr()
+	switch u := unit.(type) {
+	case *parse.SpaceDecl:
+		head += ":" + u.GetSpaceType().ToStr()
+		if u.IsReplicable() {
+			head += ":REPLICABLE"
+		}
+	case *parse.AgentDecl:
+		head += ":" + u.GetAgentType().ToStr()
+	case *parse.PathDecl:
+		head += ":" + u.GetPathType().ToStr()
+		if u.GetAccess() != parse.AnyAccess {
+			head += ":" + u.GetAccess().ToStr()
+		}
+		head += fmt.Sprintf("(%s %s, %s %s)", u.GetSource().ToStr(), spanStr(u.GetSourceSpan()), u.GetDest().ToStr(), spanStr(u.GetDestSpan()))
+	}
+	d.line("%s lines %d-%d span %s name %s", head, start, end, spanStr(unit.GetSpan()), spanStr(unit.GetNameSpan()))
+	d.depth++
+	if inst := unit.GetInstantiation(); inst != nil {
+		line, col := inst.GetPos()
+		d.line("instance %s at %d:%d span %s template %s", inst.ToStr(), line, col, spanStr(inst.GetSpan()), spanStr(inst.GetTemplateSpan()))
+	}
+	switch u := unit.(type) {
+	case *parse.SpaceDecl: d.params(u.GetParams())
+	case *parse.AgentDecl: d.params(u.GetParams())
+	case *parse.TaskDecl: d.params(u.GetParams())
+	}
+	d.vibe(unit.GetVibe())
+	for _, child := range unit.GetChildren() {
+		d.decl(child)
+	}
+	d.depth--
+}
+
+func dumpContract(c *parse.Contract, errs []parse.ParserErrorInfo) string {
+	var d dumper
+	for _, tmpl := range c.GetTemplates() {
+		line, col := tmpl.GetPos()
+		d.line("template %s at %d:%d", tmpl.GetName().ToStr(), line, col)
+		d.depth++
+		for _, p := range tmpl.GetParams() {
+			line, col := p.GetPos()
+			d.line("param %s type %t at %d:%d span %s", p.GetIdent(), p.IsType(), line, col, spanStr(p.GetSpan()))
+		}
+		d.decl(tmpl.GetBody())
+		d.depth--
+	}
+	spaces, agents, paths := c.GetSpaces(), c.GetAgents(), c.GetPaths()
+	for i := range spaces {
+		d.decl(&spaces[i])
+	}
+	for i := range agents {
+		d.decl(&agents[i])
+	}
+	for i := range paths {
+		d.decl(&paths[i])
+	}
+	for _, cm := range c.GetComments() {
+		d.line("comment %q line %d", cm.GetText(), cm.GetLine())
+	}
+	for _, e := range errs {
+		line, col := e.GetPos()
+		d.line("error at %d:%d: %s", line, col, e.GetError().Message())
+	}
+	return d.b.String()
+}
+
+func TestGolden(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "parse", "*.ang"))
+	require.NoError(t, err)
+	require.NotEmpty(t, paths)
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			src, err := os.ReadFile(path)
+			require.NoError(t, err)
+			c, errs := parse.ParseFromReader(strings.NewReader(string(src)))
+			requireInBounds(t, string(src), &c, errs)
+			got := dumpContract(&c, errs)
+
+			golden := strings.TrimSuffix(path, ".ang") + ".golden"
+			if *update {
+				require.NoError(t, os.WriteFile(golden, []byte(got), 0644))
+				return
+			}
+			want, err := os.ReadFile(golden)
+			require.NoError(t, err, "run with -update to create it")
+			require.Equal(t, string(want), got)
+		})
+	}
+}
+
+// requireInBounds checks that every position and span the parser gave lies
+// within the source: spans within its bytes, lines within its lines and
+// columns within those lines, counted in code points
+func requireInBounds(t *testing.T, src string, c *parse.Contract, errs []parse.ParserErrorInfo) {
+	t.Helper()
+	lines := strings.Split(src, "\n")
+	pos := func(what string, line, col uint64) {
+		require.Less(t, line, uint64(len(lines)), "%s line", what)
+		require.LessOrEqual(t, col, uint64(utf8.RuneCountInString(lines[line])), "%s column on line %d", what, line)
+	}
+	span := func(what string, s parse.Span) {
+		require.LessOrEqual(t, s.Start, s.End, what)
+		require.LessOrEqual(t, s.End, uint64(len(src)), what)
+	}
+	params := func(params []parse.Param) {
+		for i := range params {
+			line, col := params[i].GetPos()
+			pos("param", line, col)
+			span("param", params[i].GetSpan())
+			span("param name", params[i].GetNameSpan())
+		}
+	}
+
+	var decl func(unit parse.ParseUnit)
+	decl = func(unit parse.ParseUnit) {
+		what := unit.GetName().ToStr()
+		start, end := unit.GetLines()
+		require.LessOrEqual(t, start, end, what)
+		require.LessOrEqual(t, end, uint64(len(lines)), what)
+		span(what, unit.GetSpan())
+		span(what + " name", unit.GetNameSpan())
+		switch u := unit.(type) {
+		case *parse.SpaceDecl: params(u.GetParams())
+		case *parse.AgentDecl: params(u.GetParams())
+		case *parse.TaskDecl: params(u.GetParams())
+		case *parse.PathDecl:
+			span(what + " source", u.GetSourceSpan())
+			span(what + " dest", u.GetDestSpan())
+		}
+		vb := unit.GetVibe()
+		span(what + " vibe", vb.GetSpan())
+		require.Equal(t, len(vb.GetProse()), len(vb.GetProseLines()), what)
+		require.Equal(t, len(vb.GetProse()), len(vb.GetProseSpans()), what)
+		for i, line := range vb.GetProseLines() {
+			require.Less(t, line, uint64(len(lines)), what)
+			span(what + " prose", vb.GetProseSpans()[i])
+		}
+		for _, mr := range vb.GetMetaRefs() {
+			line, col := mr.GetPos()
+			pos(mr.ToStr(), line, col)
+			span(mr.ToStr(), mr.GetSpan())
+			span(mr.ToStr() + " name", mr.GetNameSpan())
+			if task, ok := mr.(*parse.MetaRefTask); ok {
+				span(mr.ToStr() + " space", task.GetSpaceSpan())
+				params(task.GetArgs())
+			}
+		}
+		for _, child := range unit.GetChildren() {
+			decl(child)
+		}
+	}
+	spaces, agents, paths := c.GetSpaces(), c.GetAgents(), c.GetPaths()
+	for i := range spaces {
+		decl(&spaces[i])
+	}
+	for i := range agents {
+		decl(&agents[i])
+	}
+	for i := range paths {
+		decl(&paths[i])
+	}
+	for _, cm := range c.GetComments() {
+		require.Less(t, cm.GetLine(), uint64(len(lines)), "comment")
+	}
+	for _, e := range errs {
+		line, col := e.GetPos()
+		pos("error", line, col)
+	}
+}
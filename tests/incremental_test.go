@@ -0,0 +1,174 @@
+package tests
+
+import (
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// requireFullParse checks a document against parsing its source from scratch
+func requireFullParse(t *testing.T, doc *parse.Document, cfg parse.ParseConfig, what string) {
+	t.Helper()
+	c, errs := parse.ParseFromReaderWith(strings.NewReader(doc.Source()), cfg)
+	require.Equal(t, c, *doc.Contract(), what)
+	require.Equal(t, errs, doc.Errors(), what)
+
+	po := parse.GetParseOrder(&c)
+	got := doc.Order()
+	require.Equal(t, po.Len(), got.Len(), what)
+	for i := 0; i < po.Len(); i++ {
+		id := uint64(i)
+		require.Equal(t, po.GetNode(id), got.GetNode(id), what)
+		require.Equal(t, po.GetQualifiedName(id), got.GetQualifiedName(id), what)
+		require.Equal(t, po.GetDeps(id), got.GetDeps(id), what)
+	}
+	require.Equal(t, po.GetSorted(), got.GetSorted(), what)
+	require.Equal(t, po.GetProblems(), got.GetProblems(), what)
+}
+
+// bits of contract to type in, half of them unfinished
+var incrementalSnippets = []string{
+	"\n", "\n\n", " ", "  ", ">", "@", "#", "$", "=", "%", "(", ")", ".", "x", "é",
+	"@extra:UI\n> x\n",
+	"@front", "@store.$get()", "$get", "$use(@store)",
+	"  $more(in=%q)\n  > calls $get()\n",
+	"#bot:AF\n> uses $use(@front)\n",
+	"=p2:INVOKE(@front, @store)\n> $get()\n",
+	"// note\n",
+	"[", "]", "@t2 = @store[DATA, x]\n", "  @inner = @store[IO, y]\n",
+}
+
+func randomEdit(rng *rand.Rand, src string) parse.TextEdit {
+	at := func() uint64 {
+		i := rng.Intn(len(src) + 1)
+		for i < len(src) && !utf8.RuneStart(src[i]) {
+			i++
+		}
+		return uint64(i)
+	}
+	start, end := at(), at()
+	if start > end {
+		start, end = end, start
+	}
+	switch rng.Intn(3) {
+	case 0: // insert
+		return parse.TextEdit{Span: parse.Span{Start: start, End: start}, NewText: incrementalSnippets[rng.Intn(len(incrementalSnippets))]}
+	case 1: // delete a little
+		if end - start > 12 {
+			end = start + uint64(rng.Intn(12))
+			for end < uint64(len(src)) && !utf8.RuneStart(src[end]) {
+				end++
+			}
+		}
+		return parse.TextEdit{Span: parse.Span{Start: start, End: end}}
+	default: // replace
+		if end - start > 40 {
+			end = start
+		}
+		return parse.TextEdit{Span: parse.Span{Start: start, End: end}, NewText: incrementalSnippets[rng.Intn(len(incrementalSnippets))]}
+	}
+}
+
+func TestIncrementalMatchesFullParse(t *testing.T) {
+	srcs := []string{cstSrc, halfTypedSrc, nestedSrc, runtimeSrc, renameSrc, scopeSrc, unicodeSrc, templateSrc, ""}
+	for _, columns := range []parse.ColumnUnit{parse.CodePoints, parse.UTF16Units} {
+		cfg := parse.ParseConfig{Columns: columns}
+		for s, src := range srcs {
+			rng := rand.New(rand.NewSource(int64(s + 1)))
+			doc := parse.NewDocument(src, cfg)
+			requireFullParse(t, doc, cfg, "initial")
+			for i := 0; i < 150; i++ {
+				e := randomEdit(rng, doc.Source())
+				before := doc.Source()
+				require.NoError(t, doc.Edit(e))
+				requireFullParse(t, doc, cfg, before + "\n--- edited at " + string(rune('0' + i % 10)) + " ---\n" + doc.Source())
+			}
+		}
+	}
+}
+
+func TestIncrementalUpdate(t *testing.T) {
+	cfg := parse.ParseConfig{}
+	doc := parse.NewDocument(runtimeSrc, cfg)
+	rng := rand.New(rand.NewSource(7))
+	for i := 0; i < 100; i++ {
+		src := doc.Source()
+		e := randomEdit(rng, src)
+		next, err := parse.ApplyEdits(src, []parse.TextEdit{e})
+		require.NoError(t, err)
+		doc.Update(next)
+		require.Equal(t, next, doc.Source())
+		requireFullParse(t, doc, cfg, next)
+	}
+}
+
+func TestIncrementalReparsesLittle(t *testing.T) {
+	doc := parse.NewDocument(scopeSrc, parse.ParseConfig{})
+	n := doc.Reparsed()
+	require.Greater(t, n, 2)
+
+	// a change inside the first declaration's vibe leaves the rest alone
+	at := strings.Index(scopeSrc, ">") + 1
+	require.NoError(t, doc.Edit(parse.TextEdit{Span: parse.Span{Start: uint64(at), End: uint64(at)}, NewText: " also"}))
+	require.Equal(t, 1, doc.Reparsed())
+	requireFullParse(t, doc, parse.ParseConfig{}, doc.Source())
+
+	require.Error(t, doc.Edit(parse.TextEdit{Span: parse.Span{Start: 0, End: uint64(len(doc.Source()) + 1)}}))
+}
+
+func TestIncrementalDeps(t *testing.T) {
+	cfg := parse.ParseConfig{}
+	edit := func(doc *parse.Document, old, new string) {
+		at := strings.Index(doc.Source(), old)
+		require.GreaterOrEqual(t, at, 0)
+		require.NoError(t, doc.Edit(parse.TextEdit{Span: parse.Span{Start: uint64(at), End: uint64(at + len(old))}, NewText: new}))
+		requireFullParse(t, doc, cfg, doc.Source())
+	}
+	depends := func(doc *parse.Document, from, to string) bool {
+		po := doc.Order()
+		f, ok := po.LookupQualified(from)
+		require.True(t, ok, from)
+		dep, ok := po.LookupQualified(to)
+		return ok && slices.Contains(po.GetDeps(f), dep)
+	}
+
+	// the path's declaration is left alone, but the space it was missing turns up
+	doc := parse.NewDocument("@a:UI\n> a\n\n=p:INVOKE(@a, @b)\n> p\n\n// more to come\n", cfg)
+	require.False(t, depends(doc, "=p", "@b"))
+	edit(doc, "come\n", "come\n@b:CALL\n> b\n")
+	// the comment and the new space
+	require.Equal(t, 2, doc.Reparsed())
+	require.True(t, depends(doc, "=p", "@b"))
+
+	// @a is left alone, but which tasks the path lets it see changes
+	doc = parse.NewDocument("@a:UI\n> calls $x()\n\n@b:CALL\n$x()\n> x\n$y()\n> y\n\n=p:INVOKE(@a, @b)\n> $y()\n", cfg)
+	require.False(t, depends(doc, "@a", "@b.$x"))
+	edit(doc, "> $y()", "> $x()")
+	require.True(t, depends(doc, "@a", "@b.$x"))
+
+	// @a and the path are left alone, but the task @a calls is declared
+	doc = parse.NewDocument("@a:UI\n> calls $z()\n\n@b:CALL\n$y()\n> y\n\n=p:INVOKE(@a, @b)\n> p\n", cfg)
+	require.False(t, depends(doc, "@a", "@b.$z"))
+	edit(doc, "$y()", "$z()")
+	require.True(t, depends(doc, "@a", "@b.$z"))
+
+	// the task the path names goes, so it exposes them all
+	doc = parse.NewDocument("@a:UI\n> calls $x()\n\n@b:CALL\n$x()\n> x\n$y()\n> y\n\n=p:INVOKE(@a, @b)\n> $y()\n", cfg)
+	require.False(t, depends(doc, "@a", "@b.$x"))
+	edit(doc, "$y()\n> y", "$w()\n> y")
+	require.True(t, depends(doc, "@a", "@b.$x"))
+}
+
+// a template without parameters moved along by an edit before it
+func TestIncrementalShiftsTemplate(t *testing.T) {
+	cfg := parse.ParseConfig{}
+	doc := parse.NewDocument("@a:UI\n> a\n\n@t[]:UI\n> t\n", cfg)
+	require.NoError(t, doc.Edit(parse.TextEdit{Span: parse.Span{Start: 8, End: 8}, NewText: "n\n"}))
+	requireFullParse(t, doc, cfg, doc.Source())
+}
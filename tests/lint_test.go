@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/check"
+	"github.com/anotherLostKitten/Anglish/internal/lint"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+type lintCase struct {
+	name string
+	src string
+	// lines of the expected diagnostics, in order
+	lines []uint64
+}
+
+func runLintRule(t *testing.T, rule lint.Rule, config lint.Config, src string) []check.Diagnostic {
+	t.Helper()
+	c, errs := parse.ParseFromReader(strings.NewReader(src))
+	require.Empty(t, errs)
+	po := parse.GetParseOrder(&c)
+	return lint.NewLinter([]lint.Rule{rule}, config).Run(&c, &po)
+}
+
+func runLintCases(t *testing.T, rule lint.Rule, cases []lintCase) {
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			diags := runLintRule(t, rule, nil, tc.src)
+			lines := make([]uint64, len(diags))
+			for i, d := range diags {
+				require.Equal(t, rule.Name(), d.Rule)
+				require.Equal(t, rule.DefaultSeverity(), d.Severity)
+				lines[i] = d.Line
+			}
+			if len(tc.lines) == 0 {
+				require.Empty(t, lines)
+			} else {
+				require.Equal(t, tc.lines, lines)
+			}
+		})
+	}
+}
+
+func TestLintUnusedTask(t *testing.T) {
+	runLintCases(t, lint.UnusedTask{}, []lintCase{
+		{"called", "@s:CALL\n> s\n$a()\n> a\n$b()\n> calls $a()\n", []uint64{4}},
+		{"never called", "@s:CALL\n> s\n$a()\n> a\n", []uint64{2}},
+		{"only calls itself", "@s:CALL\n> s\n$a()\n> again $a()\n", []uint64{2}},
+		{"called from agent", "#x:AF\n> runs $a() $use(@s)\n\n@s:CALL\n> s\n$a()\n> a\n", nil},
+	})
+}
+
+func TestLintNoInboundPath(t *testing.T) {
+	runLintCases(t, lint.NoInboundPath{}, []lintCase{
+		{"lonely space", "@s:CALL\n> s\n", []uint64{0}},
+		{"path in", "@a:UI\n> a\n\n@b:CALL\n> b\n\n=p:INVOKE(@a, @b)\n", []uint64{0}},
+		{"both ways", "@a:UI\n> a\n\n@b:CALL\n> b\n\n=p:ATTEND(@a, @b)\n=q:ATTEND(@b, @a)\n", nil},
+	})
+}
+
+func TestLintEmptyVibe(t *testing.T) {
+	runLintCases(t, lint.EmptyVibe{}, []lintCase{
+		{"space", "@s:CALL\n>\n", []uint64{0}},
+		{"agent and task", "@s:CALL\n> s\n#a:AF\n>\n$t()\n", []uint64{2, 4}},
+		{"path exempt", "@s:CALL\n> s\n\n=p:ATTEND(@s, @s)\n", nil},
+		{"has prose", "#a:DF\n>\n> hello\n", nil},
+	})
+}
+
+func TestLintAgentWithoutUse(t *testing.T) {
+	runLintCases(t, lint.AgentWithoutUse{}, []lintCase{
+		{"no use", "#a:AF\n> does nothing\n", []uint64{0}},
+		{"uses space", "@s:CALL\n> s\n\n#a:AF\n> works in $use(@s)\n", nil},
+		{"uses agent", "#b:DF\n> b $use(#a)\n#a:AF\n> a $use(#b)\n", nil},
+		{"inner agent", "@s:CALL\n> s\n#a:AF\n> task only $t()\n$t()\n> t\n", []uint64{2}},
+	})
+}
+
+func TestLintSelfPath(t *testing.T) {
+	runLintCases(t, lint.SelfPath{}, []lintCase{
+		{"self", "@s:CALL\n> s\n\n=p:ATTEND(@s, @s)\n", []uint64{3}},
+		{"other", "@s:CALL\n> s\n\n@t:CALL\n> t\n\n=p:INVOKE(@s, @t)\n", nil},
+	})
+}
+
+func TestLintIdentCase(t *testing.T) {
+	runLintCases(t, lint.IdentCase{}, []lintCase{
+		{"snake", "@my_space:CALL(in=%some_data)\n> s\n", nil},
+		{"camel space", "@mySpace:CALL\n> s\n", []uint64{0}},
+		{"upper task and param", "@s:CALL\n> s\n$Go(out=%Result)\n> go\n", []uint64{2, 2}},
+		{"unicode", "@données:CALL(in=%名前)\n> s\n$créer()\n> c\n", nil},
+		{"unicode upper", "@Données:CALL\n> s\n$crÉer()\n> c\n", []uint64{0, 2}},
+	})
+}
+
+func TestLintSuppression(t *testing.T) {
+	src := "@s:CALL\n> s\n// anglish:ignore unused-task\n$a()\n> a\n// anglish:ignore empty-vibe, unused-task\n$b()\n> b\n$c()\n> c\n"
+	diags := runLintRule(t, lint.UnusedTask{}, nil, src)
+	require.Len(t, diags, 1)
+	require.Equal(t, uint64(8), diags[0].Line)
+
+	// suppressing a space covers everything declared inside it
+	src = "// anglish:ignore unused-task\n@s:CALL\n> s\n$a()\n> a\n"
+	require.Empty(t, runLintRule(t, lint.UnusedTask{}, nil, src))
+
+	src = "// anglish:ignore-file all\n@s:CALL\n> s\n$a()\n> a\n"
+	require.Empty(t, runLintRule(t, lint.UnusedTask{}, nil, src))
+}
+
+func TestLintSeverityConfig(t *testing.T) {
+	src := "@s:CALL\n> s\n$a()\n> a\n"
+	diags := runLintRule(t, lint.UnusedTask{}, lint.Config{"unused-task": check.Error}, src)
+	require.Len(t, diags, 1)
+	require.Equal(t, check.Error, diags[0].Severity)
+
+	require.Empty(t, runLintRule(t, lint.UnusedTask{}, lint.Config{"unused-task": check.Off}, src))
+}
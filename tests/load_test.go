@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/load"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// writeContracts fills dir with contracts of every size and a few the parser
+// has to recover from, returning their paths in the order load.Paths gives
+func writeContracts(t *testing.T, dir string) []string {
+	t.Helper()
+	corpus, err := filepath.Glob(filepath.Join("testdata", "parse", "*.ang"))
+	require.NoError(t, err)
+	var srcs []string
+	for _, path := range corpus {
+		src, err := os.ReadFile(path)
+		require.NoError(t, err)
+		srcs = append(srcs, string(src))
+	}
+	for n := 10; n <= 200; n += 10 {
+		srcs = append(srcs, genContract(n))
+	}
+
+	var paths []string
+	for i, src := range srcs {
+		path := filepath.Join(dir, fmt.Sprintf("c%02d.ang", i))
+		require.NoError(t, os.WriteFile(path, []byte(src), 0644))
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func dumpFiles(files []load.File) []string {
+	dumps := make([]string, len(files))
+	for i := range files {
+		f := &files[i]
+		dumps[i] = f.Path + "\n" + dumpContract(&f.Contract, f.Errors)
+		for _, id := range f.Order.GetSorted() {
+			dumps[i] += fmt.Sprintf("%d %s %v\n", id, f.Order.GetQualifiedName(id), f.Order.GetDeps(id))
+		}
+	}
+	return dumps
+}
+
+func TestLoadPaths(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	for _, name := range []string{"b.ang", "a.ang", "sub/c.ang", "notes.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+	lone := filepath.Join(dir, "notes.txt")
+
+	paths, err := load.Paths([]string{lone, dir})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		lone,
+		filepath.Join(dir, "a.ang"),
+		filepath.Join(dir, "b.ang"),
+		filepath.Join(dir, "sub", "c.ang"),
+	}, paths)
+
+	_, err = load.Paths([]string{filepath.Join(dir, "missing")})
+	require.Error(t, err)
+}
+
+// Whatever the number of workers, the files come back in the order they were
+// given, parsed and resolved as if one at a time.
+func TestLoadDeterministic(t *testing.T) {
+	paths := writeContracts(t, t.TempDir())
+	want := dumpFiles(load.Files(paths, load.Config{Workers: 1}))
+	for _, workers := range []int{0, 2, 8, 64} {
+		got := dumpFiles(load.Files(paths, load.Config{Workers: workers}))
+		require.Equal(t, want, got, "%d workers", workers)
+	}
+}
+
+func TestLoadUnreadable(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.ang")
+	require.NoError(t, os.WriteFile(good, []byte("@s:CALL\n> s\n"), 0644))
+
+	files := load.Files([]string{filepath.Join(dir, "missing.ang"), good}, load.Config{})
+	require.Len(t, files, 2)
+	require.Error(t, files[0].Err)
+	require.NoError(t, files[1].Err)
+	require.Empty(t, files[1].Errors)
+	require.Equal(t, 1, files[1].Order.Len())
+}
+
+// The parser keeps no state between calls, so any number of goroutines can
+// parse at once, the same source or not. Run under go test -race, which fails
+// this test if they share anything they write.
+func TestParseConcurrent(t *testing.T) {
+	srcs := []string{genContract(100), genContract(300), "@s:DATA(in=%x\n> $a( %\xff\n\n#a\n"}
+	want := make([]string, len(srcs))
+	for i, src := range srcs {
+		c, errs := parse.ParseFromReader(strings.NewReader(src))
+		want[i] = dumpContract(&c, errs)
+	}
+
+	var wg sync.WaitGroup
+	got := make([][]string, 16)
+	for g := range got {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, src := range srcs {
+				c, errs := parse.ParseFromReader(strings.NewReader(src))
+				po := parse.GetParseOrder(&c)
+				parse.GetRoutingTable(&po)
+				got[g] = append(got[g], dumpContract(&c, errs))
+			}
+		}()
+	}
+	wg.Wait()
+	for g := range got {
+		require.Equal(t, want, got[g], "goroutine %d", g)
+	}
+}
@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/check"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+const badNamesSrc = `@front:UI
+> the front desk $use(@nowhere) over =lookup
+
+@store:CALL
+> remembers facts
+
+@store:CALL
+> remembers facts again
+
+=lookup:INVOKE(@front, @store)
+> reads facts
+`
+
+// captureStdout runs f and gives what it wrote to os.Stdout
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	f()
+	require.NoError(t, w.Close())
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestNameProblems(t *testing.T) {
+	c, errs := parse.ParseFromReader(strings.NewReader(badNamesSrc))
+	require.Empty(t, errs)
+	var po parse.ParseOrder
+	out := captureStdout(t, func() { po = parse.GetParseOrder(&c) })
+	require.Empty(t, out)
+
+	var got []string
+	for _, p := range po.GetProblems() {
+		got = append(got, po.GetQualifiedName(p.Node) + ": " + p.ToStr())
+	}
+	require.Equal(t, []string{
+		"@front: Undeclared Identifier: @nowhere",
+		"@store: Duplicate Identifier: @store",
+		"@front: @front is in a dependency cycle",
+	}, got)
+
+	var rules []string
+	for _, d := range check.Names(&po, &check.DefaultRules) {
+		rules = append(rules, d.Severity.ToStr() + " " + d.Rule)
+	}
+	require.Equal(t, []string{"error name-undeclared", "error name-duplicate", "warning dependency-cycle"}, rules)
+
+	// the cycle is still sorted, every node once
+	sorted := append([]uint64{}, po.GetSorted()...)
+	slices.Sort(sorted)
+	for i := range sorted {
+		require.Equal(t, uint64(i), sorted[i])
+	}
+	require.Len(t, sorted, po.Len())
+
+	quiet := check.DefaultRules
+	quiet.DependencyCycle = check.Off
+	require.Len(t, check.Names(&po, &quiet), 2)
+}
+
+func TestNameProblemsNone(t *testing.T) {
+	_, po := scopeOrder(t, "@front:UI\n> asks $use(@store)\n\n@store:CALL\n> remembers facts\n")
+	require.Empty(t, po.GetProblems())
+	require.Empty(t, check.Names(&po, &check.DefaultRules))
+}
+
+// "=" in a vibe line names a =path, not a %data
+func TestVibePathRef(t *testing.T) {
+	src := "@front:UI\n> sends %x over =lookup\n\n@store:CALL\n> remembers facts\n\n=lookup:INVOKE(@front, @store)\n> reads facts\n"
+	c, po := scopeOrder(t, src)
+	refs := c.GetSpaces()[0].GetVibe().GetMetaRefs()
+	require.Len(t, refs, 2)
+	require.IsType(t, &parse.MetaRefData{}, refs[0])
+	path, ok := refs[1].(*parse.MetaRefPath)
+	require.True(t, ok)
+	require.Equal(t, "lookup", path.GetIdent())
+	require.Equal(t, "sends %x over =lookup", c.GetSpaces()[0].GetVibe().GetProse()[0])
+	// the path leads out of @front, so naming it there is a cycle, not a miss
+	problems := func() []string {
+		var strs []string
+		for _, p := range po.GetProblems() {
+			strs = append(strs, p.ToStr())
+		}
+		return strs
+	}
+	require.Equal(t, []string{"@front is in a dependency cycle"}, problems())
+
+	_, po = scopeOrder(t, strings.Replace(src, "over =lookup", "over =nowhere", 1))
+	require.Equal(t, []string{"Undeclared Identifier: =nowhere"}, problems())
+}
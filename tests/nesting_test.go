@@ -0,0 +1,173 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/air/lower"
+	"github.com/anotherLostKitten/Anglish/internal/check"
+	"github.com/anotherLostKitten/Anglish/internal/doc"
+	"github.com/anotherLostKitten/Anglish/internal/graph"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+const nestedSrc = `@app:UI
+> the app $use(@login)
+$start()
+> starts the app over =open
+  @login:UI
+  > the login form
+  $submit(in=%user)
+  > submits %user over =verify
+    @captcha:CALL
+    > checks for humans
+    $check(in=%user)
+    > checks %user
+  =verify:INVOKE(@login, @captcha)
+  > asks the captcha
+  @cart:UI
+  > the cart
+  $add(in=%item)
+  > adds %item
+=open:ATTEND(@app, @login)
+> opens the login form
+
+@shop:IO
+> the shop
+$buy(in=%item)
+> buys %item
+`
+
+func parseNested(t *testing.T, src string) (parse.Contract, parse.ParseOrder) {
+	t.Helper()
+	c, errs := parse.ParseFromReader(strings.NewReader(src))
+	require.Empty(t, errs)
+	return c, parse.GetParseOrder(&c)
+}
+
+func childNames(u parse.ParseUnit) []string {
+	var names []string
+	for _, c := range u.GetChildren() {
+		names = append(names, c.GetName().ToStr())
+	}
+	return names
+}
+
+func TestNestedSpaces(t *testing.T) {
+	c, po := parseNested(t, nestedSrc)
+	require.Len(t, c.GetSpaces(), 2)
+	app := &c.GetSpaces()[0]
+	require.Equal(t, []string{"$start", "@login", "@cart", "=open"}, childNames(app))
+	require.Equal(t, []string{"$submit", "@captcha", "=verify"}, childNames(&app.GetSpaces()[0]))
+
+	var names []string
+	for _, s := range c.AllSpaces() {
+		names = append(names, s.GetName().ToStr())
+	}
+	require.Equal(t, []string{"@app", "@login", "@captcha", "@cart", "@shop"}, names)
+	require.Len(t, c.AllPaths(), 2)
+
+	captcha, ok := po.Lookup(parse.NewIdent(parse.SPACE, "captcha"))
+	require.True(t, ok)
+	var ancestors []string
+	for _, a := range po.GetAncestors(captcha) {
+		ancestors = append(ancestors, po.GetNode(a).GetName().ToStr())
+	}
+	require.Equal(t, []string{"@login", "@app"}, ancestors)
+
+	// the nested spaces are only visible from inside @app
+	shop, ok := po.Lookup(parse.NewIdent(parse.SPACE, "shop"))
+	require.True(t, ok)
+	_, ok = po.Resolve(shop, parse.NewIdent(parse.SPACE, "login"))
+	require.False(t, ok)
+	_, ok = po.Resolve(captcha, parse.NewIdent(parse.SPACE, "shop"))
+	require.True(t, ok)
+
+	m, errs := lower.Lower(&po)
+	require.Empty(t, errs)
+	require.Len(t, m.Spaces, 5)
+}
+
+func TestNestedSpaceScope(t *testing.T) {
+	src := nestedSrc + `
+=peek:ATTEND(@shop, @cart)
+> looks in the cart
+`
+	_, po := parseNested(t, src)
+	_, errs := lower.Lower(&po)
+	require.Len(t, errs, 1)
+	require.ErrorContains(t, errs[0], "=peek refers to undeclared @cart")
+
+	// a space at the same indentation is a sibling, which needs a blank line
+	_, parse_errs := parse.ParseFromReader(strings.NewReader("@a:UI\n> a\n@b:UI\n> b\n"))
+	require.Len(t, parse_errs, 1)
+	require.Equal(t, parse.IllegalDeclarationInsideSpaceScope, parse_errs[0].GetError())
+}
+
+func TestSubspaceIsolation(t *testing.T) {
+	src := strings.Replace(nestedSrc, "> the cart\n", "> the cart $use(@app)\n", 1)
+	src = strings.Replace(src, "=open:ATTEND(@app, @login)", "=back:ATTEND(@captcha, @login)\n  > goes back\n=open:ATTEND(@app, @login)", 1)
+	c, po := parseNested(t, src)
+
+	var diags []check.Diagnostic
+	for _, d := range check.Semantic(&c, &po, &check.DefaultRules) {
+		if d.Rule == "subspace-isolation" {
+			diags = append(diags, d)
+		}
+	}
+	require.Len(t, diags, 2)
+	require.Contains(t, diags[0].Msg, "@cart is nested in @app and may not $use() it")
+	require.Contains(t, diags[1].Msg, "=back leads from @captcha back into @login")
+
+	c, po = parseNested(t, nestedSrc)
+	for _, d := range check.Semantic(&c, &po, &check.DefaultRules) {
+		require.NotEqual(t, "subspace-isolation", d.Rule)
+	}
+}
+
+func TestGraphNesting(t *testing.T) {
+	_, po := parseNested(t, nestedSrc)
+	rt := parse.GetRoutingTable(&po)
+	dot, err := graph.DOT(&po, &rt)
+	require.NoError(t, err)
+	require.Contains(t, dot, `subgraph "cluster_@app" {`)
+	require.Contains(t, dot, `subgraph "cluster_@login" {`)
+	require.Contains(t, dot, `"@login in @app" -> "@captcha" [label="=verify:INVOKE"];`)
+	// @shop has no subspaces, so no cluster
+	require.NotContains(t, dot, "cluster_@shop")
+	require.Less(t, strings.Index(dot, `"@captcha" [`), strings.Index(dot, `"@cart" [`))
+}
+
+func TestGraphNestingSameNames(t *testing.T) {
+	orderOf := func(src string) (parse.ParseOrder, parse.RoutingTable) {
+		c, errs := parse.ParseFromReader(strings.NewReader(src))
+		require.Empty(t, errs)
+		po := parse.GetParseOrder(&c)
+		return po, parse.GetRoutingTable(&po)
+	}
+
+	// a subspace named for its parent, or for a cousin, is a duplicate; the
+	// graph and the docs refuse it rather than draw the names wrong
+	for _, src := range []string{
+		"@t:UI\n> outer\n @t:CALL\n > inner\n",
+		"@a:UI\n> a\n @x:UI\n > x\n\n@b:UI\n> b\n @x:UI\n > x\n",
+	} {
+		po, rt := orderOf(src)
+		_, err := graph.DOT(&po, &rt)
+		require.ErrorContains(t, err, "Duplicate Identifier")
+		_, err = doc.Render(&po, &rt, doc.Options{})
+		require.ErrorContains(t, err, "Duplicate Identifier")
+	}
+
+	po, rt := orderOf("@a:UI\n> a $use(@nowhere)\n")
+	_, err := graph.DOT(&po, &rt)
+	require.ErrorContains(t, err, "Undeclared Identifier: @nowhere")
+
+	// a cycle is only a warning, and is still drawn
+	po, rt = orderOf("@a:UI\n> a $use(@b)\n\n@b:CALL\n> b $use(@a)\n")
+	require.NotEmpty(t, po.GetProblems())
+	_, err = graph.DOT(&po, &rt)
+	require.NoError(t, err)
+}
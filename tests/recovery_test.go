@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+const halfTypedSrc = `@front:UI
+> the front desk
+$ask(in=%q)
+> asks about %q
+oops, this is not a declaration
+> and neither is this
+$tell(in=%x)
+> tells %x
+
+and this is rubbish
+> with a vibe line of its own
+
+#:AF
+> an agent with no name
+
+#helper:AF
+> helps out
+
+=peek:INVOKE(@front)
+> only names one space
+
+@store:CALL
+> remembers facts
+`
+
+func errorKinds(errs []parse.ParserErrorInfo) []parse.ParserError {
+	kinds := make([]parse.ParserError, len(errs))
+	for i := range errs {
+		kinds[i] = errs[i].GetError()
+	}
+	return kinds
+}
+
+func TestParserRecovery(t *testing.T) {
+	c, errs := parse.ParseFromReader(strings.NewReader(halfTypedSrc))
+
+	// one error per broken line, not one per character
+	require.Equal(t, []parse.ParserError{
+		parse.ExpectedInnerDecl,
+		parse.ExpectedOuterDecl,
+		parse.ExpectedIdentifier,
+		parse.IncorrectNumberPathSpaces,
+	}, errorKinds(errs))
+	line, _ := errs[0].GetPos()
+	require.Equal(t, uint64(4), line)
+	line, _ = errs[1].GetPos()
+	require.Equal(t, uint64(9), line)
+
+	// what was fine on either side of the errors is kept
+	require.Len(t, c.GetSpaces(), 2)
+	front := c.GetSpaces()[0]
+	require.Len(t, front.GetTasks(), 2)
+	require.Equal(t, "tell", front.GetTasks()[1].GetName().GetIdent())
+	require.Equal(t, "store", c.GetSpaces()[1].GetName().GetIdent())
+	require.Len(t, c.GetAgents(), 1)
+	require.Equal(t, "helper", c.GetAgents()[0].GetName().GetIdent())
+	require.Empty(t, c.GetPaths())
+}
+
+func TestParserErrorLimit(t *testing.T) {
+	_, errs := parse.ParseFromReader(strings.NewReader(strings.Repeat("?\n\n", 200)))
+	require.Len(t, errs, 51)
+	require.Equal(t, parse.TooManyErrors, errs[50].GetError())
+
+	// trailing blank lines are not an error
+	_, errs = parse.ParseFromReader(strings.NewReader("@a:UI\n> a\n\n\n  \n"))
+	require.Empty(t, errs)
+}
+
+func TestSpaceScopeEnds(t *testing.T) {
+	src := `// the desk
+@front:UI
+> the front desk
+// asks first
+$ask(in=%q)
+> asks about %q
+
+$tell(in=%x)
+> tells %x
+`
+	c, errs := parse.ParseFromReader(strings.NewReader(src))
+
+	// the blank line closes @front, so $tell is left outside any space
+	require.Equal(t, []parse.ParserError{parse.ExpectedOuterDecl}, errorKinds(errs))
+	line, _ := errs[0].GetPos()
+	require.Equal(t, uint64(7), line)
+	require.Len(t, c.GetSpaces(), 1)
+	require.Len(t, c.GetSpaces()[0].GetTasks(), 1)
+
+	// comments may stand in for declarations, inside a space or outside it
+	var comments []string
+	for _, cm := range c.GetComments() {
+		comments = append(comments, cm.GetText())
+	}
+	require.Equal(t, []string{"the desk", "asks first"}, comments)
+	require.Equal(t, uint64(3), c.GetComments()[1].GetLine())
+
+	// a lone "/" is a broken comment; the rest of its line is skipped
+	c, errs = parse.ParseFromReader(strings.NewReader("@a:UI\n> a\n/ not a comment\n$t()\n> t\n"))
+	require.Equal(t, []parse.ParserError{parse.ExpectedComment}, errorKinds(errs))
+	require.Len(t, c.GetSpaces()[0].GetTasks(), 1)
+	require.Empty(t, c.GetComments())
+}
@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/lsp"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+	"github.com/anotherLostKitten/Anglish/internal/refactor"
+)
+
+const renameSrc = `@front:UI
+> the front desk $use(@store) $use(#helper)
+#clerk:AF
+> answers with $ask over =lookup
+$init()
+> sets up
+$ask(in=%q, out=%a)
+> 😀 asks @store.$get(out=%fact) over =lookup after $init
+
+@store:CALL
+> remembers facts
+$init()
+> sets up the store
+$get(out=%fact)
+> returns %fact
+
+#helper:AF
+> helps out
+
+=lookup:INVOKE(@front, @store)
+> reads with $get
+`
+
+func rename(t *testing.T, src string, old string, new_name string) (string, error) {
+	t.Helper()
+	c, errs := parse.ParseFromReader(strings.NewReader(src))
+	require.Empty(t, errs)
+	po := parse.GetParseOrder(&c)
+	target, ok := po.LookupQualified(old)
+	require.True(t, ok, old)
+	edits, err := refactor.NewIndex(&po).Rename(src, 0, target, new_name)
+	if err != nil {
+		return "", err
+	}
+	return parse.ApplyEdits(src, edits)
+}
+
+func TestRename(t *testing.T) {
+	for _, tc := range []struct {
+		old, new_name, want string
+	}{
+		{"@store", "cache", strings.ReplaceAll(renameSrc, "@store", "@cache")},
+		{"=lookup", "=fetch", strings.ReplaceAll(renameSrc, "=lookup", "=fetch")},
+		{"@store.$get", "fetch", strings.ReplaceAll(renameSrc, "$get", "$fetch")},
+		{"#helper", "aide", strings.ReplaceAll(renameSrc, "#helper", "#aide")},
+		// only @store's $init, not @front's
+		{"@store.$init", "setup", strings.Replace(renameSrc, "$init()\n> sets up the store", "$setup()\n> sets up the store", 1)},
+		{"@front.$init", "boot", strings.ReplaceAll(strings.Replace(renameSrc, "$init()\n> sets up\n", "$boot()\n> sets up\n", 1), "after $init", "after $boot")},
+	} {
+		got, err := rename(t, renameSrc, tc.old, tc.new_name)
+		require.NoError(t, err, tc.old)
+		require.Equal(t, tc.want, got, tc.old)
+	}
+}
+
+func TestRenameRefuses(t *testing.T) {
+	_, err := rename(t, renameSrc, "@front.$init", "ask")
+	require.ErrorContains(t, err, "@front.$ask is already declared")
+	_, err = rename(t, renameSrc, "@store", "front")
+	require.ErrorContains(t, err, "@front is already declared")
+	_, err = rename(t, renameSrc, "=lookup", "9lives")
+	require.ErrorContains(t, err, "not an identifier")
+	_, err = rename(t, renameSrc, "@store.$get", "use")
+	require.ErrorContains(t, err, "reserved")
+
+	// no other #clerk at the top level, but $use(#helper) inside @front would
+	// then find @front's own #clerk
+	_, err = rename(t, renameSrc, "#helper", "clerk")
+	require.ErrorContains(t, err, "would change what other references resolve to")
+}
+
+// the index finds at each offset what looking through every occurrence of
+// every declaration in turn would
+func TestIndexAt(t *testing.T) {
+	for _, src := range []string{renameSrc, templateSrc, scopeSrc} {
+		c, errs := parse.ParseFromReader(strings.NewReader(src))
+		require.Empty(t, errs)
+		po := parse.GetParseOrder(&c)
+		ix := refactor.NewIndex(&po)
+		for offset := uint64(0); offset <= uint64(len(src)); offset++ {
+			want, want_span, want_ok := uint64(0), parse.Span{}, false
+		scan:
+			for i := 0; i < po.Len(); i++ {
+				for _, occ := range ix.Occurrences(uint64(i)) {
+					if occ.Span.Contains(offset) || occ.Span.End == offset && occ.Span.Len() > 0 {
+						want, want_span, want_ok = uint64(i), occ.Span, true
+						break scan
+					}
+				}
+			}
+			got, span, ok := ix.At(offset)
+			require.Equal(t, want_ok, ok, offset)
+			require.Equal(t, want, got, offset)
+			require.Equal(t, want_span, span, offset)
+		}
+	}
+
+	_, po := scopeOrder(t, renameSrc)
+	ix := refactor.NewIndex(&po)
+	target, span, ok := ix.At(uint64(strings.Index(renameSrc, "$get(out=%fact) over") + 2))
+	require.True(t, ok)
+	require.Equal(t, "@store.$get", po.GetQualifiedName(target))
+	require.Equal(t, "get", renameSrc[span.Start:span.End])
+}
+
+func lspMessage(t *testing.T, buf *bytes.Buffer, id int, method string, params any) {
+	msg := map[string]any{"jsonrpc": "2.0", "method": method, "params": params}
+	if id > 0 {
+		msg["id"] = id
+	}
+	body, err := json.Marshal(msg)
+	require.NoError(t, err)
+	fmt.Fprintf(buf, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func lspPosition(src string, needle string) map[string]int {
+	offset := strings.Index(src, needle)
+	line := strings.Count(src[:offset], "\n")
+	line_start := strings.LastIndex(src[:offset], "\n") + 1
+	return map[string]int{"line": line, "character": len(utf16.Encode([]rune(src[line_start:offset])))}
+}
+
+func TestLSPRename(t *testing.T) {
+	uri := "file:///contract.ang"
+	doc := map[string]string{"uri": uri}
+	var in bytes.Buffer
+	lspMessage(t, &in, 1, "initialize", map[string]any{})
+	lspMessage(t, &in, 0, "initialized", map[string]any{})
+	lspMessage(t, &in, 0, "textDocument/didOpen", map[string]any{"textDocument": map[string]string{"uri": uri, "text": renameSrc}})
+	// the cursor on the =lookup after the emoji
+	lookup := lspPosition(renameSrc, "lookup after")
+	lspMessage(t, &in, 2, "textDocument/prepareRename", map[string]any{"textDocument": doc, "position": lookup})
+	lspMessage(t, &in, 3, "textDocument/rename", map[string]any{"textDocument": doc, "position": lookup, "newName": "fetch"})
+	lspMessage(t, &in, 4, "textDocument/rename", map[string]any{"textDocument": doc, "position": lookup, "newName": "9"})
+	lspMessage(t, &in, 5, "shutdown", nil)
+	lspMessage(t, &in, 0, "exit", nil)
+
+	var out bytes.Buffer
+	require.NoError(t, lsp.NewServer(&in, &out).Serve())
+
+	replies := make(map[int]json.RawMessage)
+	errs := make(map[int]string)
+	r := bufio.NewReader(&out)
+	for {
+		headers, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		length, err := strconv.Atoi(headers.Get("Content-Length"))
+		require.NoError(t, err)
+		body := make([]byte, length)
+		_, err = io.ReadFull(r, body)
+		require.NoError(t, err)
+		var reply struct {
+			ID     int             `json:"id"`
+			Result json.RawMessage `json:"result"`
+			Error  *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		require.NoError(t, json.Unmarshal(body, &reply))
+		if reply.Error != nil {
+			errs[reply.ID] = reply.Error.Message
+		} else {
+			replies[reply.ID] = reply.Result
+		}
+	}
+	require.Len(t, replies, 4)
+
+	var prepared struct {
+		Range       lsp.Range `json:"range"`
+		Placeholder string    `json:"placeholder"`
+	}
+	require.NoError(t, json.Unmarshal(replies[2], &prepared))
+	require.Equal(t, "lookup", prepared.Placeholder)
+	// the emoji is two UTF-16 units
+	require.Equal(t, lsp.Position{Line: 7, Character: 39}, prepared.Range.Start)
+
+	var edit lsp.WorkspaceEdit
+	require.NoError(t, json.Unmarshal(replies[3], &edit))
+	require.Len(t, edit.Changes[uri], 3)
+	for _, e := range edit.Changes[uri] {
+		require.Equal(t, "fetch", e.NewText)
+		require.Equal(t, e.Range.Start.Character+6, e.Range.End.Character)
+	}
+	require.Contains(t, errs[4], "not an identifier")
+}
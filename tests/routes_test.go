@@ -0,0 +1,190 @@
+package tests
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/check"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+	"github.com/anotherLostKitten/Anglish/internal/runtime"
+)
+
+const routesSrc = `@shop:UI
+> the shop $use(@cache)
+$buy(in=%item)
+> buys %item over =fill
+
+@admin:UI
+> the admin $use(@cache) $use(@panel)
+$audit(in=%item)
+> audits %item over =clear
+
+@panel:UI
+> a panel for the admin
+$show(in=%item)
+> shows %item over =peek
+
+@cache:CALL
+> a cache
+$put(in=%item)
+> stores %item
+
+=fill:INVOKE(@shop, @cache)
+> fills the cache
+
+=clear:INVOKE(@admin, @cache)
+> clears the cache
+
+=peek:INVOKE(@panel, @cache)
+> looks in the cache
+`
+
+func routingTable(t *testing.T, src string) parse.RoutingTable {
+	t.Helper()
+	c, errs := parse.ParseFromReader(strings.NewReader(src))
+	require.Empty(t, errs)
+	po := parse.GetParseOrder(&c)
+	return parse.GetRoutingTable(&po)
+}
+
+func inst(space string, site string) parse.SpaceInstance {
+	si := parse.SpaceInstance{Space: parse.NewIdent(parse.SPACE, space)}
+	if site != "" {
+		si.Site = parse.NewIdent(parse.SPACE, site)
+	}
+	return si
+}
+
+func TestRoutingTable(t *testing.T) {
+	rt := routingTable(t, routesSrc)
+	require.ElementsMatch(t, []parse.SpaceInstance{
+		inst("shop", ""),
+		inst("admin", ""),
+		inst("panel", "admin"),
+		inst("cache", "admin"),
+		inst("cache", "shop"),
+	}, rt.GetInstances())
+	require.Empty(t, rt.GetProblems())
+
+	cases := []struct {
+		path string
+		from parse.SpaceInstance
+		to parse.SpaceInstance
+	}{
+		// the sender's own import
+		{"fill", inst("shop", ""), inst("cache", "shop")},
+		{"clear", inst("admin", ""), inst("cache", "admin")},
+		// imported alongside the sender
+		{"peek", inst("panel", "admin"), inst("cache", "admin")},
+	}
+	for _, c := range cases {
+		to, ok := rt.Lookup(parse.NewIdent(parse.PATH, c.path), c.from)
+		require.True(t, ok, c.path)
+		require.Equal(t, c.to, to, c.path)
+	}
+}
+
+func TestRoutingTableProblems(t *testing.T) {
+	src := routesSrc + `
+@cron:IO
+> runs jobs
+$tick(in=%item)
+> ticks over =flush
+
+=flush:INVOKE(@cron, @cache)
+> flushes the cache
+`
+	rt := routingTable(t, src)
+	require.Len(t, rt.GetProblems(), 1)
+	p := rt.GetProblems()[0]
+	require.Equal(t, parse.RouteAmbiguous, p.Kind)
+	require.Equal(t, inst("cron", ""), p.Instance)
+	require.Len(t, p.Candidates, 2)
+
+	// a space used by itself is not an import site
+	rt = routingTable(t, `@a:CALL
+> uses itself $use(@a)
+$go(in=%x)
+> goes
+
+=loop:ATTEND(@a, @a)
+> loops
+`)
+	require.Equal(t, []parse.SpaceInstance{inst("a", "")}, rt.GetInstances())
+	require.Empty(t, rt.GetProblems())
+
+	// only the shop's cache gets any traffic
+	rt = routingTable(t, `@shop:UI
+> the shop $use(@cache)
+$buy(in=%item)
+> buys
+
+@admin:UI
+> the admin $use(@cache)
+$audit(in=%item)
+> audits
+
+@cache:CALL
+> a cache
+$put(in=%item)
+> stores
+
+=fill:INVOKE(@shop, @cache)
+> fills
+`)
+	require.Len(t, rt.GetProblems(), 1)
+	require.Equal(t, parse.RouteUnreachable, rt.GetProblems()[0].Kind)
+	require.Equal(t, inst("cache", "admin"), rt.GetProblems()[0].Instance)
+
+	diags := check.Routes(&rt, &check.DefaultRules)
+	require.Len(t, diags, 1)
+	require.Equal(t, "route-unreachable", diags[0].Rule)
+	require.Equal(t, check.Warning, diags[0].Severity)
+}
+
+func TestRuntimeRoutes(t *testing.T) {
+	var mu sync.Mutex
+	stored := make(map[string][]string)
+	send := func(path string) runtime.TaskFunc {
+		return func(ctx context.Context, call *runtime.Call) (map[string]string, error) {
+			return call.Send(ctx, path, "put", call.Args)
+		}
+	}
+	rt := startRuntimeSrc(t, routesSrc, runtime.Config{Handlers: map[string]runtime.TaskFunc{
+		runtime.HandlerKey("shop", "buy"): send("fill"),
+		runtime.HandlerKey("admin", "audit"): send("clear"),
+		runtime.HandlerKey("panel", "show"): send("peek"),
+		runtime.HandlerKey("cache", "put"): func(_ context.Context, call *runtime.Call) (map[string]string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			site := call.Instance().Site
+			stored[site] = append(stored[site], call.Args["item"])
+			return nil, nil
+		},
+	}})
+
+	ids, err := rt.Instances("cache")
+	require.NoError(t, err)
+	require.Len(t, ids, 2)
+
+	ctx := context.Background()
+	_, err = rt.Call(ctx, "shop", "buy", map[string]string{"item": "apple"})
+	require.NoError(t, err)
+	_, err = rt.Call(ctx, "admin", "audit", map[string]string{"item": "pear"})
+	require.NoError(t, err)
+	_, err = rt.Call(ctx, "panel", "show", map[string]string{"item": "plum"})
+	require.NoError(t, err)
+
+	require.Equal(t, map[string][]string{
+		"shop": {"apple"},
+		"admin": {"pear", "plum"},
+	}, stored)
+
+	// two caches, so a call from outside has to name one
+	_, err = rt.Call(ctx, "cache", "put", map[string]string{"item": "fig"})
+	require.Error(t, err)
+}
@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+	"github.com/anotherLostKitten/Anglish/internal/rules"
+)
+
+func extract(t *testing.T, src string) []rules.Obligation {
+	t.Helper()
+	c, errs := parse.ParseFromReader(strings.NewReader(src))
+	require.Empty(t, errs)
+	po := parse.GetParseOrder(&c)
+	return rules.Extract(&c, &po)
+}
+
+// obligationsOf lists the obligations of one kind as "subject target"
+func obligationsOf(obls []rules.Obligation, kind rules.Kind) []string {
+	var strs []string
+	for _, o := range obls {
+		if o.Kind == kind {
+			strs = append(strs, o.Subject + " " + o.Target)
+		}
+	}
+	return strs
+}
+
+// the spaces each have a $get or a $init, so only qualified names tell the
+// obligations apart; a reference that does not resolve, as the ambiguous $get
+// does not, is left as written
+func TestRulesQualified(t *testing.T) {
+	obls := extract(t, scopeSrc)
+	require.Equal(t, []string{
+		"@front.$ask @front.$init",
+		"@front.$ask $get",
+		"@front.$ask @store.$wipe",
+		"@front.$ask @cache.$get",
+		"=lookup @store.$get",
+	}, obligationsOf(obls, rules.TaskCall))
+	require.Equal(t, []string{"@front =lookup", "@front =warm"}, obligationsOf(obls, rules.PathUsed))
+}
+
+func TestRulesExtract(t *testing.T) {
+	const deskSrc = "@front:UI(in=%q)\n> asks with $use(@store)\n$ask(in=%q, out=%a)\n> gets %a with @store.$get(out=%a) over =lookup\n\n" +
+		"@store:CALL\n> remembers\n$get(out=%fact)\n> returns %fact\n\n" +
+		"=lookup:INVOKE(@front, @store)\n> reads =lookup\n"
+	// each vibe block is one line, from its ">" to the end of its prose
+	front_span := rules.Span{LineStart: 1, LineEnd: 1, ColEnd: len64("> asks with $use(@store)")}
+	ask_span := rules.Span{LineStart: 3, LineEnd: 3, ColEnd: len64("> gets %a with @store.$get(out=%a) over =lookup")}
+	get_span := rules.Span{LineStart: 8, LineEnd: 8, ColEnd: len64("> returns %fact")}
+	lookup_span := rules.Span{LineStart: 11, LineEnd: 11, ColEnd: len64("> reads =lookup")}
+	tests := []struct {
+		name string
+		kind rules.Kind
+		want []rules.Obligation
+	}{
+		{"signature", rules.Signature, []rules.Obligation{
+			{Kind: rules.Signature, Subject: "@front", Args: []string{"in=%q"}, Span: front_span},
+			{Kind: rules.Signature, Subject: "@front.$ask", Args: []string{"in=%q", "out=%a"}, Span: ask_span},
+			{Kind: rules.Signature, Subject: "@store.$get", Args: []string{"out=%fact"}, Span: get_span},
+		}},
+		{"use import", rules.UseImport, []rules.Obligation{
+			{Kind: rules.UseImport, Subject: "@front", Target: "@store", Span: front_span},
+		}},
+		{"data access", rules.DataAccess, []rules.Obligation{
+			{Kind: rules.DataAccess, Subject: "@front.$ask", Target: "%a", Span: ask_span},
+			{Kind: rules.DataAccess, Subject: "@store.$get", Target: "%fact", Span: get_span},
+		}},
+		{"task call", rules.TaskCall, []rules.Obligation{
+			{Kind: rules.TaskCall, Subject: "@front.$ask", Target: "@store.$get", Args: []string{"out=%a"}, Span: ask_span},
+		}},
+		{"path send", rules.PathSend, []rules.Obligation{
+			{Kind: rules.PathSend, Subject: "@front.$ask", Target: "=lookup", Span: ask_span},
+			{Kind: rules.PathSend, Subject: "=lookup", Target: "=lookup", Span: lookup_span},
+		}},
+		{"path used", rules.PathUsed, []rules.Obligation{
+			{Kind: rules.PathUsed, Subject: "@front", Target: "=lookup", Span: lookup_span},
+		}},
+	}
+	obls := extract(t, deskSrc)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []rules.Obligation
+			for _, o := range obls {
+				if o.Kind == tt.kind {
+					got = append(got, o)
+				}
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	// a contract with nothing to check still has obligations to export: none
+	require.Empty(t, extract(t, "@a:UI\n> a\n"))
+}
+
+func TestRulesRoundTrip(t *testing.T) {
+	obls := extract(t, scopeSrc)
+	require.NotEmpty(t, obls)
+	data, err := rules.Export(obls)
+	require.NoError(t, err)
+	back, err := rules.Import(data)
+	require.NoError(t, err)
+	require.Equal(t, obls, back)
+
+	data, err = rules.Export(nil)
+	require.NoError(t, err)
+	back, err = rules.Import(data)
+	require.NoError(t, err)
+	require.Empty(t, back)
+
+	// a version we do not know is refused rather than half read
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal(data, &doc))
+	for _, v := range []int{0, rules.FormatVersion + 1} {
+		doc["version"] = v
+		data, err = json.Marshal(doc)
+		require.NoError(t, err)
+		_, err = rules.Import(data)
+		require.ErrorContains(t, err, "unsupported format version")
+	}
+}
+
+func len64(s string) uint64 {
+	return uint64(len(s))
+}
+
+// a =path named in a vibe block, even in a nested space, is resolved and
+// named as the PathUsed obligation of its declaration names it
+func TestRulesPathTargets(t *testing.T) {
+	obls := extract(t, nestedSrc)
+	require.Equal(t, []string{"@app.$start =open", "@login.$submit =verify"}, obligationsOf(obls, rules.PathSend))
+	require.Equal(t, []string{"@login =verify", "@app =open"}, obligationsOf(obls, rules.PathUsed))
+
+	// an undeclared path is left as written
+	obls = extract(t, "@a:UI\n> a over =nowhere\n")
+	require.Equal(t, []string{"@a =nowhere"}, obligationsOf(obls, rules.PathSend))
+}
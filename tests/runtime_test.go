@@ -0,0 +1,235 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+
+	"github.com/anotherLostKitten/Anglish/internal/runtime"
+)
+
+// scriptedModel hands out its replies in order, across every agent that uses it.
+type scriptedModel struct {
+	mu sync.Mutex
+	replies []*llms.ContentChoice
+	prompts [][]llms.MessageContent
+}
+
+func (sm *scriptedModel) GenerateContent(_ context.Context, msgs []llms.MessageContent, _ ...llms.CallOption) (*llms.ContentResponse, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.prompts = append(sm.prompts, msgs)
+	if len(sm.prompts) > len(sm.replies) {
+		return nil, errors.New("script exhausted")
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{sm.replies[len(sm.prompts)-1]}}, nil
+}
+
+func (sm *scriptedModel) Call(ctx context.Context, prompt string, opts ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, sm, prompt, opts...)
+}
+
+func toolCall(t *testing.T, path string, task string, args map[string]string) *llms.ContentChoice {
+	input, err := json.Marshal(map[string]any{"task": task, "args": args})
+	require.NoError(t, err)
+	wrapped, err := json.Marshal(map[string]string{"__arg1": string(input)})
+	require.NoError(t, err)
+	return &llms.ContentChoice{FuncCall: &schema.FunctionCall{Name: path, Arguments: string(wrapped)}}
+}
+
+const runtimeSrc = `@front:UI
+> the front desk
+#clerk:AF
+> answers questions $use(@calc)
+$ask(in=%q, out=%a)
+> works out %a for %q over =compute and writes it down over =record
+
+@calc:CALL
+> does sums
+#mathbot:DF
+> doubles numbers $use(@calc)
+$double(in=%n, out=%m)
+> sets %m to twice %n
+
+@store:DATA
+> remembers facts
+$put(in=%fact)
+> stores %fact
+$get(out=%fact)
+> returns %fact
+
+=compute:INVOKE(@front, @calc)
+> asks for sums
+
+=record:ATTEND(@front, @store)
+> writes facts down
+`
+
+func startRuntimeSrc(t *testing.T, src string, cfg runtime.Config) *runtime.Runtime {
+	t.Helper()
+	rt, err := runtime.New(lowerSrc(t, src), cfg)
+	require.NoError(t, err)
+	require.NoError(t, rt.Start(context.Background()))
+	t.Cleanup(rt.Stop)
+	return rt
+}
+
+func startRuntime(t *testing.T, cfg runtime.Config) *runtime.Runtime {
+	return startRuntimeSrc(t, runtimeSrc, cfg)
+}
+
+func TestRuntimeHandlers(t *testing.T) {
+	rt := startRuntime(t, runtime.Config{Handlers: map[string]runtime.TaskFunc{
+		runtime.HandlerKey("front", "ask"): func(ctx context.Context, call *runtime.Call) (map[string]string, error) {
+			out, err := call.Send(ctx, "compute", "double", map[string]string{"n": call.Args["q"]})
+			if err != nil {
+				return nil, err
+			}
+			_, err = call.Send(ctx, "record", "put", map[string]string{"fact": out["m"]})
+			return map[string]string{"a": out["m"]}, err
+		},
+		runtime.HandlerKey("calc", "double"): func(_ context.Context, call *runtime.Call) (map[string]string, error) {
+			return map[string]string{"m": call.Args["n"] + call.Args["n"]}, nil
+		},
+	}})
+
+	out, err := rt.Call(context.Background(), "front", "ask", map[string]string{"q": "21"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a": "2121"}, out)
+
+	// =record is ATTEND, so the write lands some time after $ask returns
+	require.Eventually(t, func() bool {
+		data, err := rt.Data("store")
+		return err == nil && data["fact"] == "2121"
+	}, time.Second, time.Millisecond)
+
+	out, err = rt.Call(context.Background(), "store", "get", nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"fact": "2121"}, out)
+}
+
+func TestRuntimeAgents(t *testing.T) {
+	model := &scriptedModel{replies: []*llms.ContentChoice{
+		toolCall(t, "compute", "$double", map[string]string{"n": "2"}),
+		{Content: `{"m": "4"}`},
+		{Content: "the answer is {\"a\": \"4\"}"},
+	}}
+	rt := startRuntime(t, runtime.Config{Model: model})
+
+	out, err := rt.Call(context.Background(), "front", "ask", map[string]string{"q": "2"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a": "4"}, out)
+	require.Len(t, model.prompts, 3)
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	rt := startRuntime(t, runtime.Config{Handlers: map[string]runtime.TaskFunc{
+		runtime.HandlerKey("front", "ask"): func(ctx context.Context, call *runtime.Call) (map[string]string, error) {
+			// there is no =nowhere
+			_, err := call.Send(ctx, "nowhere", "double", nil)
+			return nil, err
+		},
+	}})
+
+	_, err := rt.Call(context.Background(), "front", "nope", nil)
+	require.Error(t, err)
+	_, err = rt.Call(context.Background(), "front", "ask", nil)
+	require.Error(t, err)
+	_, err = rt.Call(context.Background(), "store", "get", nil)
+	require.Error(t, err)
+	_, err = rt.Data("calc")
+	require.Error(t, err)
+}
+
+const spawnSrc = `@boss:CALL
+> hands out jobs
+$start(in=%n, out=%w)
+> spawns a worker for %n and hands it a job over =assign
+$report(in=%r)
+> hears back from workers over =done
+
+@worker:CALL:REPLICABLE
+> does one job
+$on_spawn()
+> gets ready
+$job(in=%x)
+> works on %x and reports back over =done
+
+=assign:INVOKE(@boss, @worker)
+
+=done:ATTEND(@worker, @boss)
+`
+
+func TestRuntimeSpawn(t *testing.T) {
+	var mu sync.Mutex
+	var reports []string
+	spawned := 0
+
+	rt := startRuntimeSrc(t, spawnSrc, runtime.Config{Handlers: map[string]runtime.TaskFunc{
+		runtime.HandlerKey("boss", "start"): func(ctx context.Context, call *runtime.Call) (map[string]string, error) {
+			w, err := call.Spawn(ctx, "worker")
+			if err != nil {
+				return nil, err
+			}
+			_, err = call.SendTo(ctx, "assign", w.ID, "job", map[string]string{"x": call.Args["n"]})
+			return map[string]string{"w": fmt.Sprint(w.ID)}, err
+		},
+		runtime.HandlerKey("boss", "report"): func(_ context.Context, call *runtime.Call) (map[string]string, error) {
+			mu.Lock()
+			reports = append(reports, call.Args["r"])
+			mu.Unlock()
+			return nil, nil
+		},
+		runtime.HandlerKey("worker", "on_spawn"): func(_ context.Context, _ *runtime.Call) (map[string]string, error) {
+			mu.Lock()
+			spawned++
+			mu.Unlock()
+			return nil, nil
+		},
+		runtime.HandlerKey("worker", "job"): func(ctx context.Context, call *runtime.Call) (map[string]string, error) {
+			// =done leads back to the one @boss
+			_, err := call.Send(ctx, "done", "report", map[string]string{"r": fmt.Sprintf("%d:%s", call.Instance().ID, call.Args["x"])})
+			return nil, err
+		},
+	}})
+
+	ids, err := rt.Instances("worker")
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	for _, n := range []string{"a", "b"} {
+		_, err := rt.Call(context.Background(), "boss", "start", map[string]string{"n": n})
+		require.NoError(t, err)
+	}
+	ids, err = rt.Instances("worker")
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 1}, ids)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(reports) == 2
+	}, time.Second, time.Millisecond)
+	require.ElementsMatch(t, []string{"0:a", "1:b"}, reports)
+	require.Equal(t, 2, spawned)
+
+	// two live workers, so outside callers have to pick one
+	_, err = rt.Call(context.Background(), "worker", "job", map[string]string{"x": "c"})
+	require.Error(t, err)
+
+	require.NoError(t, rt.Despawn(context.Background(), runtime.Instance{Space: "worker", ID: 0}))
+	_, err = rt.CallInstance(context.Background(), runtime.Instance{Space: "worker", ID: 0}, "job", nil)
+	require.Error(t, err)
+	_, err = rt.Call(context.Background(), "worker", "job", map[string]string{"x": "c"})
+	require.NoError(t, err)
+
+	_, err = rt.Spawn(context.Background(), "boss")
+	require.ErrorContains(t, err, "not :REPLICABLE")
+}
@@ -0,0 +1,151 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/air"
+	"github.com/anotherLostKitten/Anglish/internal/check"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+const scopeSrc = `@front:UI
+> the front desk
+$init()
+> sets up the desk
+$ask(in=%q, out=%a)
+> runs $init, gets %a with $get(out=%fact) over =lookup
+> and tries @store.$wipe and @cache.$get(out=%fact)
+
+@store:CALL
+> remembers facts
+$init()
+> sets up the store
+$get(out=%fact)
+> returns %fact
+$wipe()
+> forgets everything
+
+@cache:CALL
+> remembers recent facts
+$get(out=%fact)
+> returns %fact
+$drop()
+> drops the cache
+
+=lookup:INVOKE(@front, @store)
+> reads facts with $get
+
+=warm:INVOKE(@front, @cache)
+> warms the cache
+`
+
+func scopeOrder(t *testing.T, src string) (parse.Contract, parse.ParseOrder) {
+	t.Helper()
+	c, errs := parse.ParseFromReader(strings.NewReader(src))
+	require.Empty(t, errs)
+	return c, parse.GetParseOrder(&c)
+}
+
+// resolveRefs maps each task reference in a node's vibe block to the qualified
+// name it resolves to, or to why it does not
+func resolveRefs(t *testing.T, po *parse.ParseOrder, from string) map[string]string {
+	t.Helper()
+	id, ok := po.LookupQualified(from)
+	require.True(t, ok, from)
+	refs := make(map[string]string)
+	for _, mr := range po.GetNode(id).GetVibe().GetMetaRefs() {
+		tr, ok := mr.(*parse.MetaRefTask)
+		if !ok {
+			continue
+		}
+		name := "$" + tr.GetIdent()
+		if tr.GetSpace() != "" {
+			name = "@" + tr.GetSpace() + "." + name
+		}
+		task, err := po.ResolveTask(id, tr)
+		if err != nil {
+			refs[name] = err.Error()
+		} else {
+			refs[name] = po.GetQualifiedName(task)
+		}
+	}
+	return refs
+}
+
+func TestQualifiedNames(t *testing.T) {
+	_, po := scopeOrder(t, scopeSrc)
+
+	front_init, ok := po.LookupQualified("@front.$init")
+	require.True(t, ok)
+	store_init, ok := po.LookupQualified("@store.$init")
+	require.True(t, ok)
+	require.NotEqual(t, front_init, store_init)
+	require.Equal(t, "@store.$init", po.GetQualifiedName(store_init))
+
+	// plain names work when only one space declares them
+	wipe, ok := po.LookupQualified("$wipe")
+	require.True(t, ok)
+	require.Equal(t, "@store.$wipe", po.GetQualifiedName(wipe))
+	_, ok = po.LookupQualified("$init")
+	require.False(t, ok)
+	_, ok = po.LookupQualified("@nowhere.$init")
+	require.False(t, ok)
+
+	lookup, ok := po.LookupQualified("=lookup")
+	require.True(t, ok)
+	require.Equal(t, "=lookup", po.GetQualifiedName(lookup))
+
+	// private tasks are not in the contract-wide index
+	_, ok = po.Lookup(parse.NewIdent(parse.TASK, "wipe"))
+	require.False(t, ok)
+}
+
+func TestTaskVisibility(t *testing.T) {
+	_, po := scopeOrder(t, scopeSrc)
+	refs := resolveRefs(t, &po, "@front.$ask")
+
+	// its own space first, so $init is @front's
+	require.Equal(t, "@front.$init", refs["$init"])
+	// =lookup names only $get, and =warm exposes all of @cache, so $get is on both
+	require.Contains(t, refs["$get"], "Ambiguous Identifier")
+	require.Equal(t, "@cache.$get", refs["@cache.$get"])
+	// =lookup exposes only $get, so $wipe stays private
+	require.Contains(t, refs["@store.$wipe"], "private to @store")
+
+	// a path's vibe block sees its destination's tasks
+	require.Equal(t, map[string]string{"$get": "@store.$get"}, resolveRefs(t, &po, "=lookup"))
+}
+
+func TestTaskVisibilityCheck(t *testing.T) {
+	c, po := scopeOrder(t, scopeSrc)
+	var lines []uint64
+	for _, d := range check.Semantic(&c, &po, &check.DefaultRules) {
+		if d.Rule == "task-visibility" {
+			require.Equal(t, check.Error, d.Severity)
+			lines = append(lines, d.Line)
+		}
+	}
+	// the unqualified $get, then @store.$wipe
+	require.Equal(t, []uint64{5, 6}, lines)
+
+	// with the $get qualified and no call to $wipe, everything resolves
+	fixed := strings.Replace(scopeSrc, "with $get(out=%fact) over =lookup", "with @store.$get(out=%fact) over =lookup", 1)
+	fixed = strings.Replace(fixed, "tries @store.$wipe and ", "tries ", 1)
+	c, po = scopeOrder(t, fixed)
+	for _, d := range check.Semantic(&c, &po, &check.DefaultRules) {
+		require.NotEqual(t, "task-visibility", d.Rule, d.Msg)
+	}
+
+	// both $inits make it into the AIR, one per space
+	m := lowerSrc(t, fixed)
+	front, _ := m.Lookup(air.KindSpace, "front")
+	store, _ := m.Lookup(air.KindSpace, "store")
+	front_init, ok := m.Child(front, air.KindTask, "init")
+	require.True(t, ok)
+	store_init, ok := m.Child(store, air.KindTask, "init")
+	require.True(t, ok)
+	require.NotEqual(t, front_init, store_init)
+}
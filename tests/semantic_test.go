@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/check"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+// ruleDiags gives the diagnostics of one rule that Semantic reports for src
+func ruleDiags(t *testing.T, src string, rules *check.SemanticRules, rule string) []check.Diagnostic {
+	t.Helper()
+	c, errs := parse.ParseFromReader(strings.NewReader(src))
+	require.Empty(t, errs)
+	po := parse.GetParseOrder(&c)
+	var diags []check.Diagnostic
+	for _, d := range check.Semantic(&c, &po, rules) {
+		if d.Rule == rule {
+			diags = append(diags, d)
+		}
+	}
+	return diags
+}
+
+func TestSemanticRules(t *testing.T) {
+	// only the rule table decides where a path may start
+	from_ui := check.DefaultRules
+	from_ui.Paths = map[parse.PathType]check.PathTypeRule{
+		parse.ATTEND: {SourceTypes: []parse.SpaceType{parse.UI}},
+	}
+
+	tests := []struct {
+		rule string
+		rules *check.SemanticRules
+		trips string
+		passes string
+		severity check.Severity
+		msg string
+	}{
+		{
+			rule: "missing-space-type",
+			trips: "@front\n> the front desk\n",
+			passes: "@front:UI\n> the front desk\n",
+			severity: check.Warning,
+			msg: "@front has no space type",
+		},
+		{
+			rule: "space-replicable",
+			trips: "@store:DATA:REPLICABLE\n> facts\n",
+			passes: "@store:CALL:REPLICABLE\n> facts\n",
+			severity: check.Error,
+			msg: "@store:DATA spaces may not be :REPLICABLE",
+		},
+		{
+			rule: "space-agents",
+			trips: "@store:DATA\n> facts\n#keeper:AF\n> keeps them\n",
+			passes: "@store:CALL\n> facts\n#keeper:AF\n> keeps them\n",
+			severity: check.Error,
+			msg: "@store:DATA spaces may not contain agents, found #keeper",
+		},
+		{
+			rule: "path-destination",
+			trips: "@front:UI\n> desk\n\n@store:DATA\n> facts\n\n=lookup:INVOKE(@front, @store)\n> reads\n",
+			passes: "@front:UI\n> desk\n\n@store:CALL\n> facts\n\n=lookup:INVOKE(@front, @store)\n> reads\n",
+			severity: check.Error,
+			msg: "=lookup:INVOKE paths must have a destination of type :CALL, but @store is :DATA",
+		},
+		{
+			rule: "path-source",
+			rules: &from_ui,
+			trips: "@front:IO\n> desk\n\n@store:CALL\n> facts\n\n=watch:ATTEND(@front, @store)\n> watches\n",
+			passes: "@front:UI\n> desk\n\n@store:CALL\n> facts\n\n=watch:ATTEND(@front, @store)\n> watches\n",
+			severity: check.Error,
+			msg: "=watch:ATTEND paths must have a source of type :UI, but @front is :IO",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			rules := tt.rules
+			if rules == nil {
+				rules = &check.DefaultRules
+			}
+			diags := ruleDiags(t, tt.trips, rules, tt.rule)
+			require.Len(t, diags, 1)
+			require.Equal(t, tt.severity, diags[0].Severity)
+			require.Contains(t, diags[0].Msg, tt.msg)
+			require.Empty(t, ruleDiags(t, tt.passes, rules, tt.rule))
+
+			// Off turns the rule off
+			quiet := *rules
+			quiet.MissingSpaceType, quiet.Violation = check.Off, check.Off
+			require.Empty(t, ruleDiags(t, tt.trips, &quiet, tt.rule))
+		})
+	}
+}
+
+// no space type forbids tasks by default, so the table is what trips the rule
+func TestSemanticRulesTasks(t *testing.T) {
+	no_tasks := check.DefaultRules
+	no_tasks.Spaces = map[parse.SpaceType]check.SpaceTypeRule{
+		parse.DATA: {AllowTasks: false},
+		parse.CALL: {AllowTasks: true},
+	}
+	diags := ruleDiags(t, "@store:DATA\n> facts\n$get(out=%fact)\n> returns %fact\n", &no_tasks, "space-tasks")
+	require.Len(t, diags, 1)
+	require.Contains(t, diags[0].Msg, "@store:DATA spaces may not contain tasks, found $get")
+	require.Empty(t, ruleDiags(t, "@store:CALL\n> facts\n$get(out=%fact)\n> returns %fact\n", &no_tasks, "space-tasks"))
+}
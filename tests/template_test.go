@@ -0,0 +1,182 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/check"
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+	"github.com/anotherLostKitten/Anglish/internal/refactor"
+)
+
+const templateSrc = `@store[K, item]:K(in=%item, out=%item)
+> keeps every %item it is given
+#clerk:AF
+> files each %item
+$get(out=%item)
+> finds an %item with @store.$put(in=%item)
+$put(in=%item)
+> stores an %item
+
+@users = @store[DATA, user]
+@orders = @store[CALL, order]
+
+@app:UI
+> shows @orders.$get(out=%order)
+  @cache = @store[IO, page]
+
+=feed:INVOKE(@app, @orders)
+> asks for orders
+`
+
+func parseErrors(src string) []parse.ParserError {
+	_, errs := parse.ParseFromReader(strings.NewReader(src))
+	var kinds []parse.ParserError
+	for _, e := range errs {
+		kinds = append(kinds, e.GetError())
+	}
+	return kinds
+}
+
+func TestTemplateExpansion(t *testing.T) {
+	c, errs := parse.ParseFromReader(strings.NewReader(templateSrc))
+	require.Empty(t, errs)
+	require.Len(t, c.GetTemplates(), 1)
+	tmpl := &c.GetTemplates()[0]
+	require.Equal(t, "@store", tmpl.GetName().ToStr())
+	require.Len(t, tmpl.GetParams(), 2)
+	require.True(t, tmpl.GetParams()[0].IsType())
+	require.False(t, tmpl.GetParams()[1].IsType())
+
+	// the template itself is not a space of the contract
+	var names []string
+	for i := range c.GetSpaces() {
+		names = append(names, c.GetSpaces()[i].GetName().ToStr())
+	}
+	require.Equal(t, []string{"@users", "@orders", "@app"}, names)
+
+	users := &c.GetSpaces()[0]
+	require.Equal(t, parse.DATA, users.GetSpaceType())
+	require.Equal(t, "in=%user", users.GetParams()[0].ToStr())
+	require.Equal(t, []string{"keeps every %user it is given"}, users.GetVibe().GetProse())
+	require.Equal(t, parse.CALL, c.GetSpaces()[1].GetSpaceType())
+
+	inst := users.GetInstantiation()
+	require.NotNil(t, inst)
+	require.Equal(t, "@users = @store[DATA, user]", inst.ToStr())
+	line, col := inst.GetPos()
+	require.Equal(t, []uint64{9, 0}, []uint64{line, col})
+	line, col, ok := inst.GetTemplatePos()
+	require.True(t, ok)
+	require.Equal(t, []uint64{0, 0}, []uint64{line, col})
+	require.Equal(t, "users", templateSrc[users.GetNameSpan().Start:users.GetNameSpan().End])
+
+	cache := &c.GetSpaces()[2].GetSpaces()[0]
+	require.Equal(t, "@cache", cache.GetName().ToStr())
+	require.Equal(t, parse.IO, cache.GetSpaceType())
+}
+
+// instances are ordinary declarations by the time names are resolved, each
+// referring to its own tasks
+func TestTemplateParseOrder(t *testing.T) {
+	c, errs := parse.ParseFromReader(strings.NewReader(templateSrc))
+	require.Empty(t, errs)
+	po := parse.GetParseOrder(&c)
+	for _, name := range []string{"@users", "@users.$get", "@users.#clerk", "@orders.$put", "@cache", "@cache.$get"} {
+		_, ok := po.LookupQualified(name)
+		require.True(t, ok, name)
+	}
+	_, ok := po.LookupQualified("@store")
+	require.False(t, ok)
+
+	get, _ := po.LookupQualified("@orders.$get")
+	put, _ := po.LookupQualified("@orders.$put")
+	require.Contains(t, po.GetDeps(get), put)
+	app, _ := po.LookupQualified("@app")
+	require.Contains(t, po.GetDeps(app), get)
+}
+
+func TestTemplateErrors(t *testing.T) {
+	const tmpl = "@store[K, item]:K(in=%item)\n> keeps %item\n\n"
+	for _, tc := range []struct {
+		src string
+		want parse.ParserError
+	}{
+		{"@users = @nothing[DATA, user]\n", parse.UnknownTemplate},
+		{tmpl + "@users = @store[DATA]\n", parse.TemplateArgCount},
+		{tmpl + "@users = @store[user, DATA]\n", parse.ExpectedSpaceTypeArg},
+		{tmpl + "@users = @store[DATA, user\n", parse.MismatchedBrackets},
+		{tmpl + "@users = store[DATA, user]\n", parse.ExpectedTemplate},
+		{tmpl + tmpl, parse.DuplicateTemplate},
+		{"@t[a, a]:UI\n> t\n", parse.DuplicateTemplateParam},
+		{"@app:UI\n> app\n  @t[a]:UI\n  > t\n", parse.NestedTemplate},
+		{"@loop[x]:UI\n> loops\n  @inner = @loop[x]\n\n@l = @loop[y]\n", parse.TemplateCycle},
+	} {
+		require.Contains(t, parseErrors(tc.src), tc.want, tc.src)
+	}
+}
+
+// an error in a template is reported once, not again for every instance
+func TestTemplateErrorsOnce(t *testing.T) {
+	src := "@store[item]:DATA(in=%item\n> keeps %item\n\n@a = @store[x]\n@b = @store[y]\n"
+	_, errs := parse.ParseFromReader(strings.NewReader(src))
+	require.Len(t, errs, 1)
+	require.Nil(t, errs[0].GetInstantiation())
+
+	// one with the arguments is reported with the instance
+	_, errs = parse.ParseFromReader(strings.NewReader("@s[k]:k\n> s\n\n@a = @s[DATA]\n@b = @s[bad]\n"))
+	require.Len(t, errs, 1)
+	require.Equal(t, parse.ExpectedSpaceTypeArg, errs[0].GetError())
+	require.Equal(t, "@b = @s[bad]", errs[0].GetInstantiation().ToStr())
+}
+
+func TestTemplateDiagnostics(t *testing.T) {
+	c, errs := parse.ParseFromReader(strings.NewReader(templateSrc))
+	require.Empty(t, errs)
+	po := parse.GetParseOrder(&c)
+
+	// :DATA spaces may not contain agents, and only @users is :DATA
+	var found []check.Diagnostic
+	for _, d := range check.Semantic(&c, &po, &check.DefaultRules) {
+		if d.Rule == "space-agents" {
+			found = append(found, d)
+		}
+	}
+	require.Len(t, found, 1)
+	require.Equal(t, uint64(2), found[0].Line)
+	require.NotNil(t, found[0].Instantiation)
+	require.Equal(t, "@users = @store[DATA, user]", found[0].Instantiation.ToStr())
+	require.Equal(t, "in @users = @store[DATA, user] at (9, 0), @store declared at (0, 0)", found[0].Instantiation.Note())
+}
+
+func TestTemplateRename(t *testing.T) {
+	got, err := rename(t, templateSrc, "@users", "people")
+	require.NoError(t, err)
+	require.Equal(t, strings.Replace(templateSrc, "@users =", "@people =", 1), got)
+
+	_, err = rename(t, templateSrc, "@orders.$get", "fetch")
+	require.ErrorContains(t, err, "template @store")
+
+	c, _ := parse.ParseFromReader(strings.NewReader(templateSrc))
+	po := parse.GetParseOrder(&c)
+	feed, _ := po.LookupQualified("=feed")
+	_, err = refactor.NewIndex(&po).Rename(templateSrc, 0, feed, "stream")
+	require.NoError(t, err)
+}
+
+// editing a template changes its instances, though their text is elsewhere
+func TestIncrementalTemplate(t *testing.T) {
+	cfg := parse.ParseConfig{}
+	doc := parse.NewDocument(templateSrc, cfg)
+	at := strings.Index(templateSrc, "it is given")
+	require.NoError(t, doc.Edit(parse.TextEdit{Span: parse.Span{Start: uint64(at), End: uint64(at + 2)}, NewText: "each"}))
+	requireFullParse(t, doc, cfg, doc.Source())
+	require.Equal(t, []string{"keeps every %user each is given"}, doc.Contract().GetSpaces()[0].GetVibe().GetProse())
+
+	at = strings.Index(doc.Source(), "@store[DATA")
+	require.NoError(t, doc.Edit(parse.TextEdit{Span: parse.Span{Start: uint64(at), End: uint64(at + 6)}, NewText: "@gone"}))
+	requireFullParse(t, doc, cfg, doc.Source())
+	require.Contains(t, parseErrors(doc.Source()), parse.UnknownTemplate)
+}
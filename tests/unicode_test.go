@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+)
+
+const unicodeSrc = `@café:UI
+> 日本語のプロンプト 😀 with %データ
+#代理:AF
+> hilft über =経路
+$計算(in=%数, out=%結果)
+> verdoppelt %数
+
+@Ωmega:CALL
+> σ
+
+=経路:INVOKE(@café, @Ωmega)
+`
+
+func TestUnicodeIdentifiers(t *testing.T) {
+	c, errs := parse.ParseFromReader(strings.NewReader(unicodeSrc))
+	require.Empty(t, errs)
+
+	cafe := &c.GetSpaces()[0]
+	require.Equal(t, "café", cafe.GetName().GetIdent())
+	require.Equal(t, "代理", cafe.GetAgents()[0].GetName().GetIdent())
+	task := &cafe.GetTasks()[0]
+	require.Equal(t, "計算", task.GetName().GetIdent())
+	require.Equal(t, "結果", task.GetParams()[1].GetDataName())
+	require.Equal(t, "Ωmega", c.GetSpaces()[1].GetName().GetIdent())
+	require.Equal(t, "日本語のプロンプト 😀 with %データ", cafe.GetVibe().GetProse()[0])
+
+	po := parse.GetParseOrder(&c)
+	_, ok := po.LookupQualified("=経路")
+	require.True(t, ok)
+	m := lowerSrc(t, unicodeSrc)
+	require.Len(t, m.Paths, 1)
+}
+
+func TestUnicodeColumns(t *testing.T) {
+	data_col := func(cfg parse.ParseConfig) uint64 {
+		c, errs := parse.ParseFromReaderWith(strings.NewReader(unicodeSrc), cfg)
+		require.Empty(t, errs)
+		_, col := c.GetSpaces()[0].GetVibe().GetMetaRefs()[0].GetPos()
+		return col
+	}
+	// the emoji is one code point, but two UTF-16 units
+	require.Equal(t, uint64(20), data_col(parse.ParseConfig{}))
+	require.Equal(t, uint64(21), data_col(parse.ParseConfig{Columns: parse.UTF16Units}))
+
+	// errors after non-ASCII text are where they look to be
+	_, errs := parse.ParseFromReader(strings.NewReader("#代理:XX\n> x\n"))
+	require.Len(t, errs, 2)
+	require.Equal(t, parse.UnknownTag, errs[0].GetError())
+	line, col := errs[0].GetPos()
+	require.Equal(t, uint64(0), line)
+	require.Equal(t, uint64(4), col)
+
+	tree := parse.LexWith(unicodeSrc, parse.ParseConfig{Columns: parse.UTF16Units})
+	offset := uint64(strings.Index(unicodeSrc, "%データ"))
+	line, col = tree.Position(offset)
+	require.Equal(t, uint64(1), line)
+	require.Equal(t, uint64(20), col)
+	back, ok := tree.Offset(1, 20)
+	require.True(t, ok)
+	require.Equal(t, offset, back)
+	// the middle of the emoji
+	_, ok = tree.Offset(1, 13)
+	require.False(t, ok)
+
+	i, ok := tree.TokenAt(offset + 1)
+	require.True(t, ok)
+	require.Equal(t, parse.TokenIdent, tree.Tokens()[i].Kind)
+	require.Equal(t, "データ", tree.Text(tree.Tokens()[i].Span))
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	_, errs := parse.ParseFromReader(strings.NewReader("@a:UI\n> bad \xff byte\n"))
+	require.Equal(t, []parse.ParserError{parse.InvalidUTF8}, errorKinds(errs))
+
+	// text that is not a declaration is an error whatever script it is in
+	_, errs = parse.ParseFromReader(strings.NewReader("日本語\n"))
+	require.Equal(t, []parse.ParserError{parse.ExpectedOuterDecl}, errorKinds(errs))
+}
@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+
+	"github.com/anotherLostKitten/Anglish/internal/parse"
+	"github.com/anotherLostKitten/Anglish/internal/verify"
+)
+
+// scriptedJudge answers questions in order from a fixed script.
+type scriptedJudge struct {
+	verdicts []verify.Verdict
+	asked []verify.Question
+}
+
+func (sj *scriptedJudge) Judge(_ context.Context, q verify.Question) (verify.Verdict, error) {
+	sj.asked = append(sj.asked, q)
+	if len(sj.asked) > len(sj.verdicts) {
+		return verify.Verdict{}, errors.New("script exhausted")
+	}
+	return sj.verdicts[len(sj.asked)-1], nil
+}
+
+// replyModel is an llms.Model that always answers with the same text.
+type replyModel struct {
+	reply string
+}
+
+func (rm replyModel) GenerateContent(_ context.Context, _ []llms.MessageContent, _ ...llms.CallOption) (*llms.ContentResponse, error) {
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: rm.reply}}}, nil
+}
+
+func (rm replyModel) Call(_ context.Context, _ string, _ ...llms.CallOption) (string, error) {
+	return rm.reply, nil
+}
+
+const verifySrc = `@front:UI
+> the front
+
+@store:CALL
+> the store
+
+=fetch:INVOKE(@front, @store)
+> only read from the store
+>
+> never send %secret
+`
+
+func verifyPath(t *testing.T) *parse.PathDecl {
+	t.Helper()
+	c, errs := parse.ParseFromReader(strings.NewReader(verifySrc))
+	require.Empty(t, errs)
+	require.Len(t, c.GetPaths(), 1)
+	return &c.GetPaths()[0]
+}
+
+func TestVerifyReport(t *testing.T) {
+	judge := &scriptedJudge{verdicts: []verify.Verdict{
+		{Pass: true, Reason: "only reads"},
+		{Pass: false, Reason: "sends secret"},
+	}}
+	report, err := verify.Verify(context.Background(), judge, verifyPath(t), "send(secret)")
+	require.NoError(t, err)
+
+	require.Equal(t, "=fetch", report.Subject)
+	require.False(t, report.Pass)
+	require.Equal(t, []verify.LineResult{
+		{Line: 7, Requirement: "only read from the store", Pass: true, Reason: "only reads"},
+		{Line: 9, Requirement: "never send %secret", Pass: false, Reason: "sends secret"},
+	}, report.Lines)
+	require.Len(t, report.Failures(), 1)
+
+	require.Len(t, judge.asked, 2)
+	require.Equal(t, "send(secret)", judge.asked[1].Artifact)
+}
+
+func TestVerifyJudgeError(t *testing.T) {
+	judge := &scriptedJudge{verdicts: []verify.Verdict{{Pass: true}}}
+	report, err := verify.Verify(context.Background(), judge, verifyPath(t), "")
+	require.Error(t, err)
+	require.False(t, report.Pass)
+	require.Len(t, report.Lines, 1)
+}
+
+func TestModelJudgeParsesReply(t *testing.T) {
+	judge := verify.NewModelJudge(replyModel{"Sure!\n```json\n{\"pass\": false, \"reason\": \"writes\"}\n```"})
+	v, err := judge.Judge(context.Background(), verify.Question{Requirement: "read only"})
+	require.NoError(t, err)
+	require.Equal(t, verify.Verdict{Pass: false, Reason: "writes"}, v)
+
+	_, err = verify.NewModelJudge(replyModel{"looks fine to me"}).Judge(context.Background(), verify.Question{})
+	require.Error(t, err)
+}